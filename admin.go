@@ -0,0 +1,107 @@
+// Serves migration status and control over HTTP.
+
+package gomigrate
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Actions of the admin handler, passed to its Authorize hook.
+const (
+	AdminStatus   = "status"
+	AdminMigrate  = "migrate"
+	AdminRollback = "rollback"
+)
+
+// Serves the status of a migrator and runs it over HTTP, for internal
+// admin panels:
+//
+//	GET  /migrations  returns the status report as JSON
+//	POST /migrate     applies pending migrations, selected with the
+//	                  comma separated tags and skip-tags parameters
+//	POST /rollback    rolls back n migrations, one by default
+//
+// Migrate and rollback respond with the status report once the run is
+// over. Mount the handler under a prefix with http.StripPrefix.
+type AdminHandler struct {
+	Migrator *Migrator
+
+	// Called with the request and its action before anything runs.
+	// Requests are refused with 403 Forbidden when it returns an error,
+	// or when it is nil, so the handler can't be exposed by accident.
+	Authorize func(r *http.Request, action string) error
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var action, method string
+	switch r.URL.Path {
+	case "/migrations":
+		action, method = AdminStatus, http.MethodGet
+	case "/migrate":
+		action, method = AdminMigrate, http.MethodPost
+	case "/rollback":
+		action, method = AdminRollback, http.MethodPost
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeAdminError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+		return
+	}
+	if h.Authorize == nil {
+		writeAdminError(w, http.StatusForbidden, errors.New("No authorization hook configured"))
+		return
+	}
+	if err := h.Authorize(r, action); err != nil {
+		writeAdminError(w, http.StatusForbidden, err)
+		return
+	}
+
+	var err error
+	switch action {
+	case AdminMigrate:
+		options := make([]RunOption, 0)
+		if tags := r.FormValue("tags"); tags != "" {
+			options = append(options, WithTags(strings.Split(tags, ",")...))
+		}
+		if tags := r.FormValue("skip-tags"); tags != "" {
+			options = append(options, WithoutTags(strings.Split(tags, ",")...))
+		}
+		h.Migrator.infof("Migrating through the admin handler from %s", r.RemoteAddr)
+		err = h.Migrator.MigrateContext(r.Context(), options...)
+	case AdminRollback:
+		n := 1
+		if value := r.FormValue("n"); value != "" {
+			if n, err = strconv.Atoi(value); err != nil || n < 1 {
+				writeAdminError(w, http.StatusBadRequest, errors.New("Invalid number of migrations"))
+				return
+			}
+		}
+		h.Migrator.infof("Rolling back %d migrations through the admin handler from %s", n, r.RemoteAddr)
+		err = h.Migrator.RollbackNContext(r.Context(), n)
+	}
+	switch {
+	case errors.Is(err, MigrationLocked):
+		writeAdminError(w, http.StatusConflict, err)
+	case err != nil:
+		writeAdminError(w, http.StatusInternalServerError, err)
+	default:
+		writeAdminJson(w, http.StatusOK, h.Migrator.Status())
+	}
+}
+
+func writeAdminJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	writeAdminJson(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,153 @@
+// Requires approvals to apply migrations to protected environments.
+
+package gomigrate
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Approvals of migrations for protected environments, as read by
+// LoadApprovals from a file such as:
+//
+//	approvals:
+//	  - id: 3
+//	    checksum: 9f86d081884c7d659a2feaa0c55ad015...
+//	    approved_by: sam
+//	    signature: 3q2+7wAAAAC7u7u7...
+type Approvals struct {
+	Approvals []*Approval `yaml:"approvals"`
+
+	// Public keys of the approvers, by name. When set, only approvals
+	// signed with the key of their approver count.
+	Keys map[string]ed25519.PublicKey `yaml:"-"`
+}
+
+// An approval of a migration.
+type Approval struct {
+	Id uint64 `yaml:"id"`
+
+	// The SHA-256 checksum of the up file approved, so editing the file
+	// voids the approval.
+	Checksum string `yaml:"checksum"`
+
+	ApprovedBy string `yaml:"approved_by"`
+
+	// The signature of the approval with the ed25519 key of the
+	// approver, base64 encoded, as set by Sign.
+	Signature string `yaml:"signature,omitempty"`
+}
+
+// Reads an approvals file. Returns InvalidApprovals when it can't be
+// parsed.
+func LoadApprovals(path string) (*Approvals, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var approvals Approvals
+	if err := yaml.Unmarshal(data, &approvals); err != nil {
+		return nil, InvalidApprovals
+	}
+	return &approvals, nil
+}
+
+// Returns a new approval of a migration by approver, covering its up
+// file as it is now. The database isn't read.
+func (m *Migrator) Approve(id uint64, approver string) (*Approval, error) {
+	m.mu.RLock()
+	migration, ok := m.migrations[id]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown migration: %d", id)
+	}
+	checksum, err := m.checksum(migration, ChecksumSha256)
+	if err != nil {
+		return nil, err
+	}
+	return &Approval{Id: id, Checksum: checksum, ApprovedBy: approver}, nil
+}
+
+// The message signed by approvers.
+func (a *Approval) message() []byte {
+	return []byte(fmt.Sprintf("gomigrate approval %d %s %s", a.Id, a.Checksum, a.ApprovedBy))
+}
+
+// Signs the approval with the private key of the approver.
+func (a *Approval) Sign(key ed25519.PrivateKey) {
+	a.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, a.message()))
+}
+
+// Returns whether the approval is signed with key.
+func (a *Approval) verify(key ed25519.PublicKey) bool {
+	signature, err := base64.StdEncoding.DecodeString(a.Signature)
+	return err == nil && len(key) == ed25519.PublicKeySize && ed25519.Verify(key, a.message(), signature)
+}
+
+// Returns an approval of migration, whose up file has checksum, or nil
+// along with why none counts. Authors can't approve their own
+// migrations.
+func (a *Approvals) find(migration *Migration, checksum string) (*Approval, string) {
+	reason := "no approval"
+	for _, approval := range a.Approvals {
+		switch {
+		case approval.Id != migration.Id:
+			continue
+		case approval.Checksum != checksum:
+			reason = "the up file changed since it was approved"
+		case approval.ApprovedBy == "":
+			reason = "approval without an approver"
+		case approval.ApprovedBy == migration.Author:
+			reason = "approved by its author"
+		case len(a.Keys) > 0 && !approval.verify(a.Keys[approval.ApprovedBy]):
+			reason = "invalid signature by " + approval.ApprovedBy
+		default:
+			return approval, ""
+		}
+	}
+	return nil, reason
+}
+
+// Returns UnapprovedMigration when the migrator is Protected and any of
+// the migrations to apply lacks an approval, logging each of them.
+func (m *Migrator) checkApprovals(migrations []*Migration) error {
+	if !m.Protected {
+		return nil
+	}
+	var err error
+	for _, migration := range migrations {
+		if _, skip := m.Skip[migration.Id]; skip {
+			continue
+		}
+		if approvalErr := m.checkApproval(migration); approvalErr != nil {
+			err = approvalErr
+		}
+	}
+	return err
+}
+
+// Returns UnapprovedMigration when the migrator is Protected and the
+// migration lacks an approval.
+func (m *Migrator) checkApproval(migration *Migration) error {
+	if !m.Protected {
+		return nil
+	}
+	checksum, err := m.checksum(migration, ChecksumSha256)
+	if err != nil {
+		return err
+	}
+	reason := "no approvals"
+	if m.Approvals != nil {
+		var approval *Approval
+		if approval, reason = m.Approvals.find(migration, checksum); approval != nil {
+			m.debugf("Migration %d approved by %s", migration.Id, approval.ApprovedBy)
+			return nil
+		}
+	}
+	m.errorf("Migration %d %s is not approved: %s", migration.Id, migration.Name, reason)
+	return UnapprovedMigration
+}
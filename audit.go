@@ -0,0 +1,140 @@
+// Records who applied each migration and from where.
+
+package gomigrate
+
+import (
+	"database/sql"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+)
+
+const modulePath = "github.com/DavidHuie/gomigrate"
+
+// Identifies who applied a migration and from where.
+type AuditInfo struct {
+	User           string
+	Host           string
+	Application    string
+	LibraryVersion string
+
+	// The main module of the binary that applied the migration, with
+	// its version, VCS revision and Go version, and the runner that
+	// applied it, as set by Migrator.Runner.
+	Build  string
+	Runner string
+}
+
+// Implemented by adapters that record audit information for applied
+// migrations.
+type MigrationAuditRecorder interface {
+	// Sets applied_by, applied_host, application, library_version,
+	// build_info and runner, in that order, for a migration id.
+	MigrationAuditUpdateSql() string
+
+	// Selects applied_by, applied_host, application, library_version,
+	// build_info and runner for a migration id.
+	MigrationAuditSelectSql() string
+}
+
+// Returns the audit information of the running process. The
+// application name defaults to the executable name.
+func currentAuditInfo(application string) AuditInfo {
+	info := AuditInfo{
+		Application:    application,
+		LibraryVersion: libraryVersion(),
+		Build:          buildInfo(),
+	}
+	if current, err := user.Current(); err == nil {
+		info.User = current.Username
+	} else {
+		info.User = os.Getenv("USER")
+	}
+	info.Host, _ = os.Hostname()
+	if info.Application == "" && len(os.Args) > 0 {
+		info.Application = filepath.Base(os.Args[0])
+	}
+	return info
+}
+
+// Returns the version of this package as recorded in the build info of
+// the running binary.
+func libraryVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			if dep.Replace != nil {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return "unknown"
+}
+
+// Describes the binary from its build info, such as
+// "example.com/app@v1.2.0 vcs=4f1c2a9e0b7d+dirty go1.22.1".
+func buildInfo() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	build := info.Main.Path
+	if info.Main.Version != "" {
+		build += "@" + info.Main.Version
+	}
+	var revision, modified string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value
+		}
+	}
+	if revision != "" {
+		if len(revision) > 12 {
+			revision = revision[:12]
+		}
+		build += " vcs=" + revision
+		if modified == "true" {
+			build += "+dirty"
+		}
+	}
+	return strings.TrimSpace(build + " " + info.GoVersion)
+}
+
+// Returns the audit information of the running process and runner.
+func (m *Migrator) auditInfo() AuditInfo {
+	info := currentAuditInfo(m.ApplicationName)
+	info.Runner = m.Runner
+	if info.Runner == "" {
+		info.Runner = m.lockOwner
+	}
+	return info
+}
+
+// Loads the audit information of an applied migration.
+func (m *run) getMigrationAudit(migration *Migration) error {
+	recorder, ok := m.dbAdapter.(MigrationAuditRecorder)
+	if !ok || m.tableVersion < 7 {
+		return nil
+	}
+
+	var user, host, application, version, build, runner sql.NullString
+	row := m.executor.QueryRow(recorder.MigrationAuditSelectSql(), migration.Id)
+	if err := row.Scan(&user, &host, &application, &version, &build, &runner); err != nil {
+		m.errorf("Error getting migration audit for %s: %v", migration.Name, err)
+		return err
+	}
+	migration.Audit = AuditInfo{user.String, host.String, application.String, version.String, build.String, runner.String}
+	return nil
+}
@@ -0,0 +1,108 @@
+// Applies pending migrations when an application starts.
+
+package gomigrate
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Environment variable disabling AutoMigrate when KillSwitch isn't set.
+const DefaultKillSwitch = "GOMIGRATE_DISABLE"
+
+// Options of AutoMigrate.
+type AutoMigrateOptions struct {
+	// Defaults to the adapter registered for the driver of db.
+	Adapter Migratable
+
+	// Defaults to DefaultLogger.
+	Logger Logger
+
+	// Environment variable that disables migrating when set to a true
+	// value such as "1". Defaults to DefaultKillSwitch.
+	KillSwitch string
+
+	// Refuses to migrate when more migrations are pending, so a stale
+	// or misconfigured deployment doesn't apply months of migrations
+	// on start. Zero allows any number.
+	MaxPending int
+
+	// How long to wait for another replica holding the migration lock.
+	// Zero waits until ctx is done.
+	LockTimeout time.Duration
+
+	// The environment migrations run in, as Migrator.Environment.
+	Environment string
+
+	// Called with the migrator before anything runs, to set other
+	// options.
+	Configure func(m *Migrator)
+}
+
+// Applies pending migrations for an application starting up. Nothing
+// runs when the kill switch environment variable is set, which returns
+// a RunDisabled result. Otherwise MaxPending is checked, returning
+// TooManyPending, and the migrations are applied while holding the
+// migration lock, like MigrateLeader, when the adapter supports it.
+// Unlike MigrateLeader, giving up on the lock is an error, since the
+// schema may still be behind.
+func AutoMigrate(ctx context.Context, db *sql.DB, source MigrationSource, options AutoMigrateOptions) (*RunResult, error) {
+	result := &RunResult{Outcome: RunError, Direction: string(upMigration), Applied: make([]uint64, 0)}
+	fail := func(err error) (*RunResult, error) {
+		result.Error = err.Error()
+		return result, err
+	}
+
+	logger := options.Logger
+	if logger == nil {
+		logger = DefaultLogger()
+	}
+	killSwitch := options.KillSwitch
+	if killSwitch == "" {
+		killSwitch = DefaultKillSwitch
+	}
+	if disabled, _ := strconv.ParseBool(os.Getenv(killSwitch)); disabled {
+		logf(logger, LevelWarn, "Migrations on start are disabled by %s", killSwitch)
+		result.Outcome = RunDisabled
+		return result, nil
+	}
+
+	adapter := options.Adapter
+	if adapter == nil {
+		var err error
+		if adapter, err = DetectAdapter(db); err != nil {
+			return fail(err)
+		}
+	}
+	m, err := NewMigratorWithLogger(db, adapter, source, logger)
+	if err != nil {
+		return fail(err)
+	}
+	m.Environment = options.Environment
+	m.LockTimeout = options.LockTimeout
+	if options.Configure != nil {
+		options.Configure(m)
+	}
+
+	if err := m.initialize(); err != nil {
+		return fail(err)
+	}
+	if pending := len(m.Pending()); options.MaxPending > 0 && pending > options.MaxPending {
+		m.errorf("Refusing to apply %d pending migrations on start, at most %d are allowed", pending, options.MaxPending)
+		return fail(TooManyPending)
+	}
+
+	if _, ok := adapter.(MigrationLocker); !ok {
+		m.warnf("Adapter does not support the migration lock table, migrating without it")
+		return m.MigrateWithResult(ctx)
+	}
+	result, err = m.MigrateLeader(ctx)
+	if err == nil && result.Outcome == RunLockHeld {
+		result.Error = MigrationLocked.Error()
+		return result, MigrationLocked
+	}
+	return result, err
+}
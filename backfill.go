@@ -0,0 +1,194 @@
+// Applies data migrations in chunks of keys, each in its own
+// transaction.
+
+package gomigrate
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Implemented by adapters that can record the progress of backfill
+// migrations in the gomigrate_backfill table, so an interrupted backfill
+// resumes after the last chunk it committed.
+type BackfillRecorder interface {
+	// Creates the gomigrate_backfill table when it doesn't exist.
+	CreateBackfillTableSql() string
+
+	// Selects last_key and rows_affected of a migration id.
+	SelectBackfillSql() string
+
+	// Inserts migration_id, last_key, rows_affected and updated_at_ns,
+	// in that order.
+	InsertBackfillSql() string
+
+	// Sets last_key, rows_affected and updated_at_ns of a migration id,
+	// given in that order.
+	UpdateBackfillSql() string
+
+	// Deletes the row of a migration id.
+	DeleteBackfillSql() string
+}
+
+// The options of a backfill migration, declared in its up file with:
+//
+//	-- gomigrate: backfill table=orders key=id chunk=10000
+//
+// The up file holds a single statement taking the range of keys of a
+// chunk as its two parameters, the key after which it starts and the
+// last key it includes:
+//
+//	UPDATE orders SET total = price * quantity WHERE id > $1 AND id <= $2
+type Backfill struct {
+	// The table updated and its integer key.
+	Table string
+	Key   string
+
+	// The number of keys updated per transaction. Defaults to
+	// DefaultChunkSize.
+	ChunkSize int64
+}
+
+// The number of keys backfill migrations update per transaction when
+// they don't declare a chunk size.
+const DefaultChunkSize = 1000
+
+// Parses the value of a backfill directive.
+func parseBackfill(value string) (*Backfill, error) {
+	backfill := &Backfill{ChunkSize: DefaultChunkSize}
+	for _, pair := range strings.Fields(value) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid backfill option %q, expected key=value", pair)
+		}
+		switch parts[0] {
+		case "table":
+			backfill.Table = parts[1]
+		case "key":
+			backfill.Key = parts[1]
+		case "chunk":
+			size, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil || size <= 0 {
+				return nil, fmt.Errorf("Invalid backfill chunk size %q", parts[1])
+			}
+			backfill.ChunkSize = size
+		default:
+			return nil, fmt.Errorf("Unknown backfill option %q", parts[0])
+		}
+	}
+	if backfill.Table == "" || backfill.Key == "" {
+		return nil, fmt.Errorf("Backfill requires a table and a key")
+	}
+	return backfill, nil
+}
+
+// Runs the statement of a backfill migration over the keys of its table
+// in chunks of ChunkSize keys, each committed in its own transaction
+// along with the last key it covered. A backfill interrupted by a
+// failure resumes after the last committed chunk when the migration is
+// applied again. Keys added after the backfill starts aren't covered.
+// Returns the total rows affected.
+func (m *run) backfill(migration *Migration, statement string) (sql.Result, error) {
+	recorder, ok := m.dbAdapter.(BackfillRecorder)
+	if !ok {
+		m.warnf("Adapter does not support backfill migrations")
+		return nil, BackfillUnsupported
+	}
+	backfill := migration.Backfill
+	if _, err := m.executor.Exec(recorder.CreateBackfillTableSql()); err != nil {
+		m.errorf("Error creating backfill table: %v", err)
+		return nil, err
+	}
+
+	var first, last sql.NullInt64
+	query := fmt.Sprintf("SELECT MIN(%s), MAX(%s) FROM %s", backfill.Key, backfill.Key, backfill.Table)
+	if err := m.executor.QueryRow(query).Scan(&first, &last); err != nil {
+		m.errorf("Error reading the keys of %s: %v", backfill.Table, err)
+		return nil, err
+	}
+	if !first.Valid {
+		m.infof("Nothing to backfill for migration %d: %s is empty", migration.Id, backfill.Table)
+		return driver.RowsAffected(0), m.clearBackfill(recorder, migration)
+	}
+
+	start, rows, resumed := first.Int64-1, int64(0), false
+	switch err := m.executor.QueryRow(recorder.SelectBackfillSql(), migration.Id).Scan(&start, &rows); {
+	case err == nil:
+		resumed = true
+		m.infof("Resuming backfill of migration %d after key %d", migration.Id, start)
+	case err != sql.ErrNoRows:
+		m.errorf("Error reading the progress of migration %d: %v", migration.Id, err)
+		return nil, err
+	}
+
+	for start < last.Int64 {
+		end := start + backfill.ChunkSize
+		if end > last.Int64 {
+			end = last.Int64
+		}
+		if err := m.waitForReplicas(migration); err != nil {
+			return nil, err
+		}
+		chunkStart := m.now()
+		affected, err := m.backfillChunk(recorder, migration, statement, start, end, rows, resumed)
+		if err != nil {
+			m.errorf("Error backfilling keys %d to %d of migration %d: %v", start+1, end, migration.Id, err)
+			return nil, err
+		}
+		start, rows, resumed = end, rows+affected, true
+		m.infof(
+			"Backfill of migration %d: %d%% of keys of %s, %d rows affected",
+			migration.Id,
+			(end-first.Int64+1)*100/(last.Int64-first.Int64+1),
+			backfill.Table,
+			rows,
+		)
+		if start < last.Int64 {
+			m.throttle(migration, affected, m.now().Sub(chunkStart))
+		}
+	}
+	return driver.RowsAffected(rows), m.clearBackfill(recorder, migration)
+}
+
+// Updates the keys after start up to end in a transaction recording the
+// progress of the backfill. Returns the rows affected.
+func (m *run) backfillChunk(recorder BackfillRecorder, migration *Migration, statement string, start, end, rows int64, recorded bool) (int64, error) {
+	transaction, err := m.executor.BeginTx(m.TxOptions)
+	if err != nil {
+		return 0, err
+	}
+	rollback := func(err error) (int64, error) {
+		if rollbackErr := transaction.Rollback(); rollbackErr != nil {
+			m.errorf("Error rolling back transaction: %v", rollbackErr)
+		}
+		return 0, err
+	}
+	result, err := secretExecutor{transaction, m.Migrator}.Exec(statement, start, end)
+	if err != nil {
+		return rollback(err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return rollback(err)
+	}
+	progress, args := recorder.InsertBackfillSql(), []interface{}{migration.Id, end, rows + affected, m.now().UnixNano()}
+	if recorded {
+		progress, args = recorder.UpdateBackfillSql(), []interface{}{end, rows + affected, m.now().UnixNano(), migration.Id}
+	}
+	if _, err := transaction.Exec(progress, args...); err != nil {
+		return rollback(err)
+	}
+	return affected, transaction.Commit()
+}
+
+// Deletes the progress of a finished backfill.
+func (m *run) clearBackfill(recorder BackfillRecorder, migration *Migration) error {
+	if _, err := m.executor.Exec(recorder.DeleteBackfillSql(), migration.Id); err != nil {
+		m.errorf("Error clearing the progress of migration %d: %v", migration.Id, err)
+		return err
+	}
+	return nil
+}
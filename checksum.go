@@ -0,0 +1,146 @@
+// Records checksums of applied migrations.
+
+package gomigrate
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"database/sql"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+)
+
+// Computes the checksums of migration files. The name is recorded with
+// each checksum, so applied migrations are compared with the algorithm
+// that recorded them after Migrator.ChecksumAlgorithm changes.
+type ChecksumAlgorithm interface {
+	// Names the algorithm in the migrations table, up to 32 characters.
+	Name() string
+
+	// Returns the checksum of data as up to 128 characters, such as a
+	// hex digest.
+	Sum(data []byte) string
+}
+
+// Checksum algorithms provided by the package. SHA-256 is the default.
+var (
+	ChecksumSha256 = NewChecksumAlgorithm("sha256", sha256.New)
+	ChecksumSha512 = NewChecksumAlgorithm("sha512", sha512.New)
+	ChecksumCrc32  = NewChecksumAlgorithm("crc32", func() hash.Hash { return crc32.NewIEEE() })
+)
+
+type hashChecksum struct {
+	name    string
+	newHash func() hash.Hash
+}
+
+// Returns a ChecksumAlgorithm recording the hex digests of a hash,
+// such as one from a FIPS validated module.
+func NewChecksumAlgorithm(name string, newHash func() hash.Hash) ChecksumAlgorithm {
+	return hashChecksum{name: name, newHash: newHash}
+}
+
+func (h hashChecksum) Name() string {
+	return h.name
+}
+
+func (h hashChecksum) Sum(data []byte) string {
+	hash := h.newHash()
+	hash.Write(data)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Implemented by adapters that record the checksum of the up file of
+// applied migrations.
+type MigrationChecksumRecorder interface {
+	// Sets checksum and checksum_algorithm for a migration id, in that
+	// order.
+	MigrationChecksumUpdateSql() string
+
+	// Selects checksum and checksum_algorithm for a migration id.
+	MigrationChecksumSelectSql() string
+}
+
+// Implemented by adapters enforcing the length of the checksum column,
+// which is widened for digests longer than SHA-256's.
+type ChecksumColumnWidener interface {
+	WidenChecksumSql() string
+}
+
+// Returns the algorithm checksums are recorded with.
+func (m *Migrator) checksumAlgorithm() ChecksumAlgorithm {
+	if m.ChecksumAlgorithm == nil {
+		return ChecksumSha256
+	}
+	return m.ChecksumAlgorithm
+}
+
+// Returns the algorithm named in the migrations table, or nil when it
+// is unknown. Checksums recorded without an algorithm are SHA-256.
+func (m *Migrator) checksumAlgorithmNamed(name string) ChecksumAlgorithm {
+	if name == "" {
+		name = ChecksumSha256.Name()
+	}
+	for _, algorithm := range []ChecksumAlgorithm{m.checksumAlgorithm(), ChecksumSha256, ChecksumSha512, ChecksumCrc32} {
+		if algorithm.Name() == name {
+			return algorithm
+		}
+	}
+	return nil
+}
+
+// Returns the checksum of the up file of a migration. Encrypted files
+// are decrypted first.
+func (m *Migrator) checksum(migration *Migration, algorithm ChecksumAlgorithm) (string, error) {
+	data, err := m.readMigrationFile(migration.UpPath)
+	if err != nil {
+		return "", err
+	}
+	return algorithm.Sum(data), nil
+}
+
+// Records the checksum of an applied migration in the transaction it
+// runs in.
+func (m *Migrator) recordChecksum(transaction TxExecutor, migration *Migration) error {
+	recorder, ok := m.dbAdapter.(MigrationChecksumRecorder)
+	if !ok || m.tableVersion < 6 {
+		return nil
+	}
+	algorithm := m.checksumAlgorithm()
+	checksum, err := m.checksum(migration, algorithm)
+	if err != nil {
+		return err
+	}
+	if _, err := transaction.Exec(recorder.MigrationChecksumUpdateSql(), checksum, algorithm.Name(), migration.Id); err != nil {
+		m.errorf("Error logging migration: %v", err)
+		return err
+	}
+	m.mu.Lock()
+	migration.Checksum = checksum
+	migration.ChecksumAlgorithm = algorithm.Name()
+	m.mu.Unlock()
+	return nil
+}
+
+// Loads the recorded checksum of an applied migration. Migrations
+// applied before checksums were recorded are left without one.
+func (m *run) getMigrationChecksum(migration *Migration) error {
+	recorder, ok := m.dbAdapter.(MigrationChecksumRecorder)
+	if !ok || m.tableVersion < 6 {
+		return nil
+	}
+
+	var checksum, algorithm sql.NullString
+	row := m.executor.QueryRow(recorder.MigrationChecksumSelectSql(), migration.Id)
+	if err := row.Scan(&checksum, &algorithm); err != nil {
+		m.errorf("Error getting migration checksum for %s: %v", migration.Name, err)
+		return err
+	}
+	migration.Checksum = checksum.String
+	migration.ChecksumAlgorithm = algorithm.String
+	if migration.Checksum != "" && migration.ChecksumAlgorithm == "" {
+		migration.ChecksumAlgorithm = ChecksumSha256.Name()
+	}
+	return nil
+}
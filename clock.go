@@ -0,0 +1,74 @@
+// Abstracts the current time and the ids of new migrations.
+
+package gomigrate
+
+import (
+	"strconv"
+	"time"
+)
+
+// Tells the current time. Durations, notification times and recorded
+// statements use the migrator's clock.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Returns the current time according to the migrator's clock.
+func (m *Migrator) now() time.Time {
+	if m.Clock == nil {
+		return systemClock{}.Now()
+	}
+	return m.Clock.Now()
+}
+
+// Chooses the id of a new migration given the ids of the existing ones.
+type IdGenerator interface {
+	NextId(existing []uint64) uint64
+}
+
+// Numbers migrations sequentially, following the highest existing id.
+type SequentialIds struct{}
+
+func (SequentialIds) NextId(existing []uint64) uint64 {
+	return highestId(existing) + 1
+}
+
+// Numbers migrations with the current UTC time formatted with Layout,
+// which defaults to 20060102150405. Returns the id following the
+// highest existing one when the time isn't higher, so ids stay ordered.
+type TimestampIds struct {
+	Clock  Clock
+	Layout string
+}
+
+func (t TimestampIds) NextId(existing []uint64) uint64 {
+	clock, layout := t.Clock, t.Layout
+	if clock == nil {
+		clock = systemClock{}
+	}
+	if layout == "" {
+		layout = "20060102150405"
+	}
+	highest := highestId(existing)
+	id, err := strconv.ParseUint(clock.Now().UTC().Format(layout), 10, 64)
+	if err != nil || id <= highest {
+		return highest + 1
+	}
+	return id
+}
+
+func highestId(ids []uint64) uint64 {
+	var highest uint64
+	for _, id := range ids {
+		if id > highest {
+			highest = id
+		}
+	}
+	return highest
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/DavidHuie/gomigrate"
+	"gopkg.in/yaml.v3"
+)
+
+// Prints an approval of a migration as an entry of an approvals file,
+// signed with the key of the approver when -key is given.
+func approve(migrator *gomigrate.Migrator, args []string) error {
+	flags := flag.NewFlagSet("approve", flag.ContinueOnError)
+	by := flags.String("by", os.Getenv("USER"), "name of the approver")
+	keyPath := flags.String("key", "", "file holding the approver's private key, as written by approval-key")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("expected a migration id")
+	}
+	id, err := strconv.ParseUint(flags.Arg(0), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid migration id: %s", flags.Arg(0))
+	}
+	approval, err := migrator.Approve(id, *by)
+	if err != nil {
+		return err
+	}
+	if *keyPath != "" {
+		key, err := readPrivateKey(*keyPath)
+		if err != nil {
+			return err
+		}
+		approval.Sign(key)
+	}
+	return yaml.NewEncoder(os.Stdout).Encode([]*gomigrate.Approval{approval})
+}
+
+// Reads a private key written by approval-key.
+func readPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid private key: %s", path)
+	}
+	return key, nil
+}
+
+// Writes a new private key signing approvals and migration files to
+// path and prints the public key as an entry of the approvers or
+// signers file.
+func approvalKey(path string) error {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(private)+"\n"), 0600); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "%s: %s\n", os.Getenv("USER"), base64.StdEncoding.EncodeToString(public))
+	return nil
+}
+
+// Reads public keys by name, such as those of the approvers, from a
+// file written as "name: key" lines.
+func readPublicKeys(path string) (map[string]ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	encoded := make(map[string]string)
+	if err := yaml.Unmarshal(data, &encoded); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]ed25519.PublicKey, len(encoded))
+	for name, value := range encoded {
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key of %s", name)
+		}
+		keys[name] = key
+	}
+	return keys, nil
+}
+
+// Signs every file of -dir with the key given with -key, writing a
+// detached signature next to each, or the manifest given with
+// -checksum-manifest.
+func sign(args []string) error {
+	flags := flag.NewFlagSet("sign", flag.ContinueOnError)
+	keyPath := flags.String("key", "", "file holding the private key, as written by approval-key")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *keyPath == "" {
+		return errors.New("expected -key")
+	}
+	key, err := readPrivateKey(*keyPath)
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0)
+	err = filepath.WalkDir(*dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		if strings.HasSuffix(path, ".sig") || *sumsFile != "" && filepath.Clean(path) == filepath.Clean(*sumsFile) {
+			return nil
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if *sumsFile != "" {
+		return gomigrate.SignManifest(key, *sumsFile, paths)
+	}
+	return gomigrate.SignFiles(key, paths)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+
+	"github.com/DavidHuie/gomigrate"
+)
+
+// Sets the flags that weren't given on the command line from an
+// environment of the configuration file. The environment also selects
+// the migrations restricted to it unless -env is given.
+func applyConfig(path, name string) error {
+	config, err := gomigrate.LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	environment, err := config.Environment(name)
+	if err != nil {
+		return err
+	}
+	dsn, err := environment.DataSourceName()
+	if err != nil {
+		return err
+	}
+
+	values := map[string]string{
+		"driver":       environment.Driver,
+		"dsn":          dsn,
+		"dir":          environment.Dir,
+		"track":        environment.Track,
+		"track-table":  environment.Table,
+		"out-of-order": environment.OutOfOrderPolicy,
+		"gaps":         environment.GapPolicy,
+		"approvals":    environment.Approvals,
+		"approvers":    environment.Approvers,
+		"env":          name,
+	}
+	bools := map[string]bool{
+		"split-dirs":         environment.SplitDirs,
+		"allow-missing-down": environment.AllowMissingDown,
+		"production":         environment.Production,
+		"require-writable":   environment.RequireWritable,
+		"require-privileges": environment.RequirePrivileges,
+		"validate-first":     environment.ValidateFirst,
+		"create-extensions":  environment.CreateExtensions,
+		"protected":          environment.Protected,
+	}
+	for flagName, value := range bools {
+		if value {
+			values[flagName] = strconv.FormatBool(value)
+		}
+	}
+
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	for flagName, value := range values {
+		if value == "" || given[flagName] {
+			continue
+		}
+		if err := flag.Set(flagName, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
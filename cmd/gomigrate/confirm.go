@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Queries returning the name of the connected database, by driver.
+var databaseNameSql = map[string]string{
+	"postgres": "SELECT current_database()",
+	"mysql":    "SELECT DATABASE()",
+}
+
+var (
+	errNotConfirmed = errors.New("Confirmation did not match the database name")
+	errNoTerminal   = errors.New("Refusing to run a destructive command on production without a terminal; use -yes")
+)
+
+// Asks the operator to type the database name before a destructive
+// command runs against a production target. Nothing is asked when the
+// target isn't flagged as production or when -yes is given.
+func confirmDestructive(db *sql.DB, cmd string) error {
+	if !*production || *yes {
+		return nil
+	}
+	if !isTerminal(os.Stdin) {
+		return errNoTerminal
+	}
+
+	name, err := databaseName(db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Running %q against production database %q.\n", cmd, name)
+	fmt.Fprint(os.Stderr, "Type the database name to confirm: ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(answer) != name {
+		return errNotConfirmed
+	}
+	return nil
+}
+
+// Returns the name of the connected database.
+func databaseName(db *sql.DB) (string, error) {
+	query, ok := databaseNameSql[*driver]
+	if !ok {
+		return "main", nil
+	}
+	var name string
+	if err := db.QueryRow(query).Scan(&name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// Returns true if f is attached to a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/DavidHuie/gomigrate"
+)
+
+// Applies an up file to a scratch database and writes the generated
+// down file next to it. Existing down files are never overwritten.
+func generateDown(db *sql.DB, adapter gomigrate.Migratable, upPath string) error {
+	if !strings.HasSuffix(upPath, "_up.sql") {
+		return gomigrate.InvalidMigrationFile
+	}
+	downPath := strings.TrimSuffix(upPath, "_up.sql") + "_down.sql"
+	if _, err := os.Stat(downPath); err == nil {
+		return fmt.Errorf("Down file already exists: %s", downPath)
+	}
+
+	up, err := ioutil.ReadFile(upPath)
+	if err != nil {
+		return err
+	}
+	down, err := gomigrate.GenerateDown(db, adapter, string(up))
+	if err != nil {
+		return err
+	}
+
+	header := "-- Generated from the schema changes of " + upPath + ". Review before use.\n"
+	if err := ioutil.WriteFile(downPath, []byte(header+down), 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s\n", downPath)
+	return nil
+}
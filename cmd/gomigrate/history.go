@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/DavidHuie/gomigrate"
+)
+
+// Prints every recorded application of a migration as JSON or CSV.
+func history(migrator *gomigrate.Migrator, args []string) error {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	format := flags.String("format", "json", "output format: json or csv")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	entries, err := migrator.History()
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	case "csv":
+		return writeHistoryCsv(entries)
+	default:
+		return fmt.Errorf("invalid format: %s", *format)
+	}
+}
+
+// Writes a row per entry, with a column per custom column after the
+// recorded fields.
+func writeHistoryCsv(entries []*gomigrate.HistoryEntry) error {
+	custom := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		for name := range entry.Columns {
+			if !seen[name] {
+				seen[name] = true
+				custom = append(custom, name)
+			}
+		}
+	}
+	sort.Strings(custom)
+
+	w := csv.NewWriter(os.Stdout)
+	header := []string{
+		"id", "name", "description", "author", "ticket", "applied_at", "duration_ms", "statement_count",
+		"checksum", "checksum_algorithm", "skip_reason", "applied_by",
+		"host", "application", "library_version", "build", "runner",
+	}
+	w.Write(append(header, custom...))
+	for _, entry := range entries {
+		appliedAt := ""
+		if !entry.AppliedAt.IsZero() {
+			appliedAt = entry.AppliedAt.UTC().Format(time.RFC3339)
+		}
+		record := []string{
+			strconv.FormatUint(entry.Id, 10),
+			entry.Name,
+			entry.Description,
+			entry.Author,
+			entry.Ticket,
+			appliedAt,
+			strconv.FormatInt(entry.DurationMs, 10),
+			strconv.Itoa(entry.StatementCount),
+			entry.Checksum,
+			entry.ChecksumAlgorithm,
+			entry.SkipReason,
+			entry.AppliedBy,
+			entry.Host,
+			entry.Application,
+			entry.LibraryVersion,
+			entry.Build,
+			entry.Runner,
+		}
+		for _, name := range custom {
+			record = append(record, entry.Columns[name])
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/DavidHuie/gomigrate"
+)
+
+var errLintFailed = errors.New("Pending migrations contain risky statements")
+
+// Prints the lint issues of pending migrations. Fails if any issue has
+// error severity.
+func lint(migrator *gomigrate.Migrator) error {
+	issues, err := migrator.Lint()
+	if err != nil {
+		return err
+	}
+
+	failed := false
+	for _, issue := range issues {
+		level := "warning"
+		if issue.Severity == gomigrate.LintError {
+			level = "error"
+			failed = true
+		}
+		fmt.Fprintf(
+			os.Stdout,
+			"%s: %s: %s (%s)\n\t%s\n",
+			issue.Migration.UpPath,
+			level,
+			issue.Message,
+			issue.Rule,
+			issue.Statement,
+		)
+	}
+	if failed {
+		return errLintFailed
+	}
+	return nil
+}
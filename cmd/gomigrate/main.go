@@ -0,0 +1,668 @@
+// Command gomigrate applies and rolls back migrations from the command
+// line.
+//
+// Usage:
+//
+//	gomigrate -driver postgres -dsn "dbname=app sslmode=disable" -dir ./migrations up
+//	gomigrate -e staging up
+//	gomigrate -driver postgres -dsn "..." -dir ./migrations leader
+//	gomigrate -driver postgres -dsn "..." -dir ./migrations down [n]
+//	gomigrate -driver postgres -dsn "..." -dir ./migrations down-to <id>
+//	gomigrate -driver postgres -dsn "..." -dir ./migrations down-all
+//	gomigrate -driver postgres -dsn "..." -dir ./migrations squash <id> <name>
+//	gomigrate -driver postgres -dsn "..." -dir ./migrations new <name>
+//	gomigrate -driver postgres -dsn "..." -dir ./migrations scaffold <pattern> <key>=<value>...
+//	gomigrate -dir ./migrations renumber <id>[_<name>]=<new id>...
+//	gomigrate -driver postgres -dsn "..." -dir ./migrations unlock [owner]
+//	gomigrate -driver postgres -dsn "..." -dir ./migrations heartbeats
+//	gomigrate -driver postgres -dsn "..." -dir ./migrations status
+//	gomigrate -driver postgres -dsn "..." -dir ./migrations history [-format json|csv]
+//	gomigrate -driver postgres -dsn "..." -dir ./migrations export-state|import-state <file>
+//	gomigrate -driver postgres -dsn "..." -dir ./migrations preflight
+//	gomigrate -driver postgres -dsn "..." -dir ./migrations privileges
+//	gomigrate -driver postgres -dsn "..." -dir ./migrations [-explain] plan up|down [target]
+//	gomigrate -driver postgres -dsn "..." -dir ./migrations validate
+//	gomigrate -driver postgres -dsn "..." -dir ./migrations lint
+//	gomigrate -dir ./migrations approve [-by name] [-key file] <id>
+//	gomigrate approval-key <file>
+//	gomigrate -dir ./migrations sign -key <file>
+//	gomigrate -driver postgres -dsn "..." -dir ./migrations tui
+//	gomigrate -driver postgres -dsn "<scratch database>" gen-down <up file>
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/DavidHuie/gomigrate"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	driver     = flag.String("driver", "postgres", "database driver with a registered adapter: postgres, mysql or sqlite3")
+	dsn        = flag.String("dsn", "", "data source name of the target database")
+	dir        = flag.String("dir", "./migrations", "directory containing the migration files")
+	production = flag.Bool("production", false, "mark the target as a production database")
+	yes        = flag.Bool("yes", false, "skip confirmation of destructive commands")
+	outOfOrder = flag.String("out-of-order", "warn", "pending migrations older than applied ones: ignore, warn or fail")
+	writable   = flag.Bool("require-writable", false, "refuse to run against read-only databases such as replicas")
+	needPrivs  = flag.Bool("require-privileges", false, "check the privileges pending migrations need before up applies any")
+	upOnly     = flag.Bool("allow-missing-down", false, "accept migrations without a down file")
+	splitDirs  = flag.Bool("split-dirs", false, "keep up and down files in up and down directories of -dir")
+	pattern    = flag.String("pattern", "", "names of the migration files if not 1_name_up.sql: dotted for 0001_name.up.sql or flyway for V1__name.sql")
+	strictName = flag.Bool("strict-names", false, "fail when a .sql file of -dir isn't named like a migration instead of skipping it")
+	recursive  = flag.Bool("recursive", false, "find migration files in the subdirectories of -dir too")
+	groupBy    = flag.String("group", "", "time layout of the subdirectory new creates migrations in with -recursive, such as 2006/01")
+	gaps       = flag.String("gaps", "ignore", "holes in the migration numbering: ignore, warn or fail")
+	env        = flag.String("env", "", "environment of the target, for migrations restricted with only directives")
+	tags       = flag.String("tags", "", "comma separated tags; up only applies migrations with one of them")
+	skipTags   = flag.String("skip-tags", "", "comma separated tags; up doesn't apply migrations with any of them")
+	waitWindow = flag.Bool("wait-windows", false, "keep up running until the windows of the migrations it defers open and apply them")
+	delay      = flag.Duration("throttle-delay", 0, "sleep this long between the statements of data migrations")
+	maxLag     = flag.Duration("max-replication-lag", 0, "pause data migrations while replicas lag further behind, measured with pg_stat_replication")
+	maxPause   = flag.Duration("max-replication-pause", 0, "fail data migrations paused this long for lagging replicas, an hour by default")
+	maxRows    = flag.Int64("max-rows-per-second", 0, "sleep between the statements of data migrations to average at most this many affected rows per second")
+	phase      = flag.String("phase", "", "only apply migrations of a phase with up: schema before deploying the application, data after, or post once it is rolled out")
+	parallel   = flag.Int("parallel", 1, "number of independent migrations to apply at once")
+	keyFile    = flag.String("key-file", "", "file holding the hex-encoded AES key of .sql.enc migrations")
+	logLevel   = flag.String("log-level", "info", "least severe messages logged: debug, info, warn or error")
+	analyze    = flag.Bool("analyze", false, "analyze the tables each migration changed once it commits")
+	vacuum     = flag.Bool("vacuum", false, "with -analyze, vacuum the tables where migrations updated or deleted rows first")
+	recordSql  = flag.Bool("record-sql", false, "record the executed statements in the gomigrate_sql table")
+	lock       = flag.Duration("lock", 0, "wait up to this long for other runners holding the gomigrate_lock table")
+	heartbeat  = flag.Duration("heartbeat", 0, "record running migrations in gomigrate_heartbeat and refresh the lock this often")
+	lockWait   = flag.String("lock-wait", "", "how to wait for the gomigrate_lock table: timeout, forever or none")
+	detailed   = flag.Bool("detailed-exit-codes", false, "exit up with 3 when up to date, 4 when locked and 5 when a migration failed")
+	progress   = flag.Bool("progress", false, "log the share of each migration executed and the estimated time left after every statement")
+	resultJson = flag.Bool("result", false, "print the outcome of up and leader as JSON")
+	skip       = make(skipList)
+	vars       = make(templateVars)
+	windows    = make(windowList)
+	strictVars = flag.Bool("strict-templates", false, "fail .sql.tmpl migrations referring to a variable without a -var")
+	timestamps = flag.Bool("timestamp-ids", false, "number migrations created with new by the current UTC time")
+	track      = flag.String("track", "", "keep the migrations table of another tool instead: goose or golang-migrate")
+	trackTable = flag.String("track-table", "", "name of the table kept with -track, if not the default of the tool")
+	configFile = flag.String("config", gomigrate.ConfigFileName, "configuration file read for the environment given with -e")
+	envName    = flag.String("e", "", "environment of the configuration file whose settings are used for flags not given")
+	runner     = flag.String("runner", "", "identity recorded with applied migrations, such as a CI job; defaults to host and pid")
+	checksum   = flag.String("checksum", "sha256", "algorithm of the checksums recorded with applied migrations: sha256, sha512 or crc32")
+	printSql   = flag.Bool("renumber-sql", false, "print the SQL fixing ids recorded before renumber, including gomigrate_sql with -record-sql")
+	precheck   = flag.Bool("validate-first", false, "check every pending migration before up applies any")
+	minVersion = flag.String("min-server-version", "", "lowest database server version preflight accepts, such as 14.2")
+	extensions = flag.String("extensions", "", "comma separated extensions preflight requires")
+	createExts = flag.Bool("create-extensions", false, "create the extensions pending migrations require with requires-extension when missing")
+	force      = flag.Bool("force", false, "roll back migrations declared irreversible by running their down files")
+	explain    = flag.Bool("explain", false, "estimate the cost of the statements changing data in plan up with the database's EXPLAIN")
+	dryRun     = flag.Bool("dry-run", false, "print the down files down, down-to and down-all would run without running them")
+	protected  = flag.Bool("protected", false, "only apply migrations approved in the -approvals file")
+	approvals  = flag.String("approvals", "", "approvals file of migrations for -protected targets")
+	approvers  = flag.String("approvers", "", "file of the approvers' public keys; approvals must be signed when given")
+	signers    = flag.String("signers", "", "file of public keys; migration files must carry a .sig signature by one of them")
+	sumsFile   = flag.String("checksum-manifest", "", "signed manifest of the checksums of migration files, checked with -signers instead of .sig files")
+	shadowDsn  = flag.String("shadow-dsn", "", "data source name of a disposable database up applies the pending migrations to before the target")
+	shadowName = flag.String("shadow-create", "", "with -shadow-dsn, name of the shadow database to create on the target's server and drop afterwards")
+	shadowCopy = flag.String("shadow-template", "", "with -shadow-create, database the shadow database is created as a copy of")
+)
+
+var lockWaits = map[string]int{
+	"timeout": gomigrate.LockWaitTimeout,
+	"forever": gomigrate.LockWaitForever,
+	"none":    gomigrate.LockNoWait,
+}
+
+// Exit codes of the leader command, and of up with
+// -detailed-exit-codes, by outcome.
+var exitCodes = map[gomigrate.RunOutcome]int{
+	gomigrate.RunApplied:         0,
+	gomigrate.RunError:           1,
+	gomigrate.RunUpToDate:        3,
+	gomigrate.RunLockHeld:        4,
+	gomigrate.RunMigrationFailed: 5,
+}
+
+var checksums = map[string]gomigrate.ChecksumAlgorithm{
+	"sha256": gomigrate.ChecksumSha256,
+	"sha512": gomigrate.ChecksumSha512,
+	"crc32":  gomigrate.ChecksumCrc32,
+}
+
+var policies = map[string]int{
+	"ignore": gomigrate.PolicyIgnore,
+	"warn":   gomigrate.PolicyWarn,
+	"fail":   gomigrate.PolicyFail,
+}
+
+var patterns = map[string]*gomigrate.FilenamePattern{
+	"dotted": gomigrate.DottedPattern,
+	"flyway": gomigrate.FlywayPattern,
+}
+
+func init() {
+	flag.Var(skip, "skip", "id=reason of a migration to record as applied without running it; repeatable")
+	flag.Var(vars, "var", "name=value available to .sql.tmpl migrations as {{ .name }}; repeatable")
+	flag.Var(windows, "window", "name=cron fields of a window that migrations declaring window=name are deferred to, such as off-peak=\"* 22-23,0-5 * * *\"; repeatable")
+}
+
+// Reasons for migrations to skip by id, from repeated -skip flags.
+type skipList map[uint64]string
+
+func (s skipList) String() string {
+	return fmt.Sprint(map[uint64]string(s))
+}
+
+func (s skipList) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil || len(parts) != 2 {
+		return fmt.Errorf("expected id=reason: %s", value)
+	}
+	s[id] = parts[1]
+	return nil
+}
+
+// Values of .sql.tmpl migrations by name, from repeated -var flags.
+type templateVars map[string]interface{}
+
+func (v templateVars) String() string {
+	return fmt.Sprint(map[string]interface{}(v))
+}
+
+func (v templateVars) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("expected name=value: %s", value)
+	}
+	v[parts[0]] = parts[1]
+	return nil
+}
+
+// Scheduling windows by name, from repeated -window flags.
+type windowList map[string]*gomigrate.Window
+
+func (w windowList) String() string {
+	return fmt.Sprint(map[string]*gomigrate.Window(w))
+}
+
+func (w windowList) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("expected name=cron fields: %s", value)
+	}
+	window, err := gomigrate.ParseWindow(parts[1])
+	if err != nil {
+		return err
+	}
+	w[parts[0]] = window
+	return nil
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: gomigrate [flags] up|leader|down [n]|down-to <id>|down-all|squash <id> <name>|new <name>|scaffold <pattern> <key>=<value>...|renumber <id>[_<name>]=<new id>...|unlock [owner]|heartbeats|status|history [-format json|csv]|export-state <file>|import-state <file>|plan up|down [target]|validate|preflight|privileges|lint|approve [-by name] [-key file] <id>|approval-key <file>|sign -key <file>|tui|gen-down <up file>\n\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	if *envName != "" {
+		if err := applyConfig(*configFile, *envName); err != nil {
+			logger.Fatalf("Error reading environment %s of %s: %v", *envName, *configFile, err)
+		}
+	}
+	level, err := gomigrate.ParseLogLevel(*logLevel)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	leveled := &gomigrate.LeveledLogger{Logger: logger, Level: level}
+
+	adapter, err := gomigrate.NewAdapter(*driver)
+	if err != nil {
+		logger.Fatalf("Unsupported driver %s, adapters are registered for: %s", *driver, strings.Join(gomigrate.Adapters(), ", "))
+	}
+	switch *track {
+	case "":
+	case "goose":
+		adapter = gomigrate.GooseTable{Adapter: adapter, Table: *trackTable}
+	case "golang-migrate":
+		adapter = gomigrate.GolangMigrateTable{Adapter: adapter, Table: *trackTable}
+	default:
+		logger.Fatalf("Invalid migrations table: %s", *track)
+	}
+	db, err := sql.Open(*driver, *dsn)
+	if err != nil {
+		logger.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	// Generating a down file works on a scratch database and doesn't
+	// need a migrator.
+	if flag.Arg(0) == "gen-down" {
+		if flag.NArg() != 2 {
+			usage()
+			os.Exit(2)
+		}
+		if err := generateDown(db, adapter, flag.Arg(1)); err != nil {
+			logger.Fatalf("Error generating down migration: %v", err)
+		}
+		return
+	}
+
+	// Approval keys are used away from the database.
+	if flag.Arg(0) == "approval-key" {
+		if flag.NArg() != 2 {
+			usage()
+			os.Exit(2)
+		}
+		if err := approvalKey(flag.Arg(1)); err != nil {
+			logger.Fatalf("Error generating approval key: %v", err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "sign" {
+		if err := sign(flag.Args()[1:]); err != nil {
+			logger.Fatalf("Error signing migrations: %v", err)
+		}
+		return
+	}
+
+	// Renumbering only touches the migration files, which may not load
+	// while ids are duplicated.
+	if flag.Arg(0) == "renumber" {
+		if flag.NArg() < 2 {
+			usage()
+			os.Exit(2)
+		}
+		if err := renumber(leveled, flag.Args()[1:]); err != nil {
+			logger.Fatalf("Error renumbering migrations: %v", err)
+		}
+		return
+	}
+
+	// Commands that only report on the migrations don't create the
+	// migrations table.
+	source := &gomigrate.FileMigrationSource{
+		Dir:              *dir,
+		AllowMissingDown: *upOnly,
+		SplitDirs:        *splitDirs,
+		Recursive:        *recursive,
+		GroupLayout:      *groupBy,
+		StrictNames:      *strictName,
+	}
+	if *pattern != "" {
+		var ok bool
+		if source.Pattern, ok = patterns[*pattern]; !ok {
+			logger.Fatalf("Invalid filename pattern: %s", *pattern)
+		}
+	}
+	if *keyFile != "" {
+		key, err := readKey(*keyFile)
+		if err != nil {
+			logger.Fatalf("Error reading key: %v", err)
+		}
+		source.KeyProvider = key
+	}
+	var migrator *gomigrate.Migrator
+	switch flag.Arg(0) {
+	case "status", "history", "export-state", "plan", "validate", "privileges", "lint", "new", "scaffold", "heartbeats", "approve":
+		migrator, err = gomigrate.NewReadOnlyMigrator(db, adapter, source, leveled)
+	default:
+		migrator, err = gomigrate.NewMigratorWithLogger(db, adapter, source, leveled)
+	}
+	if duplicate, ok := err.(*gomigrate.DuplicateMigrationId); ok {
+		suggestRenumber(duplicate)
+	}
+	if err != nil {
+		logger.Fatalf("Error creating migrator: %v", err)
+	}
+	migrator.RequireWritable = *writable
+	migrator.RequirePrivileges = *needPrivs
+	migrator.ValidateFirst = *precheck
+	migrator.ForceRollback = *force
+	migrator.MinServerVersion = *minVersion
+	if *extensions != "" {
+		migrator.RequiredExtensions = strings.Split(*extensions, ",")
+	}
+	migrator.CreateExtensions = *createExts
+	migrator.Environment = *env
+	migrator.Protected = *protected
+	if *signers != "" {
+		keys, err := readPublicKeys(*signers)
+		if err != nil {
+			logger.Fatalf("Error reading signers: %v", err)
+		}
+		signatures := gomigrate.Ed25519Signatures{Manifest: *sumsFile}
+		for _, key := range keys {
+			signatures.Keys = append(signatures.Keys, key)
+		}
+		migrator.Signatures = signatures
+	}
+	if *approvals != "" {
+		if migrator.Approvals, err = gomigrate.LoadApprovals(*approvals); err != nil {
+			logger.Fatalf("Error reading approvals: %v", err)
+		}
+		if *approvers != "" {
+			if migrator.Approvals.Keys, err = readPublicKeys(*approvers); err != nil {
+				logger.Fatalf("Error reading approvers: %v", err)
+			}
+		}
+	}
+	migrator.Parallelism = *parallel
+	migrator.RecordSql = *recordSql
+	migrator.AnalyzeAfter = *analyze
+	migrator.VacuumAfter = *vacuum
+	migrator.Skip = skip
+	migrator.Windows = windows
+	if *shadowDsn != "" {
+		migrator.Shadow = &gomigrate.Shadow{Name: *shadowName, Template: *shadowCopy}
+		if *shadowName != "" {
+			migrator.Shadow.Open = func(string) (*sql.DB, error) {
+				return sql.Open(*driver, *shadowDsn)
+			}
+		} else if migrator.Shadow.DB, err = sql.Open(*driver, *shadowDsn); err != nil {
+			logger.Fatalf("Error opening shadow database: %v", err)
+		}
+	}
+	if *delay > 0 || *maxRows > 0 || *maxLag > 0 {
+		migrator.Throttle = &gomigrate.Throttle{Delay: *delay, RowsPerSecond: *maxRows, MaxLag: *maxLag, MaxPause: *maxPause}
+	}
+	if *progress {
+		migrator.Subscribe(func(e gomigrate.Event) {
+			if e, ok := e.(gomigrate.StatementExecuted); ok {
+				logger.Print(progressLine(e))
+			}
+		})
+	}
+	migrator.TemplateData = vars
+	migrator.StrictTemplates = *strictVars
+	migrator.Runner = *runner
+	migrator.LockTable = *lock > 0 || *lockWait != ""
+	migrator.LockTimeout = *lock
+	migrator.HeartbeatInterval = *heartbeat
+	if *timestamps {
+		migrator.IdGenerator = gomigrate.TimestampIds{}
+	}
+	var ok bool
+	if migrator.OutOfOrderPolicy, ok = policies[*outOfOrder]; !ok {
+		logger.Fatalf("Invalid policy: %s", *outOfOrder)
+	}
+	if migrator.GapPolicy, ok = policies[*gaps]; !ok {
+		logger.Fatalf("Invalid policy: %s", *gaps)
+	}
+	if migrator.ChecksumAlgorithm, ok = checksums[*checksum]; !ok {
+		logger.Fatalf("Invalid checksum algorithm: %s", *checksum)
+	}
+	if *lockWait != "" {
+		if migrator.LockWait, ok = lockWaits[*lockWait]; !ok {
+			logger.Fatalf("Invalid lock wait: %s", *lockWait)
+		}
+	}
+
+	ctx := cancelOnSignal(logger)
+
+	switch cmd := flag.Arg(0); cmd {
+	case "up", "leader":
+		var result *gomigrate.RunResult
+		if cmd == "up" && *waitWindow {
+			result, err = migrator.MigrateWindows(ctx, selection()...)
+		} else if cmd == "up" {
+			result, err = migrator.MigrateWithResult(ctx, selection()...)
+		} else {
+			result, err = migrator.MigrateLeader(ctx, selection()...)
+		}
+		if *resultJson {
+			json.NewEncoder(os.Stdout).Encode(result)
+		}
+		if cmd == "leader" || *detailed {
+			if err != nil {
+				logger.Printf("Error running %s: %v", cmd, err)
+			}
+			os.Exit(exitCodes[result.Outcome])
+		}
+	case "down":
+		n := 1
+		if flag.NArg() > 1 {
+			if n, err = strconv.Atoi(flag.Arg(1)); err != nil {
+				logger.Fatalf("Invalid number of migrations: %s", flag.Arg(1))
+			}
+		}
+		if *dryRun {
+			err = printPlan(migrator.PlanRollback(n))
+		} else if err = confirmDestructive(db, cmd); err == nil {
+			err = migrator.RollbackNContext(ctx, n)
+		}
+	case "down-to":
+		if flag.NArg() != 2 {
+			usage()
+			os.Exit(2)
+		}
+		var target uint64
+		if target, err = strconv.ParseUint(flag.Arg(1), 10, 64); err != nil {
+			logger.Fatalf("Invalid migration id: %s", flag.Arg(1))
+		}
+		if *dryRun {
+			err = printPlan(migrator.Plan(gomigrate.Down, target))
+		} else if err = confirmDestructive(db, cmd); err == nil {
+			err = migrator.RollbackToContext(ctx, target)
+		}
+	case "down-all":
+		if *dryRun {
+			err = printPlan(migrator.Plan(gomigrate.Down, 0))
+		} else if err = confirmDestructive(db, cmd); err == nil {
+			err = migrator.RollbackNContext(ctx, rollbackable(migrator))
+		}
+	case "status":
+		printStatus(migrator.Status())
+	case "history":
+		err = history(migrator, flag.Args()[1:])
+	case "export-state", "import-state":
+		if flag.NArg() != 2 {
+			usage()
+			os.Exit(2)
+		}
+		if flag.Arg(0) == "export-state" {
+			err = exportState(migrator, flag.Arg(1))
+		} else {
+			err = importState(migrator, flag.Arg(1))
+		}
+	case "plan":
+		if flag.NArg() < 2 || flag.NArg() > 3 {
+			usage()
+			os.Exit(2)
+		}
+		var target uint64
+		if flag.NArg() == 3 {
+			if target, err = strconv.ParseUint(flag.Arg(2), 10, 64); err != nil {
+				logger.Fatalf("Invalid migration id: %s", flag.Arg(2))
+			}
+		}
+		direction := gomigrate.Up
+		if flag.Arg(1) == "down" {
+			direction = gomigrate.Down
+		} else if flag.Arg(1) != "up" {
+			usage()
+			os.Exit(2)
+		}
+		var plan *gomigrate.Plan
+		if plan, err = migrator.Plan(direction, target, selection()...); err == nil {
+			if *explain {
+				err = migrator.Explain(plan)
+			}
+			plan.Print(os.Stdout)
+		}
+	case "validate":
+		if err = migrator.Validate(); err == nil {
+			var issues []gomigrate.PendingIssue
+			if issues, err = migrator.ValidatePending(); err == nil && len(issues) > 0 {
+				err = gomigrate.InvalidPending
+			}
+		}
+	case "preflight":
+		var report *gomigrate.PreflightReport
+		report, err = migrator.Preflight()
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	case "privileges":
+		var missing []gomigrate.MissingPrivilege
+		missing, err = migrator.CheckPrivileges()
+		for _, privilege := range missing {
+			fmt.Fprintf(os.Stdout, "missing %s\n", privilege)
+		}
+	case "heartbeats":
+		var heartbeats []*gomigrate.Heartbeat
+		heartbeats, err = migrator.Heartbeats()
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(heartbeats)
+	case "lint":
+		err = lint(migrator)
+	case "tui":
+		err = tui(ctx, db, migrator)
+	case "squash":
+		if flag.NArg() != 3 {
+			usage()
+			os.Exit(2)
+		}
+		var id uint64
+		if id, err = strconv.ParseUint(flag.Arg(1), 10, 64); err != nil {
+			logger.Fatalf("Invalid migration id: %s", flag.Arg(1))
+		}
+		err = migrator.Squash(id, flag.Arg(2))
+	case "new":
+		if flag.NArg() != 2 {
+			usage()
+			os.Exit(2)
+		}
+		_, err = migrator.CreateMigration(flag.Arg(1))
+	case "scaffold":
+		if flag.NArg() < 2 {
+			usage()
+			os.Exit(2)
+		}
+		params := make(map[string]string)
+		for _, arg := range flag.Args()[2:] {
+			parts := strings.SplitN(arg, "=", 2)
+			if len(parts) != 2 {
+				logger.Fatalf("Invalid parameter, expected key=value: %s", arg)
+			}
+			params[parts[0]] = parts[1]
+		}
+		_, err = migrator.CreatePatternMigrations(flag.Arg(1), params)
+	case "unlock":
+		if flag.NArg() > 2 {
+			usage()
+			os.Exit(2)
+		}
+		err = unlock(migrator, db, cmd, flag.Arg(1))
+	case "approve":
+		err = approve(migrator, flag.Args()[1:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		logger.Fatalf("Error running %s: %v", flag.Arg(0), err)
+	}
+}
+
+// Returns the run options selecting migrations by tag.
+func selection() []gomigrate.RunOption {
+	options := make([]gomigrate.RunOption, 0)
+	if *tags != "" {
+		options = append(options, gomigrate.WithTags(strings.Split(*tags, ",")...))
+	}
+	if *skipTags != "" {
+		options = append(options, gomigrate.WithoutTags(strings.Split(*skipTags, ",")...))
+	}
+	if *phase != "" {
+		options = append(options, gomigrate.WithPhase(*phase))
+	}
+	return options
+}
+
+// Returns a context that is canceled on SIGINT or SIGTERM, letting the
+// running migration finish before the command stops. A second signal
+// exits immediately.
+func cancelOnSignal(logger *log.Logger) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		logger.Print("Stopping after the current migration, signal again to exit immediately")
+		cancel()
+		<-signals
+		os.Exit(1)
+	}()
+	return ctx
+}
+
+// Reads a hex-encoded AES key from a file.
+func readKey(path string) (gomigrate.AESKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(strings.TrimSpace(string(data)))
+}
+
+// Describes how far a migration got, such as "migration 12: statement
+// 3/10, 45% of 2.1 MB, 4m30s elapsed, about 5m30s left".
+func progressLine(e gomigrate.StatementExecuted) string {
+	line := fmt.Sprintf(
+		"migration %d: statement %d/%d, %.0f%% of %.1f MB, %v elapsed",
+		e.Migration.Id,
+		e.Index+1,
+		e.Total,
+		e.Progress()*100,
+		float64(e.TotalBytes)/1e6,
+		e.Elapsed.Round(time.Second),
+	)
+	if e.Remaining > 0 {
+		line += fmt.Sprintf(", about %v left", e.Remaining.Round(time.Second))
+	}
+	return line
+}
+
+// Prints a plan, failing when it can't run.
+func printPlan(plan *gomigrate.Plan, err error) error {
+	if err != nil {
+		return err
+	}
+	plan.Print(os.Stdout)
+	if !plan.Runnable() {
+		return gomigrate.IrreversibleMigration
+	}
+	return nil
+}
+
+// Returns the number of applied migrations that aren't archived.
+func rollbackable(migrator *gomigrate.Migrator) int {
+	n := 0
+	for _, migration := range migrator.Migrations(gomigrate.Active) {
+		if !migration.Archived {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/DavidHuie/gomigrate"
+)
+
+// Parses renumberings given as id=new or id_name=new, the name
+// selecting among migrations sharing the id.
+func parseRenumberings(args []string) ([]gomigrate.Renumbering, error) {
+	renumberings := make([]gomigrate.Renumbering, 0, len(args))
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected id=new or id_name=new: %s", arg)
+		}
+		from := strings.SplitN(parts[0], "_", 2)
+		id, err := strconv.ParseUint(from[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration id: %s", arg)
+		}
+		newId, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration id: %s", arg)
+		}
+		renumbering := gomigrate.Renumbering{Id: id, NewId: newId}
+		if len(from) == 2 {
+			renumbering.Name = from[1]
+		}
+		renumberings = append(renumberings, renumbering)
+	}
+	return renumberings, nil
+}
+
+// Renumbers the migration files, then prints the statements fixing the
+// ids recorded by databases that applied them under their old ids.
+func renumber(logger gomigrate.Logger, args []string) error {
+	renumberings, err := parseRenumberings(args)
+	if err != nil {
+		return err
+	}
+	if err := gomigrate.RenumberMigrations(*dir, renumberings, logger); err != nil {
+		return err
+	}
+	if *printSql {
+		fmt.Fprintln(os.Stderr, "Run on databases that applied the renumbered migrations under their old ids:")
+		for _, statement := range gomigrate.RenumberSql(renumberings, *recordSql) {
+			fmt.Fprintln(os.Stdout, statement)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/DavidHuie/gomigrate"
+)
+
+// Writes the contents of the migrations table to path, or to stdout
+// when path is -.
+func exportState(migrator *gomigrate.Migrator, path string) error {
+	if path == "-" {
+		return migrator.ExportState(os.Stdout)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := migrator.ExportState(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Restores the migrations of a state file missing from the migrations
+// table.
+func importState(migrator *gomigrate.Migrator, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	imported, err := migrator.ImportState(file)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored %d migrations\n", imported)
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/DavidHuie/gomigrate"
+)
+
+// Prints the applied and pending migrations, each followed by its
+// description, author and ticket, along with any problems found in their numbering.
+func printStatus(report *gomigrate.StatusReport) {
+	for _, migration := range report.Applied {
+		switch {
+		case migration.Archived:
+			fmt.Fprintf(os.Stdout, "applied  %d_%s (archived)\n", migration.Id, migration.Name)
+		case migration.SkipReason != "":
+			fmt.Fprintf(os.Stdout, "applied  %d_%s (skipped: %s)\n", migration.Id, migration.Name, migration.SkipReason)
+		default:
+			fmt.Fprintf(
+				os.Stdout,
+				"applied  %d_%s (%d statements, %dms, by %s@%s)\n",
+				migration.Id,
+				migration.Name,
+				migration.StatementCount,
+				migration.DurationMs,
+				migration.Audit.User,
+				migration.Audit.Host,
+			)
+		}
+		printDescription(migration)
+	}
+	for _, migration := range report.Pending {
+		fmt.Fprintf(os.Stdout, "pending  %d_%s\n", migration.Id, migration.Name)
+		printDescription(migration)
+	}
+	for _, migration := range report.Post {
+		fmt.Fprintf(os.Stdout, "post     %d_%s\n", migration.Id, migration.Name)
+		printDescription(migration)
+	}
+	for _, migration := range report.Skipped {
+		fmt.Fprintf(os.Stdout, "skipped  %d_%s (only %v)\n", migration.Id, migration.Name, migration.Environments)
+	}
+	for _, migration := range report.OutOfOrder {
+		fmt.Fprintf(os.Stdout, "out of order: %d_%s\n", migration.Id, migration.Name)
+	}
+	for _, migration := range report.Irreversible {
+		fmt.Fprintf(os.Stdout, "irreversible: %d_%s\n", migration.Id, migration.Name)
+	}
+	if len(report.Gaps) > 0 {
+		fmt.Fprintf(os.Stdout, "gaps: %v\n", report.Gaps)
+	}
+}
+
+// Prints the description, author and ticket of a migration under it,
+// when it has them.
+func printDescription(migration *gomigrate.Migration) {
+	if migration.Description != "" {
+		fmt.Fprintf(os.Stdout, "         %s\n", migration.Description)
+	}
+	details := make([]string, 0, 2)
+	if migration.Author != "" {
+		details = append(details, "author: "+migration.Author)
+	}
+	if migration.Ticket != "" {
+		details = append(details, "ticket: "+migration.Ticket)
+	}
+	if len(details) > 0 {
+		fmt.Fprintf(os.Stdout, "         %s\n", strings.Join(details, ", "))
+	}
+}
+
+// Prints the command renumbering the files of a conflicting migration.
+func suggestRenumber(duplicate *gomigrate.DuplicateMigrationId) {
+	fmt.Fprintf(os.Stderr, "Migration id %d is used by more than one migration. To renumber, run:\n", duplicate.Id)
+	names := make(map[string]bool)
+	for from := range duplicate.Renames() {
+		// Migration files are named <id>_<name>_up.sql or _down.sql,
+		// or <id>_<name>.sql in up and down directories.
+		name := strings.SplitN(filepath.Base(from), "_", 2)[1]
+		name = strings.SplitN(name, ".", 2)[0]
+		name = strings.TrimSuffix(strings.TrimSuffix(name, "_up"), "_down")
+		if !names[name] {
+			names[name] = true
+			fmt.Fprintf(os.Stderr, "\tgomigrate -dir %s renumber %d_%s=%d\n", *dir, duplicate.Id, name, duplicate.NextId)
+		}
+	}
+}
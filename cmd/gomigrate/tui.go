@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/DavidHuie/gomigrate"
+)
+
+const (
+	clearScreen = "\033[H\033[2J"
+	clearLine   = "\r\033[K"
+)
+
+// Runs an interactive dashboard showing applied and pending migrations,
+// with live progress while migrations are applied or rolled back.
+func tui(ctx context.Context, db *sql.DB, migrator *gomigrate.Migrator) error {
+	var summary string
+	migrator.Subscribe(func(e gomigrate.Event) {
+		switch e := e.(type) {
+		case gomigrate.MigrationStarted:
+			fmt.Fprintf(os.Stdout, "%s%s %d_%s", clearLine, e.Direction, e.Migration.Id, e.Migration.Name)
+		case gomigrate.StatementExecuted:
+			fmt.Fprintf(os.Stdout, "%s%s", clearLine, progressLine(e))
+		case gomigrate.MigrationFinished:
+			result := "done"
+			if e.Err != nil {
+				result = "failed: " + e.Err.Error()
+			}
+			fmt.Fprintf(os.Stdout, "%s%d_%s: %s in %v\n", clearLine, e.Migration.Id, e.Migration.Name, result, e.Duration.Round(time.Millisecond))
+		case gomigrate.RunCompleted:
+			summary = fmt.Sprintf("%s: %d migrations applied", e.Direction, e.Applied)
+		}
+	})
+
+	input := bufio.NewReader(os.Stdin)
+	message := ""
+	for {
+		fmt.Fprint(os.Stdout, clearScreen)
+		printStatus(migrator.Status())
+		if message != "" {
+			fmt.Fprintf(os.Stdout, "\n%s\n", message)
+		}
+		fmt.Fprint(os.Stdout, "\n[u]p, [d]own one, [r]efresh, [q]uit: ")
+
+		line, err := input.ReadString('\n')
+		if err != nil {
+			return nil
+		}
+
+		message, summary = "", ""
+		switch strings.TrimSpace(line) {
+		case "u":
+			err = migrator.MigrateContext(ctx)
+		case "d":
+			if err = confirmDestructive(db, "down"); err == nil {
+				err = migrator.RollbackNContext(ctx, 1)
+			}
+		case "r":
+		case "q":
+			return nil
+		default:
+			message = "Unknown command"
+		}
+		if summary != "" {
+			message = summary
+		}
+		if err != nil {
+			message = "Error: " + err.Error()
+		}
+		if err == gomigrate.Interrupted {
+			return err
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/DavidHuie/gomigrate"
+)
+
+var errOwnerRequired = errors.New("Pass the owner of the lock to release it")
+
+// Reports the holder of the migration lock, or releases it when the
+// owner is given and the release is confirmed. Requiring the owner
+// makes sure a lock taken over by a live runner since it was reported
+// isn't released.
+func unlock(migrator *gomigrate.Migrator, db *sql.DB, cmd, owner string) error {
+	holder, err := migrator.LockHolder()
+	if err != nil {
+		return err
+	}
+	if holder == nil {
+		fmt.Fprintln(os.Stderr, "The migration lock is free.")
+		return nil
+	}
+	if owner == "" {
+		state := "expires"
+		if holder.ExpiresAt.Before(time.Now()) {
+			state = "expired"
+		}
+		fmt.Fprintf(os.Stderr, "The migration lock is held by %s and %s at %s.\n", holder.Owner, state, holder.ExpiresAt.Format(time.RFC3339))
+		fmt.Fprintf(os.Stderr, "Make sure it stopped, then run: gomigrate unlock %s\n", holder.Owner)
+		return errOwnerRequired
+	}
+	fmt.Fprintf(os.Stderr, "WARNING: releasing the migration lock held by %s. Migrations it is still running would run alongside the next runner's.\n", holder.Owner)
+	if err := confirmDestructive(db, cmd); err != nil {
+		return err
+	}
+	return migrator.ForceUnlock(owner)
+}
@@ -0,0 +1,196 @@
+// Records application defined columns in the migrations table.
+
+package gomigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var columnName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// A column added to the migrations table and filled when migrations
+// are applied, such as the team owning a migration, its ticket or the
+// git revision it was applied from.
+type CustomColumn struct {
+	// Name of the column, which must not be used by gomigrate.
+	Name string
+
+	// SQL type the column is added with, VARCHAR(255) by default.
+	Type string
+
+	// Returns the value recorded for a migration being applied.
+	Value func(migration *Migration) (string, error)
+}
+
+// Implemented by adapters declaring custom columns, in addition to
+// Migrator.Columns.
+type CustomColumnDeclarer interface {
+	CustomColumns() []CustomColumn
+}
+
+// Implemented by adapters that can record custom columns.
+type CustomColumnRecorder interface {
+	// Sets the columns for a migration id. Values are passed in the
+	// order of the columns, followed by the id.
+	CustomColumnsUpdateSql(columns []string) string
+
+	// Selects the columns for a migration id.
+	CustomColumnsSelectSql(columns []string) string
+}
+
+// Returns the custom columns of the adapter followed by those of the
+// migrator.
+func (m *Migrator) customColumns() []CustomColumn {
+	columns := make([]CustomColumn, 0)
+	if declarer, ok := m.dbAdapter.(CustomColumnDeclarer); ok {
+		columns = append(columns, declarer.CustomColumns()...)
+	}
+	return append(columns, m.Columns...)
+}
+
+// Returns the custom column recorder of the adapter, or
+// ColumnsUnsupported.
+func (m *Migrator) columnRecorder() (CustomColumnRecorder, error) {
+	recorder, ok := m.dbAdapter.(CustomColumnRecorder)
+	if !ok {
+		m.warnf("Adapter does not support custom columns")
+		return nil, ColumnsUnsupported
+	}
+	return recorder, nil
+}
+
+// Adds the custom columns missing from the migrations table.
+func (m *run) ensureCustomColumns() error {
+	columns := m.customColumns()
+	if len(columns) == 0 {
+		return nil
+	}
+	if _, err := m.columnRecorder(); err != nil {
+		return err
+	}
+	for _, column := range columns {
+		if !columnName.MatchString(column.Name) || column.Value == nil {
+			m.warnf("Invalid custom column: %q", column.Name)
+			return InvalidColumn
+		}
+		probe := fmt.Sprintf("SELECT %s FROM %s WHERE 1 = 0", column.Name, migrationTableName)
+		if err := m.executor.QueryRow(probe).Scan(); err == sql.ErrNoRows {
+			continue
+		}
+		columnType := column.Type
+		if columnType == "" {
+			columnType = "VARCHAR(255)"
+		}
+		m.infof("Adding column %s to the migrations table", column.Name)
+		statement := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", migrationTableName, column.Name, columnType)
+		if _, err := m.executor.Exec(statement); err != nil {
+			m.errorf("Error adding column to migrations table: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the names of the custom columns found in the migrations
+// table, which read-only migrators don't add.
+func (m *run) presentColumns() []string {
+	names := make([]string, 0)
+	if _, ok := m.dbAdapter.(CustomColumnRecorder); !ok {
+		return names
+	}
+	for _, column := range m.customColumns() {
+		if !columnName.MatchString(column.Name) {
+			continue
+		}
+		probe := fmt.Sprintf("SELECT %s FROM %s WHERE 1 = 0", column.Name, migrationTableName)
+		if err := m.executor.QueryRow(probe).Scan(); err == sql.ErrNoRows {
+			names = append(names, column.Name)
+		}
+	}
+	return names
+}
+
+// Records the custom columns of an applied migration in the
+// transaction it runs in.
+func (m *Migrator) recordColumns(transaction TxExecutor, migration *Migration) error {
+	columns := m.customColumns()
+	if len(columns) == 0 {
+		return nil
+	}
+	recorder, err := m.columnRecorder()
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(columns))
+	args := make([]interface{}, 0, len(columns)+1)
+	values := make(map[string]string)
+	for _, column := range columns {
+		value, err := column.Value(migration)
+		if err != nil {
+			m.errorf("Error getting column %s of migration %d: %v", column.Name, migration.Id, err)
+			return err
+		}
+		names = append(names, column.Name)
+		args = append(args, value)
+		values[column.Name] = value
+	}
+	args = append(args, migration.Id)
+	if _, err := transaction.Exec(recorder.CustomColumnsUpdateSql(names), args...); err != nil {
+		m.errorf("Error logging migration: %v", err)
+		return err
+	}
+	m.mu.Lock()
+	migration.Columns = values
+	m.mu.Unlock()
+	return nil
+}
+
+// Loads the custom columns of an applied migration.
+func (m *run) getMigrationColumns(migration *Migration, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	recorder := m.dbAdapter.(CustomColumnRecorder)
+	values := make([]sql.NullString, len(names))
+	dest := make([]interface{}, len(names))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	row := m.executor.QueryRow(recorder.CustomColumnsSelectSql(names), migration.Id)
+	if err := row.Scan(dest...); err != nil {
+		m.errorf("Error getting custom columns for %s: %v", migration.Name, err)
+		return err
+	}
+	migration.Columns = make(map[string]string)
+	for i, name := range names {
+		migration.Columns[name] = values[i].String
+	}
+	return nil
+}
+
+// Returns the statement setting columns for a migration id, with the
+// nth placeholder given by placeholder.
+func customColumnsUpdateSql(columns []string, placeholder func(n int) string) string {
+	assignments := make([]string, len(columns))
+	for i, column := range columns {
+		assignments[i] = column + " = " + placeholder(i+1)
+	}
+	return fmt.Sprintf(
+		"UPDATE %s SET %s WHERE migration_id = %s",
+		migrationTableName,
+		strings.Join(assignments, ", "),
+		placeholder(len(columns)+1),
+	)
+}
+
+// Returns the query selecting columns for a migration id.
+func customColumnsSelectSql(columns []string, placeholder string) string {
+	return fmt.Sprintf("SELECT %s FROM %s WHERE migration_id = %s", strings.Join(columns, ", "), migrationTableName, placeholder)
+}
+
+func questionMark(n int) string {
+	return "?"
+}
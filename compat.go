@@ -0,0 +1,225 @@
+// Tracks migrations in the tables of other migration tools.
+
+package gomigrate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Implemented by adapters keeping the migrations table of another
+// migration tool instead of the gomigrate table, so both tools can run
+// against the same database during a cutover. The table is never
+// upgraded and no stats, audit information, checksums or custom
+// columns are recorded.
+type ForeignMigrationTable interface {
+	Migratable
+
+	// Names the table of the other tool.
+	ForeignTableName() string
+
+	// Selects the highest applied migration id.
+	MaxMigrationSql() string
+
+	// Returns an error when the table is in a state gomigrate can't
+	// run from.
+	CheckTable(executor DBExecutor) error
+
+	// Records a migration as applied or rolled back in the transaction
+	// it runs in. version is the highest migration id applied once it
+	// is recorded, 0 when none are.
+	LogMigration(transaction TxExecutor, id uint64, up bool, version uint64) error
+}
+
+// Returns the placeholder of the single parameter of the adapter's
+// GetMigrationSql, such as $1, ? or @p1.
+func placeholderOf(adapter Migratable) string {
+	fields := strings.Fields(adapter.GetMigrationSql())
+	return fields[len(fields)-1]
+}
+
+// Returns the name of the table tracking migrations.
+func (m *Migrator) trackingTableName() string {
+	if foreign, ok := m.dbAdapter.(ForeignMigrationTable); ok {
+		return foreign.ForeignTableName()
+	}
+	return migrationTableName
+}
+
+// Returns the highest id of the applied migrations once migration is
+// applied or rolled back.
+func (m *Migrator) versionAfter(migration *Migration, mType migrationType) uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var version uint64
+	if mType == upMigration {
+		version = migration.Id
+	}
+	for id, other := range m.migrations {
+		if other.Status == Active && id != migration.Id && id > version {
+			version = id
+		}
+	}
+	return version
+}
+
+// Tracks migrations in the goose_db_version table of goose, which
+// keeps a row for each applied migration. Adapter provides the
+// statements of the database.
+type GooseTable struct {
+	Adapter Migratable
+
+	// The table name, goose_db_version by default.
+	Table string
+}
+
+func (g GooseTable) table() string {
+	if g.Table == "" {
+		return "goose_db_version"
+	}
+	return g.Table
+}
+
+func (g GooseTable) ForeignTableName() string {
+	return g.table()
+}
+
+func (g GooseTable) SelectMigrationTableSql() string {
+	return g.Adapter.SelectMigrationTableSql()
+}
+
+func (g GooseTable) CreateMigrationTableSql() string {
+	id := "id SERIAL NOT NULL PRIMARY KEY"
+	if _, ok := g.Adapter.(Sqlite3); ok {
+		id = "id INTEGER PRIMARY KEY AUTOINCREMENT"
+	}
+	return fmt.Sprintf(`CREATE TABLE %s (
+                  %s,
+                  version_id BIGINT NOT NULL,
+                  is_applied BOOLEAN NOT NULL,
+                  tstamp     TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+                )`, g.table(), id)
+}
+
+// Goose appends a row when a migration is rolled back by older
+// versions, so only the latest row of a version counts.
+func (g GooseTable) GetMigrationSql() string {
+	return fmt.Sprintf(
+		"SELECT version_id FROM %s WHERE id = (SELECT MAX(id) FROM %s WHERE version_id = %s) AND is_applied",
+		g.table(), g.table(), placeholderOf(g.Adapter),
+	)
+}
+
+func (g GooseTable) MigrationLogInsertSql() string {
+	return fmt.Sprintf("INSERT INTO %s (version_id, is_applied) VALUES (%s, TRUE)", g.table(), placeholderOf(g.Adapter))
+}
+
+func (g GooseTable) MigrationLogDeleteSql() string {
+	return fmt.Sprintf("DELETE FROM %s WHERE version_id = %s", g.table(), placeholderOf(g.Adapter))
+}
+
+func (g GooseTable) GetMigrationCommands(sql string) []string {
+	return g.Adapter.GetMigrationCommands(sql)
+}
+
+func (g GooseTable) Capabilities() Capability {
+	return capabilities(g.Adapter)
+}
+
+func (g GooseTable) MaxMigrationSql() string {
+	return fmt.Sprintf("SELECT MAX(version_id) FROM %s WHERE is_applied", g.table())
+}
+
+func (g GooseTable) CheckTable(executor DBExecutor) error {
+	return nil
+}
+
+func (g GooseTable) LogMigration(transaction TxExecutor, id uint64, up bool, version uint64) error {
+	query := g.MigrationLogDeleteSql()
+	if up {
+		query = g.MigrationLogInsertSql()
+	}
+	_, err := transaction.Exec(query, id)
+	return err
+}
+
+// Tracks migrations in the schema_migrations table of golang-migrate,
+// which keeps a single row with the highest applied migration and
+// whether it failed halfway. Every migration up to that version counts
+// as applied, so migrations must be applied in order. Adapter provides
+// the statements of the database.
+type GolangMigrateTable struct {
+	Adapter Migratable
+
+	// The table name, schema_migrations by default.
+	Table string
+}
+
+func (g GolangMigrateTable) table() string {
+	if g.Table == "" {
+		return "schema_migrations"
+	}
+	return g.Table
+}
+
+func (g GolangMigrateTable) ForeignTableName() string {
+	return g.table()
+}
+
+func (g GolangMigrateTable) SelectMigrationTableSql() string {
+	return g.Adapter.SelectMigrationTableSql()
+}
+
+func (g GolangMigrateTable) CreateMigrationTableSql() string {
+	return fmt.Sprintf("CREATE TABLE %s (version BIGINT NOT NULL PRIMARY KEY, dirty BOOLEAN NOT NULL)", g.table())
+}
+
+func (g GolangMigrateTable) GetMigrationSql() string {
+	return fmt.Sprintf("SELECT version FROM %s WHERE version >= %s", g.table(), placeholderOf(g.Adapter))
+}
+
+func (g GolangMigrateTable) MigrationLogInsertSql() string {
+	return fmt.Sprintf("INSERT INTO %s (version, dirty) VALUES (%s, FALSE)", g.table(), placeholderOf(g.Adapter))
+}
+
+func (g GolangMigrateTable) MigrationLogDeleteSql() string {
+	return fmt.Sprintf("DELETE FROM %s WHERE version = %s", g.table(), placeholderOf(g.Adapter))
+}
+
+func (g GolangMigrateTable) GetMigrationCommands(sql string) []string {
+	return g.Adapter.GetMigrationCommands(sql)
+}
+
+func (g GolangMigrateTable) Capabilities() Capability {
+	return capabilities(g.Adapter)
+}
+
+func (g GolangMigrateTable) MaxMigrationSql() string {
+	return fmt.Sprintf("SELECT MAX(version) FROM %s", g.table())
+}
+
+// Refuses dirty tables, left by a failed golang-migrate run, which
+// must be fixed with golang-migrate force first.
+func (g GolangMigrateTable) CheckTable(executor DBExecutor) error {
+	var dirty int
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE dirty", g.table())
+	if err := executor.QueryRow(query).Scan(&dirty); err != nil {
+		return err
+	}
+	if dirty > 0 {
+		return DirtyMigrationTable
+	}
+	return nil
+}
+
+// Replaces the single row with the new version.
+func (g GolangMigrateTable) LogMigration(transaction TxExecutor, id uint64, up bool, version uint64) error {
+	if _, err := transaction.Exec(fmt.Sprintf("DELETE FROM %s", g.table())); err != nil {
+		return err
+	}
+	if version == 0 {
+		return nil
+	}
+	_, err := transaction.Exec(g.MigrationLogInsertSql(), version)
+	return err
+}
@@ -0,0 +1,121 @@
+// Reads project settings from a gomigrate.yaml file.
+
+package gomigrate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The name of the configuration file looked for by the command line.
+const ConfigFileName = "gomigrate.yaml"
+
+// Project settings with a section for each environment migrations run
+// in, as read by LoadConfig:
+//
+//	environments:
+//	  staging:
+//	    driver: postgres
+//	    dsn_env: STAGING_DATABASE_URL
+//	    dir: migrations
+//	    require_writable: true
+//	  production:
+//	    driver: postgres
+//	    dsn_env: DATABASE_URL
+//	    dir: migrations
+//	    protected: true
+//	    approvals: approvals.yaml
+type Config struct {
+	Environments map[string]*EnvironmentConfig `yaml:"environments"`
+}
+
+// The settings of an environment.
+type EnvironmentConfig struct {
+	// The name of the environment in the file.
+	Name string `yaml:"-"`
+
+	Driver string `yaml:"driver"`
+
+	// The data source name, or the environment variable holding it, so
+	// credentials stay out of the file.
+	Dsn    string `yaml:"dsn"`
+	DsnEnv string `yaml:"dsn_env"`
+
+	// The migrations directory, relative to the configuration file.
+	Dir              string `yaml:"dir"`
+	SplitDirs        bool   `yaml:"split_dirs"`
+	AllowMissingDown bool   `yaml:"allow_missing_down"`
+
+	// Keeps the migrations table of another tool, goose or
+	// golang-migrate, named Table instead of the default of the tool.
+	Track string `yaml:"track"`
+	Table string `yaml:"table"`
+
+	// Safety settings. Policies are ignore, warn or fail.
+	Production        bool   `yaml:"production"`
+	RequireWritable   bool   `yaml:"require_writable"`
+	RequirePrivileges bool   `yaml:"require_privileges"`
+	ValidateFirst     bool   `yaml:"validate_first"`
+	OutOfOrderPolicy  string `yaml:"out_of_order"`
+	GapPolicy         string `yaml:"gaps"`
+	CreateExtensions  bool   `yaml:"create_extensions"`
+
+	// Only applies migrations approved in the Approvals file, signed
+	// with the keys of the Approvers file when it is set. Both are
+	// relative to the configuration file.
+	Protected bool   `yaml:"protected"`
+	Approvals string `yaml:"approvals"`
+	Approvers string `yaml:"approvers"`
+}
+
+// Reads a configuration file. Returns InvalidConfig when it can't be
+// parsed.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, InvalidConfig
+	}
+	for name, environment := range config.Environments {
+		if environment == nil {
+			environment = &EnvironmentConfig{}
+			config.Environments[name] = environment
+		}
+		environment.Name = name
+		for _, file := range []*string{&environment.Dir, &environment.Approvals, &environment.Approvers} {
+			if *file != "" && !filepath.IsAbs(*file) {
+				*file = filepath.Join(filepath.Dir(path), *file)
+			}
+		}
+	}
+	return &config, nil
+}
+
+// Returns the settings of an environment, or UnknownEnvironment when
+// the file has none by that name.
+func (c *Config) Environment(name string) (*EnvironmentConfig, error) {
+	environment, ok := c.Environments[name]
+	if !ok {
+		return nil, UnknownEnvironment
+	}
+	return environment, nil
+}
+
+// Returns the data source name of the environment, read from DsnEnv
+// when it is set. Returns InvalidConfig when the variable is unset.
+func (e *EnvironmentConfig) DataSourceName() (string, error) {
+	if e.DsnEnv == "" {
+		return e.Dsn, nil
+	}
+	dsn, ok := os.LookupEnv(e.DsnEnv)
+	if !ok {
+		return "", InvalidConfig
+	}
+	return dsn, nil
+}
@@ -1,6 +1,11 @@
 package gomigrate
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/DavidHuie/gomigrate/sqlsplit"
+)
 
 type Migratable interface {
 	SelectMigrationTableSql() string
@@ -11,6 +16,39 @@ type Migratable interface {
 	GetMigrationCommands(string) []string
 }
 
+// Implemented by adapters that can tell whether the connected database
+// only accepts reads, such as a replica.
+type ReadOnlyChecker interface {
+	// Returns a query selecting a single boolean.
+	ReadOnlySql() string
+}
+
+// Implemented by adapters needing session settings, such as the
+// warehouse to use, on the connection running a migration.
+type SessionInitializer interface {
+	// Returns the statements run at the start of every migration
+	// transaction.
+	SessionSql() []string
+}
+
+// Implemented by adapters for databases that commit DDL statements
+// implicitly, so a failed migration may be left partially applied.
+// Adapters implementing Adapter declare it with their capabilities
+// instead.
+type ImplicitDdlCommitter interface {
+	CommitsDdlImplicitly() bool
+}
+
+// Implemented by adapters for databases that can't run several DDL
+// statements in a transaction, or run them asynchronously. Migrations
+// run one statement at a time outside of a transaction, and are logged
+// once the schema changes completed.
+type NonTransactionalDdl interface {
+	// Returns a query selecting the number of schema changes still
+	// running, or an empty string when DDL statements are synchronous.
+	PendingDdlSql() string
+}
+
 // POSTGRES
 
 type Postgres struct{}
@@ -42,6 +80,293 @@ func (p Postgres) GetMigrationCommands(sql string) []string {
 	return []string{sql}
 }
 
+func (p Postgres) MigrationStatsUpdateSql() string {
+	return "UPDATE gomigrate SET duration_ms = $1, statement_count = $2, applied_at_ns = $3 WHERE migration_id = $4"
+}
+
+func (p Postgres) MigrationStatsSelectSql() string {
+	return "SELECT duration_ms, statement_count, applied_at_ns FROM gomigrate WHERE migration_id = $1"
+}
+
+func (p Postgres) MigrationAuditUpdateSql() string {
+	return "UPDATE gomigrate SET applied_by = $1, applied_host = $2, application = $3, library_version = $4, build_info = $5, runner = $6 WHERE migration_id = $7"
+}
+
+func (p Postgres) MigrationAuditSelectSql() string {
+	return "SELECT applied_by, applied_host, application, library_version, build_info, runner FROM gomigrate WHERE migration_id = $1"
+}
+
+func (p Postgres) MigrationChecksumUpdateSql() string {
+	return "UPDATE gomigrate SET checksum = $1, checksum_algorithm = $2 WHERE migration_id = $3"
+}
+
+func (p Postgres) MigrationChecksumSelectSql() string {
+	return "SELECT checksum, checksum_algorithm FROM gomigrate WHERE migration_id = $1"
+}
+
+func (p Postgres) WidenChecksumSql() string {
+	return "ALTER TABLE gomigrate ALTER COLUMN checksum TYPE VARCHAR(128)"
+}
+
+func (p Postgres) MigrationSkipUpdateSql() string {
+	return "UPDATE gomigrate SET skip_reason = $1 WHERE migration_id = $2"
+}
+
+func (p Postgres) MigrationSkipSelectSql() string {
+	return "SELECT skip_reason FROM gomigrate WHERE migration_id = $1"
+}
+
+func (p Postgres) MigrationDescriptionUpdateSql() string {
+	return "UPDATE gomigrate SET description = $1 WHERE migration_id = $2"
+}
+
+func (p Postgres) MigrationDescriptionSelectSql() string {
+	return "SELECT description FROM gomigrate WHERE migration_id = $1"
+}
+
+func (p Postgres) MigrationMetadataUpdateSql() string {
+	return "UPDATE gomigrate SET author = $1, ticket = $2 WHERE migration_id = $3"
+}
+
+func (p Postgres) MigrationMetadataSelectSql() string {
+	return "SELECT author, ticket FROM gomigrate WHERE migration_id = $1"
+}
+
+func (p Postgres) MigrationPhaseUpdateSql() string {
+	return "UPDATE gomigrate SET phase = $1 WHERE migration_id = $2"
+}
+
+func (p Postgres) MigrationPhaseSelectSql() string {
+	return "SELECT phase FROM gomigrate WHERE migration_id = $1"
+}
+
+func (p Postgres) CustomColumnsUpdateSql(columns []string) string {
+	return customColumnsUpdateSql(columns, func(n int) string { return fmt.Sprintf("$%d", n) })
+}
+
+func (p Postgres) CustomColumnsSelectSql(columns []string) string {
+	return customColumnsSelectSql(columns, "$1")
+}
+
+func (p Postgres) ReadOnlySql() string {
+	return "SELECT pg_is_in_recovery() OR current_setting('transaction_read_only') = 'on'"
+}
+
+func (p Postgres) SchemaColumnsSql() string {
+	return `SELECT table_name, column_name, data_type
+                FROM information_schema.columns
+                WHERE table_schema = current_schema()`
+}
+
+func (p Postgres) CreateMigrationSqlTableSql() string {
+	return `CREATE TABLE IF NOT EXISTS gomigrate_sql (
+                  migration_id    BIGINT NOT NULL,
+                  direction       VARCHAR(4) NOT NULL,
+                  applied_at_ns   BIGINT NOT NULL,
+                  statement_index INTEGER NOT NULL,
+                  sql_text        TEXT NOT NULL
+                )`
+}
+
+func (p Postgres) MigrationSqlInsertSql() string {
+	return "INSERT INTO gomigrate_sql (migration_id, direction, applied_at_ns, statement_index, sql_text) VALUES ($1, $2, $3, $4, $5)"
+}
+
+func (p Postgres) MigrationSqlLatestSql() string {
+	return "SELECT MAX(applied_at_ns) FROM gomigrate_sql WHERE migration_id = $1 AND direction = $2"
+}
+
+func (p Postgres) MigrationSqlSelectSql() string {
+	return "SELECT sql_text FROM gomigrate_sql WHERE migration_id = $1 AND applied_at_ns = $2 AND statement_index = $3"
+}
+
+func (p Postgres) CreateLockTableSql() string {
+	return `CREATE TABLE IF NOT EXISTS gomigrate_lock (
+                  id            INTEGER PRIMARY KEY,
+                  owner         VARCHAR(255) NOT NULL,
+                  expires_at_ns BIGINT NOT NULL
+                )`
+}
+
+func (p Postgres) InsertLockRowSql() string {
+	return "INSERT INTO gomigrate_lock (id, owner, expires_at_ns) VALUES (1, '', 0) ON CONFLICT (id) DO NOTHING"
+}
+
+func (p Postgres) AcquireLockSql() string {
+	return "UPDATE gomigrate_lock SET owner = $1, expires_at_ns = $2 WHERE id = 1 AND (owner = '' OR owner = $3 OR expires_at_ns < $4)"
+}
+
+func (p Postgres) ReleaseLockSql() string {
+	return "UPDATE gomigrate_lock SET owner = '', expires_at_ns = 0 WHERE id = 1 AND owner = $1"
+}
+
+func (p Postgres) SelectLockSql() string {
+	return "SELECT owner, expires_at_ns FROM gomigrate_lock WHERE id = 1"
+}
+
+func (p Postgres) CreateHeartbeatTableSql() string {
+	return `CREATE TABLE IF NOT EXISTS gomigrate_heartbeat (
+                  owner         VARCHAR(255) NOT NULL,
+                  migration_id  BIGINT NOT NULL,
+                  started_at_ns BIGINT NOT NULL,
+                  last_seen_ns  BIGINT NOT NULL,
+                  PRIMARY KEY (owner, migration_id)
+                )`
+}
+
+func (p Postgres) InsertHeartbeatSql() string {
+	return "INSERT INTO gomigrate_heartbeat (owner, migration_id, started_at_ns, last_seen_ns) VALUES ($1, $2, $3, $4)"
+}
+
+func (p Postgres) UpdateHeartbeatSql() string {
+	return "UPDATE gomigrate_heartbeat SET last_seen_ns = $1 WHERE owner = $2 AND migration_id = $3"
+}
+
+func (p Postgres) DeleteHeartbeatSql() string {
+	return "DELETE FROM gomigrate_heartbeat WHERE owner = $1 AND migration_id = $2"
+}
+
+func (p Postgres) SelectHeartbeatsSql() string {
+	return "SELECT owner, migration_id, started_at_ns, last_seen_ns FROM gomigrate_heartbeat ORDER BY started_at_ns"
+}
+
+func (p Postgres) CreateBackfillTableSql() string {
+	return `CREATE TABLE IF NOT EXISTS gomigrate_backfill (
+                  migration_id  BIGINT PRIMARY KEY,
+                  last_key      BIGINT NOT NULL,
+                  rows_affected BIGINT NOT NULL,
+                  updated_at_ns BIGINT NOT NULL
+                )`
+}
+
+func (p Postgres) SelectBackfillSql() string {
+	return "SELECT last_key, rows_affected FROM gomigrate_backfill WHERE migration_id = $1"
+}
+
+func (p Postgres) InsertBackfillSql() string {
+	return "INSERT INTO gomigrate_backfill (migration_id, last_key, rows_affected, updated_at_ns) VALUES ($1, $2, $3, $4)"
+}
+
+func (p Postgres) UpdateBackfillSql() string {
+	return "UPDATE gomigrate_backfill SET last_key = $1, rows_affected = $2, updated_at_ns = $3 WHERE migration_id = $4"
+}
+
+func (p Postgres) DeleteBackfillSql() string {
+	return "DELETE FROM gomigrate_backfill WHERE migration_id = $1"
+}
+
+func (p Postgres) CreateVersionTableSql() string {
+	return `CREATE TABLE IF NOT EXISTS gomigrate_version (
+                  id      INTEGER PRIMARY KEY,
+                  version INTEGER NOT NULL
+                )`
+}
+
+func (p Postgres) InsertVersionRowSql() string {
+	return "INSERT INTO gomigrate_version (id, version) VALUES (1, 1) ON CONFLICT (id) DO NOTHING"
+}
+
+func (p Postgres) SelectVersionSql() string {
+	return "SELECT version FROM gomigrate_version WHERE id = 1"
+}
+
+func (p Postgres) UpdateVersionSql() string {
+	return "UPDATE gomigrate_version SET version = $1 WHERE id = 1"
+}
+
+// Postgres explains the statements it can explain, which parses and
+// plans them without running them. Other statements aren't checked.
+func (p Postgres) CheckStatementSql(statement string) string {
+	if !explainable.MatchString(lineComment.ReplaceAllString(statement, "")) {
+		return ""
+	}
+	return "EXPLAIN " + statement
+}
+
+func (p Postgres) SyntaxError(err error) bool {
+	e, ok := err.(interface {
+		SQLState() string
+	})
+	return ok && e.SQLState() == "42601"
+}
+
+func (p Postgres) ServerVersionSql() string {
+	return "SHOW server_version"
+}
+
+func (p Postgres) ExtensionSql() string {
+	return "SELECT COUNT(*) FROM pg_extension WHERE extname = $1"
+}
+
+func (p Postgres) ExplainSql(statement string) string {
+	return "EXPLAIN (FORMAT JSON) " + statement
+}
+
+func (p Postgres) ParseExplain(output string) (*Estimate, error) {
+	return parsePostgresExplain(output)
+}
+
+func (p Postgres) IndexValidSql() string {
+	return "SELECT indisvalid FROM pg_index WHERE indexrelid = to_regclass($1)"
+}
+
+func (p Postgres) DropIndexSql(name string) string {
+	return "DROP INDEX CONCURRENTLY IF EXISTS " + name
+}
+
+func (p Postgres) IndexProgressSql() string {
+	return "SELECT phase, blocks_done, blocks_total FROM pg_stat_progress_create_index WHERE relid = to_regclass($1) LIMIT 1"
+}
+
+func (p Postgres) AnalyzeLocks(statement string) *LockImpact {
+	return postgresLockImpact(statement)
+}
+
+// replay_lag is null once a replica caught up with an idle primary.
+func (p Postgres) ReplicationLagSql() string {
+	return "SELECT COALESCE(EXTRACT(EPOCH FROM MAX(replay_lag)), 0)::float8 FROM pg_stat_replication"
+}
+
+func (p Postgres) AnalyzeSql(table string) string {
+	return "ANALYZE " + table
+}
+
+// Plain VACUUM doesn't lock out reads or writes, unlike VACUUM FULL.
+func (p Postgres) VacuumSql(table string) string {
+	return "VACUUM " + table
+}
+
+func (p Postgres) CreateDatabaseSql(name, template string) string {
+	if template != "" {
+		return "CREATE DATABASE " + quoteIdent(name) + " TEMPLATE " + quoteIdent(template)
+	}
+	return "CREATE DATABASE " + quoteIdent(name)
+}
+
+func (p Postgres) DropDatabaseSql(name string) string {
+	return "DROP DATABASE IF EXISTS " + quoteIdent(name)
+}
+
+func (p Postgres) CurrentDatabaseSql() string {
+	return "SELECT current_database()"
+}
+
+func (p Postgres) CreatePrivilegeSql() string {
+	return "SELECT has_schema_privilege(current_schema(), 'CREATE')"
+}
+
+func (p Postgres) TableOwnerSql() string {
+	return "SELECT pg_has_role(relowner, 'USAGE') FROM pg_class WHERE oid = to_regclass($1::text)"
+}
+
+func (p Postgres) CreateExtensionSql(name string) string {
+	return "CREATE EXTENSION IF NOT EXISTS " + quoteIdent(name)
+}
+
+func (p Postgres) Capabilities() Capability {
+	return TransactionalDdl | Savepoints | ConcurrentMigrations
+}
+
 // MYSQL
 
 type Mysql struct{}
@@ -71,9 +396,244 @@ func (m Mysql) MigrationLogDeleteSql() string {
 }
 
 func (m Mysql) GetMigrationCommands(sql string) []string {
-	count := strings.Count(sql, ";")
-	commands := strings.SplitN(string(sql), ";", count)
-	return commands
+	return sqlsplit.Mysql.Split(sql)
+}
+
+func (m Mysql) MigrationStatsUpdateSql() string {
+	return "UPDATE gomigrate SET duration_ms = ?, statement_count = ?, applied_at_ns = ? WHERE migration_id = ?"
+}
+
+func (m Mysql) MigrationStatsSelectSql() string {
+	return "SELECT duration_ms, statement_count, applied_at_ns FROM gomigrate WHERE migration_id = ?"
+}
+
+func (m Mysql) MigrationAuditUpdateSql() string {
+	return "UPDATE gomigrate SET applied_by = ?, applied_host = ?, application = ?, library_version = ?, build_info = ?, runner = ? WHERE migration_id = ?"
+}
+
+func (m Mysql) MigrationAuditSelectSql() string {
+	return "SELECT applied_by, applied_host, application, library_version, build_info, runner FROM gomigrate WHERE migration_id = ?"
+}
+
+func (m Mysql) MigrationChecksumUpdateSql() string {
+	return "UPDATE gomigrate SET checksum = ?, checksum_algorithm = ? WHERE migration_id = ?"
+}
+
+func (m Mysql) MigrationChecksumSelectSql() string {
+	return "SELECT checksum, checksum_algorithm FROM gomigrate WHERE migration_id = ?"
+}
+
+func (m Mysql) WidenChecksumSql() string {
+	return "ALTER TABLE gomigrate MODIFY checksum VARCHAR(128)"
+}
+
+func (m Mysql) MigrationSkipUpdateSql() string {
+	return "UPDATE gomigrate SET skip_reason = ? WHERE migration_id = ?"
+}
+
+func (m Mysql) MigrationSkipSelectSql() string {
+	return "SELECT skip_reason FROM gomigrate WHERE migration_id = ?"
+}
+
+func (m Mysql) MigrationDescriptionUpdateSql() string {
+	return "UPDATE gomigrate SET description = ? WHERE migration_id = ?"
+}
+
+func (m Mysql) MigrationDescriptionSelectSql() string {
+	return "SELECT description FROM gomigrate WHERE migration_id = ?"
+}
+
+func (m Mysql) MigrationMetadataUpdateSql() string {
+	return "UPDATE gomigrate SET author = ?, ticket = ? WHERE migration_id = ?"
+}
+
+func (m Mysql) MigrationMetadataSelectSql() string {
+	return "SELECT author, ticket FROM gomigrate WHERE migration_id = ?"
+}
+
+func (m Mysql) MigrationPhaseUpdateSql() string {
+	return "UPDATE gomigrate SET phase = ? WHERE migration_id = ?"
+}
+
+func (m Mysql) MigrationPhaseSelectSql() string {
+	return "SELECT phase FROM gomigrate WHERE migration_id = ?"
+}
+
+func (m Mysql) CustomColumnsUpdateSql(columns []string) string {
+	return customColumnsUpdateSql(columns, questionMark)
+}
+
+func (m Mysql) CustomColumnsSelectSql(columns []string) string {
+	return customColumnsSelectSql(columns, "?")
+}
+
+func (m Mysql) ReadOnlySql() string {
+	return "SELECT @@global.read_only"
+}
+
+func (m Mysql) SchemaColumnsSql() string {
+	return `SELECT table_name, column_name, column_type
+                FROM information_schema.columns
+                WHERE table_schema = (SELECT DATABASE())`
+}
+
+func (m Mysql) CreateMigrationSqlTableSql() string {
+	return `CREATE TABLE IF NOT EXISTS gomigrate_sql (
+                  migration_id    BIGINT NOT NULL,
+                  direction       VARCHAR(4) NOT NULL,
+                  applied_at_ns   BIGINT NOT NULL,
+                  statement_index INTEGER NOT NULL,
+                  sql_text        TEXT NOT NULL
+                )`
+}
+
+func (m Mysql) MigrationSqlInsertSql() string {
+	return "INSERT INTO gomigrate_sql (migration_id, direction, applied_at_ns, statement_index, sql_text) VALUES (?, ?, ?, ?, ?)"
+}
+
+func (m Mysql) MigrationSqlLatestSql() string {
+	return "SELECT MAX(applied_at_ns) FROM gomigrate_sql WHERE migration_id = ? AND direction = ?"
+}
+
+func (m Mysql) MigrationSqlSelectSql() string {
+	return "SELECT sql_text FROM gomigrate_sql WHERE migration_id = ? AND applied_at_ns = ? AND statement_index = ?"
+}
+
+func (m Mysql) CreateLockTableSql() string {
+	return `CREATE TABLE IF NOT EXISTS gomigrate_lock (
+                  id            INTEGER PRIMARY KEY,
+                  owner         VARCHAR(255) NOT NULL,
+                  expires_at_ns BIGINT NOT NULL
+                )`
+}
+
+func (m Mysql) InsertLockRowSql() string {
+	return "INSERT IGNORE INTO gomigrate_lock (id, owner, expires_at_ns) VALUES (1, '', 0)"
+}
+
+func (m Mysql) AcquireLockSql() string {
+	return "UPDATE gomigrate_lock SET owner = ?, expires_at_ns = ? WHERE id = 1 AND (owner = '' OR owner = ? OR expires_at_ns < ?)"
+}
+
+func (m Mysql) ReleaseLockSql() string {
+	return "UPDATE gomigrate_lock SET owner = '', expires_at_ns = 0 WHERE id = 1 AND owner = ?"
+}
+
+func (m Mysql) SelectLockSql() string {
+	return "SELECT owner, expires_at_ns FROM gomigrate_lock WHERE id = 1"
+}
+
+func (m Mysql) CreateHeartbeatTableSql() string {
+	return `CREATE TABLE IF NOT EXISTS gomigrate_heartbeat (
+                  owner         VARCHAR(255) NOT NULL,
+                  migration_id  BIGINT NOT NULL,
+                  started_at_ns BIGINT NOT NULL,
+                  last_seen_ns  BIGINT NOT NULL,
+                  PRIMARY KEY (owner, migration_id)
+                )`
+}
+
+func (m Mysql) InsertHeartbeatSql() string {
+	return "INSERT INTO gomigrate_heartbeat (owner, migration_id, started_at_ns, last_seen_ns) VALUES (?, ?, ?, ?)"
+}
+
+func (m Mysql) UpdateHeartbeatSql() string {
+	return "UPDATE gomigrate_heartbeat SET last_seen_ns = ? WHERE owner = ? AND migration_id = ?"
+}
+
+func (m Mysql) DeleteHeartbeatSql() string {
+	return "DELETE FROM gomigrate_heartbeat WHERE owner = ? AND migration_id = ?"
+}
+
+func (m Mysql) SelectHeartbeatsSql() string {
+	return "SELECT owner, migration_id, started_at_ns, last_seen_ns FROM gomigrate_heartbeat ORDER BY started_at_ns"
+}
+
+func (m Mysql) CreateBackfillTableSql() string {
+	return `CREATE TABLE IF NOT EXISTS gomigrate_backfill (
+                  migration_id  BIGINT PRIMARY KEY,
+                  last_key      BIGINT NOT NULL,
+                  rows_affected BIGINT NOT NULL,
+                  updated_at_ns BIGINT NOT NULL
+                )`
+}
+
+func (m Mysql) SelectBackfillSql() string {
+	return "SELECT last_key, rows_affected FROM gomigrate_backfill WHERE migration_id = ?"
+}
+
+func (m Mysql) InsertBackfillSql() string {
+	return "INSERT INTO gomigrate_backfill (migration_id, last_key, rows_affected, updated_at_ns) VALUES (?, ?, ?, ?)"
+}
+
+func (m Mysql) UpdateBackfillSql() string {
+	return "UPDATE gomigrate_backfill SET last_key = ?, rows_affected = ?, updated_at_ns = ? WHERE migration_id = ?"
+}
+
+func (m Mysql) DeleteBackfillSql() string {
+	return "DELETE FROM gomigrate_backfill WHERE migration_id = ?"
+}
+
+func (m Mysql) AnalyzeSql(table string) string {
+	return "ANALYZE TABLE " + table
+}
+
+// OPTIMIZE TABLE rebuilds the table, so dead rows are left to InnoDB's
+// purge.
+func (m Mysql) VacuumSql(table string) string {
+	return ""
+}
+
+// MySQL can't copy a database in a statement.
+func (m Mysql) CreateDatabaseSql(name, template string) string {
+	if template != "" {
+		return ""
+	}
+	return "CREATE DATABASE `" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func (m Mysql) DropDatabaseSql(name string) string {
+	return "DROP DATABASE IF EXISTS `" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func (m Mysql) CurrentDatabaseSql() string {
+	return "SELECT DATABASE()"
+}
+
+func (m Mysql) CreateVersionTableSql() string {
+	return `CREATE TABLE IF NOT EXISTS gomigrate_version (
+                  id      INTEGER PRIMARY KEY,
+                  version INTEGER NOT NULL
+                )`
+}
+
+func (m Mysql) InsertVersionRowSql() string {
+	return "INSERT IGNORE INTO gomigrate_version (id, version) VALUES (1, 1)"
+}
+
+func (m Mysql) SelectVersionSql() string {
+	return "SELECT version FROM gomigrate_version WHERE id = 1"
+}
+
+func (m Mysql) UpdateVersionSql() string {
+	return "UPDATE gomigrate_version SET version = ? WHERE id = 1"
+}
+
+func (m Mysql) ExplainSql(statement string) string {
+	return "EXPLAIN FORMAT=JSON " + statement
+}
+
+func (m Mysql) ParseExplain(output string) (*Estimate, error) {
+	return parseMysqlExplain(output)
+}
+
+func (m Mysql) ServerVersionSql() string {
+	return "SELECT VERSION()"
+}
+
+// MySQL commits DDL statements implicitly.
+func (m Mysql) Capabilities() Capability {
+	return Savepoints | ConcurrentMigrations
 }
 
 // MARIADB
@@ -112,3 +672,559 @@ func (s Sqlite3) MigrationLogDeleteSql() string {
 func (s Sqlite3) GetMigrationCommands(sql string) []string {
 	return []string{sql}
 }
+
+func (s Sqlite3) MigrationStatsUpdateSql() string {
+	return "UPDATE gomigrate SET duration_ms = ?, statement_count = ?, applied_at_ns = ? WHERE migration_id = ?"
+}
+
+func (s Sqlite3) MigrationStatsSelectSql() string {
+	return "SELECT duration_ms, statement_count, applied_at_ns FROM gomigrate WHERE migration_id = ?"
+}
+
+func (s Sqlite3) MigrationAuditUpdateSql() string {
+	return "UPDATE gomigrate SET applied_by = ?, applied_host = ?, application = ?, library_version = ?, build_info = ?, runner = ? WHERE migration_id = ?"
+}
+
+func (s Sqlite3) MigrationAuditSelectSql() string {
+	return "SELECT applied_by, applied_host, application, library_version, build_info, runner FROM gomigrate WHERE migration_id = ?"
+}
+
+func (s Sqlite3) MigrationChecksumUpdateSql() string {
+	return "UPDATE gomigrate SET checksum = ?, checksum_algorithm = ? WHERE migration_id = ?"
+}
+
+func (s Sqlite3) MigrationChecksumSelectSql() string {
+	return "SELECT checksum, checksum_algorithm FROM gomigrate WHERE migration_id = ?"
+}
+
+func (s Sqlite3) MigrationSkipUpdateSql() string {
+	return "UPDATE gomigrate SET skip_reason = ? WHERE migration_id = ?"
+}
+
+func (s Sqlite3) MigrationSkipSelectSql() string {
+	return "SELECT skip_reason FROM gomigrate WHERE migration_id = ?"
+}
+
+func (s Sqlite3) MigrationDescriptionUpdateSql() string {
+	return "UPDATE gomigrate SET description = ? WHERE migration_id = ?"
+}
+
+func (s Sqlite3) MigrationDescriptionSelectSql() string {
+	return "SELECT description FROM gomigrate WHERE migration_id = ?"
+}
+
+func (s Sqlite3) MigrationMetadataUpdateSql() string {
+	return "UPDATE gomigrate SET author = ?, ticket = ? WHERE migration_id = ?"
+}
+
+func (s Sqlite3) MigrationMetadataSelectSql() string {
+	return "SELECT author, ticket FROM gomigrate WHERE migration_id = ?"
+}
+
+func (s Sqlite3) MigrationPhaseUpdateSql() string {
+	return "UPDATE gomigrate SET phase = ? WHERE migration_id = ?"
+}
+
+func (s Sqlite3) MigrationPhaseSelectSql() string {
+	return "SELECT phase FROM gomigrate WHERE migration_id = ?"
+}
+
+func (s Sqlite3) CustomColumnsUpdateSql(columns []string) string {
+	return customColumnsUpdateSql(columns, questionMark)
+}
+
+func (s Sqlite3) CustomColumnsSelectSql(columns []string) string {
+	return customColumnsSelectSql(columns, "?")
+}
+
+func (s Sqlite3) ReadOnlySql() string {
+	return "SELECT query_only FROM pragma_query_only"
+}
+
+func (s Sqlite3) SchemaColumnsSql() string {
+	return `SELECT m.name, p.name, p.type
+  FROM sqlite_master m JOIN pragma_table_info(m.name) p
+  WHERE m.type = 'table'`
+}
+
+func (s Sqlite3) CreateMigrationSqlTableSql() string {
+	return `CREATE TABLE IF NOT EXISTS gomigrate_sql (
+                  migration_id    BIGINT NOT NULL,
+                  direction       VARCHAR(4) NOT NULL,
+                  applied_at_ns   BIGINT NOT NULL,
+                  statement_index INTEGER NOT NULL,
+                  sql_text        TEXT NOT NULL
+                )`
+}
+
+func (s Sqlite3) MigrationSqlInsertSql() string {
+	return "INSERT INTO gomigrate_sql (migration_id, direction, applied_at_ns, statement_index, sql_text) VALUES (?, ?, ?, ?, ?)"
+}
+
+func (s Sqlite3) MigrationSqlLatestSql() string {
+	return "SELECT MAX(applied_at_ns) FROM gomigrate_sql WHERE migration_id = ? AND direction = ?"
+}
+
+func (s Sqlite3) MigrationSqlSelectSql() string {
+	return "SELECT sql_text FROM gomigrate_sql WHERE migration_id = ? AND applied_at_ns = ? AND statement_index = ?"
+}
+
+func (s Sqlite3) CreateLockTableSql() string {
+	return `CREATE TABLE IF NOT EXISTS gomigrate_lock (
+                  id            INTEGER PRIMARY KEY,
+                  owner         VARCHAR(255) NOT NULL,
+                  expires_at_ns BIGINT NOT NULL
+                )`
+}
+
+func (s Sqlite3) InsertLockRowSql() string {
+	return "INSERT OR IGNORE INTO gomigrate_lock (id, owner, expires_at_ns) VALUES (1, '', 0)"
+}
+
+func (s Sqlite3) AcquireLockSql() string {
+	return "UPDATE gomigrate_lock SET owner = ?, expires_at_ns = ? WHERE id = 1 AND (owner = '' OR owner = ? OR expires_at_ns < ?)"
+}
+
+func (s Sqlite3) ReleaseLockSql() string {
+	return "UPDATE gomigrate_lock SET owner = '', expires_at_ns = 0 WHERE id = 1 AND owner = ?"
+}
+
+func (s Sqlite3) SelectLockSql() string {
+	return "SELECT owner, expires_at_ns FROM gomigrate_lock WHERE id = 1"
+}
+
+func (s Sqlite3) CreateHeartbeatTableSql() string {
+	return `CREATE TABLE IF NOT EXISTS gomigrate_heartbeat (
+                  owner         VARCHAR(255) NOT NULL,
+                  migration_id  BIGINT NOT NULL,
+                  started_at_ns BIGINT NOT NULL,
+                  last_seen_ns  BIGINT NOT NULL,
+                  PRIMARY KEY (owner, migration_id)
+                )`
+}
+
+func (s Sqlite3) InsertHeartbeatSql() string {
+	return "INSERT INTO gomigrate_heartbeat (owner, migration_id, started_at_ns, last_seen_ns) VALUES (?, ?, ?, ?)"
+}
+
+func (s Sqlite3) UpdateHeartbeatSql() string {
+	return "UPDATE gomigrate_heartbeat SET last_seen_ns = ? WHERE owner = ? AND migration_id = ?"
+}
+
+func (s Sqlite3) DeleteHeartbeatSql() string {
+	return "DELETE FROM gomigrate_heartbeat WHERE owner = ? AND migration_id = ?"
+}
+
+func (s Sqlite3) SelectHeartbeatsSql() string {
+	return "SELECT owner, migration_id, started_at_ns, last_seen_ns FROM gomigrate_heartbeat ORDER BY started_at_ns"
+}
+
+func (s Sqlite3) CreateBackfillTableSql() string {
+	return `CREATE TABLE IF NOT EXISTS gomigrate_backfill (
+                  migration_id  BIGINT PRIMARY KEY,
+                  last_key      BIGINT NOT NULL,
+                  rows_affected BIGINT NOT NULL,
+                  updated_at_ns BIGINT NOT NULL
+                )`
+}
+
+func (s Sqlite3) SelectBackfillSql() string {
+	return "SELECT last_key, rows_affected FROM gomigrate_backfill WHERE migration_id = ?"
+}
+
+func (s Sqlite3) InsertBackfillSql() string {
+	return "INSERT INTO gomigrate_backfill (migration_id, last_key, rows_affected, updated_at_ns) VALUES (?, ?, ?, ?)"
+}
+
+func (s Sqlite3) UpdateBackfillSql() string {
+	return "UPDATE gomigrate_backfill SET last_key = ?, rows_affected = ?, updated_at_ns = ? WHERE migration_id = ?"
+}
+
+func (s Sqlite3) DeleteBackfillSql() string {
+	return "DELETE FROM gomigrate_backfill WHERE migration_id = ?"
+}
+
+func (s Sqlite3) AnalyzeSql(table string) string {
+	return "ANALYZE " + table
+}
+
+// VACUUM rebuilds the whole database file while locking it.
+func (s Sqlite3) VacuumSql(table string) string {
+	return ""
+}
+
+func (s Sqlite3) CreateVersionTableSql() string {
+	return `CREATE TABLE IF NOT EXISTS gomigrate_version (
+                  id      INTEGER PRIMARY KEY,
+                  version INTEGER NOT NULL
+                )`
+}
+
+func (s Sqlite3) InsertVersionRowSql() string {
+	return "INSERT OR IGNORE INTO gomigrate_version (id, version) VALUES (1, 1)"
+}
+
+func (s Sqlite3) SelectVersionSql() string {
+	return "SELECT version FROM gomigrate_version WHERE id = 1"
+}
+
+func (s Sqlite3) UpdateVersionSql() string {
+	return "UPDATE gomigrate_version SET version = ? WHERE id = 1"
+}
+
+// SQLite compiles any statement it explains without running it.
+func (s Sqlite3) CheckStatementSql(statement string) string {
+	return "EXPLAIN " + statement
+}
+
+func (s Sqlite3) SyntaxError(err error) bool {
+	for _, message := range []string{"syntax error", "incomplete input", "unrecognized token"} {
+		if strings.Contains(err.Error(), message) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Sqlite3) ServerVersionSql() string {
+	return "SELECT sqlite_version()"
+}
+
+// SQLite only allows one writer at a time.
+func (s Sqlite3) Capabilities() Capability {
+	return TransactionalDdl | Savepoints
+}
+
+// SNOWFLAKE
+
+// Snowflake commits DDL statements implicitly, so a migration failing
+// after a DDL statement is left partially applied. Migrations are split
+// into statements, since the driver runs one statement at a time.
+//
+// Role, Warehouse, Database and Schema, when set, are selected at the
+// start of every migration, as pooled connections don't share a
+// session. Unquoted identifiers are stored in uppercase by Snowflake.
+type Snowflake struct {
+	Role      string
+	Warehouse string
+	Database  string
+	Schema    string
+}
+
+func (s Snowflake) SelectMigrationTableSql() string {
+	return "SELECT table_name FROM information_schema.tables WHERE table_name = UPPER(?) AND table_schema = CURRENT_SCHEMA()"
+}
+
+func (s Snowflake) CreateMigrationTableSql() string {
+	return `CREATE TABLE gomigrate (
+                  id           INTEGER      AUTOINCREMENT PRIMARY KEY,
+                  migration_id BIGINT       NOT NULL UNIQUE
+                )`
+}
+
+func (s Snowflake) GetMigrationSql() string {
+	return "SELECT migration_id FROM gomigrate WHERE migration_id = ?"
+}
+
+func (s Snowflake) MigrationLogInsertSql() string {
+	return "INSERT INTO gomigrate (migration_id) values (?)"
+}
+
+func (s Snowflake) MigrationLogDeleteSql() string {
+	return "DELETE FROM gomigrate WHERE migration_id = ?"
+}
+
+func (s Snowflake) GetMigrationCommands(sql string) []string {
+	return splitStatements(sql)
+}
+
+func (s Snowflake) MigrationStatsUpdateSql() string {
+	return "UPDATE gomigrate SET duration_ms = ?, statement_count = ?, applied_at_ns = ? WHERE migration_id = ?"
+}
+
+func (s Snowflake) MigrationStatsSelectSql() string {
+	return "SELECT duration_ms, statement_count, applied_at_ns FROM gomigrate WHERE migration_id = ?"
+}
+
+func (s Snowflake) MigrationAuditUpdateSql() string {
+	return "UPDATE gomigrate SET applied_by = ?, applied_host = ?, application = ?, library_version = ?, build_info = ?, runner = ? WHERE migration_id = ?"
+}
+
+func (s Snowflake) MigrationAuditSelectSql() string {
+	return "SELECT applied_by, applied_host, application, library_version, build_info, runner FROM gomigrate WHERE migration_id = ?"
+}
+
+func (s Snowflake) MigrationChecksumUpdateSql() string {
+	return "UPDATE gomigrate SET checksum = ?, checksum_algorithm = ? WHERE migration_id = ?"
+}
+
+func (s Snowflake) MigrationChecksumSelectSql() string {
+	return "SELECT checksum, checksum_algorithm FROM gomigrate WHERE migration_id = ?"
+}
+
+func (s Snowflake) WidenChecksumSql() string {
+	return "ALTER TABLE gomigrate ALTER COLUMN checksum SET DATA TYPE VARCHAR(128)"
+}
+
+func (s Snowflake) MigrationSkipUpdateSql() string {
+	return "UPDATE gomigrate SET skip_reason = ? WHERE migration_id = ?"
+}
+
+func (s Snowflake) MigrationSkipSelectSql() string {
+	return "SELECT skip_reason FROM gomigrate WHERE migration_id = ?"
+}
+
+func (s Snowflake) MigrationDescriptionUpdateSql() string {
+	return "UPDATE gomigrate SET description = ? WHERE migration_id = ?"
+}
+
+func (s Snowflake) MigrationDescriptionSelectSql() string {
+	return "SELECT description FROM gomigrate WHERE migration_id = ?"
+}
+
+func (s Snowflake) MigrationMetadataUpdateSql() string {
+	return "UPDATE gomigrate SET author = ?, ticket = ? WHERE migration_id = ?"
+}
+
+func (s Snowflake) MigrationMetadataSelectSql() string {
+	return "SELECT author, ticket FROM gomigrate WHERE migration_id = ?"
+}
+
+func (s Snowflake) MigrationPhaseUpdateSql() string {
+	return "UPDATE gomigrate SET phase = ? WHERE migration_id = ?"
+}
+
+func (s Snowflake) MigrationPhaseSelectSql() string {
+	return "SELECT phase FROM gomigrate WHERE migration_id = ?"
+}
+
+func (s Snowflake) CustomColumnsUpdateSql(columns []string) string {
+	return customColumnsUpdateSql(columns, questionMark)
+}
+
+func (s Snowflake) CustomColumnsSelectSql(columns []string) string {
+	return customColumnsSelectSql(columns, "?")
+}
+
+func (s Snowflake) SchemaColumnsSql() string {
+	return `SELECT LOWER(table_name), LOWER(column_name), data_type
+                FROM information_schema.columns
+                WHERE table_schema = CURRENT_SCHEMA()`
+}
+
+func (s Snowflake) CreateMigrationSqlTableSql() string {
+	return `CREATE TABLE IF NOT EXISTS gomigrate_sql (
+                  migration_id    BIGINT NOT NULL,
+                  direction       VARCHAR(4) NOT NULL,
+                  applied_at_ns   BIGINT NOT NULL,
+                  statement_index INTEGER NOT NULL,
+                  sql_text        TEXT NOT NULL
+                )`
+}
+
+func (s Snowflake) MigrationSqlInsertSql() string {
+	return "INSERT INTO gomigrate_sql (migration_id, direction, applied_at_ns, statement_index, sql_text) VALUES (?, ?, ?, ?, ?)"
+}
+
+func (s Snowflake) MigrationSqlLatestSql() string {
+	return "SELECT MAX(applied_at_ns) FROM gomigrate_sql WHERE migration_id = ? AND direction = ?"
+}
+
+func (s Snowflake) MigrationSqlSelectSql() string {
+	return "SELECT sql_text FROM gomigrate_sql WHERE migration_id = ? AND applied_at_ns = ? AND statement_index = ?"
+}
+
+func (s Snowflake) SessionSql() []string {
+	statements := make([]string, 0)
+	for _, setting := range []struct{ kind, name string }{
+		{"ROLE", s.Role},
+		{"WAREHOUSE", s.Warehouse},
+		{"DATABASE", s.Database},
+		{"SCHEMA", s.Schema},
+	} {
+		if setting.name != "" {
+			statements = append(statements, "USE "+setting.kind+" "+snowflakeIdentifier(setting.name))
+		}
+	}
+	return statements
+}
+
+func (s Snowflake) Capabilities() Capability {
+	return ConcurrentMigrations
+}
+
+// Quotes a name as a Snowflake IDENTIFIER() literal, keeping its case
+// rules while escaping quotes.
+func snowflakeIdentifier(name string) string {
+	name = strings.Replace(name, `\`, `\\`, -1)
+	name = strings.Replace(name, "'", `\'`, -1)
+	return "IDENTIFIER('" + name + "')"
+}
+
+// TIDB
+
+// TiDB runs DDL statements as jobs that commit implicitly and can't be
+// grouped in a transaction. Each statement of a migration runs on its
+// own and the migration is logged once its DDL jobs are synced.
+type Tidb struct {
+	Mysql
+}
+
+func (t Tidb) CreateMigrationTableSql() string {
+	return `CREATE TABLE gomigrate (
+                  id           INT          NOT NULL AUTO_INCREMENT,
+                  migration_id BIGINT       NOT NULL UNIQUE,
+                  PRIMARY KEY (id)
+                )`
+}
+
+func (t Tidb) GetMigrationCommands(sql string) []string {
+	return sqlsplit.Mysql.Split(sql)
+}
+
+func (t Tidb) PendingDdlSql() string {
+	return "SELECT COUNT(*) FROM information_schema.ddl_jobs WHERE state NOT IN ('synced', 'cancelled', 'rollback done')"
+}
+
+// Statements run outside of transactions, so savepoints don't apply.
+func (t Tidb) Capabilities() Capability {
+	return ConcurrentMigrations
+}
+
+// VITESS
+
+// Vitess runs each statement of a migration on its own, outside of a
+// transaction. DDL statements must be synchronous, with the "direct"
+// ddl_strategy, so they completed before the migration is logged.
+type Vitess struct {
+	Tidb
+}
+
+func (v Vitess) PendingDdlSql() string {
+	return ""
+}
+
+// SPANNER
+
+// Cloud Spanner can't run DDL statements through a SQL connection; use
+// it with the spannerexecutor package, which submits them through the
+// database admin API. The migrations table is created with every column
+// up front, since its upgrades use types Spanner doesn't support.
+type Spanner struct{}
+
+func (s Spanner) SelectMigrationTableSql() string {
+	return "SELECT table_name FROM information_schema.tables WHERE table_schema = '' AND table_name = @p1"
+}
+
+func (s Spanner) CreateMigrationTableSql() string {
+	return `CREATE TABLE gomigrate (
+                  migration_id       INT64 NOT NULL,
+                  duration_ms        INT64,
+                  statement_count    INT64,
+                  applied_by         STRING(255),
+                  applied_host       STRING(255),
+                  application        STRING(255),
+                  library_version    STRING(255),
+                  checksum           STRING(128),
+                  skip_reason        STRING(255),
+                  checksum_algorithm STRING(32),
+                  build_info         STRING(255),
+                  runner             STRING(255),
+                  applied_at_ns      INT64,
+                  description        STRING(1024),
+                  author             STRING(255),
+                  ticket             STRING(255),
+                  phase              STRING(32)
+                ) PRIMARY KEY (migration_id)`
+}
+
+func (s Spanner) GetMigrationSql() string {
+	return "SELECT migration_id FROM gomigrate WHERE migration_id = @p1"
+}
+
+func (s Spanner) MigrationLogInsertSql() string {
+	return "INSERT INTO gomigrate (migration_id) VALUES (@p1)"
+}
+
+func (s Spanner) MigrationLogDeleteSql() string {
+	return "DELETE FROM gomigrate WHERE migration_id = @p1"
+}
+
+func (s Spanner) GetMigrationCommands(sql string) []string {
+	return splitStatements(sql)
+}
+
+func (s Spanner) MigrationStatsUpdateSql() string {
+	return "UPDATE gomigrate SET duration_ms = @p1, statement_count = @p2, applied_at_ns = @p3 WHERE migration_id = @p4"
+}
+
+func (s Spanner) MigrationStatsSelectSql() string {
+	return "SELECT duration_ms, statement_count, applied_at_ns FROM gomigrate WHERE migration_id = @p1"
+}
+
+func (s Spanner) MigrationAuditUpdateSql() string {
+	return "UPDATE gomigrate SET applied_by = @p1, applied_host = @p2, application = @p3, library_version = @p4, build_info = @p5, runner = @p6 WHERE migration_id = @p7"
+}
+
+func (s Spanner) MigrationAuditSelectSql() string {
+	return "SELECT applied_by, applied_host, application, library_version, build_info, runner FROM gomigrate WHERE migration_id = @p1"
+}
+
+func (s Spanner) MigrationChecksumUpdateSql() string {
+	return "UPDATE gomigrate SET checksum = @p1, checksum_algorithm = @p2 WHERE migration_id = @p3"
+}
+
+func (s Spanner) MigrationChecksumSelectSql() string {
+	return "SELECT checksum, checksum_algorithm FROM gomigrate WHERE migration_id = @p1"
+}
+
+func (s Spanner) MigrationSkipUpdateSql() string {
+	return "UPDATE gomigrate SET skip_reason = @p1 WHERE migration_id = @p2"
+}
+
+func (s Spanner) MigrationSkipSelectSql() string {
+	return "SELECT skip_reason FROM gomigrate WHERE migration_id = @p1"
+}
+
+func (s Spanner) MigrationDescriptionUpdateSql() string {
+	return "UPDATE gomigrate SET description = @p1 WHERE migration_id = @p2"
+}
+
+func (s Spanner) MigrationDescriptionSelectSql() string {
+	return "SELECT description FROM gomigrate WHERE migration_id = @p1"
+}
+
+func (s Spanner) MigrationMetadataUpdateSql() string {
+	return "UPDATE gomigrate SET author = @p1, ticket = @p2 WHERE migration_id = @p3"
+}
+
+func (s Spanner) MigrationMetadataSelectSql() string {
+	return "SELECT author, ticket FROM gomigrate WHERE migration_id = @p1"
+}
+
+func (s Spanner) MigrationPhaseUpdateSql() string {
+	return "UPDATE gomigrate SET phase = @p1 WHERE migration_id = @p2"
+}
+
+func (s Spanner) MigrationPhaseSelectSql() string {
+	return "SELECT phase FROM gomigrate WHERE migration_id = @p1"
+}
+
+func (s Spanner) CustomColumnsUpdateSql(columns []string) string {
+	return customColumnsUpdateSql(columns, func(n int) string { return fmt.Sprintf("@p%d", n) })
+}
+
+func (s Spanner) CustomColumnsSelectSql(columns []string) string {
+	return customColumnsSelectSql(columns, "@p1")
+}
+
+func (s Spanner) SchemaColumnsSql() string {
+	return `SELECT table_name, column_name, spanner_type
+                FROM information_schema.columns
+                WHERE table_schema = ''`
+}
+
+// Spanner applies one schema change at a time.
+func (s Spanner) Capabilities() Capability {
+	return 0
+}
@@ -0,0 +1,141 @@
+// Orders migrations by their declared dependencies.
+
+package gomigrate
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Parses a list of migration ids.
+func parseIds(fields []string) ([]uint64, error) {
+	ids := make([]uint64, 0, len(fields))
+	for _, field := range fields {
+		id, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Returns true if none of the dependencies of a migration are
+// unfinished. Migrations without declared dependencies depend on every
+// migration with a lower id, preserving the numeric order, so they can
+// only start once they're the lowest unfinished migration.
+func dependenciesApplied(migration *Migration, unfinished map[uint64]bool, lowest uint64) bool {
+	if len(migration.DependsOn) == 0 {
+		return migration.Id == lowest
+	}
+	for _, id := range migration.DependsOn {
+		if unfinished[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the lowest id in the set.
+func lowestId(ids map[uint64]bool) uint64 {
+	var lowest uint64
+	for id := range ids {
+		if lowest == 0 || id < lowest {
+			lowest = id
+		}
+	}
+	return lowest
+}
+
+// Returns the migrations, sorted by id, reordered so every migration
+// comes after its dependencies. Ties are broken by id, so migrations
+// without declared dependencies keep their numeric order. On a cycle,
+// the migrations ordered so far are returned with DependencyCycle.
+func orderByDependencies(migrations []*Migration) ([]*Migration, error) {
+	unordered := make(map[uint64]bool)
+	for _, migration := range migrations {
+		unordered[migration.Id] = true
+	}
+
+	ordered := make([]*Migration, 0, len(migrations))
+	for len(unordered) > 0 {
+		lowest := lowestId(unordered)
+		var next *Migration
+		for _, migration := range migrations {
+			if unordered[migration.Id] && dependenciesApplied(migration, unordered, lowest) {
+				next = migration
+				break
+			}
+		}
+		if next == nil {
+			return ordered, DependencyCycle
+		}
+		delete(unordered, next.Id)
+		ordered = append(ordered, next)
+	}
+	return ordered, nil
+}
+
+// Checks that every declared dependency exists and that the
+// dependencies contain no cycles, logging the offending migrations.
+func (m *Migrator) checkDependencies(migrations map[uint64]*Migration) error {
+	all := sortById(migrations)
+	for _, migration := range all {
+		for _, id := range migration.DependsOn {
+			if _, ok := migrations[id]; !ok {
+				m.warnf("Migration %d depends on unknown migration %d: %s", migration.Id, id, migration.UpPath)
+				return UnknownDependency
+			}
+		}
+	}
+
+	ordered, err := orderByDependencies(all)
+	if err != nil {
+		unordered := make(map[uint64]bool)
+		for _, migration := range all {
+			unordered[migration.Id] = true
+		}
+		for _, migration := range ordered {
+			delete(unordered, migration.Id)
+		}
+		m.warnf("Migration dependencies contain a cycle: %s", dependencyCycle(migrations, unordered))
+		return err
+	}
+	return nil
+}
+
+// Follows the dependencies of the unordered migrations, none of which
+// can be applied, until a migration repeats. Returns the cycle found,
+// such as "5 -> 7 -> 5".
+func dependencyCycle(migrations map[uint64]*Migration, unordered map[uint64]bool) string {
+	lowest := lowestId(unordered)
+	path := make([]uint64, 0)
+	seen := make(map[uint64]int)
+	id := lowest
+	for {
+		if i, ok := seen[id]; ok {
+			path = append(path[i:], id)
+			break
+		}
+		seen[id] = len(path)
+		path = append(path, id)
+
+		// Migrations without declared dependencies are blocked by the
+		// lowest unordered migration.
+		migration := migrations[id]
+		next := lowest
+		for _, dependency := range migration.DependsOn {
+			if unordered[dependency] {
+				next = dependency
+				break
+			}
+		}
+		id = next
+	}
+
+	ids := make([]string, len(path))
+	for i, id := range path {
+		ids[i] = strconv.FormatUint(id, 10)
+	}
+	return strings.Join(ids, " -> ")
+}
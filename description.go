@@ -0,0 +1,100 @@
+// Describes migrations with the comments leading their up file.
+
+package gomigrate
+
+import (
+	"database/sql"
+	"strings"
+	"unicode/utf8"
+)
+
+// Longest description recorded, in bytes.
+const maxDescriptionLength = 1024
+
+// Implemented by adapters that can record the description of applied
+// migrations.
+type MigrationDescriptionRecorder interface {
+	// Sets description for a migration id, in that order.
+	MigrationDescriptionUpdateSql() string
+
+	// Selects description for a migration id.
+	MigrationDescriptionSelectSql() string
+}
+
+// Returns the comments leading sql, before any statement, without their
+// markers and directives, as a single line. A blank line ends the
+// description.
+func parseDescription(sql string) string {
+	words := make([]string, 0)
+	inBlock := false
+	for _, line := range strings.Split(sql, "\n") {
+		line = strings.TrimSpace(line)
+		if !inBlock && strings.HasPrefix(line, "/*") {
+			inBlock = true
+			line = line[2:]
+		}
+		switch {
+		case inBlock:
+			if end := strings.Index(line, "*/"); end >= 0 {
+				inBlock = false
+				line = line[:end]
+			}
+			words = append(words, strings.Fields(strings.TrimPrefix(line, "*"))...)
+		case directiveLine.MatchString(line):
+		case strings.HasPrefix(line, "--"):
+			words = append(words, strings.Fields(strings.TrimLeft(line, "-"))...)
+		case line == "" && len(words) == 0:
+		default:
+			return strings.Join(words, " ")
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// Returns description cut to maxDescriptionLength bytes, between
+// characters.
+func truncateDescription(description string) string {
+	if len(description) <= maxDescriptionLength {
+		return description
+	}
+	end := maxDescriptionLength
+	for end > 0 && !utf8.RuneStart(description[end]) {
+		end--
+	}
+	return description[:end]
+}
+
+// Records the description of an applied migration in the transaction
+// it runs in.
+func (m *Migrator) recordDescription(transaction TxExecutor, migration *Migration) error {
+	recorder, ok := m.dbAdapter.(MigrationDescriptionRecorder)
+	if !ok || m.tableVersion < 9 || migration.Description == "" {
+		return nil
+	}
+	description := truncateDescription(migration.Description)
+	if _, err := transaction.Exec(recorder.MigrationDescriptionUpdateSql(), description, migration.Id); err != nil {
+		m.errorf("Error logging migration: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Loads the recorded description of an applied migration. Migrations
+// applied without one keep the description of their files.
+func (m *run) getMigrationDescription(migration *Migration) error {
+	recorder, ok := m.dbAdapter.(MigrationDescriptionRecorder)
+	if !ok || m.tableVersion < 9 {
+		return nil
+	}
+
+	var description sql.NullString
+	row := m.executor.QueryRow(recorder.MigrationDescriptionSelectSql(), migration.Id)
+	if err := row.Scan(&description); err != nil {
+		m.errorf("Error getting migration description for %s: %v", migration.Name, err)
+		return err
+	}
+	if description.String != "" {
+		migration.Description = description.String
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+// Parses options declared in comments of migration files.
+
+package gomigrate
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Matches "-- gomigrate: key=value" and "-- gomigrate: key value"
+// comments. The value is optional.
+var directiveLine = regexp.MustCompile(`(?m)^[ \t]*--[ \t]*gomigrate:[ \t]*([\w-]+)(?:[ \t]*=[ \t]*|[ \t]+)?([^\r\n]*?)[ \t\r]*$`)
+
+// Matches a key=value pair following the value of a directive on the
+// same line.
+var directivePair = regexp.MustCompile(`^([\w-]+)=(.*)$`)
+
+// Options declared in a migration file with comments such as:
+//
+//	-- gomigrate: timeout=30s
+//	-- gomigrate: tags data, long-running
+//	-- gomigrate: only dev,staging
+//	-- gomigrate: depends-on 20240101, 20240102
+//	-- gomigrate: no-transaction
+//	-- gomigrate: online-index
+//	-- gomigrate: backfill table=orders key=id chunk=10000
+//	-- gomigrate: phase=data
+//	-- gomigrate: window=off-peak
+//	-- gomigrate: throttle delay=100ms rows=5000 max-lag=10s
+//	-- gomigrate: irreversible
+//	-- gomigrate: author=jane ticket=PROJ-123
+//	-- gomigrate: requires postgres>=14
+//	-- gomigrate: requires-extension postgis
+//
+// A key=value directive may be followed by other key=value pairs on the
+// same line. Repeated keys accumulate their values. Keys unknown to
+// gomigrate are kept, so applications can declare their own options.
+type Directives map[string][]string
+
+// Returns the directives declared in sql.
+func ParseDirectives(sql string) Directives {
+	directives := make(Directives)
+	for _, match := range directiveLine.FindAllStringSubmatchIndex(sql, -1) {
+		key, value := sql[match[2]:match[3]], sql[match[4]:match[5]]
+		if strings.Contains(sql[match[3]:match[4]], "=") {
+			directives.addPairs(key, value)
+		} else {
+			directives[key] = append(directives[key], value)
+		}
+	}
+	return directives
+}
+
+// Adds the value of a key declared with "=", splitting off the
+// key=value pairs following it. The first word always belongs to key.
+func (d Directives) addPairs(key, value string) {
+	keys := []string{key}
+	values := [][]string{nil}
+	for i, word := range strings.Fields(value) {
+		if pair := directivePair.FindStringSubmatch(word); pair != nil && i > 0 {
+			keys = append(keys, pair[1])
+			values = append(values, []string{pair[2]})
+			continue
+		}
+		values[len(values)-1] = append(values[len(values)-1], word)
+	}
+	if len(keys) == 1 {
+		d[key] = append(d[key], value)
+		return
+	}
+	for i, key := range keys {
+		d[key] = append(d[key], strings.Join(values[i], " "))
+	}
+}
+
+// Returns true if the key is declared.
+func (d Directives) Has(key string) bool {
+	_, ok := d[key]
+	return ok
+}
+
+// Returns the last value declared for a key, or an empty string.
+func (d Directives) Get(key string) string {
+	values := d[key]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
+
+// Returns the values declared for a key, split on commas and spaces.
+func (d Directives) List(key string) []string {
+	list := make([]string, 0)
+	for _, value := range d[key] {
+		list = append(list, strings.FieldsFunc(value, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})...)
+	}
+	return list
+}
+
+// Sets the options of a migration declared by the directives of its up
+// file.
+func applyDirectives(migration *Migration, directives Directives) error {
+	dependsOn, err := parseIds(directives.List("depends-on"))
+	if err != nil {
+		return err
+	}
+	requires, err := parseRequirements(directives.List("requires"))
+	if err != nil {
+		return err
+	}
+	var backfill *Backfill
+	if directives.Has("backfill") {
+		if backfill, err = parseBackfill(directives.Get("backfill")); err != nil {
+			return err
+		}
+	}
+	var throttle *Throttle
+	if directives.Has("throttle") {
+		if throttle, err = parseThrottle(directives.Get("throttle")); err != nil {
+			return err
+		}
+	}
+	phase, err := parsePhase(directives, backfill)
+	if err != nil {
+		return err
+	}
+	var timeout time.Duration
+	if directives.Has("timeout") {
+		if timeout, err = time.ParseDuration(directives.Get("timeout")); err != nil {
+			return err
+		}
+	}
+
+	// Directives add to the metadata declared in a manifest.
+	migration.Directives = directives
+	migration.DependsOn = append(migration.DependsOn, dependsOn...)
+	migration.Environments = append(migration.Environments, directives.List("only")...)
+	migration.Tags = append(migration.Tags, directives.List("tags")...)
+	migration.Requires = append(migration.Requires, requires...)
+	migration.Extensions = append(migration.Extensions, directives.List("requires-extension")...)
+	migration.OnlineIndex = directives.Has("online-index")
+	migration.Backfill = backfill
+	migration.Throttle = throttle
+	if directives.Has("phase") || migration.Phase == "" {
+		migration.Phase = phase
+	}
+	migration.NoTransaction = directives.Has("no-transaction") || migration.OnlineIndex || backfill != nil
+	migration.Irreversible = migration.Irreversible || directives.Has("irreversible")
+	migration.Timeout = timeout
+	if directives.Has("window") {
+		migration.Window = directives.Get("window")
+	}
+	if directives.Has("author") {
+		migration.Author = directives.Get("author")
+	}
+	if directives.Has("ticket") {
+		migration.Ticket = directives.Get("ticket")
+	}
+	return nil
+}
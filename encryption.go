@@ -0,0 +1,80 @@
+// Decrypts migration files ending in .sql.enc.
+
+package gomigrate
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Decrypts encrypted migration files, for example with age, a key
+// management service or AESKey.
+type KeyProvider interface {
+	// Returns the plaintext of the encrypted migration file at path.
+	Decrypt(path string, ciphertext []byte) ([]byte, error)
+}
+
+// A 16, 24 or 32 byte key decrypting files encrypted with AES-GCM, with
+// the nonce prepended to the ciphertext as done by Encrypt.
+type AESKey []byte
+
+// Returns the ciphertext of a migration file, to be saved with the
+// .sql.enc extension.
+func (k AESKey) Encrypt(plaintext []byte) ([]byte, error) {
+	gcm, err := k.gcm()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (k AESKey) Decrypt(path string, ciphertext []byte) ([]byte, error) {
+	gcm, err := k.gcm()
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("Encrypted migration is too short")
+	}
+	nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, sealed, nil)
+}
+
+func (k AESKey) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Returns true for migration files that must be decrypted.
+func isEncrypted(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".sql.enc")
+}
+
+// Returns the plaintext of a migration file, decrypting it with keys
+// when it is encrypted.
+func (m *Migrator) decryptMigrationFile(keys KeyProvider, path string, data []byte) ([]byte, error) {
+	if !isEncrypted(path) {
+		return data, nil
+	}
+	if keys == nil {
+		m.warnf("No key provider to decrypt migration: %s", path)
+		return nil, MissingKeyProvider
+	}
+	plaintext, err := keys.Decrypt(path, data)
+	if err != nil {
+		m.errorf("Error decrypting migration %s: %v", path, err)
+		return nil, err
+	}
+	return plaintext, nil
+}
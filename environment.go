@@ -0,0 +1,30 @@
+// Restricts migrations to environments.
+
+package gomigrate
+
+// Returns true if the migration runs in the migrator's environment.
+// Migrations without only directives run everywhere; restricted
+// migrations never run when Environment is unset.
+func (m *Migrator) inEnvironment(migration *Migration) bool {
+	if len(migration.Environments) == 0 {
+		return true
+	}
+	for _, environment := range migration.Environments {
+		if environment == m.Environment {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the inactive migrations that run in the migrator's
+// environment.
+func (m *Migrator) pendingMigrations() []*Migration {
+	pending := make([]*Migration, 0)
+	for _, migration := range m.Migrations(Inactive) {
+		if m.inEnvironment(migration) {
+			pending = append(pending, migration)
+		}
+	}
+	return pending
+}
@@ -0,0 +1,115 @@
+// Emits typed events while migrations run.
+
+package gomigrate
+
+import (
+	"time"
+)
+
+// An event emitted while migrations run: MigrationStarted,
+// StatementExecuted, MigrationFinished or RunCompleted.
+type Event interface {
+	isEvent()
+}
+
+// Emitted before a migration is applied.
+type MigrationStarted struct {
+	Migration *Migration
+	Direction string
+}
+
+// Emitted after each statement of a migration succeeds.
+type StatementExecuted struct {
+	Migration *Migration
+
+	// Zero-based position of the statement and the number of
+	// statements in the migration.
+	Index int
+	Total int
+
+	Statement    string
+	RowsAffected int64
+	Duration     time.Duration
+
+	// Bytes of SQL executed so far, including the statement, and in
+	// the whole migration, so progress through large files can be
+	// reported.
+	Bytes      int64
+	TotalBytes int64
+
+	// Time since the migration started, and the time it will take to
+	// execute the remaining statements, estimated from the bytes
+	// executed so far. Statements of very different costs make the
+	// estimate rough.
+	Elapsed   time.Duration
+	Remaining time.Duration
+}
+
+// Returns the share of the migration's SQL executed, between 0 and 1.
+func (e StatementExecuted) Progress() float64 {
+	if e.TotalBytes == 0 {
+		return 1
+	}
+	return float64(e.Bytes) / float64(e.TotalBytes)
+}
+
+// Estimates the time left from the bytes executed in elapsed.
+func estimateRemaining(elapsed time.Duration, bytes, totalBytes int64) time.Duration {
+	if bytes <= 0 || bytes >= totalBytes {
+		return 0
+	}
+	return time.Duration(float64(elapsed) * float64(totalBytes-bytes) / float64(bytes))
+}
+
+// Emitted after a migration was applied or failed.
+type MigrationFinished struct {
+	Migration *Migration
+	Direction string
+	Duration  time.Duration
+	Summary   MigrationSummary
+	Err       error
+}
+
+// Totals of the statements a migration executed. When the migration
+// failed, only the statements that succeeded are counted.
+type MigrationSummary struct {
+	Statements   int
+	RowsAffected int64
+}
+
+// Emitted when Migrate or a rollback finishes, with the number of
+// migrations applied.
+type RunCompleted struct {
+	Direction string
+	Applied   int
+	Err       error
+}
+
+func (MigrationStarted) isEvent()  {}
+func (StatementExecuted) isEvent() {}
+func (MigrationFinished) isEvent() {}
+func (RunCompleted) isEvent()      {}
+
+// Registers a handler called synchronously with every event. Handlers
+// must be registered before migrations run, and must be safe for
+// concurrent use when Parallelism is set.
+func (m *Migrator) Subscribe(handler func(Event)) {
+	m.handlers = append(m.handlers, handler)
+}
+
+// Returns a channel receiving every event. Sends block until the event
+// is received, so the channel must be drained while migrations run.
+func (m *Migrator) Events() <-chan Event {
+	events := make(chan Event, 64)
+	m.Subscribe(func(e Event) {
+		events <- e
+	})
+	return events
+}
+
+// Sends an event to the registered handlers.
+func (m *Migrator) emit(e Event) {
+	for _, handler := range m.handlers {
+		handler(e)
+	}
+}
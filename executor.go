@@ -0,0 +1,139 @@
+// Abstracts the database connection used by the migrator.
+
+package gomigrate
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Executes statements against a database. Implementations must return
+// sql.ErrNoRows from Row.Scan when a query returns no rows.
+type DBExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) Row
+	BeginTx(opts *sql.TxOptions) (TxExecutor, error)
+}
+
+// A transaction opened by a DBExecutor. *sql.Tx implements it.
+type TxExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Commit() error
+	Rollback() error
+}
+
+// A single row returned by DBExecutor.QueryRow.
+type Row interface {
+	Scan(dest ...interface{}) error
+}
+
+// Adapts a *sql.DB to DBExecutor.
+type sqlExecutor struct {
+	db *sql.DB
+}
+
+func (s sqlExecutor) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return s.db.Exec(query, args...)
+}
+
+func (s sqlExecutor) QueryRow(query string, args ...interface{}) Row {
+	return s.db.QueryRow(query, args...)
+}
+
+func (s sqlExecutor) BeginTx(opts *sql.TxOptions) (TxExecutor, error) {
+	return s.db.BeginTx(context.Background(), opts)
+}
+
+// Adapts a *sql.Conn to DBExecutor.
+type connExecutor struct {
+	conn *sql.Conn
+}
+
+func (c connExecutor) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.conn.ExecContext(context.Background(), query, args...)
+}
+
+func (c connExecutor) QueryRow(query string, args ...interface{}) Row {
+	return c.conn.QueryRowContext(context.Background(), query, args...)
+}
+
+func (c connExecutor) BeginTx(opts *sql.TxOptions) (TxExecutor, error) {
+	return c.conn.BeginTx(context.Background(), opts)
+}
+
+// Runs statements one at a time outside of a transaction, for databases
+// that can't run DDL statements inside transactions. Commit and
+// Rollback do nothing, statements that ran stay applied.
+type autocommitExecutor struct {
+	executor DBExecutor
+}
+
+func (a autocommitExecutor) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return a.executor.Exec(query, args...)
+}
+
+func (a autocommitExecutor) Commit() error {
+	return nil
+}
+
+func (a autocommitExecutor) Rollback() error {
+	return nil
+}
+
+// Implemented by executors that can bind transactions to a context.
+type contextBeginner interface {
+	beginTxContext(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+func (s sqlExecutor) beginTxContext(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return s.db.BeginTx(ctx, opts)
+}
+
+func (c connExecutor) beginTxContext(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return c.conn.BeginTx(ctx, opts)
+}
+
+// A transaction whose statements are canceled once its context is done.
+type contextTx struct {
+	tx     *sql.Tx
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (c contextTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.tx.ExecContext(c.ctx, query, args...)
+}
+
+func (c contextTx) Commit() error {
+	defer c.cancel()
+	return c.tx.Commit()
+}
+
+// Transactions are rolled back by database/sql when their context is
+// done, so rolling back again isn't an error.
+func (c contextTx) Rollback() error {
+	defer c.cancel()
+	err := c.tx.Rollback()
+	if err == sql.ErrTxDone && c.ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// Opens a migration transaction canceled after timeout. Executors that
+// can't bind transactions to a context run migrations without timeout.
+func (m *run) beginWithTimeout(timeout time.Duration) (TxExecutor, error) {
+	beginner, ok := m.executor.(contextBeginner)
+	if !ok {
+		m.warnf("Executor does not support migration timeouts")
+		return m.executor.BeginTx(m.TxOptions)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	tx, err := beginner.beginTxContext(ctx, m.TxOptions)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return contextTx{tx, ctx, cancel}, nil
+}
@@ -0,0 +1,239 @@
+// Creates the linked migrations of zero-downtime schema changes.
+
+package gomigrate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// A schema change split into migrations applied in turn, so the
+// application keeps working while it is deployed between them: the
+// expand steps add what the new version needs, the backfill steps copy
+// data over and the contract steps remove what only the old version
+// used.
+type ScaffoldPattern struct {
+	Description string
+
+	// The parameters the templates of the steps refer to, as in
+	// {{.table}}.
+	Params []string
+
+	Steps []ScaffoldStep
+}
+
+// A migration of a ScaffoldPattern. Name, Up and Down are text/template
+// templates of the parameters of the pattern.
+type ScaffoldStep struct {
+	// expand, backfill or contract, declared as the tag of the
+	// migration.
+	Phase string
+
+	Name string
+	Up   string
+	Down string
+}
+
+// The patterns CreatePatternMigrations scaffolds, by name. Applications
+// can add their own.
+var ScaffoldPatterns = map[string]*ScaffoldPattern{
+	"add-column": {
+		Description: "adds a column, fills it and then makes it NOT NULL",
+		Params:      []string{"table", "column", "type", "value"},
+		Steps: []ScaffoldStep{
+			{
+				Phase: "expand",
+				Name:  "add_{{.column}}_to_{{.table}}",
+				Up:    "ALTER TABLE {{.table}} ADD COLUMN {{.column}} {{.type}};\n",
+				Down:  "ALTER TABLE {{.table}} DROP COLUMN {{.column}};\n",
+			},
+			{
+				Phase: "backfill",
+				Name:  "backfill_{{.column}}_of_{{.table}}",
+				Up:    "UPDATE {{.table}} SET {{.column}} = {{.value}} WHERE {{.column}} IS NULL;\n",
+				Down:  "-- The column is dropped by rolling back the migration adding it.\n",
+			},
+			{
+				Phase: "contract",
+				Name:  "require_{{.column}}_of_{{.table}}",
+				Up: "ALTER TABLE {{.table}} ADD CONSTRAINT {{.table}}_{{.column}}_not_null CHECK ({{.column}} IS NOT NULL) NOT VALID;\n" +
+					"ALTER TABLE {{.table}} VALIDATE CONSTRAINT {{.table}}_{{.column}}_not_null;\n" +
+					"ALTER TABLE {{.table}} ALTER COLUMN {{.column}} SET NOT NULL;\n" +
+					"ALTER TABLE {{.table}} DROP CONSTRAINT {{.table}}_{{.column}}_not_null;\n",
+				Down: "ALTER TABLE {{.table}} ALTER COLUMN {{.column}} DROP NOT NULL;\n",
+			},
+		},
+	},
+	"add-index": {
+		Description: "builds an index without blocking writes",
+		Params:      []string{"table", "index", "columns"},
+		Steps: []ScaffoldStep{
+			{
+				Phase: "expand",
+				Name:  "add_{{.index}}",
+				Up: "-- gomigrate: online-index\n" +
+					"CREATE INDEX CONCURRENTLY {{.index}} ON {{.table}} ({{.columns}});\n",
+				Down: "DROP INDEX CONCURRENTLY IF EXISTS {{.index}};\n",
+			},
+		},
+	},
+	"rename-column": {
+		Description: "renames a column through a duplicate the application writes to both",
+		Params:      []string{"table", "column", "new_column", "type"},
+		Steps: []ScaffoldStep{
+			{
+				Phase: "expand",
+				Name:  "add_{{.new_column}}_to_{{.table}}",
+				Up: "-- Deploy the application writing to both {{.column}} and {{.new_column}}\n" +
+					"-- before the backfill runs.\n" +
+					"ALTER TABLE {{.table}} ADD COLUMN {{.new_column}} {{.type}};\n",
+				Down: "ALTER TABLE {{.table}} DROP COLUMN {{.new_column}};\n",
+			},
+			{
+				Phase: "backfill",
+				Name:  "copy_{{.column}}_to_{{.new_column}}_of_{{.table}}",
+				Up:    "UPDATE {{.table}} SET {{.new_column}} = {{.column}} WHERE {{.new_column}} IS NULL;\n",
+				Down:  "-- The copy is dropped by rolling back the migration adding it.\n",
+			},
+			{
+				Phase: "contract",
+				Name:  "drop_{{.column}}_of_{{.table}}",
+				Up: "-- Deploy the application reading and writing {{.new_column}} only first.\n" +
+					"ALTER TABLE {{.table}} DROP COLUMN {{.column}};\n",
+				Down: "ALTER TABLE {{.table}} ADD COLUMN {{.column}} {{.type}};\n" +
+					"UPDATE {{.table}} SET {{.column}} = {{.new_column}};\n",
+			},
+		},
+	},
+	"rename-table": {
+		Description: "renames a table, keeping a view of the old name the old version reads and writes through",
+		Params:      []string{"table", "new_table"},
+		Steps: []ScaffoldStep{
+			{
+				Phase: "expand",
+				Name:  "rename_{{.table}}_to_{{.new_table}}",
+				Up: "ALTER TABLE {{.table}} RENAME TO {{.new_table}};\n" +
+					"CREATE VIEW {{.table}} AS SELECT * FROM {{.new_table}};\n",
+				Down: "DROP VIEW {{.table}};\n" +
+					"ALTER TABLE {{.new_table}} RENAME TO {{.table}};\n",
+			},
+			{
+				Phase: "contract",
+				Name:  "drop_{{.table}}_view",
+				Up: "-- Deploy the application using {{.new_table}} only first.\n" +
+					"DROP VIEW {{.table}};\n",
+				Down: "CREATE VIEW {{.table}} AS SELECT * FROM {{.new_table}};\n",
+			},
+		},
+	},
+}
+
+// Creates the migrations of a ScaffoldPattern, numbered in the order
+// they must be applied, and loads them. Each migration is tagged with
+// its phase, so deploys can apply one phase at a time with WithTags,
+// and depends on the migration before it. Returns an error naming the
+// patterns when name isn't one, or the parameters when params misses
+// any.
+func (m *Migrator) CreatePatternMigrations(name string, params map[string]string) ([]*Migration, error) {
+	pattern, ok := ScaffoldPatterns[name]
+	if !ok {
+		names := make([]string, 0, len(ScaffoldPatterns))
+		for name := range ScaffoldPatterns {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("Unknown pattern %s, expected one of %s", name, strings.Join(names, ", "))
+	}
+	for _, param := range pattern.Params {
+		if params[param] == "" {
+			return nil, fmt.Errorf("Pattern %s requires %s", name, strings.Join(pattern.Params, ", "))
+		}
+	}
+	source, ok := m.Source.(*FileMigrationSource)
+	if !ok {
+		return nil, errors.New("Creating migrations requires a FileMigrationSource")
+	}
+
+	m.mu.RLock()
+	ids := make([]uint64, 0, len(m.migrations))
+	for id := range m.migrations {
+		ids = append(ids, id)
+	}
+	m.mu.RUnlock()
+	generator := m.IdGenerator
+	if generator == nil {
+		generator = SequentialIds{}
+	}
+
+	now := m.now()
+	created := make([]uint64, 0, len(pattern.Steps))
+	for _, step := range pattern.Steps {
+		stepName, err := renderScaffold(step.Name, params)
+		if err != nil {
+			return nil, err
+		}
+		if !migrationName.MatchString(stepName) {
+			m.warnf("Invalid migration name: %s", stepName)
+			return nil, InvalidMigrationFile
+		}
+		id := generator.NextId(ids)
+		ids = append(ids, id)
+
+		header := fmt.Sprintf("-- gomigrate: tags %s\n", step.Phase)
+		if len(created) > 0 {
+			header += fmt.Sprintf("-- gomigrate: depends-on %d\n", created[len(created)-1])
+		}
+		created = append(created, id)
+		for _, mType := range []migrationType{upMigration, downMigration} {
+			body := header + step.Up
+			if mType == downMigration {
+				body = step.Down
+			}
+			sql, err := renderScaffold(body, params)
+			if err != nil {
+				return nil, err
+			}
+			path := source.newMigrationPath(id, stepName, mType, now)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return nil, err
+			}
+			if err := ioutil.WriteFile(path, []byte(sql), 0644); err != nil {
+				m.errorf("Error creating migration: %v", err)
+				return nil, err
+			}
+			m.infof("Created migration file: %s", path)
+		}
+	}
+
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	migrations := make([]*Migration, 0, len(created))
+	for _, id := range created {
+		migrations = append(migrations, m.migrations[id])
+	}
+	return migrations, nil
+}
+
+// Renders a template of a ScaffoldStep, failing on parameters the
+// pattern doesn't have.
+func renderScaffold(text string, params map[string]string) (string, error) {
+	tmpl, err := template.New("scaffold").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, params); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
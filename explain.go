@@ -0,0 +1,194 @@
+// Estimates the cost of the data changes of planned migrations.
+
+package gomigrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Implemented by adapters that can estimate the cost of a statement by
+// explaining it without running it.
+type Explainer interface {
+	// Returns a query explaining statement as a single text value.
+	ExplainSql(statement string) string
+
+	// Parses the output of the query.
+	ParseExplain(output string) (*Estimate, error)
+}
+
+// The estimated cost of a statement of a planned migration, as told by
+// the query planner of the database.
+type Estimate struct {
+	Statement string
+
+	// The cost in the planner's units and the number of rows the
+	// statement is expected to read or change.
+	Cost float64
+	Rows int64
+
+	// Tables read in full, which large tables make slow.
+	FullScans []string
+
+	// Why the statement couldn't be explained, such as because it
+	// refers to a table created by an earlier migration of the plan.
+	Error string
+}
+
+func (e *Estimate) String() string {
+	if e.Error != "" {
+		return fmt.Sprintf("can't explain: %s", e.Error)
+	}
+	s := fmt.Sprintf("cost %.2f, %d rows", e.Cost, e.Rows)
+	if len(e.FullScans) > 0 {
+		s += ", full scan of " + strings.Join(e.FullScans, ", ")
+	}
+	return s
+}
+
+// Matches the statements changing data.
+var dataStatement = regexp.MustCompile(`(?i)^\s*(INSERT|UPDATE|DELETE|MERGE|WITH)\b`)
+
+// Estimates the cost of the statements changing data in the migrations
+// a plan applies, so reviewers can spot statements rewriting or
+// scanning whole tables before they run. Statements are explained
+// without running them; those that can't be explained, such as
+// statements referring to tables created by earlier migrations of the
+// plan, get an estimate with an Error.
+// Returns ExplainUnsupported when the adapter doesn't implement
+// Explainer.
+func (m *Migrator) Explain(plan *Plan) error {
+	explainer, ok := m.dbAdapter.(Explainer)
+	if !ok {
+		return ExplainUnsupported
+	}
+	if plan.Direction != upMigration {
+		return nil
+	}
+	for _, planned := range plan.Migrations {
+		planned.Estimates = make([]*Estimate, 0)
+		for _, statement := range planned.Statements {
+			if !dataStatement.MatchString(lineComment.ReplaceAllString(statement, "")) {
+				continue
+			}
+			estimate, err := m.explain(explainer, statement)
+			if err != nil {
+				m.warnf("Can't explain a statement of migration %d: %v", planned.Migration.Id, err)
+				estimate = &Estimate{Error: err.Error()}
+			}
+			estimate.Statement = statement
+			planned.Estimates = append(planned.Estimates, estimate)
+		}
+	}
+	return nil
+}
+
+// Explains a statement without running it.
+func (m *Migrator) explain(explainer Explainer, statement string) (*Estimate, error) {
+	var output string
+	if err := m.executor.QueryRow(explainer.ExplainSql(statement)).Scan(&output); err != nil {
+		return nil, err
+	}
+	return explainer.ParseExplain(output)
+}
+
+// Returns statement on a single line, cut to n characters.
+func abbreviate(statement string, n int) string {
+	statement = strings.Join(strings.Fields(lineComment.ReplaceAllString(statement, "")), " ")
+	if runes := []rune(statement); len(runes) > n {
+		return string(runes[:n]) + "..."
+	}
+	return statement
+}
+
+// A node of a plan explained by Postgres with FORMAT JSON.
+type postgresPlanNode struct {
+	NodeType  string             `json:"Node Type"`
+	Relation  string             `json:"Relation Name"`
+	TotalCost float64            `json:"Total Cost"`
+	PlanRows  int64              `json:"Plan Rows"`
+	Plans     []postgresPlanNode `json:"Plans"`
+}
+
+// Returns the estimate of the top node of a Postgres plan, with the
+// tables scanned sequentially by any node.
+func parsePostgresExplain(output string) (*Estimate, error) {
+	var plans []struct {
+		Plan postgresPlanNode `json:"Plan"`
+	}
+	if err := json.Unmarshal([]byte(output), &plans); err != nil {
+		return nil, err
+	}
+	if len(plans) == 0 {
+		return nil, fmt.Errorf("empty plan")
+	}
+	top := plans[0].Plan
+	estimate := &Estimate{Cost: top.TotalCost, Rows: top.PlanRows, FullScans: make([]string, 0)}
+	var walk func(node postgresPlanNode)
+	walk = func(node postgresPlanNode) {
+		if node.NodeType == "Seq Scan" && node.Relation != "" {
+			estimate.FullScans = append(estimate.FullScans, node.Relation)
+		}
+		for _, child := range node.Plans {
+			walk(child)
+		}
+	}
+	walk(top)
+	return estimate, nil
+}
+
+// Returns the estimate of a MySQL plan explained with FORMAT=JSON: the
+// cost of the query block, the rows examined by its tables and the
+// tables accessed in full.
+func parseMysqlExplain(output string) (*Estimate, error) {
+	var plan map[string]interface{}
+	if err := json.Unmarshal([]byte(output), &plan); err != nil {
+		return nil, err
+	}
+	block, ok := plan["query_block"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("missing query block")
+	}
+	estimate := &Estimate{FullScans: make([]string, 0)}
+	if info, ok := block["cost_info"].(map[string]interface{}); ok {
+		estimate.Cost = mysqlNumber(info["query_cost"])
+	}
+	var walk func(value interface{})
+	walk = func(value interface{}) {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			if name, ok := value["table_name"].(string); ok {
+				estimate.Rows += int64(mysqlNumber(value["rows_examined_per_scan"]))
+				if value["access_type"] == "ALL" {
+					estimate.FullScans = append(estimate.FullScans, name)
+				}
+			}
+			for _, child := range value {
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range value {
+				walk(child)
+			}
+		}
+	}
+	walk(block)
+	sort.Strings(estimate.FullScans)
+	return estimate, nil
+}
+
+// MySQL writes some numbers of plans as strings.
+func mysqlNumber(value interface{}) float64 {
+	switch value := value.(type) {
+	case float64:
+		return value
+	case string:
+		number, _ := strconv.ParseFloat(value, 64)
+		return number
+	}
+	return 0
+}
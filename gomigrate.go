@@ -3,10 +3,13 @@
 package gomigrate
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"io/ioutil"
 	"sort"
+	"sync"
+	"time"
 )
 
 type migrationType string
@@ -15,22 +18,281 @@ const (
 	migrationTableName = "gomigrate"
 	upMigration        = migrationType("up")
 	downMigration      = migrationType("down")
+
+	// Migration directions accepted by ApplyMigration.
+	Up   = upMigration
+	Down = downMigration
 )
 
 var (
+	BackfillUnsupported   = errors.New("Adapter does not support backfill migrations")
+	ColumnsUnsupported    = errors.New("Adapter does not support custom columns")
+	DependencyCycle       = errors.New("Migration dependencies contain a cycle")
+	DirtyMigrationTable   = errors.New("Migrations table of the other tool is dirty")
+	ExplainUnsupported    = errors.New("Adapter does not support explaining statements")
+	HeartbeatUnsupported  = errors.New("Adapter does not support migration heartbeats")
+	IndexBuildUnsupported = errors.New("Adapter does not support building indexes online")
+	Interrupted           = errors.New("Migration run interrupted")
+	InvalidColumn         = errors.New("Invalid custom column")
+	InvalidApprovals      = errors.New("Invalid approvals file")
+	InvalidBackfill       = errors.New("Invalid backfill migration")
+	InvalidConfig         = errors.New("Invalid configuration file")
+	InvalidIndex          = errors.New("Index built concurrently is invalid")
+	InvalidInclude        = errors.New("Invalid included migration file")
+	InvalidManifest       = errors.New("Invalid migrations manifest")
 	InvalidMigrationFile  = errors.New("Invalid migration file")
 	InvalidMigrationPair  = errors.New("Invalid pair of migration files")
 	InvalidMigrationsPath = errors.New("Invalid migrations path")
 	InvalidMigrationType  = errors.New("Invalid migration type")
+	InvalidPending        = errors.New("Pending migrations failed validation")
+	InvalidRenumbering    = errors.New("Invalid migration renumbering")
+	InvalidShadow         = errors.New("Shadow database needs a DB, or a Name and an Open function")
+	InvalidSquashTarget   = errors.New("Invalid squash target")
+	InvalidState          = errors.New("Invalid migration state")
+	IrreversibleMigration = errors.New("Migration is irreversible")
+	LagProbeUnsupported   = errors.New("Adapter does not support measuring replication lag")
+	LockNotHeld           = errors.New("Migration lock is not held by the given owner")
+	LockingUnsupported    = errors.New("Adapter does not support the migration lock table")
+	MigrationIdGaps       = errors.New("Gaps found in migration ids")
+	MigrationLocked       = errors.New("Migrations are locked by another runner")
+	MissingExtension      = errors.New("Extension required by a migration is not installed")
+	MissingKeyProvider    = errors.New("No key provider to decrypt migration")
+	MissingPrivileges     = errors.New("Database user lacks privileges pending migrations need")
+	MissingSecret         = errors.New("Secret not found")
+	NewerMigrations       = errors.New("Database has migrations newer than the known migrations")
+	NewerTableLayout      = errors.New("Migrations table was upgraded by a newer version of gomigrate")
 	NoActiveMigrations    = errors.New("No active migrations to rollback")
+	OutOfOrderMigrations  = errors.New("Pending migrations are older than applied migrations")
+	PartiallyApplied      = errors.New("Migrations to squash are only partially applied")
+	PreflightFailed       = errors.New("Database failed the preflight checks")
+	PrivilegesUnsupported = errors.New("Adapter does not support checking privileges")
+	ReadOnlyDatabase      = errors.New("Database is read-only")
+	ReadOnlyMigrator      = errors.New("Migrator is read-only")
+	SavepointsUnsupported = errors.New("Adapter does not support savepoints")
+	ShadowIsTarget        = errors.New("Shadow database is named after the target database")
+	ShadowUnsupported     = errors.New("Adapter does not support creating shadow databases")
+	ServerTooOld          = errors.New("Database server is older than a migration requires")
+	SkipUnsupported       = errors.New("Adapter does not support skipping migrations")
+	SqlLogUnsupported     = errors.New("Adapter does not support recording migration SQL")
+	StalePlan             = errors.New("Migrations changed since the plan was made")
+	TooManyPending        = errors.New("Too many pending migrations")
+	UnapprovedMigration   = errors.New("Migration is not approved for a protected environment")
+	UnknownAdapter        = errors.New("No adapter registered for driver")
+	UnknownDependency     = errors.New("Migration depends on an unknown migration")
+	UnknownEnvironment    = errors.New("Environment not found in the configuration file")
+	UnknownWindow         = errors.New("Migration declares an unknown scheduling window")
+	UnsignedMigration     = errors.New("Migration file is not signed by a trusted key")
+	UnsupportedAdapter    = errors.New("Adapter does not support schema inspection")
 )
 
+// Migrators are safe for concurrent use, so the status can be reported
+// while migrations run. Runs changing the database are serialized.
 type Migrator struct {
+	// The database being migrated. Nil when the migrator was created
+	// with NewMigratorWithExecutor.
 	DB         *sql.DB
+	executor   DBExecutor
 	dbAdapter  Migratable
 	migrations map[uint64]*Migration
 	logger     Logger
 	Source     MigrationSource
+	handlers   []func(Event)
+
+	// Set for migrators that only report on the migrations, once the
+	// migrations table was checked and the statuses loaded, and the
+	// layout version of the migrations table, 0 when it is missing.
+	readOnly     bool
+	initialized  bool
+	tableVersion int
+
+	// Identifies the migrator in the lock table.
+	lockOwner string
+
+	// Guards the migrations and their statuses, and serializes runs.
+	mu    sync.RWMutex
+	runMu sync.Mutex
+
+	// Runs each statement of a migration in its own savepoint, so
+	// failures report the exact statement. With Lenient set, failing
+	// statements marked with "-- gomigrate: allow-failure" are rolled
+	// back to their savepoint and the migration continues. Requires an
+	// adapter with the Savepoints capability.
+	SavepointPerStatement bool
+	Lenient               bool
+
+	// Runs the down files of migrations declared irreversible, with
+	// "-- gomigrate: irreversible" or in a manifest, instead of
+	// refusing to roll them back. Migrations without a down file still
+	// can't be rolled back.
+	ForceRollback bool
+
+	// Options for the transactions migrations run in, such as the
+	// isolation level.
+	TxOptions *sql.TxOptions
+
+	// Refuse to run against read-only databases such as replicas.
+	// Requires an adapter implementing ReadOnlyChecker.
+	RequireWritable bool
+
+	// Checks that the user has the privileges pending migrations need,
+	// as done by CheckPrivileges, before a run applies any. Requires an
+	// adapter implementing PrivilegeChecker.
+	RequirePrivileges bool
+
+	// Checked by Preflight. MinServerVersion, such as "14.2", requires
+	// an adapter implementing ServerVersioner, and RequiredExtensions
+	// one implementing ExtensionChecker.
+	MinServerVersion   string
+	RequiredExtensions []string
+
+	// Creates the extensions required by pending migrations, declared
+	// with "-- gomigrate: requires-extension", when they're missing
+	// before a run. Requires an adapter implementing ExtensionCreator
+	// and a user allowed to create them.
+	CreateExtensions bool
+
+	// Recorded with each applied migration. Defaults to the name of
+	// the executable.
+	ApplicationName string
+
+	// Identifies the runner applying migrations, such as a CI job,
+	// recorded with each applied migration. Defaults to the host,
+	// process id and random suffix the runner takes the lock with.
+	Runner string
+
+	// Records the statements executed by each migration in the
+	// gomigrate_sql table, as returned by AppliedSql. Requires an
+	// adapter implementing MigrationSqlRecorder.
+	RecordSql bool
+
+	// Resolves ${SECRET:NAME} placeholders in migration statements when
+	// they run. Defaults to environment variables. Statements are
+	// logged, recorded and reported with their placeholders.
+	Secrets SecretResolver
+
+	// Values available to migration files ending in .sql.tmpl, which
+	// are rendered with text/template before they are split into
+	// statements, along with the functions of RegisterTemplateFunc.
+	// With StrictTemplates set, referring to a missing key fails
+	// instead of rendering "<no value>".
+	TemplateData    map[string]interface{}
+	StrictTemplates bool
+
+	// Notified when migration runs start, succeed or fail.
+	Notifier Notifier
+
+	// Retries migrations failing with transient errors when set.
+	Retry *RetryPolicy
+
+	// Overrides the severity of lint rules by name.
+	LintSeverity map[string]int
+
+	// Checks every pending migration with ValidatePending before a run
+	// applies any, failing the run with InvalidPending when issues are
+	// found.
+	ValidateFirst bool
+
+	// How Validate and Migrate treat pending migrations older than
+	// applied ones and holes in the numbering.
+	OutOfOrderPolicy int
+	GapPolicy        int
+
+	// The environment migrations run in, such as "production".
+	// Migrations restricted with "-- gomigrate: only" to other
+	// environments are skipped.
+	Environment string
+
+	// Marks the environment as protected, such as production, where
+	// migrations only run with an approval in Approvals by someone
+	// other than their author, for the up file as it is. Runs fail with
+	// UnapprovedMigration before applying any migration when one lacks
+	// an approval.
+	Protected bool
+	Approvals *Approvals
+
+	// Verifies the signatures of migration files, and of the files they
+	// include, before they run. Runs fail with UnsignedMigration before
+	// applying any migration when an up file isn't signed.
+	Signatures SignatureVerifier
+
+	// Applies up to Parallelism independent migrations at once, each
+	// on its own connection from DB, when greater than one. Migrations
+	// declaring dependencies with "-- gomigrate: depends-on" only wait
+	// for those; others wait for every migration with a lower id.
+	// Ignored by adapters without the ConcurrentMigrations capability.
+	Parallelism int
+
+	// Tells the time, defaulting to the system clock.
+	Clock Clock
+
+	// Numbers migrations created with CreateMigration, defaulting to
+	// SequentialIds.
+	IdGenerator IdGenerator
+
+	// Computes the checksums recorded with applied migrations,
+	// defaulting to ChecksumSha256.
+	ChecksumAlgorithm ChecksumAlgorithm
+
+	// Columns added to the migrations table and filled when migrations
+	// are applied, after those declared by the adapter. Requires an
+	// adapter implementing CustomColumnRecorder.
+	Columns []CustomColumn
+
+	// Reasons for migrations to skip, by id. Skipped migrations are
+	// recorded as applied with their reason instead of running, so
+	// later migrations can run when a historical one is impossible to
+	// apply to an environment; rolling them back only removes the
+	// record. Requires an adapter implementing MigrationSkipRecorder.
+	Skip map[uint64]string
+
+	// Serializes runs across processes with the gomigrate_lock table,
+	// for databases without advisory locks. LockWait tells how runs
+	// wait for a lock held by another runner, by default up to
+	// LockTimeout, or until their context is done when it is zero. Runs
+	// giving up return a LockTimeout error. A lock expires LockExpiry
+	// after it was taken or renewed, 10 minutes by default, so a
+	// crashed runner doesn't block others; it is renewed before each
+	// migration. Requires an adapter implementing MigrationLocker.
+	LockTable   bool
+	LockWait    int
+	LockTimeout time.Duration
+	LockExpiry  time.Duration
+
+	// Records each running migration in the gomigrate_heartbeat table
+	// and refreshes it this often, along with the migration lock, so
+	// other runners and dashboards can tell a long migration still
+	// running from a crashed runner, as reported by Heartbeats.
+	// Requires an adapter implementing HeartbeatRecorder.
+	HeartbeatInterval time.Duration
+
+	// Scheduling windows by name. Migrations declaring one with
+	// "-- gomigrate: window=off-peak" are only applied while it is open;
+	// runs outside of it apply the other migrations and report those
+	// deferred in RunResult.Deferred. MigrateWindows waits for the
+	// windows to apply them.
+	Windows map[string]*Window
+
+	// Throttles the migrations of PhaseData that don't declare their own
+	// throttle with "-- gomigrate: throttle", such as during business
+	// hours on a shared database.
+	Throttle *Throttle
+
+	// Measures the lag of replicas for throttles with a MaxLag, instead
+	// of an adapter implementing ReplicationLagChecker.
+	LagProbe LagProbe
+
+	// Analyzes the tables a migration changed once it commits, found in
+	// its statements, so query plans account for the changes. With
+	// VacuumAfter set too, tables where rows were updated or deleted
+	// are vacuumed first when the database can do so without blocking
+	// them. Requires an adapter implementing TableMaintainer.
+	AnalyzeAfter bool
+	VacuumAfter  bool
+
+	// Applies the migrations of each run to a shadow database first,
+	// and only applies them to the target once they all succeeded
+	// there. Runs fail with a ShadowError otherwise.
+	Shadow *Shadow
 }
 
 type Logger interface {
@@ -42,79 +304,248 @@ type Logger interface {
 
 // Returns true if the migration table already exists.
 func (m *Migrator) MigrationTableExists() (bool, error) {
-	row := m.DB.QueryRow(m.dbAdapter.SelectMigrationTableSql(), migrationTableName)
+	return m.newRun().migrationTableExists()
+}
+
+func (m *run) migrationTableExists() (bool, error) {
+	row := m.executor.QueryRow(m.dbAdapter.SelectMigrationTableSql(), m.trackingTableName())
 	var tableName string
 	err := row.Scan(&tableName)
 	if err == sql.ErrNoRows {
-		m.logger.Print("Migrations table not found")
+		m.debugf("Migrations table not found")
 		return false, nil
 	}
 	if err != nil {
-		m.logger.Printf("Error checking for migration table: %v", err)
+		m.errorf("Error checking for migration table: %v", err)
 		return false, err
 	}
-	m.logger.Print("Migrations table found")
+	m.debugf("Migrations table found")
 	return true, nil
 }
 
 // Creates the migrations table if it doesn't exist.
 func (m *Migrator) CreateMigrationsTable() error {
-	_, err := m.DB.Exec(m.dbAdapter.CreateMigrationTableSql())
+	return m.newRun().createMigrationsTable()
+}
+
+func (m *run) createMigrationsTable() error {
+	_, err := m.executor.Exec(m.dbAdapter.CreateMigrationTableSql())
 	if err != nil {
 		m.logger.Fatalf("Error creating migrations table: %v", err)
 	}
 
-	m.logger.Printf("Created migrations table: %s", migrationTableName)
+	m.infof("Created migrations table: %s", m.trackingTableName())
 
 	return nil
 }
 
+// Returns ReadOnlyDatabase if RequireWritable is set and the database
+// only accepts reads.
+func (m *run) checkWritable() error {
+	if err := m.checkReadOnly(); err != nil {
+		return err
+	}
+	if !m.RequireWritable {
+		return nil
+	}
+	checker, ok := m.dbAdapter.(ReadOnlyChecker)
+	if !ok {
+		m.warnf("Adapter can't check whether the database is read-only")
+		return nil
+	}
+	var readOnly bool
+	if err := m.executor.QueryRow(checker.ReadOnlySql()).Scan(&readOnly); err != nil {
+		m.errorf("Error checking whether the database is read-only: %v", err)
+		return err
+	}
+	if readOnly {
+		m.warnf("Refusing to migrate a read-only database")
+		return ReadOnlyDatabase
+	}
+	return nil
+}
+
+// Returns a new migrator applying the migrations found in a directory
+// and logging informational messages, warnings and errors to stderr.
+func NewMigrator(db *sql.DB, adapter Migratable, migrationsPath string) (*Migrator, error) {
+	logger := DefaultLogger()
+	return NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: migrationsPath}, logger)
+}
+
+// Returns a new migrator logging to stderr, with the adapter registered
+// for the driver of db. Returns UnknownAdapter when the driver has no
+// registered adapter.
+func NewMigratorAuto(db *sql.DB, ms MigrationSource) (*Migrator, error) {
+	adapter, err := DetectAdapter(db)
+	if err != nil {
+		return nil, err
+	}
+	logger := DefaultLogger()
+	return NewMigratorWithLogger(db, adapter, ms, logger)
+}
+
 // Returns a new migrator with the specified logger.
 func NewMigratorWithLogger(db *sql.DB, adapter Migratable, ms MigrationSource, logger Logger) (*Migrator, error) {
-
-	migrator := Migrator{
-		db,
-		adapter,
-		make(map[uint64]*Migration),
-		logger,
-		ms,
+	migrator, err := NewMigratorWithExecutor(sqlExecutor{db}, adapter, ms, logger)
+	if err != nil {
+		return nil, err
 	}
+	migrator.DB = db
+	return migrator, nil
+}
 
-	// Create the migrations table if it doesn't exist.
-	tableExists, err := migrator.MigrationTableExists()
+// Returns a new migrator running its statements through executor, for
+// connections that aren't managed by database/sql.
+func NewMigratorWithExecutor(executor DBExecutor, adapter Migratable, ms MigrationSource, logger Logger) (*Migrator, error) {
+	return newMigrator(executor, adapter, ms, logger, false)
+}
+
+// Returns a new migrator that only reports on the migrations, for
+// monitoring with read-only credentials. The migrations table is never
+// created or upgraded; when it is missing every migration is pending.
+// Methods changing the database return ReadOnlyMigrator.
+func NewReadOnlyMigrator(db *sql.DB, adapter Migratable, ms MigrationSource, logger Logger) (*Migrator, error) {
+	migrator, err := newMigrator(sqlExecutor{db}, adapter, ms, logger, true)
 	if err != nil {
 		return nil, err
 	}
-	if !tableExists {
-		if err := migrator.CreateMigrationsTable(); err != nil {
-			return nil, err
-		}
+	migrator.DB = db
+	return migrator, nil
+}
+
+func newMigrator(executor DBExecutor, adapter Migratable, ms MigrationSource, logger Logger, readOnly bool) (*Migrator, error) {
+
+	migrator := Migrator{
+		executor:   executor,
+		dbAdapter:  adapter,
+		migrations: make(map[uint64]*Migration),
+		logger:     logger,
+		Source:     ms,
+		readOnly:   readOnly,
+		lockOwner:  newLockOwner(),
 	}
 
-	// Get all metadata from the database.
+	// Find the migrations. The migrations table is only read once an
+	// operation needs the status of the migrations.
+	var err error
 	migrator.migrations, err = migrator.Source.FindMigrations(logger)
 	if err != nil {
 		return nil, err
 	}
-	if err := migrator.getMigrationStatuses(); err != nil {
+	if err := migrator.loadDirectives(migrator.migrations); err != nil {
+		return nil, err
+	}
+	if err := migrator.checkDependencies(migrator.migrations); err != nil {
 		return nil, err
 	}
 
 	return &migrator, nil
 }
 
+// Creates the migrations table if it doesn't exist and upgrades it to
+// the current layout. Operations needing the table call it on first
+// use, so creating a migrator doesn't change the database.
+func (m *Migrator) EnsureMigrationTable() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.newRun().ensureMigrationTable()
+}
+
+func (m *run) ensureMigrationTable() error {
+	if err := m.checkReadOnly(); err != nil {
+		return err
+	}
+	if m.tableVersion > 0 {
+		return nil
+	}
+	tableExists, err := m.migrationTableExists()
+	if err != nil {
+		return err
+	}
+	if !tableExists {
+		if err := m.createMigrationsTable(); err != nil {
+			return err
+		}
+	}
+	if foreign, ok := m.dbAdapter.(ForeignMigrationTable); ok {
+		if err := foreign.CheckTable(m.executor); err != nil {
+			m.errorf("Error checking migrations table %s: %v", foreign.ForeignTableName(), err)
+			return err
+		}
+		m.tableVersion = 1
+	} else {
+		if err := m.upgradeMigrationsTable(!tableExists); err != nil {
+			return err
+		}
+		if err := m.ensureCustomColumns(); err != nil {
+			return err
+		}
+	}
+	if err := m.ensureLockTable(); err != nil {
+		return err
+	}
+	if err := m.ensureHeartbeatTable(); err != nil {
+		return err
+	}
+	return m.ensureSqlLogTable()
+}
+
+// Prepares the migrations table and loads the status of each migration
+// the first time it is called. Read-only migrators only inspect the
+// table.
+func (m *Migrator) initialize() error {
+	return m.newRun().initialize()
+}
+
+func (m *run) initialize() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.initialized {
+		return nil
+	}
+	if m.readOnly {
+		tableExists, err := m.migrationTableExists()
+		if err != nil {
+			return err
+		}
+		if tableExists {
+			m.tableVersion = m.migrationsTableVersion()
+		}
+	} else if err := m.ensureMigrationTable(); err != nil {
+		return err
+	}
+	if m.tableVersion > 0 {
+		if err := m.getMigrationStatuses(m.migrations); err != nil {
+			return err
+		}
+	}
+	m.initialized = true
+	return nil
+}
+
+// Returns ReadOnlyMigrator for migrators created with
+// NewReadOnlyMigrator.
+func (m *Migrator) checkReadOnly() error {
+	if m.readOnly {
+		m.warnf("Refusing to change the database with a read-only migrator")
+		return ReadOnlyMigrator
+	}
+	return nil
+}
+
 // Queries the migration table to determine the status of each
 // migration.
-func (m *Migrator) getMigrationStatuses() error {
-	for _, migration := range m.migrations {
-		row := m.DB.QueryRow(m.dbAdapter.GetMigrationSql(), migration.Id)
+func (m *run) getMigrationStatuses(migrations map[uint64]*Migration) error {
+	columns := m.presentColumns()
+	for _, migration := range migrations {
+		row := m.executor.QueryRow(m.dbAdapter.GetMigrationSql(), migration.Id)
 		var mid uint64
 		err := row.Scan(&mid)
 		if err == sql.ErrNoRows {
 			continue
 		}
 		if err != nil {
-			m.logger.Printf(
+			m.errorf(
 				"Error getting migration status for %s: %v",
 				migration.Name,
 				err,
@@ -122,96 +553,464 @@ func (m *Migrator) getMigrationStatuses() error {
 			return err
 		}
 		migration.Status = Active
+		if err := m.getMigrationStats(migration); err != nil {
+			return err
+		}
+		if err := m.getMigrationAudit(migration); err != nil {
+			return err
+		}
+		if err := m.getMigrationChecksum(migration); err != nil {
+			return err
+		}
+		if err := m.getMigrationSkip(migration); err != nil {
+			return err
+		}
+		if err := m.getMigrationDescription(migration); err != nil {
+			return err
+		}
+		if err := m.getMigrationMetadata(migration); err != nil {
+			return err
+		}
+		if err := m.getMigrationPhase(migration); err != nil {
+			return err
+		}
+		if err := m.getMigrationColumns(migration, columns); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-// Returns a sorted list of migration ids for a given status. -1 returns
-// all migrations.
+// Returns the migrations for a given status, ordered so that every
+// migration comes after the migrations it depends on. Without declared
+// dependencies they are sorted by id. -1 returns all migrations.
 func (m *Migrator) Migrations(status int) []*Migration {
+	// Errors are logged, leaving the migrations inactive.
+	m.initialize()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	all, err := orderByDependencies(m.migrationsById())
+	if err != nil {
+		all = m.migrationsById()
+	}
+
+	// Find migrations for the given status.
+	migrations := make([]*Migration, 0)
+	for _, migration := range all {
+		if status == -1 || migration.Status == status {
+			migrations = append(migrations, migration)
+		}
+	}
+	return migrations
+}
+
+// Returns all migrations sorted by id. Callers must hold mu, except
+// while the migrator is created.
+func (m *Migrator) migrationsById() []*Migration {
+	return sortById(m.migrations)
+}
+
+// Returns the migrations sorted by id.
+func sortById(migrations map[uint64]*Migration) []*Migration {
 	// Sort all migration ids.
 	ids := make([]uint64, 0)
-	for id, _ := range m.migrations {
+	for id, _ := range migrations {
 		ids = append(ids, id)
 	}
 	sort.Sort(uint64slice(ids))
 
-	// Find ids for the given status.
-	migrations := make([]*Migration, 0)
+	sorted := make([]*Migration, 0, len(ids))
 	for _, id := range ids {
-		migration := m.migrations[id]
-		if status == -1 || migration.Status == status {
-			migrations = append(migrations, migration)
+		sorted = append(sorted, migrations[id])
+	}
+	return sorted
+}
+
+// Finds the migrations again, picking up files added since the
+// migrator was created. Known migrations keep their status and the
+// status of new ones is read from the migrations table. Waits for
+// running migrations to finish.
+func (m *Migrator) Reload() error {
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+
+	migrations, err := m.Source.FindMigrations(m.logger)
+	if err != nil {
+		return err
+	}
+	if err := m.loadDirectives(migrations); err != nil {
+		return err
+	}
+	if err := m.checkDependencies(migrations); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	added := make(map[uint64]*Migration)
+	for id, migration := range migrations {
+		current, ok := m.migrations[id]
+		if !ok {
+			added[id] = migration
+			continue
+		}
+		migration.Status = current.Status
+		migration.DurationMs = current.DurationMs
+		migration.StatementCount = current.StatementCount
+		migration.AppliedAt = current.AppliedAt
+		migration.Audit = current.Audit
+		if current.Status == Active {
+			migration.Description = current.Description
+			migration.Author = current.Author
+			migration.Ticket = current.Ticket
 		}
 	}
-	return migrations
+	for id, current := range m.migrations {
+		if _, ok := migrations[id]; !ok && current.Status == Active {
+			m.warnf("Applied migration no longer found: %s", current.UpPath)
+		}
+	}
+	if m.initialized && m.tableVersion > 0 {
+		if err := m.newRun().getMigrationStatuses(added); err != nil {
+			return err
+		}
+	}
+	m.migrations = migrations
+	m.infof("Reloaded migrations, %d added", len(added))
+	return nil
+}
+
+// Reads a migration file from the migration source, decrypting it when
+// it is encrypted.
+func (m *Migrator) readMigrationFile(path string) ([]byte, error) {
+	sql, err := m.readSourceFile(path)
+	if err != nil {
+		m.errorf("Error reading migration: %s", path)
+		return nil, err
+	}
+	var keys KeyProvider
+	switch source := m.Source.(type) {
+	case *FileMigrationSource:
+		keys = source.KeyProvider
+	case *AssetMigrationSource:
+		keys = source.KeyProvider
+	}
+	return m.decryptMigrationFile(keys, path, sql)
+}
+
+// Reads a file from the migration source as it is stored.
+func (m *Migrator) readSourceFile(path string) ([]byte, error) {
+	switch source := m.Source.(type) {
+	case *FileMigrationSource:
+		return ioutil.ReadFile(path)
+	case *AssetMigrationSource:
+		return source.Asset(path)
+	}
+	m.warnf("Unsupport MigrationSource type")
+	return nil, errors.New("Unsupport MigrationSource type")
+}
+
+// Reads the statements of a migration file, expanding included files
+// and rendering templates, once its signature is verified. Checksums,
+// directives and lint rules use the file as it is written.
+func (m *Migrator) migrationSql(path string) ([]byte, error) {
+	if err := m.verifySignature(path); err != nil {
+		return nil, err
+	}
+	sql, err := m.readMigrationFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if sql, err = m.expandIncludes(path, sql, []string{path}); err != nil || !isTemplate(path) {
+		return sql, err
+	}
+	return m.renderTemplate(path, sql)
 }
 
 // Applies a single migration.
 func (m *Migrator) ApplyMigration(migration *Migration, mType migrationType) error {
+	return m.newRun().applyMigration(migration, mType)
+}
+
+func (m *run) applyMigration(migration *Migration, mType migrationType) (err error) {
+	if err := m.checkReadOnly(); err != nil {
+		return err
+	}
+	if err := m.initialize(); err != nil {
+		return err
+	}
+	start := m.now()
+	var summary MigrationSummary
+	m.emit(MigrationStarted{migration, string(mType)})
+	defer func() {
+		m.emit(MigrationFinished{migration, string(mType), m.now().Sub(start), summary, err})
+	}()
+
+	if mType == upMigration && m.CreateExtensions {
+		if err := m.checkExtensions([]*Migration{migration}); err != nil {
+			return err
+		}
+	}
+	commands, err := m.migrationCommands(migration, mType)
+	if err != nil {
+		return err
+	}
+	defer m.startHeartbeat(migration)()
+	var transaction TxExecutor
+	_, nonTransactional := m.dbAdapter.(NonTransactionalDdl)
+	switch {
+	case nonTransactional || migration.NoTransaction:
+		transaction = autocommitExecutor{m.executor}
+	case migration.Timeout > 0:
+		transaction, err = m.beginWithTimeout(migration.Timeout)
+	default:
+		transaction, err = m.executor.BeginTx(m.TxOptions)
+	}
+	if err != nil {
+		m.errorf("Error opening transaction: %v", err)
+		return err
+	}
+
+	if err := m.runMigration(transaction, migration, mType, commands, &summary); err != nil {
+		if !capabilities(m.dbAdapter).Has(TransactionalDdl) {
+			m.warnf("DDL statements of migration %d may have been committed before the failure", migration.Id)
+		}
+		if rollbackErr := transaction.Rollback(); rollbackErr != nil {
+			m.errorf("Error rolling back transaction: %v", rollbackErr)
+			return rollbackErr
+		}
+		return err
+	}
+
+	// Commit and update the struct status.
+	if err := transaction.Commit(); err != nil {
+		m.errorf("Error commiting transaction: %v", err)
+		return err
+	}
+	m.updateStatus(migration, mType)
+	m.logSummary(migration, mType, summary, m.now().Sub(start))
+	m.maintainTables(migration, commands)
+
+	return nil
+}
+
+// Applies a single migration inside a transaction owned by the caller,
+// who is responsible for committing or rolling it back. The migration's
+// status is updated as if the transaction will be committed.
+func (m *Migrator) ApplyMigrationTx(tx *sql.Tx, migration *Migration, mType migrationType) error {
+	return m.newRun().applyMigrationTx(tx, migration, mType)
+}
+
+func (m *run) applyMigrationTx(tx *sql.Tx, migration *Migration, mType migrationType) (err error) {
+	if err := m.checkReadOnly(); err != nil {
+		return err
+	}
+	if err := m.initialize(); err != nil {
+		return err
+	}
+	start := m.now()
+	var summary MigrationSummary
+	m.emit(MigrationStarted{migration, string(mType)})
+	defer func() {
+		m.emit(MigrationFinished{migration, string(mType), m.now().Sub(start), summary, err})
+	}()
+
+	if mType == upMigration && m.CreateExtensions {
+		if err := m.checkExtensions([]*Migration{migration}); err != nil {
+			return err
+		}
+	}
+	commands, err := m.migrationCommands(migration, mType)
+	if err != nil {
+		return err
+	}
+	defer m.startHeartbeat(migration)()
+	if err := m.runMigration(tx, migration, mType, commands, &summary); err != nil {
+		return err
+	}
+	m.updateStatus(migration, mType)
+	m.logSummary(migration, mType, summary, m.now().Sub(start))
+	return nil
+}
+
+// Logs the totals of a migration that succeeded.
+func (m *Migrator) logSummary(migration *Migration, mType migrationType, summary MigrationSummary, duration time.Duration) {
+	m.infof(
+		"Migration %d %s finished: %d statements, %d rows affected in %s",
+		migration.Id,
+		mType,
+		summary.Statements,
+		summary.RowsAffected,
+		duration.Round(time.Millisecond),
+	)
+}
+
+// Returns true if the down file of a migration may run, which takes
+// ForceRollback for migrations declared irreversible.
+func (m *Migrator) reversible(migration *Migration) bool {
+	if !migration.Irreversible {
+		return true
+	}
+	return m.ForceRollback && migration.DownPath != "" && !migration.Archived
+}
+
+// Reads a migration file and splits it into the commands to execute.
+func (m *run) migrationCommands(migration *Migration, mType migrationType) ([]string, error) {
+	if skip, err := m.skipped(migration, mType); skip || err != nil {
+		return []string{}, err
+	}
+
 	var path string
 	if mType == upMigration {
 		path = migration.UpPath
 	} else if mType == downMigration {
+		if !m.reversible(migration) {
+			m.warnf("Migration %d %s is irreversible and can't be rolled back: %s", migration.Id, migration.Name, migration.UpPath)
+			return nil, IrreversibleMigration
+		}
+		if migration.Irreversible {
+			m.warnf("Forcing the rollback of irreversible migration %d %s", migration.Id, migration.Name)
+		}
 		path = migration.DownPath
 	} else {
-		return InvalidMigrationType
+		return nil, InvalidMigrationType
 	}
 
-	m.logger.Printf("Applying migration: %s", path)
+	if mType == upMigration {
+		if err := m.checkApproval(migration); err != nil {
+			return nil, err
+		}
+		if err := m.checkServerVersion(migration); err != nil {
+			return nil, err
+		}
+		// Missing extensions are created before migrations apply when
+		// CreateExtensions is set.
+		if !m.CreateExtensions {
+			if err := m.checkExtension(migration); err != nil {
+				return nil, err
+			}
+		}
+	}
 
-	var sql []byte
-	var err error
+	m.infof("Applying migration: %s", path)
 
-	switch m.Source.(type) {
-	case *FileMigrationSource:
-		sql, err = ioutil.ReadFile(path)
-	case *AssetMigrationSource:
-		sql, err = m.Source.(*AssetMigrationSource).Asset(path)
-	default:
-		m.logger.Println("Unsupport MigrationSource type")
-		return errors.New("Unsupport MigrationSource type")
-	}
+	migrationSql, err := m.migrationSql(path)
 	if err != nil {
-		m.logger.Printf("Error reading migration: %s", path)
-		return err
-	}
-	transaction, err := m.DB.Begin()
-	if err != nil {
-		m.logger.Printf("Error opening transaction: %v", err)
-		return err
+		return nil, err
 	}
 
 	// Certain adapters can not handle multiple sql commands in one file so we need the adapter to split up the command
-	commands := m.dbAdapter.GetMigrationCommands(string(sql))
+	if m.SavepointPerStatement {
+		return splitStatements(string(migrationSql)), nil
+	}
+	return m.dbAdapter.GetMigrationCommands(string(migrationSql)), nil
+}
+
+// Executes the commands of a migration and logs it in the migrations
+// table, without committing or rolling back the transaction. The
+// statements that succeed are counted in summary.
+func (m *run) runMigration(transaction TxExecutor, migration *Migration, mType migrationType, commands []string, summary *MigrationSummary) error {
+	start := m.now()
+
+	if m.SavepointPerStatement && !capabilities(m.dbAdapter).Has(Savepoints) {
+		m.warnf("Adapter does not support savepoints")
+		return SavepointsUnsupported
+	}
+
+	// Set up the session of the connection running the migration.
+	if initializer, ok := m.dbAdapter.(SessionInitializer); ok {
+		for _, statement := range initializer.SessionSql() {
+			if _, err := transaction.Exec(statement); err != nil {
+				m.errorf("Error setting up session: %v", err)
+				return err
+			}
+		}
+	}
 
 	// Perform the migration.
+	statements := secretExecutor{transaction, m.Migrator}
+	var bytes, totalBytes int64
 	for _, cmd := range commands {
-		result, err := transaction.Exec(cmd)
-		if err != nil {
-			m.logger.Printf("Error executing migration: %v", err)
-			if rollbackErr := transaction.Rollback(); rollbackErr != nil {
-				m.logger.Printf("Error rolling back transaction: %v", rollbackErr)
-				return rollbackErr
+		totalBytes += int64(len(cmd))
+	}
+	for i, cmd := range commands {
+		// Backfills wait for replicas between chunks.
+		if migration.Backfill == nil || mType != upMigration {
+			if err := m.waitForReplicas(migration); err != nil {
+				return err
 			}
+		}
+		statementStart := m.now()
+		var result sql.Result
+		var err error
+		switch {
+		case m.SavepointPerStatement:
+			result, err = m.execWithSavepoint(statements, migration, i, cmd)
+		case migration.Backfill != nil && mType == upMigration:
+			if len(commands) != 1 {
+				m.errorf("Backfill migration %d must hold a single statement", migration.Id)
+				return InvalidBackfill
+			}
+			result, err = m.backfill(migration, cmd)
+		case migration.OnlineIndex && mType == upMigration:
+			result, err = m.execOnlineIndex(statements, migration, cmd)
+		default:
+			result, err = statements.Exec(cmd)
+		}
+		if err != nil {
+			m.errorf("Error executing migration: %v", err)
 			return err
 		}
+		var rowsAffected int64
 		if result != nil {
-			if rowsAffected, err := result.RowsAffected(); err != nil {
-				m.logger.Printf("Error getting rows affected: %v", err)
-				if rollbackErr := transaction.Rollback(); rollbackErr != nil {
-					m.logger.Printf("Error rolling back transaction: %v", rollbackErr)
-					return rollbackErr
-				}
+			if rowsAffected, err = result.RowsAffected(); err != nil {
+				m.errorf("Error getting rows affected: %v", err)
 				return err
-			} else {
-				m.logger.Printf("Rows affected: %v", rowsAffected)
 			}
 		}
+		summary.Statements++
+		summary.RowsAffected += rowsAffected
+		bytes += int64(len(cmd))
+		elapsed := m.now().Sub(start)
+		m.infof(
+			"Statement %d/%d of migration %d: %d rows affected in %s",
+			i+1,
+			len(commands),
+			migration.Id,
+			rowsAffected,
+			m.now().Sub(statementStart).Round(time.Millisecond),
+		)
+		m.emit(StatementExecuted{
+			Migration:    migration,
+			Index:        i,
+			Total:        len(commands),
+			Statement:    cmd,
+			RowsAffected: rowsAffected,
+			Duration:     m.now().Sub(statementStart),
+			Bytes:        bytes,
+			TotalBytes:   totalBytes,
+			Elapsed:      elapsed,
+			Remaining:    estimateRemaining(elapsed, bytes, totalBytes),
+		})
+		// Backfills are throttled between chunks.
+		if i < len(commands)-1 && (migration.Backfill == nil || mType != upMigration) {
+			m.throttle(migration, rowsAffected, m.now().Sub(statementStart))
+		}
+	}
+
+	// Wait for the schema changes to complete before logging them.
+	if checker, ok := m.dbAdapter.(NonTransactionalDdl); ok && checker.PendingDdlSql() != "" {
+		if err := m.waitForDdl(checker.PendingDdlSql()); err != nil {
+			return err
+		}
 	}
 
 	// Log the event.
-	if mType == upMigration {
+	var err error
+	if foreign, ok := m.dbAdapter.(ForeignMigrationTable); ok {
+		err = foreign.LogMigration(transaction, migration.Id, mType == upMigration, m.versionAfter(migration, mType))
+	} else if mType == upMigration {
 		_, err = transaction.Exec(
 			m.dbAdapter.MigrationLogInsertSql(),
 			migration.Id,
@@ -223,36 +1022,148 @@ func (m *Migrator) ApplyMigration(migration *Migration, mType migrationType) err
 		)
 	}
 	if err != nil {
-		m.logger.Printf("Error logging migration: %v", err)
-		if rollbackErr := transaction.Rollback(); rollbackErr != nil {
-			m.logger.Printf("Error rolling back transaction: %v", rollbackErr)
-			return rollbackErr
-		}
+		m.errorf("Error logging migration: %v", err)
 		return err
 	}
 
-	// Commit and update the struct status.
-	if err := transaction.Commit(); err != nil {
-		m.logger.Printf("Error commiting transaction: %v", err)
-		return err
+	// Record the executed statements.
+	if m.RecordSql {
+		if err := m.recordSql(transaction, migration, mType, commands); err != nil {
+			return err
+		}
 	}
-	if mType == upMigration {
-		migration.Status = Active
-	} else {
-		migration.Status = Inactive
+
+	// Record the stats of applied migrations.
+	if recorder, ok := m.dbAdapter.(MigrationStatsRecorder); ok && mType == upMigration {
+		appliedAt := m.now()
+		durationMs := appliedAt.Sub(start).Nanoseconds() / int64(time.Millisecond)
+		if _, err := transaction.Exec(
+			recorder.MigrationStatsUpdateSql(),
+			durationMs,
+			len(commands),
+			appliedAt.UnixNano(),
+			migration.Id,
+		); err != nil {
+			m.errorf("Error logging migration: %v", err)
+			return err
+		}
+		m.mu.Lock()
+		migration.DurationMs = durationMs
+		migration.StatementCount = len(commands)
+		migration.AppliedAt = appliedAt
+		m.mu.Unlock()
+	}
+
+	// Record who applied the migration.
+	if recorder, ok := m.dbAdapter.(MigrationAuditRecorder); ok && mType == upMigration {
+		audit := m.auditInfo()
+		if _, err := transaction.Exec(
+			recorder.MigrationAuditUpdateSql(),
+			audit.User,
+			audit.Host,
+			audit.Application,
+			audit.LibraryVersion,
+			audit.Build,
+			audit.Runner,
+			migration.Id,
+		); err != nil {
+			m.errorf("Error logging migration: %v", err)
+			return err
+		}
+		m.mu.Lock()
+		migration.Audit = audit
+		m.mu.Unlock()
 	}
 
+	// Record the checksum of the up file, why it was skipped, its
+	// description, author, ticket and phase.
+	if mType == upMigration {
+		if err := m.recordChecksum(transaction, migration); err != nil {
+			return err
+		}
+		if err := m.recordSkip(transaction, migration); err != nil {
+			return err
+		}
+		if err := m.recordDescription(transaction, migration); err != nil {
+			return err
+		}
+		if err := m.recordMetadata(transaction, migration); err != nil {
+			return err
+		}
+		if err := m.recordPhase(transaction, migration); err != nil {
+			return err
+		}
+		if err := m.recordColumns(transaction, migration); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// Applies all inactive migrations.
-func (m *Migrator) Migrate() error {
-	for _, migration := range m.Migrations(Inactive) {
-		if err := m.ApplyMigration(migration, upMigration); err != nil {
+// How often to check whether asynchronous schema changes completed.
+var DdlPollInterval = time.Second
+
+// Polls the database until no schema changes are running.
+func (m *run) waitForDdl(pendingSql string) error {
+	for {
+		var pending int
+		if err := m.executor.QueryRow(pendingSql).Scan(&pending); err != nil {
+			m.errorf("Error checking for running schema changes: %v", err)
 			return err
 		}
+		if pending == 0 {
+			return nil
+		}
+		m.debugf("Waiting for %d schema changes to complete", pending)
+		time.Sleep(DdlPollInterval)
 	}
-	return nil
+}
+
+// Updates the status of a migration after it was applied. The
+// migrator's own copy is updated too when given a snapshot.
+func (m *Migrator) updateStatus(migration *Migration, mType migrationType) {
+	status := Inactive
+	if mType == upMigration {
+		status = Active
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	migration.Status = status
+	if current, ok := m.migrations[migration.Id]; ok {
+		current.Status = status
+	}
+	if mType == downMigration {
+		migration.SkipReason = ""
+		if current, ok := m.migrations[migration.Id]; ok {
+			current.SkipReason = ""
+		}
+	}
+}
+
+// Applies all inactive migrations using a connection owned by the
+// caller, such as a session with a different role.
+func (m *Migrator) MigrateWithConn(conn *sql.Conn, options ...RunOption) error {
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+	r := m.newRun()
+	r.executor = connExecutor{conn}
+	_, err := r.migrate(context.Background(), options, false)
+	return err
+}
+
+// Applies all inactive migrations for the environment after validating
+// them. Options such as WithTags restrict the migrations applied.
+func (m *Migrator) Migrate(options ...RunOption) error {
+	return m.MigrateContext(context.Background(), options...)
+}
+
+// Applies all inactive migrations like Migrate, stopping once ctx is
+// done. A migration that is already running is allowed to finish, so
+// the migrations table stays accurate, and Interrupted is returned.
+func (m *Migrator) MigrateContext(ctx context.Context, options ...RunOption) error {
+	_, err := m.MigrateWithResult(ctx, options...)
+	return err
 }
 
 // Rolls back the last migration.
@@ -262,24 +1173,164 @@ func (m *Migrator) Rollback() error {
 
 // Rolls back N migrations.
 func (m *Migrator) RollbackN(n int) error {
-	migrations := m.Migrations(Active)
-	if len(migrations) == 0 {
+	return m.RollbackNContext(context.Background(), n)
+}
+
+// Rolls back N migrations like RollbackN, stopping between migrations
+// once ctx is done. Every applied migration is rolled back when n
+// exceeds their number.
+func (m *Migrator) RollbackNContext(ctx context.Context, n int) error {
+	return m.newRun().rollback(ctx, func(applied []*Migration) []*Migration {
+		return lastApplied(applied, n)
+	})
+}
+
+// Returns the last n applied migrations, or all of them when n exceeds
+// their number, in the order they are rolled back.
+func lastApplied(applied []*Migration, n int) []*Migration {
+	if n > len(applied) {
+		n = len(applied)
+	}
+	migrations := make([]*Migration, 0)
+	for i := len(applied) - 1; i >= len(applied)-n; i-- {
+		migrations = append(migrations, applied[i])
+	}
+	return migrations
+}
+
+// Rolls back the applied migrations with ids greater than target, most
+// recent first. A target of 0 rolls back every applied migration.
+func (m *Migrator) RollbackTo(target uint64) error {
+	return m.RollbackToContext(context.Background(), target)
+}
+
+// Rolls back to a target like RollbackTo, stopping between migrations
+// once ctx is done.
+func (m *Migrator) RollbackToContext(ctx context.Context, target uint64) error {
+	return m.newRun().rollback(ctx, func(applied []*Migration) []*Migration {
+		return appliedAbove(applied, target)
+	})
+}
+
+// Returns the applied migrations with ids greater than target, in the
+// order they are rolled back.
+func appliedAbove(applied []*Migration, target uint64) []*Migration {
+	migrations := make([]*Migration, 0)
+	for i := len(applied) - 1; i >= 0; i-- {
+		if applied[i].Id > target {
+			migrations = append(migrations, applied[i])
+		}
+	}
+	return migrations
+}
+
+// Rolls back the migrations choose selects among the rollbackable
+// migrations, which it is given in the order they were applied.
+func (m *run) rollback(ctx context.Context, choose func(applied []*Migration) []*Migration) error {
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+	if err := m.initialize(); err != nil {
+		return err
+	}
+	release, err := m.acquireLock(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	rollbacks := choose(m.rollbackable())
+	if len(rollbacks) == 0 {
 		return nil
 	}
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
 
-	last_migration := len(migrations) - 1 - n
+	_, err = m.runMigrations(ctx, rollbacks, downMigration)
+	return err
+}
 
-	for i := len(migrations) - 1; i != last_migration; i-- {
-		if err := m.ApplyMigration(migrations[i], downMigration); err != nil {
-			return err
+// Applies migrations in order in the given direction, notifying the
+// notifier when the run starts, succeeds or fails. Nothing is notified
+// when there are no migrations to apply. Returns the migration that
+// failed.
+func (m *run) runMigrations(ctx context.Context, migrations []*Migration, mType migrationType) (*Migration, error) {
+	if len(migrations) == 0 {
+		return nil, nil
+	}
+	m.notify(&Notification{Event: RunStarted, Direction: string(mType), Pending: len(migrations)})
+
+	var applied int
+	var failed *Migration
+	var err error
+	if _, ok := m.executor.(sqlExecutor); ok && m.Parallelism > 1 && mType == upMigration && capabilities(m.dbAdapter).Has(ConcurrentMigrations) {
+		applied, failed, err = m.applyParallel(ctx, migrations)
+	} else {
+		applied, failed, err = m.applySequential(ctx, migrations, mType)
+	}
+	if err != nil {
+		m.emit(RunCompleted{string(mType), applied, err})
+		m.notify(&Notification{
+			Event:     RunFailed,
+			Direction: string(mType),
+			Pending:   len(migrations),
+			Applied:   applied,
+			Failed:    failed,
+			Error:     err.Error(),
+		})
+		return failed, err
+	}
+
+	m.emit(RunCompleted{string(mType), len(migrations), nil})
+	m.notify(&Notification{
+		Event:     RunSucceeded,
+		Direction: string(mType),
+		Pending:   len(migrations),
+		Applied:   len(migrations),
+	})
+	return nil, nil
+}
+
+// Applies migrations one at a time in the given order. Returns the
+// number of migrations applied and the migration that failed.
+func (m *run) applySequential(ctx context.Context, migrations []*Migration, mType migrationType) (int, *Migration, error) {
+	for i, migration := range migrations {
+		err := interrupted(ctx)
+		if err != nil {
+			m.warnf("Interrupted before migration: %d", migration.Id)
+		} else {
+			err = m.applyWithRetry(ctx, migration, mType)
+		}
+		if err != nil {
+			return i, migration, err
 		}
 	}
+	return len(migrations), nil, nil
+}
 
-	return nil
+// Returns Interrupted once ctx is done.
+func interrupted(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return Interrupted
+	default:
+		return nil
+	}
 }
 
 // Rolls back all migrations.
 func (m *Migrator) RollbackAll() error {
-	migrations := m.Migrations(Active)
+	migrations := m.rollbackable()
 	return m.RollbackN(len(migrations))
 }
+
+// Returns the applied migrations in order, leaving out archived
+// migrations, which can't be rolled back.
+func (m *Migrator) rollbackable() []*Migration {
+	migrations := make([]*Migration, 0)
+	for _, migration := range m.Migrations(Active) {
+		if !migration.Archived {
+			migrations = append(migrations, migration)
+		}
+	}
+	return migrations
+}
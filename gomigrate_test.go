@@ -1,11 +1,22 @@
 package gomigrate
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
@@ -24,6 +35,9 @@ func GetMigrator(test string) *Migrator {
 	if err != nil {
 		panic(err)
 	}
+	if err := m.initialize(); err != nil {
+		panic(err)
+	}
 	return m
 }
 
@@ -81,6 +95,41 @@ func TestCreatingMigratorWhenTableExists(t *testing.T) {
 	cleanup()
 }
 
+func TestLazyMigrationTable(t *testing.T) {
+	path := fmt.Sprintf("test_migrations/test1_%s", dbType)
+	m, err := NewMigrator(db, adapter, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := m.MigrationTableExists(); err != nil || exists {
+		t.Errorf("Creating a migrator created the migrations table")
+	}
+	if len(m.Pending()) != len(m.migrations) {
+		t.Errorf("Expected every migration to be pending")
+	}
+	if exists, err := m.MigrationTableExists(); err != nil || !exists {
+		t.Errorf("Migrations table not created on first use")
+	}
+	cleanup()
+}
+
+func TestReadOnlyMigrator(t *testing.T) {
+	path := fmt.Sprintf("test_migrations/test1_%s", dbType)
+	m, err := NewReadOnlyMigrator(db, adapter, &FileMigrationSource{Dir: path}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := m.MigrationTableExists(); err != nil || exists {
+		t.Errorf("Read-only migrator created the migrations table")
+	}
+	if len(m.Pending()) != len(m.migrations) {
+		t.Errorf("Expected every migration to be pending")
+	}
+	if err := m.Migrate(); err != ReadOnlyMigrator {
+		t.Errorf("Expected a read-only migrator error, got: %v", err)
+	}
+}
+
 func TestMigrationAndRollback(t *testing.T) {
 	m := GetMigrator("test1")
 
@@ -112,6 +161,9 @@ func TestMigrationAndRollback(t *testing.T) {
 	if status != Active || m.migrations[1].Status != Active {
 		t.Error("Invalid status for migration")
 	}
+	if len(m.Pending()) != 0 || len(m.Applied()) != len(m.migrations) {
+		t.Errorf("Invalid number of pending migrations: %d", len(m.Pending()))
+	}
 	if err := m.RollbackN(len(m.migrations)); err != nil {
 		t.Error(err)
 	}
@@ -137,7 +189,3167 @@ func TestMigrationAndRollback(t *testing.T) {
 	if m.migrations[1].Status != Inactive {
 		t.Errorf("Invalid status for migration, expected: %d, got: %v", Inactive, m.migrations[1].Status)
 	}
+	if m.Version() != 0 {
+		t.Errorf("Invalid version after rollback: %d", m.Version())
+	}
+
+	cleanup()
+}
+
+func TestImportRailsHistory(t *testing.T) {
+	if _, err := db.Exec("CREATE TABLE schema_migrations (version VARCHAR(255))"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO schema_migrations (version) VALUES ('1')"); err != nil {
+		t.Fatal(err)
+	}
+
+	m := GetMigrator("test1")
+	imported, err := m.ImportHistory(RailsImporter{})
+	if err != nil {
+		t.Error(err)
+	}
+	if imported != 1 {
+		t.Errorf("Invalid number of imported migrations: %d", imported)
+	}
+	if m.migrations[1].Status != Active {
+		t.Error("Imported migration should be active")
+	}
+
+	// Importing again shouldn't record anything new.
+	imported, err = m.ImportHistory(RailsImporter{})
+	if err != nil {
+		t.Error(err)
+	}
+	if imported != 0 {
+		t.Errorf("Invalid number of imported migrations: %d", imported)
+	}
+
+	if _, err := db.Exec("DROP TABLE schema_migrations"); err != nil {
+		t.Error(err)
+	}
+	cleanup()
+}
+
+func TestGenerateDown(t *testing.T) {
+	down, err := GenerateDown(db, adapter, "CREATE TABLE generated (id INTEGER)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if down != "DROP TABLE generated;\n" {
+		t.Errorf("Invalid down migration generated: %q", down)
+	}
+	if _, err := db.Exec(down); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSchemaString(t *testing.T) {
+	schema := Schema{
+		"users": {"id": "INTEGER", "email": "VARCHAR"},
+		"posts": {"id": "integer"},
+	}
+	if dump := schema.String(); dump != "posts\n  id integer\nusers\n  email varchar\n  id integer\n" {
+		t.Errorf("Invalid schema dump: %q", dump)
+	}
+}
+
+func TestParseDirectives(t *testing.T) {
+	directives := ParseDirectives(`-- gomigrate: timeout=1m30s
+-- gomigrate: no-transaction
+--gomigrate: tags data, backfill
+-- gomigrate: tags=slow
+-- gomigrate: owner = billing
+-- gomigrate: author=Jane Doe ticket=PROJ-123 reviewer=sam
+-- gomigrate: note=a=b
+CREATE INDEX CONCURRENTLY a ON b (c);`)
+
+	migration := &Migration{}
+	if err := applyDirectives(migration, directives); err != nil {
+		t.Fatal(err)
+	}
+	if migration.Timeout != 90*time.Second || !migration.NoTransaction {
+		t.Errorf("Invalid migration options: %+v", migration)
+	}
+	if tags := fmt.Sprint(migration.Tags); tags != "[data backfill slow]" {
+		t.Errorf("Invalid tags: %s", tags)
+	}
+	if owner := migration.Directives.Get("owner"); owner != "billing" {
+		t.Errorf("Invalid custom directive: %q", owner)
+	}
+	if migration.Author != "Jane Doe" || migration.Ticket != "PROJ-123" || directives.Get("reviewer") != "sam" {
+		t.Errorf("Invalid metadata: %q %q %q", migration.Author, migration.Ticket, directives.Get("reviewer"))
+	}
+	if note := directives.Get("note"); note != "a=b" {
+		t.Errorf("Invalid single pair directive: %q", note)
+	}
+	if err := applyDirectives(&Migration{}, ParseDirectives("-- gomigrate: timeout=soon")); err == nil {
+		t.Errorf("Expected an invalid timeout error")
+	}
+}
+
+func TestLeveledLogger(t *testing.T) {
+	var output strings.Builder
+	m := &Migrator{logger: &LeveledLogger{Logger: log.New(&output, "", 0), Level: LevelWarn}}
+	m.infof("Applying migration: %d", 1)
+	m.warnf("Gaps found in migration ids: %v", "1..3")
+	if output.String() != "[warn] Gaps found in migration ids: 1..3\n" {
+		t.Errorf("Invalid log output: %q", output.String())
+	}
+
+	if level, err := ParseLogLevel("debug"); err != nil || level != LevelDebug {
+		t.Errorf("Invalid level: %v, %v", level, err)
+	}
+}
+
+func TestSnowflakeSessionSql(t *testing.T) {
+	statements := Snowflake{Warehouse: "etl", Schema: "o'brien"}.SessionSql()
+	expected := []string{"USE WAREHOUSE IDENTIFIER('etl')", `USE SCHEMA IDENTIFIER('o\'brien')`}
+	if fmt.Sprint(statements) != fmt.Sprint(expected) {
+		t.Errorf("Invalid session statements: %q", statements)
+	}
+}
+
+func TestAdapterRegistry(t *testing.T) {
+	mysql, err := NewAdapter("mysql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mysql.(Mysql); !ok {
+		t.Errorf("Invalid adapter for mysql: %T", mysql)
+	}
+	if _, err := NewAdapter("unknown"); err != UnknownAdapter {
+		t.Errorf("Expected UnknownAdapter, got: %v", err)
+	}
+
+	detected, err := DetectAdapter(db)
+	if err != nil {
+		t.Fatalf("Adapter not detected: %v", err)
+	}
+	if _, mariadb := adapter.(Mariadb); !mariadb && Migratable(detected) != adapter {
+		t.Errorf("Invalid adapter detected: %T", detected)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Registering a driver twice should panic")
+			}
+		}()
+		RegisterAdapter("mysql", func() Adapter { return Tidb{} })
+	}()
+
+	if capabilities(Mysql{}).Has(TransactionalDdl) || !capabilities(Postgres{}).Has(TransactionalDdl|Savepoints) {
+		t.Errorf("Invalid adapter capabilities")
+	}
+}
+
+func TestLintSql(t *testing.T) {
+	sql := `
+DROP TABLE users;
+ALTER TABLE accounts ADD COLUMN owner INTEGER NOT NULL;
+ALTER TABLE accounts ADD COLUMN kind TEXT NOT NULL DEFAULT 'basic';
+DELETE FROM sessions;
+DELETE FROM sessions WHERE expired;
+`
+	issues := lintSql(sql, nil)
+	rules := make([]string, 0)
+	for _, issue := range issues {
+		rules = append(rules, issue.Rule)
+	}
+	expected := "[drop-table not-null-without-default missing-where]"
+	if fmt.Sprint(rules) != expected {
+		t.Errorf("Invalid lint issues, expected: %s, got: %v", expected, rules)
+	}
+
+	// Allowed and disabled rules aren't reported.
+	issues = lintSql("-- gomigrate: allow drop-table\n"+sql, map[string]int{"missing-where": LintOff})
+	if len(issues) != 1 || issues[0].Rule != "not-null-without-default" {
+		t.Errorf("Invalid lint issues: %v", issues)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	m := &Migrator{
+		migrations: map[uint64]*Migration{
+			1: {Id: 1, Status: Active},
+			2: {Id: 2, Status: Inactive},
+			3: {Id: 3, Status: Active},
+			7: {Id: 7, Status: Inactive},
+		},
+		logger:      log.New(os.Stderr, "", 0),
+		initialized: true,
+	}
+
+	report := m.Status()
+	if len(report.OutOfOrder) != 1 || report.OutOfOrder[0].Id != 2 {
+		t.Errorf("Invalid out of order migrations: %v", report.OutOfOrder)
+	}
+	if len(report.Gaps) != 1 || report.Gaps[0] != (IdGap{3, 7}) {
+		t.Errorf("Invalid gaps: %v", report.Gaps)
+	}
+
+	if err := m.Validate(); err != nil {
+		t.Errorf("Validation should pass by default: %v", err)
+	}
+	m.GapPolicy = PolicyWarn
+	if err := m.Validate(); err != nil {
+		t.Errorf("Gaps should only warn: %v", err)
+	}
+	m.OutOfOrderPolicy = PolicyFail
+	if err := m.Validate(); err != OutOfOrderMigrations {
+		t.Errorf("Invalid validation error: %v", err)
+	}
+}
+
+func TestOutOfOrderTags(t *testing.T) {
+	m := &Migrator{
+		migrations: map[uint64]*Migration{
+			1: {Id: 1, Status: Active, Tags: []string{"data"}},
+			2: {Id: 2, Status: Inactive},
+			3: {Id: 3, Status: Inactive, Tags: []string{"long-running", "data"}},
+			4: {Id: 4, Status: Active, Tags: []string{"data", "long-running"}},
+			5: {Id: 5, Status: Inactive, Tags: []string{"data"}},
+		},
+		logger:           log.New(io.Discard, "", 0),
+		initialized:      true,
+		OutOfOrderPolicy: PolicyFail,
+	}
+
+	// Migration 2 was left out by tag, only 3 is older than an applied
+	// migration with the same tags.
+	if report := m.Status(); len(report.OutOfOrder) != 1 || report.OutOfOrder[0].Id != 3 {
+		t.Errorf("Invalid out of order migrations: %v", report.OutOfOrder)
+	}
+	if err := m.Validate(); err != OutOfOrderMigrations {
+		t.Errorf("Invalid validation error: %v", err)
+	}
+	m.migrations[3].Status = Active
+	if err := m.Validate(); err != nil {
+		t.Errorf("Tag-filtered migrations should not be out of order: %v", err)
+	}
+}
+
+func TestDuplicateMigrationId(t *testing.T) {
+	files := []string{
+		"migrations/001_add_users_down.sql",
+		"migrations/001_add_users_up.sql",
+		"migrations/001_add_posts_down.sql",
+		"migrations/001_add_posts_up.sql",
+		"migrations/002_add_tags_down.sql",
+		"migrations/002_add_tags_up.sql",
+	}
+	_, err := collectMigrations(files, false, nil, log.New(os.Stderr, "", 0))
+	duplicate, ok := err.(*DuplicateMigrationId)
+	if !ok {
+		t.Fatalf("Expected a duplicate migration id error, got: %v", err)
+	}
+	if duplicate.Id != 1 || len(duplicate.Paths) != 4 {
+		t.Errorf("Invalid duplicate migration id error: %v", duplicate)
+	}
+
+	renames := duplicate.Renames()
+	if len(renames) != 2 || renames["migrations/001_add_posts_up.sql"] != "migrations/003_add_posts_up.sql" {
+		t.Errorf("Invalid renames suggested: %v", renames)
+	}
+}
+
+func TestMissingDownMigrations(t *testing.T) {
+	files := []string{
+		"migrations/1_add_users_down.sql",
+		"migrations/1_add_users_up.sql",
+		"migrations/2_backfill_users_up.sql",
+	}
+	logger := log.New(os.Stderr, "", 0)
+	if _, err := collectMigrations(files, false, nil, logger); err != InvalidMigrationPair {
+		t.Errorf("Expected an invalid migration pair, got: %v", err)
+	}
+
+	ms, err := collectMigrations(files, true, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms[1].Irreversible || !ms[2].Irreversible {
+		t.Error("Only migrations without a down file should be irreversible")
+	}
+
+	m := &Migrator{migrations: ms, logger: logger, initialized: true}
+	if err := m.ApplyMigration(ms[2], downMigration); err != IrreversibleMigration {
+		t.Errorf("Expected an irreversible migration error, got: %v", err)
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	sql := `-- gomigrate: allow-failure
+DROP TABLE legacy;
+INSERT INTO notes VALUES ('a;b', "c;d");
+/* ; */
+CREATE FUNCTION f() RETURNS void AS $body$
+BEGIN
+  PERFORM 1;
+END
+$body$ LANGUAGE plpgsql;
+-- trailing comment;
+`
+	statements := splitStatements(sql)
+	if len(statements) != 3 {
+		t.Fatalf("Invalid number of statements: %d: %q", len(statements), statements)
+	}
+	if statements[0] != "-- gomigrate: allow-failure\nDROP TABLE legacy" {
+		t.Errorf("Invalid first statement: %q", statements[0])
+	}
+	if statements[1] != "INSERT INTO notes VALUES ('a;b', \"c;d\")" {
+		t.Errorf("Invalid second statement: %q", statements[1])
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	ids, err := parseIds(ParseDirectives("-- gomigrate: depends-on 1, 2\n-- gomigrate: depends-on 5\nCREATE TABLE a (id int);").List("depends-on"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ids) != "[1 2 5]" {
+		t.Errorf("Invalid dependencies: %v", ids)
+	}
+
+	unfinished := map[uint64]bool{2: true, 3: true, 4: true}
+	if !dependenciesApplied(&Migration{Id: 4, DependsOn: []uint64{1}}, unfinished, 2) {
+		t.Errorf("Expected a migration depending on an applied migration to be ready")
+	}
+	if dependenciesApplied(&Migration{Id: 4, DependsOn: []uint64{3}}, unfinished, 2) {
+		t.Errorf("Expected a migration depending on an unfinished migration to wait")
+	}
+	if dependenciesApplied(&Migration{Id: 3}, unfinished, 2) {
+		t.Errorf("Expected a migration without dependencies to wait for lower ids")
+	}
+
+	migrations := []*Migration{
+		{Id: 1},
+		{Id: 2, DependsOn: []uint64{3}},
+		{Id: 3, DependsOn: []uint64{1}},
+		{Id: 4},
+	}
+	ordered, err := orderByDependencies(migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range []uint64{1, 3, 2, 4} {
+		if ordered[i].Id != id {
+			t.Errorf("Invalid migration order: %v", ordered)
+		}
+	}
+	migrations[1].DependsOn = []uint64{4}
+	if _, err := orderByDependencies(migrations); err != DependencyCycle {
+		t.Errorf("Expected a dependency cycle, got: %v", err)
+	}
+}
+
+func TestEnvironments(t *testing.T) {
+	migration := &Migration{Environments: ParseDirectives("-- gomigrate: only dev, staging\nINSERT INTO users VALUES (1);").List("only")}
+	if fmt.Sprint(migration.Environments) != "[dev staging]" {
+		t.Errorf("Invalid environments: %v", migration.Environments)
+	}
+
+	m := &Migrator{}
+	for environment, expected := range map[string]bool{"": false, "production": false, "staging": true} {
+		m.Environment = environment
+		if m.inEnvironment(migration) != expected {
+			t.Errorf("Invalid result for environment %q", environment)
+		}
+	}
+	if !m.inEnvironment(&Migration{}) {
+		t.Errorf("Expected unrestricted migrations to run everywhere")
+	}
+
+	// Migrations depending on a migration of another environment are
+	// skipped.
+	m.logger = log.New(io.Discard, "", 0)
+	m.Environment = "production"
+	migrations := []*Migration{
+		{Id: 1},
+		{Id: 2, Environments: []string{"dev"}},
+		{Id: 3, DependsOn: []uint64{2}},
+		{Id: 4, DependsOn: []uint64{1}},
+	}
+	ids := make([]uint64, 0)
+	for _, migration := range m.selectMigrations(migrations, nil) {
+		ids = append(ids, migration.Id)
+	}
+	if fmt.Sprint(ids) != "[1 4]" {
+		t.Errorf("Invalid selected migrations: %v", ids)
+	}
+}
+
+func TestSelectMigrations(t *testing.T) {
+	m := &Migrator{logger: log.New(io.Discard, "", 0)}
+	migrations := []*Migration{
+		{Id: 1},
+		{Id: 2, Tags: []string{"data", "long-running"}},
+		{Id: 3, Tags: []string{"data"}},
+		{Id: 4, DependsOn: []uint64{2}},
+	}
+	for expected, options := range map[string][]RunOption{
+		"[1 2 3 4]": nil,
+		"[2 3]":     {WithTags("data")},
+		"[1 3]":     {WithoutTags("long-running")},
+		"[3]":       {WithTags("data"), WithoutTags("long-running")},
+	} {
+		ids := make([]uint64, 0)
+		for _, migration := range m.selectMigrations(migrations, options) {
+			ids = append(ids, migration.Id)
+		}
+		if fmt.Sprint(ids) != expected {
+			t.Errorf("Expected %s, got: %v", expected, ids)
+		}
+	}
+}
+
+func TestPhases(t *testing.T) {
+	dir := t.TempDir()
+	for path, sql := range map[string]string{
+		"/1_add_totals_up.sql":    "CREATE TABLE phase_test (total INT)",
+		"/1_add_totals_down.sql":  "DROP TABLE phase_test",
+		"/2_fill_totals_up.sql":   "-- gomigrate: phase=data\nUPDATE phase_test SET total = 0",
+		"/2_fill_totals_down.sql": "SELECT 1",
+		"/3_add_index_up.sql":     "CREATE INDEX phase_test_total ON phase_test (total)",
+		"/3_add_index_down.sql":   "SELECT 1",
+	} {
+		os.WriteFile(dir+path, []byte(sql), 0644)
+	}
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.migrations[2].Phase != PhaseData || m.migrations[3].Phase != PhaseSchema {
+		t.Fatalf("Invalid phases: %s, %s", m.migrations[2].Phase, m.migrations[3].Phase)
+	}
+	m.OutOfOrderPolicy = PolicyFail
+	if err := m.MigrateSchema(); err != nil {
+		t.Fatal(err)
+	}
+	if pending := m.Status(); len(pending.Pending) != 1 || len(pending.OutOfOrder) != 0 {
+		t.Errorf("Expected migration 2 to be pending in order, got: %v, %v", pending.Pending, pending.OutOfOrder)
+	}
+	if err := m.MigrateData(); err != nil {
+		t.Fatal(err)
+	}
+	history, err := m.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range history {
+		if expected := map[uint64]string{1: PhaseSchema, 2: PhaseData, 3: PhaseSchema}[entry.Id]; entry.Phase != expected {
+			t.Errorf("Expected migration %d to be recorded in phase %s, got %q", entry.Id, expected, entry.Phase)
+		}
+	}
+	if err := applyDirectives(&Migration{}, ParseDirectives("-- gomigrate: phase=later")); err == nil {
+		t.Error("Expected an unknown phase error")
+	}
+	m.RollbackN(3)
+	cleanup()
+}
+
+func TestPostMigrations(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(dir+"/post", 0755)
+	for path, sql := range map[string]string{
+		"/1_add_flag_up.sql":        "CREATE TABLE post_test (old_flag INT, flag INT)",
+		"/1_add_flag_down.sql":      "DROP TABLE post_test",
+		"/post/2_drop_old_up.sql":   "UPDATE post_test SET old_flag = NULL",
+		"/post/2_drop_old_down.sql": "SELECT 1",
+	} {
+		os.WriteFile(dir+path, []byte(sql), 0644)
+	}
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.migrations[2] == nil || m.migrations[2].Phase != PhasePost {
+		t.Fatalf("Expected migration 2 to be found in the post phase")
+	}
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if status := m.Status(); len(status.Pending) != 0 || len(status.Post) != 1 {
+		t.Errorf("Expected migration 2 to be deferred, got: %v, %v", status.Pending, status.Post)
+	}
+	if err := m.MigratePost(); err != nil {
+		t.Fatal(err)
+	}
+	if m.migrations[2].Status != Active {
+		t.Error("Expected migration 2 to be applied by MigratePost")
+	}
+	m.RollbackN(2)
+	cleanup()
+}
+
+func TestWindows(t *testing.T) {
+	window, err := ParseWindow("* 22-23,0-5 * * 1-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for hour, open := range map[time.Time]bool{
+		time.Date(2024, 1, 31, 23, 30, 0, 0, time.UTC): true,
+		time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC):  false,
+		time.Date(2024, 2, 3, 23, 30, 0, 0, time.UTC):  false,
+	} {
+		if window.Open(hour) != open {
+			t.Errorf("Expected window to be open at %v: %v", hour, open)
+		}
+	}
+	for _, spec := range []string{"* * * *", "60 * * * *", "* 5-2 * * *", "*/0 * * * *"} {
+		if _, err := ParseWindow(spec); err == nil {
+			t.Errorf("Expected %q to be invalid", spec)
+		}
+	}
+
+	dir := t.TempDir()
+	for path, sql := range map[string]string{
+		"/1_add_events_up.sql":     "CREATE TABLE window_test (payload INT)",
+		"/1_add_events_down.sql":   "DROP TABLE window_test",
+		"/2_clear_events_up.sql":   "-- gomigrate: window=off-peak\nUPDATE window_test SET payload = 0",
+		"/2_clear_events_down.sql": "SELECT 1",
+		"/3_add_index_up.sql":      "CREATE INDEX window_test_payload ON window_test (payload)",
+		"/3_add_index_down.sql":    "SELECT 1",
+	} {
+		os.WriteFile(dir+path, []byte(sql), 0644)
+	}
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Migrate(); err != UnknownWindow {
+		t.Fatalf("Expected an unknown window error, got: %v", err)
+	}
+	m.Windows = map[string]*Window{"off-peak": window}
+	m.Clock = fixedClock(time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC))
+	m.OutOfOrderPolicy = PolicyFail
+	result, err := m.MigrateWithResult(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Applied) != 2 || len(result.Deferred) != 1 || result.Deferred[0] != 2 {
+		t.Errorf("Expected migration 2 to be deferred, got: %+v", result)
+	}
+	m.Clock = fixedClock(time.Date(2024, 1, 31, 23, 0, 0, 0, time.UTC))
+	if result, err = m.MigrateWindows(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Applied) != 1 || m.migrations[2].Status != Active {
+		t.Errorf("Expected migration 2 to be applied in its window, got: %+v", result)
+	}
+	m.RollbackN(3)
+	cleanup()
+}
+
+func TestThrottle(t *testing.T) {
+	throttle, err := parseThrottle("delay=100ms rows=1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows, expected := range map[int64]time.Duration{
+		0:    100 * time.Millisecond,
+		500:  300 * time.Millisecond,
+		5000: 4800 * time.Millisecond,
+	} {
+		if wait := throttle.wait(rows, 200*time.Millisecond); wait != expected {
+			t.Errorf("Expected to wait %s after %d rows, got %s", expected, rows, wait)
+		}
+	}
+	for _, value := range []string{"delay=fast", "rows=0", "burst=10"} {
+		if _, err := parseThrottle(value); err == nil {
+			t.Errorf("Expected %q to be invalid", value)
+		}
+	}
+
+	m := &Migrator{Throttle: &Throttle{Delay: time.Second}}
+	if m.throttleOf(&Migration{Phase: PhaseSchema}) != nil || m.throttleOf(&Migration{Phase: PhaseData}) != m.Throttle {
+		t.Error("Expected only data migrations to be throttled by default")
+	}
+	if m.throttleOf(&Migration{Phase: PhaseSchema, Throttle: throttle}) != throttle {
+		t.Error("Expected the throttle of the migration to take precedence")
+	}
+}
+
+func TestTouchedTables(t *testing.T) {
+	analyze, vacuum := touchedTables([]string{
+		"-- Move the totals\nUPDATE orders SET total = 0 WHERE id < 10",
+		"INSERT INTO order_totals SELECT id, total FROM orders",
+		"CREATE INDEX orders_total ON orders (total)",
+		"ALTER TABLE customers ADD COLUMN vip BOOLEAN",
+		"DELETE FROM scratch",
+		"DROP TABLE scratch",
+		"SELECT 1",
+	})
+	if fmt.Sprint(analyze) != "[orders order_totals customers]" {
+		t.Errorf("Invalid tables to analyze: %v", analyze)
+	}
+	if fmt.Sprint(vacuum) != "[orders]" {
+		t.Errorf("Invalid tables to vacuum: %v", vacuum)
+	}
+}
+
+type lagProbe []time.Duration
+
+func (p *lagProbe) Lag() (time.Duration, error) {
+	lag := (*p)[0]
+	if len(*p) > 1 {
+		*p = (*p)[1:]
+	}
+	return lag, nil
+}
+
+func TestReplicationLag(t *testing.T) {
+	interval := LagPollInterval
+	LagPollInterval = time.Millisecond
+	defer func() { LagPollInterval = interval }()
+
+	probe := &lagProbe{time.Minute, time.Minute, time.Second}
+	m := &Migrator{LagProbe: probe, Throttle: &Throttle{MaxLag: 10 * time.Second}, logger: log.New(io.Discard, "", 0)}
+	if err := m.newRun().waitForReplicas(&Migration{Phase: PhaseData}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*probe) != 1 {
+		t.Errorf("Expected the migration to wait for the lag to recover, %d probes left", len(*probe))
+	}
+
+	// Replicas that don't catch up fail the migration.
+	probe = &lagProbe{time.Minute}
+	m.LagProbe = probe
+	m.Throttle.MaxPause = 5 * time.Millisecond
+	var timeout *ReplicationLagTimeout
+	if err := m.newRun().waitForReplicas(&Migration{Id: 3, Phase: PhaseData}); !errors.As(err, &timeout) {
+		t.Fatalf("Expected ReplicationLagTimeout, got: %v", err)
+	}
+	if timeout.MigrationId != 3 || timeout.Lag != time.Minute || timeout.Paused < m.Throttle.MaxPause {
+		t.Errorf("Invalid ReplicationLagTimeout: %+v", timeout)
+	}
+	if throttle, err := parseThrottle("max-lag=5s max-pause=1m"); err != nil || throttle.MaxLag != 5*time.Second || throttle.MaxPause != time.Minute {
+		t.Errorf("Invalid max lag: %v, %v", throttle, err)
+	}
+}
+
+// Names the connected database gomigrate, and drops the shadow_guard
+// table in place of shadow databases.
+type shadowAdapter struct {
+	Migratable
+}
+
+func (s shadowAdapter) CreateDatabaseSql(name, template string) string {
+	return "SELECT 1"
+}
+
+func (s shadowAdapter) DropDatabaseSql(name string) string {
+	return "DROP TABLE shadow_guard"
+}
+
+func (s shadowAdapter) CurrentDatabaseSql() string {
+	return "SELECT 'gomigrate'"
+}
+
+func TestShadow(t *testing.T) {
+	if _, err := db.Exec("CREATE TABLE shadow_guard (id INT)"); err != nil {
+		t.Fatal(err)
+	}
+	guarded, err := NewMigratorWithLogger(db, shadowAdapter{adapter}, &FileMigrationSource{Dir: t.TempDir()}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	guarded.Shadow = &Shadow{Name: "gomigrate", Open: func(name string) (*sql.DB, error) { return db, nil }}
+	if _, _, err := guarded.newRun().openShadow(); err != ShadowIsTarget {
+		t.Errorf("Expected ShadowIsTarget, got: %v", err)
+	}
+	if _, err := db.Exec("DROP TABLE shadow_guard"); err != nil {
+		t.Errorf("Expected the target not to be dropped: %v", err)
+	}
+
+	// The target can be copied.
+	unopened := errors.New("Shadow database not opened")
+	guarded.Shadow = &Shadow{Name: "copy", Template: "gomigrate", Open: func(name string) (*sql.DB, error) { return nil, unopened }}
+	if _, _, err := guarded.newRun().openShadow(); err != unopened {
+		t.Errorf("Expected the target to be copied, got: %v", err)
+	}
+
+	// Only Postgres test databases can create databases.
+	if dbType != "pg" {
+		return
+	}
+	dir := t.TempDir()
+	for path, sql := range map[string]string{
+		"/1_add_accounts_up.sql":    "CREATE TABLE shadow_test (id INT)",
+		"/1_add_accounts_down.sql":  "DROP TABLE shadow_test",
+		"/2_alter_missing_up.sql":   "ALTER TABLE shadow_missing ADD COLUMN flag INT",
+		"/2_alter_missing_down.sql": "SELECT 1",
+	} {
+		os.WriteFile(dir+path, []byte(sql), 0644)
+	}
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Shadow = &Shadow{
+		Name: "gomigrate_shadow",
+		Open: func(name string) (*sql.DB, error) {
+			return sql.Open("postgres", "host=localhost sslmode=disable dbname="+name)
+		},
+	}
+	var shadowErr *ShadowError
+	if err := m.Migrate(); !errors.As(err, &shadowErr) || shadowErr.MigrationId != 2 {
+		t.Fatalf("Expected migration 2 to fail on the shadow database, got: %v", err)
+	}
+	if m.migrations[1].Status == Active {
+		t.Error("Expected no migration to be applied to the target")
+	}
+	var shadows int
+	if err := db.QueryRow("SELECT COUNT(*) FROM pg_database WHERE datname = 'gomigrate_shadow'").Scan(&shadows); err != nil || shadows != 0 {
+		t.Errorf("Expected the shadow database to be dropped: %d, %v", shadows, err)
+	}
+	cleanup()
+}
+
+type sqlStateError string
 
+func (e sqlStateError) Error() string    { return "SQLSTATE " + string(e) }
+func (e sqlStateError) SQLState() string { return string(e) }
+
+func TestRetryable(t *testing.T) {
+	policy := &RetryPolicy{}
+	for err, expected := range map[error]bool{
+		sqlStateError("40P01"):       true,
+		sqlStateError("08006"):       true,
+		sqlStateError("42601"):       false,
+		errors.New("Error 1213: x"):  true,
+		errors.New("syntax error"):   false,
+		&StatementError{Err: io.EOF}: true,
+	} {
+		if policy.Retryable(err) != expected {
+			t.Errorf("Invalid retryable result for %v", err)
+		}
+	}
+}
+
+func TestMigrateInterrupted(t *testing.T) {
+	m := GetMigrator("test1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.MigrateContext(ctx); err != Interrupted {
+		t.Errorf("Expected an interrupted error, got: %v", err)
+	}
+	if len(m.Migrations(Active)) != 0 {
+		t.Error("No migrations should run once interrupted")
+	}
+
+	cleanup()
+}
+
+func TestApplyMigrationTx(t *testing.T) {
+	m := GetMigrator("test1")
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ApplyMigrationTx(tx, m.migrations[1], Up); err != nil {
+		t.Error(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Error(err)
+	}
+
+	// The caller's rollback should undo the migration log.
+	var id uint64
+	err = db.QueryRow(adapter.GetMigrationSql(), 1).Scan(&id)
+	if err != sql.ErrNoRows {
+		t.Errorf("Migration should not be logged after a rollback: %v", err)
+	}
+
+	cleanup()
+}
+
+func TestRequireWritable(t *testing.T) {
+	m := GetMigrator("test1")
+	m.RequireWritable = true
+	if err := m.newRun().checkWritable(); err != nil {
+		t.Errorf("Database should be writable: %v", err)
+	}
+	cleanup()
+}
+
+func TestConcurrentStatus(t *testing.T) {
+	m := GetMigrator("test1")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			m.Status()
+		}
+	}()
+	if err := m.Migrate(); err != nil {
+		t.Error(err)
+	}
+	<-done
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+	cleanup()
+}
+
+func TestMigrateWithConn(t *testing.T) {
+	m := GetMigrator("test1")
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	executor := m.executor
+	done := make(chan error)
+	go func() {
+		done <- m.MigrateWithConn(conn)
+	}()
+	m.Status()
+	if err := m.Migrate(); err != nil {
+		t.Error(err)
+	}
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	if m.executor != executor {
+		t.Error("The migrator's executor should not change")
+	}
+	if len(m.Pending()) != 0 {
+		t.Errorf("Invalid pending migrations: %v", m.Pending())
+	}
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+	cleanup()
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, sql string) {
+		if err := os.WriteFile(dir+"/"+name, []byte(sql), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("1_reload_a_up.sql", "CREATE TABLE reload_a (id INT)")
+	write("1_reload_a_down.sql", "DROP TABLE reload_a")
+
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	write("2_reload_b_up.sql", "CREATE TABLE reload_b (id INT)")
+	write("2_reload_b_down.sql", "DROP TABLE reload_b")
+	if err := m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if pending := m.Pending(); len(pending) != 1 || pending[0].Id != 2 {
+		t.Errorf("Invalid pending migrations after reload: %v", pending)
+	}
+	if m.Version() != 1 {
+		t.Errorf("Invalid version after reload: %d", m.Version())
+	}
+
+	if err := m.Migrate(); err != nil {
+		t.Error(err)
+	}
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+	cleanup()
+}
+
+func TestEncryptedMigrations(t *testing.T) {
+	key := AESKey("0123456789abcdef")
+	up, err := key.Encrypt([]byte("CREATE TABLE secret (id INT)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(dir+"/1_secret_up.sql.enc", up, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir+"/1_secret_down.sql", []byte("DROP TABLE secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := log.New(io.Discard, "", 0)
+	if _, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, logger); err != MissingKeyProvider {
+		t.Errorf("Expected MissingKeyProvider, got: %v", err)
+	}
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir, KeyProvider: key}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands, err := m.newRun().migrationCommands(m.migrations[1], upMigration)
+	if err != nil || len(commands) != 1 || commands[0] != "CREATE TABLE secret (id INT)" {
+		t.Errorf("Invalid decrypted migration: %q, %v", commands, err)
+	}
+}
+
+func TestSecretPlaceholders(t *testing.T) {
+	m := &Migrator{logger: log.New(io.Discard, "", 0)}
+	m.Secrets = SecretFunc(func(name string) (string, error) {
+		if name == "app/password" {
+			return "hunter2", nil
+		}
+		return "", MissingSecret
+	})
+
+	resolved, secrets, err := m.resolveSecrets("CREATE ROLE app PASSWORD '${SECRET:app/password}'")
+	if err != nil || resolved != "CREATE ROLE app PASSWORD 'hunter2'" {
+		t.Errorf("Invalid resolved statement: %q, %v", resolved, err)
+	}
+	redacted := &redactedError{fmt.Errorf("syntax error near %s", resolved), secrets}
+	if redacted.Error() != "syntax error near CREATE ROLE app PASSWORD '[REDACTED]'" {
+		t.Errorf("Secret not redacted: %s", redacted)
+	}
+	if _, _, err := m.resolveSecrets("SELECT '${SECRET:missing}'"); err != MissingSecret {
+		t.Errorf("Expected MissingSecret, got: %v", err)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	m := GetMigrator("test1")
+	plan, err := m.Plan(Up, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Migrations) != len(m.migrations) || plan.Size() == 0 {
+		t.Errorf("Invalid plan: %+v", plan)
+	}
+	if err := m.ExecutePlan(plan); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Pending()) != 0 {
+		t.Errorf("Plan not executed")
+	}
+	if err := m.ExecutePlan(plan); err != StalePlan {
+		t.Errorf("Expected StalePlan, got: %v", err)
+	}
+
+	if plan, err = m.Plan(Down, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ExecutePlan(plan); err != nil {
+		t.Error(err)
+	}
+	cleanup()
+}
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(dir+"/"+name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("1_users_up.sql", "CREATE TABLE users (id INT)")
+	write("1_users_down.sql", "DROP TABLE users")
+	write("2_seed_up.sql", "-- gomigrate: tags data\nINSERT INTO users VALUES (1)")
+	write("migrations.yaml", `
+migrations:
+  - id: 1
+    up: 1_users_up.sql
+    down: 1_users_down.sql
+    description: Creates the users table
+  - id: 2
+    description: Seeds users
+    tags: [seed]
+    irreversible: true
+`)
+
+	logger := log.New(io.Discard, "", 0)
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.migrations[1].Description != "Creates the users table" || !m.migrations[2].Irreversible {
+		t.Errorf("Manifest metadata not loaded: %+v", m.migrations[1])
+	}
+	if tags := m.migrations[2].Tags; len(tags) != 2 || tags[0] != "seed" || tags[1] != "data" {
+		t.Errorf("Invalid tags: %v", tags)
+	}
+
+	write("3_undeclared_up.sql", "SELECT 1")
+	write("3_undeclared_down.sql", "SELECT 1")
+	if _, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, logger); err != InvalidManifest {
+		t.Errorf("Expected InvalidManifest, got: %v", err)
+	}
+}
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func TestRenumberMigrations(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"001_add_users_up.sql":   "SELECT 1",
+		"001_add_users_down.sql": "SELECT 1",
+		"001_add_posts_up.sql":   "SELECT 1",
+		"001_add_posts_down.sql": "SELECT 1",
+		"002_add_tags_up.sql":    "SELECT 1",
+		"002_add_tags_down.sql":  "SELECT 1",
+		"003_tag_posts_up.sql":   "-- gomigrate: depends-on 1, 2\nSELECT 1",
+		"003_tag_posts_down.sql": "SELECT 1",
+	}
+	for name, sql := range files {
+		if err := os.WriteFile(dir+"/"+name, []byte(sql), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logger := log.New(io.Discard, "", 0)
+	if err := RenumberMigrations(dir, []Renumbering{{Id: 1, NewId: 4}}, logger); err != InvalidRenumbering {
+		t.Errorf("Expected an ambiguous renumbering to fail, got: %v", err)
+	}
+	if err := RenumberMigrations(dir, []Renumbering{{Id: 1, Name: "add_posts", NewId: 3}}, logger); err != InvalidRenumbering {
+		t.Errorf("Expected a used id to fail, got: %v", err)
+	}
+	renumberings := []Renumbering{{Id: 1, Name: "add_posts", NewId: 4}, {Id: 2, NewId: 5}}
+	if err := RenumberMigrations(dir, renumberings, logger); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"001_add_users_up.sql", "004_add_posts_up.sql", "004_add_posts_down.sql", "005_add_tags_up.sql"} {
+		if _, err := os.Stat(dir + "/" + name); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Only the vacated id is a reference to the renumbered migration.
+	sql, err := os.ReadFile(dir + "/003_tag_posts_up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(sql) != "-- gomigrate: depends-on 1, 5\nSELECT 1" {
+		t.Errorf("Invalid dependencies: %q", sql)
+	}
+
+	statements := RenumberSql(renumberings, false)
+	if len(statements) != 2 || statements[1] != "UPDATE gomigrate SET migration_id = 5 WHERE migration_id = 2;" {
+		t.Errorf("Invalid statements: %v", statements)
+	}
+}
+
+func TestCreateMigration(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(dir+"/1_users_up.sql", []byte("SELECT 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir+"/1_users_down.sql", []byte("SELECT 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := log.New(io.Discard, "", 0)
+	m, err := NewReadOnlyMigrator(db, adapter, &FileMigrationSource{Dir: dir}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migration, err := m.CreateMigration("add_posts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migration.Id != 2 || migration.Name != "add_posts" {
+		t.Errorf("Invalid migration: %+v", migration)
+	}
+
+	clock := fixedClock(time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC))
+	m.Clock = clock
+	m.IdGenerator = TimestampIds{Clock: clock}
+	if migration, err = m.CreateMigration("add_comments"); err != nil {
+		t.Fatal(err)
+	}
+	if migration.Id != 20240131120000 {
+		t.Errorf("Invalid id: %d", migration.Id)
+	}
+	if _, err := os.Stat(dir + "/20240131120000_add_comments_down.sql"); err != nil {
+		t.Error(err)
+	}
+	if id := (TimestampIds{Clock: clock}).NextId([]uint64{20240131120000}); id != 20240131120001 {
+		t.Errorf("Invalid id: %d", id)
+	}
+	if !m.now().Equal(time.Time(clock)) {
+		t.Errorf("Clock not used: %v", m.now())
+	}
+
+	if _, err := m.CreateMigration("bad name"); err != InvalidMigrationFile {
+		t.Errorf("Expected InvalidMigrationFile, got: %v", err)
+	}
+}
+
+func TestCreatePatternMigrations(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewReadOnlyMigrator(db, adapter, &FileMigrationSource{Dir: dir}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrations, err := m.CreatePatternMigrations("rename-column", map[string]string{
+		"table": "orders", "column": "total", "new_column": "amount", "type": "INT",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 3 || migrations[2].Id != 3 || migrations[2].Name != "drop_total_of_orders" {
+		t.Fatalf("Invalid migrations: %v", migrations)
+	}
+	if tags := fmt.Sprint(migrations[1].Tags); tags != "[backfill]" {
+		t.Errorf("Invalid tags: %s", tags)
+	}
+	if depends := fmt.Sprint(migrations[2].DependsOn); depends != "[2]" {
+		t.Errorf("Invalid dependencies: %s", depends)
+	}
+	up, _ := os.ReadFile(dir + "/2_copy_total_to_amount_of_orders_up.sql")
+	if !strings.Contains(string(up), "UPDATE orders SET amount = total WHERE amount IS NULL;") {
+		t.Errorf("Invalid up file: %s", up)
+	}
+
+	if _, err := m.CreatePatternMigrations("add-column", map[string]string{"table": "orders"}); err == nil {
+		t.Error("Expected a missing parameter error")
+	}
+	if _, err := m.CreatePatternMigrations("split-table", nil); err == nil {
+		t.Error("Expected an unknown pattern error")
+	}
+}
+
+func TestMigrationStats(t *testing.T) {
+	m := GetMigrator("test1")
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The stats should be loaded by a new migrator.
+	m = GetMigrator("test1")
+	if count := m.migrations[1].StatementCount; count != 1 {
+		t.Errorf("Invalid statement count: %d", count)
+	}
+	if audit := m.migrations[1].Audit; audit.Application == "" || audit.Host == "" || audit.Build == "" || audit.Runner == "" {
+		t.Errorf("Invalid audit information: %+v", audit)
+	}
+
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+	cleanup()
+}
+
+func TestRecordSql(t *testing.T) {
+	path := fmt.Sprintf("test_migrations/test1_%s", dbType)
+	m, err := NewMigrator(db, adapter, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.RecordSql = true
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	statements, err := m.AppliedSql(m.migrations[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands, _ := m.newRun().migrationCommands(m.migrations[1], upMigration)
+	if fmt.Sprint(statements) != fmt.Sprint(commands) {
+		t.Errorf("Invalid recorded statements: %q", statements)
+	}
+
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+	if _, err := db.Exec("drop table gomigrate_sql"); err != nil {
+		t.Error(err)
+	}
+	cleanup()
+}
+
+func TestHeartbeat(t *testing.T) {
+	// The migration only writes rows, so the heartbeats can be read
+	// while it runs on every database.
+	db.Exec("CREATE TABLE heartbeat_test (id INT)")
+	defer db.Exec("DROP TABLE heartbeat_test")
+	dir := t.TempDir()
+	os.WriteFile(dir+"/1_seed_up.sql", []byte("INSERT INTO heartbeat_test VALUES (1)"), 0644)
+	os.WriteFile(dir+"/1_seed_down.sql", []byte("DELETE FROM heartbeat_test"), 0644)
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.initialize(); err != nil {
+		t.Fatal(err)
+	}
+	m.HeartbeatInterval = time.Millisecond
+	m.Runner = "deploy-42"
+	m.LockTable = true
+	if err := m.newRun().ensureHeartbeatTable(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.newRun().ensureLockTable(); err != nil {
+		t.Fatal(err)
+	}
+	var running []*Heartbeat
+	m.Subscribe(func(e Event) {
+		if _, ok := e.(StatementExecuted); ok && running == nil {
+			running, _ = m.Heartbeats()
+		}
+	})
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(running) != 1 || running[0].Owner != "deploy-42" || running[0].MigrationId != 1 {
+		t.Errorf("Invalid heartbeats while running: %v", running)
+	}
+	if heartbeats, err := m.Heartbeats(); err != nil || len(heartbeats) != 0 {
+		t.Errorf("Heartbeats left after the run: %v, %v", heartbeats, err)
+	}
+
+	// Runs on a connection of the caller record heartbeats with the
+	// migrator's executor, outside of the migration's transaction.
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	r := m.newRun()
+	r.executor = connExecutor{conn}
+	stop := r.startHeartbeat(m.Migrations(-1)[0])
+	if heartbeats, err := m.Heartbeats(); err != nil || len(heartbeats) != 1 {
+		t.Errorf("Invalid heartbeats of a run on a connection: %v, %v", heartbeats, err)
+	}
+	stop()
+
+	// Beats refresh the heartbeat and the lock held by the runner.
+	release, err := m.newRun().acquireLock(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	m.Clock = fixedClock(time.Now().Add(time.Hour))
+	migration := m.Migrations(-1)[0]
+	recorder := m.dbAdapter.(HeartbeatRecorder)
+	db.Exec("INSERT INTO gomigrate_heartbeat (owner, migration_id, started_at_ns, last_seen_ns) VALUES ('deploy-42', 1, 0, 0)")
+	m.newRun().beat(recorder, "deploy-42", migration)
+	heartbeats, err := m.Heartbeats()
+	if err != nil || len(heartbeats) != 1 || !heartbeats[0].LastSeen.Equal(m.now()) {
+		t.Fatalf("Invalid heartbeats: %v, %v", heartbeats, err)
+	}
+	if holder, _ := m.LockHolder(); holder == nil || holder.ExpiresAt.Before(m.now()) {
+		t.Errorf("Lock not refreshed: %+v", holder)
+	}
+	if heartbeats[0].Stale(m.now().Add(time.Second), time.Minute) || !heartbeats[0].Stale(m.now().Add(time.Hour), time.Minute) {
+		t.Errorf("Invalid staleness of %+v", heartbeats[0])
+	}
+	db.Exec("DELETE FROM gomigrate_heartbeat")
+	m.RollbackAll()
+	cleanup()
+}
+
+func TestLockTable(t *testing.T) {
+	holder := GetMigrator("test1")
+	holder.LockTable = true
+	if err := holder.newRun().ensureLockTable(); err != nil {
+		t.Fatal(err)
+	}
+	release, err := holder.newRun().acquireLock(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := GetMigrator("test1")
+	m.LockTable = true
+	m.LockTimeout = 10 * time.Millisecond
+	err = m.Migrate()
+	var timeout *LockTimeout
+	if !errors.As(err, &timeout) || !errors.Is(err, MigrationLocked) {
+		t.Fatalf("Expected LockTimeout, got: %v", err)
+	}
+	if timeout.Holder == nil || timeout.Holder.Owner != holder.lockOwner || timeout.Waited < m.LockTimeout {
+		t.Errorf("Invalid LockTimeout: %+v", timeout)
+	}
+	m.LockWait = LockNoWait
+	if err := m.Migrate(); !errors.As(err, &timeout) || timeout.Waited >= m.LockTimeout {
+		t.Errorf("Expected immediate LockTimeout, got: %v", err)
+	}
+	m.LockWait = LockWaitTimeout
+	release()
+	if err := m.Migrate(); err != nil {
+		t.Error(err)
+	}
+
+	// Migrations rolled back by another runner are inactive once the
+	// lock is taken.
+	other := GetMigrator("test1")
+	other.LockTable = true
+	if err := other.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+	if release, err = m.newRun().acquireLock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if pending := m.Pending(); len(pending) == 0 || len(pending) != len(other.Pending()) {
+		t.Errorf("Invalid pending migrations: %v", pending)
+	}
+
+	// An expired lock is taken over.
+	holder.Clock = fixedClock(time.Now().Add(-time.Hour))
+	if _, err := holder.newRun().acquireLock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if release, err = m.newRun().acquireLock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := holder.newRun().renewLock(); err != MigrationLocked {
+		t.Errorf("Expected MigrationLocked, got: %v", err)
+	}
+
+	owner, err := holder.LockHolder()
+	if err != nil || owner == nil || owner.Owner != m.lockOwner {
+		t.Fatalf("Invalid lock holder: %v, %v", owner, err)
+	}
+	readOnly, err := NewReadOnlyMigrator(db, adapter, holder.Source, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := readOnly.ForceUnlock(owner.Owner); err != ReadOnlyMigrator {
+		t.Errorf("Expected a read-only migrator error, got: %v", err)
+	}
+	if err := holder.ForceUnlock(holder.lockOwner); err != LockNotHeld {
+		t.Errorf("Expected LockNotHeld, got: %v", err)
+	}
+	if err := holder.ForceUnlock(owner.Owner); err != nil {
+		t.Error(err)
+	}
+	if owner, err := holder.LockHolder(); owner != nil || err != nil {
+		t.Errorf("Lock still held: %v, %v", owner, err)
+	}
+
+	if _, err := db.Exec("drop table gomigrate_lock"); err != nil {
+		t.Error(err)
+	}
+	cleanup()
+}
+
+func TestMigrateLeader(t *testing.T) {
+	holder := GetMigrator("test1")
+	holder.LockTable = true
+	if err := holder.newRun().ensureLockTable(); err != nil {
+		t.Fatal(err)
+	}
+	release, err := holder.newRun().acquireLock(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := GetMigrator("test1")
+	m.LockWait = LockNoWait
+	m.Subscribe(func(e Event) {
+		if m.LockTable {
+			t.Errorf("LockTable set during a leader run")
+		}
+	})
+	if result, err := m.MigrateLeader(context.Background()); result.Outcome != RunLockHeld || err != nil {
+		t.Errorf("Expected RunLockHeld, got: %v, %v", result.Outcome, err)
+	}
+	release()
+	if result, err := m.MigrateLeader(context.Background()); result.Outcome != RunApplied || err != nil {
+		t.Errorf("Expected RunApplied, got: %v, %v", result.Outcome, err)
+	}
+	if result, err := m.MigrateLeader(context.Background()); result.Outcome != RunUpToDate || err != nil {
+		t.Errorf("Expected RunUpToDate, got: %v, %v", result.Outcome, err)
+	}
+
+	if _, err := db.Exec(adapter.MigrationLogInsertSql(), 999); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.MigrateLeader(context.Background()); err != NewerMigrations {
+		t.Errorf("Expected NewerMigrations, got: %v", err)
+	}
+	if _, err := db.Exec(adapter.MigrationLogDeleteSql(), 999); err != nil {
+		t.Error(err)
+	}
+
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+	if _, err := db.Exec("drop table gomigrate_lock"); err != nil {
+		t.Error(err)
+	}
+	cleanup()
+}
+
+func TestMigrateWithResult(t *testing.T) {
+	m := GetMigrator("test1")
+	result, err := m.MigrateWithResult(context.Background())
+	if err != nil || result.Outcome != RunApplied || len(result.Applied) != len(m.migrations) {
+		t.Errorf("Invalid result: %+v, %v", result, err)
+	}
+	if result, err = m.MigrateWithResult(context.Background()); err != nil || result.Outcome != RunUpToDate {
+		t.Errorf("Invalid result: %+v, %v", result, err)
+	}
+	encoded, _ := json.Marshal(result)
+	if !strings.Contains(string(encoded), `"outcome":"up-to-date"`) {
+		t.Errorf("Invalid JSON: %s", encoded)
+	}
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(dir+"/1_broken_up.sql", []byte("NOT SQL"), 0644)
+	os.WriteFile(dir+"/1_broken_down.sql", []byte("SELECT 1"), 0644)
+	broken, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = broken.MigrateWithResult(context.Background())
+	if err == nil || result.Outcome != RunMigrationFailed || result.FailedId != 1 || result.Error == "" {
+		t.Errorf("Invalid result: %+v, %v", result, err)
+	}
+
+	cleanup()
+}
+
+func TestAutoMigrate(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"1_first", "2_second"} {
+		os.WriteFile(dir+"/"+name+"_up.sql", []byte("SELECT 1"), 0644)
+		os.WriteFile(dir+"/"+name+"_down.sql", []byte("SELECT 1"), 0644)
+	}
+	source := &FileMigrationSource{Dir: dir}
+	logger := log.New(io.Discard, "", 0)
+	options := AutoMigrateOptions{Adapter: adapter, Logger: logger, KillSwitch: "GOMIGRATE_TEST_DISABLE"}
+
+	os.Setenv("GOMIGRATE_TEST_DISABLE", "true")
+	result, err := AutoMigrate(context.Background(), db, source, options)
+	os.Unsetenv("GOMIGRATE_TEST_DISABLE")
+	if err != nil || result.Outcome != RunDisabled {
+		t.Errorf("Expected RunDisabled, got: %+v, %v", result, err)
+	}
+
+	options.MaxPending = 1
+	if _, err := AutoMigrate(context.Background(), db, source, options); err != TooManyPending {
+		t.Errorf("Expected TooManyPending, got: %v", err)
+	}
+
+	options.MaxPending = 2
+	result, err = AutoMigrate(context.Background(), db, source, options)
+	if err != nil || result.Outcome != RunApplied || len(result.Applied) != 2 {
+		t.Errorf("Expected RunApplied, got: %+v, %v", result, err)
+	}
+
+	m, err := NewMigratorWithLogger(db, adapter, source, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+	if _, err := db.Exec("drop table gomigrate_lock"); err != nil {
+		t.Error(err)
+	}
+	cleanup()
+}
+
+func TestArchivedMigrations(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(dir+"/archive", 0755)
+	os.WriteFile(dir+"/archive/1_old_up.sql", []byte("NOT SQL"), 0644)
+	os.WriteFile(dir+"/archive/2_baseline_up.sql", []byte("NOT SQL"), 0644)
+	os.WriteFile(dir+"/2_baseline_up.sql", []byte("SELECT 1"), 0644)
+	os.WriteFile(dir+"/2_baseline_down.sql", []byte("SELECT 1"), 0644)
+	os.WriteFile(dir+"/4_new_up.sql", []byte("SELECT 1"), 0644)
+	os.WriteFile(dir+"/4_new_down.sql", []byte("SELECT 1"), 0644)
+	logger := log.New(io.Discard, "", 0)
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.migrations[1].Archived || m.migrations[1].Status != Active || m.migrations[2].Archived {
+		t.Errorf("Invalid archived migrations: %+v", m.migrations)
+	}
+	if gaps := m.Status().Gaps; len(gaps) != 1 || gaps[0] != (IdGap{2, 4}) {
+		t.Errorf("Invalid gaps: %v", gaps)
+	}
+
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+	if m.migrations[1].Status != Active || m.migrations[2].Status != Inactive {
+		t.Errorf("Invalid statuses after rollback: %+v", m.migrations)
+	}
+	cleanup()
+}
+
+func TestSquash(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/1_a_up.sql", []byte("SELECT 1"), 0644)
+	os.WriteFile(dir+"/1_a_down.sql", []byte("SELECT -1"), 0644)
+	os.WriteFile(dir+"/2_b_up.sql", []byte("SELECT 2"), 0644)
+	os.WriteFile(dir+"/2_b_down.sql", []byte("SELECT -2"), 0644)
+	os.WriteFile(dir+"/3_c_up.sql", []byte("SELECT 3"), 0644)
+	os.WriteFile(dir+"/3_c_down.sql", []byte("SELECT -3"), 0644)
+	logger := log.New(io.Discard, "", 0)
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Squash(9, "baseline"); err != InvalidSquashTarget {
+		t.Errorf("Expected InvalidSquashTarget, got: %v", err)
+	}
+	if err := m.ApplyMigration(m.migrations[1], upMigration); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Squash(2, "baseline"); err != PartiallyApplied {
+		t.Errorf("Expected PartiallyApplied with a pending migration, got: %v", err)
+	}
+	if _, err := os.Stat(dir + "/2_b_up.sql"); err != nil {
+		t.Errorf("Expected a refused squash to leave files alone: %v", err)
+	}
+
+	if err := m.ApplyMigration(m.migrations[2], upMigration); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Squash(2, "baseline"); err != nil {
+		t.Fatal(err)
+	}
+	up, _ := os.ReadFile(dir + "/2_baseline_up.sql")
+	if string(up) != "-- 1_a_up.sql\nSELECT 1\n-- 2_b_up.sql\nSELECT 2\n" {
+		t.Errorf("Invalid baseline up migration: %q", up)
+	}
+	down, _ := os.ReadFile(dir + "/2_baseline_down.sql")
+	if string(down) != "-- 2_b_down.sql\nSELECT -2\n-- 1_a_down.sql\nSELECT -1\n" {
+		t.Errorf("Invalid baseline down migration: %q", down)
+	}
+	for _, name := range []string{"1_a_up.sql", "1_a_down.sql", "2_b_up.sql", "2_b_down.sql"} {
+		if _, err := os.Stat(dir + "/archive/" + name); err != nil {
+			t.Error(err)
+		}
+		if _, err := os.Stat(dir + "/" + name); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be archived, got: %v", name, err)
+		}
+	}
+
+	// Only the baseline is left in the log.
+	var squashed, baseline int
+	db.QueryRow("SELECT COUNT(*) FROM gomigrate WHERE migration_id = 1").Scan(&squashed)
+	db.QueryRow("SELECT COUNT(*) FROM gomigrate WHERE migration_id = 2").Scan(&baseline)
+	if squashed != 0 || baseline != 1 {
+		t.Errorf("Invalid migration log: %d squashed, %d baseline entries", squashed, baseline)
+	}
+	reloaded, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending := reloaded.Pending(); len(pending) != 1 || pending[0].Id != 3 {
+		t.Errorf("Invalid pending migrations: %v", pending)
+	}
+	if !reloaded.migrations[1].Archived || reloaded.migrations[2].Status != Active || reloaded.migrations[2].Name != "baseline" {
+		t.Errorf("Invalid migrations after squashing: %+v", reloaded.migrations)
+	}
+
+	// Squashing again folds the first baseline into the second one.
+	if err := reloaded.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.Squash(1, "again"); err != InvalidSquashTarget {
+		t.Errorf("Expected archived migrations not to be squashed again, got: %v", err)
+	}
+	if err := reloaded.Squash(3, "second"); err != nil {
+		t.Fatal(err)
+	}
+	up, _ = os.ReadFile(dir + "/3_second_up.sql")
+	if string(up) != "-- 2_baseline_up.sql\n-- 1_a_up.sql\nSELECT 1\n-- 2_b_up.sql\nSELECT 2\n\n-- 3_c_up.sql\nSELECT 3\n" {
+		t.Errorf("Invalid second baseline up migration: %q", up)
+	}
+	for _, path := range []string{"/2_baseline_up.sql", "/archive/2_baseline_up.sql", "/archive/archive"} {
+		if _, err := os.Stat(dir + path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be gone, got: %v", path, err)
+		}
+	}
+	if _, err := os.Stat(dir + "/archive/3_c_up.sql"); err != nil {
+		t.Error(err)
+	}
+	second, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second.Pending()) != 0 || second.migrations[3].Status != Active || !second.migrations[2].Archived {
+		t.Errorf("Invalid migrations after squashing again: %+v", second.migrations)
+	}
+
+	// Directives of one migration would apply to the whole baseline.
+	os.WriteFile(dir+"/4_d_up.sql", []byte("-- gomigrate: no-transaction\nSELECT 4"), 0644)
+	os.WriteFile(dir+"/4_d_down.sql", []byte("SELECT -4"), 0644)
+	if err := second.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Squash(4, "third"); err != InvalidSquashTarget {
+		t.Errorf("Expected migrations with directives not to be squashed, got: %v", err)
+	}
+	cleanup()
+}
+
+func TestSkip(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/1_broken_up.sql", []byte("NOT SQL"), 0644)
+	os.WriteFile(dir+"/1_broken_down.sql", []byte("NOT SQL EITHER"), 0644)
+	os.WriteFile(dir+"/2_fine_up.sql", []byte("SELECT 1"), 0644)
+	os.WriteFile(dir+"/2_fine_down.sql", []byte("SELECT 1"), 0644)
+	logger := log.New(io.Discard, "", 0)
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Skip = map[uint64]string{1: "Applied by hand"}
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied := reloaded.Applied()
+	if len(applied) != 2 || applied[0].SkipReason != "Applied by hand" || applied[1].SkipReason != "" {
+		t.Errorf("Invalid applied migrations: %+v", applied)
+	}
+	if err := reloaded.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+	cleanup()
+}
+
+func TestRequireVersion(t *testing.T) {
+	m := GetMigrator("test1")
+	var versionErr *SchemaVersionError
+	if err := m.RequireVersion(1); !errors.As(err, &versionErr) || versionErr.Current != 0 {
+		t.Errorf("Expected SchemaVersionError, got: %v", err)
+	}
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RequireVersion(1); err != nil {
+		t.Error(err)
+	}
+	if err := RequireVersion(db, 1); err != nil {
+		t.Error(err)
+	}
+	if err := RequireVersion(db, 1000); !errors.As(err, &versionErr) || versionErr.Required != 1000 {
+		t.Errorf("Expected SchemaVersionError, got: %v", err)
+	}
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+	cleanup()
+}
+
+func TestHealthy(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/1_users_up.sql", []byte("CREATE TABLE health_users (id INT)"), 0644)
+	os.WriteFile(dir+"/1_users_down.sql", []byte("DROP TABLE health_users"), 0644)
+	logger := log.New(io.Discard, "", 0)
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var health *HealthError
+	if err := m.Healthy(); !errors.As(err, &health) || len(health.Pending) != 1 {
+		t.Errorf("Expected a pending migration, got: %v", err)
+	}
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Healthy(); err != nil {
+		t.Errorf("Expected healthy, got: %v", err)
+	}
+	recorder := httptest.NewRecorder()
+	m.HealthHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected 200, got: %d", recorder.Code)
+	}
+
+	os.WriteFile(dir+"/1_users_up.sql", []byte("CREATE TABLE health_users (id BIGINT)"), 0644)
+	if err := m.Healthy(); !errors.As(err, &health) || len(health.Modified) != 1 || health.Modified[0] != 1 {
+		t.Errorf("Expected a modified migration, got: %v", err)
+	}
+	recorder = httptest.NewRecorder()
+	m.HealthHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	if recorder.Code != http.StatusServiceUnavailable || !strings.Contains(recorder.Body.String(), `"modified":[1]`) {
+		t.Errorf("Invalid response: %d, %s", recorder.Code, recorder.Body)
+	}
+
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+	cleanup()
+}
+
+func TestChecksumAlgorithm(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/1_users_up.sql", []byte("CREATE TABLE checksum_users (id INT)"), 0644)
+	os.WriteFile(dir+"/1_users_down.sql", []byte("DROP TABLE checksum_users"), 0644)
+	logger := log.New(io.Discard, "", 0)
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.ChecksumAlgorithm = ChecksumSha512
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	migration := m.Migrations(Active)[0]
+	if migration.ChecksumAlgorithm != "sha512" || len(migration.Checksum) != 128 {
+		t.Errorf("Invalid checksum: %s %s", migration.ChecksumAlgorithm, migration.Checksum)
+	}
+
+	// Checksums are compared with the algorithm that recorded them.
+	m.ChecksumAlgorithm = ChecksumCrc32
+	if err := m.Healthy(); err != nil {
+		t.Errorf("Expected healthy, got: %v", err)
+	}
+	os.WriteFile(dir+"/1_users_up.sql", []byte("CREATE TABLE checksum_users (id BIGINT)"), 0644)
+	var health *HealthError
+	if err := m.Healthy(); !errors.As(err, &health) || len(health.Modified) != 1 {
+		t.Errorf("Expected a modified migration, got: %v", err)
+	}
+	if sum := ChecksumCrc32.Sum([]byte("gomigrate")); len(sum) != 8 {
+		t.Errorf("Invalid crc32 checksum: %s", sum)
+	}
+
+	os.WriteFile(dir+"/1_users_up.sql", []byte("CREATE TABLE checksum_users (id INT)"), 0644)
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+	cleanup()
+}
+
+func TestTableVersion(t *testing.T) {
+	// A table created by the first version of this package is upgraded
+	// and its version recorded.
+	if _, err := db.Exec(adapter.CreateMigrationTableSql()); err != nil {
+		t.Fatal(err)
+	}
+	db.Exec("drop table gomigrate_version")
+	m := GetMigrator("test1")
+	version, err := m.TableVersion()
+	if err != nil || version != latestTableVersion() {
+		t.Errorf("Invalid table version: %d, %v", version, err)
+	}
+	if recorded := m.newRun().recordedTableVersion(); recorded != latestTableVersion() {
+		t.Errorf("Invalid recorded table version: %d", recorded)
+	}
+	if err := m.Migrate(); err != nil {
+		t.Error(err)
+	}
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+
+	// Tables upgraded by newer versions are refused.
+	if _, err := db.Exec("UPDATE gomigrate_version SET version = 99 WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	m, err = NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: "test_migrations/test1_" + dbType}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Migrate(); err != NewerTableLayout {
+		t.Errorf("Expected a newer table layout, got: %v", err)
+	}
+	db.Exec("drop table gomigrate_version")
+	cleanup()
+}
+
+func TestHistory(t *testing.T) {
+	m := GetMigrator("test1")
+	m.Clock = fixedClock(time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC))
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	// Migrations without files are reported too.
+	if _, err := db.Exec(adapter.MigrationLogInsertSql(), 999); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := m.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != len(m.migrations)+1 || history[0].Id != 999 {
+		t.Fatalf("Invalid history: %+v", history)
+	}
+	entry := history[1]
+	if entry.Name == "" || entry.Checksum == "" || entry.Runner == "" || !entry.AppliedAt.Equal(time.Time(m.Clock.(fixedClock))) {
+		t.Errorf("Invalid history entry: %+v", entry)
+	}
+
+	if _, err := db.Exec(adapter.MigrationLogDeleteSql(), 999); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+	cleanup()
+}
+
+func TestRollbackTo(t *testing.T) {
+	dir := t.TempDir()
+	for i, table := range []string{"first", "second", "third"} {
+		os.WriteFile(fmt.Sprintf("%s/%d_%s_up.sql", dir, i+1, table), []byte("CREATE TABLE rollback_"+table+" (id INT)"), 0644)
+		os.WriteFile(fmt.Sprintf("%s/%d_%s_down.sql", dir, i+1, table), []byte("DROP TABLE rollback_"+table), 0644)
+	}
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RollbackTo(1); err != nil {
+		t.Fatal(err)
+	}
+	if applied := m.Applied(); len(applied) != 1 || applied[0].Id != 1 {
+		t.Errorf("Invalid applied migrations: %v", applied)
+	}
+
+	// Counts beyond the applied migrations roll back all of them.
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RollbackN(10); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Applied()) != 0 {
+		t.Errorf("Migrations left applied: %d", len(m.Applied()))
+	}
+	cleanup()
+}
+
+func TestTemplates(t *testing.T) {
+	RegisterTemplateFunc("upper", strings.ToUpper)
+	dir := t.TempDir()
+	os.WriteFile(dir+"/1_seed_up.sql.tmpl", []byte(`CREATE TABLE {{ ident .table }} (name VARCHAR(64));
+INSERT INTO {{ ident .table }} VALUES ({{ quote (upper .owner) }}), ({{ quote now.Year }})`), 0644)
+	os.WriteFile(dir+"/1_seed_down.sql.tmpl", []byte(`DROP TABLE {{ ident .table }}`), 0644)
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Clock = fixedClock(time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC))
+	m.TemplateData = map[string]interface{}{"table": "templated", "owner": "o'neil"}
+	m.StrictTemplates = true
+
+	plan, err := m.Plan(Up, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statements := strings.Join(plan.Migrations[0].Statements, ";"); !strings.Contains(statements, `VALUES ('O''NEIL'), ('2024')`) {
+		t.Errorf("Invalid rendering: %s", statements)
+	}
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM templated").Scan(&count); err != nil || count != 2 {
+		t.Errorf("Invalid rows: %d, %v", count, err)
+	}
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+
+	// Missing keys fail in strict mode.
+	delete(m.TemplateData, "owner")
+	if err := m.Migrate(); err == nil {
+		t.Errorf("Expected an error for a missing key")
+	}
+	if uuid, err := newUuid(); err != nil || len(uuid) != 36 || uuid[14] != '4' {
+		t.Errorf("Invalid uuid: %s, %v", uuid, err)
+	}
+	cleanup()
+}
+
+func TestMigrationPaths(t *testing.T) {
+	// Separators and glob metacharacters in the directory.
+	dir := filepath.Join(t.TempDir(), "migrations [2024]")
+	os.MkdirAll(filepath.Join(dir, "down"), 0755)
+	os.WriteFile(filepath.Join(dir, "1_add_users_up.sql"), []byte("CREATE TABLE users (id INT)"), 0644)
+	os.WriteFile(filepath.Join(dir, "down", "1_add_users.sql"), []byte("DROP TABLE users"), 0644)
+	source := FileMigrationSource{Dir: dir + string(filepath.Separator), SplitDirs: true}
+	migrations, err := source.FindMigrations(log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migration := migrations[1]; migration == nil || migration.DownPath != filepath.Join(dir, "down", "1_add_users.sql") {
+		t.Errorf("Invalid migrations: %v", migrations)
+	}
+	if path := source.migrationPath(2, "add_roles", upMigration); path != filepath.Join(dir, "up", "2_add_roles.sql") {
+		t.Errorf("Invalid path: %s", path)
+	}
+	if migrations, err := (FileMigrationSource{Dir: filepath.Join(dir, "missing")}).FindMigrations(log.New(io.Discard, "", 0)); err != nil || len(migrations) != 0 {
+		t.Errorf("Invalid missing directory: %v, %v", migrations, err)
+	}
+
+	// Absolute paths parse with the separator of the platform.
+	for path, want := range map[string]string{
+		filepath.FromSlash(`/app/migrations/1_add_users_up.sql`):   "1 up add_users",
+		filepath.FromSlash(`/app/migrations/down/1_add_users.sql`): "1 down add_users",
+		filepath.FromSlash(`/srv/share/up/12_add_roles.sql`):       "12 up add_roles",
+		filepath.FromSlash(`/app/migrations/1_add_users.sql`):      "invalid",
+	} {
+		got := "invalid"
+		if id, mType, name, err := parseMigrationFile(path); err == nil {
+			got = fmt.Sprintf("%d %s %s", id, mType, name)
+		}
+		if got != want {
+			t.Errorf("Invalid parse of %s: %s", path, got)
+		}
+	}
+}
+
+func TestParseMigrationFile(t *testing.T) {
+	for path, want := range map[string]string{
+		"01_add_users_up.sql":           "1 up add_users",
+		"2_add__user_roles_down.SQL":    "2 down add__user_roles",
+		"3_Seed_Data_UP.Sql.TMPL":       "3 up Seed_Data",
+		"4_ajouter_les_données_up.sql":  "4 up ajouter_les_données",
+		"5_ユーザー追加_down.sql.enc":         "5 down ユーザー追加",
+		"up/6_add-tags.sql":             "6 up add-tags",
+		"add_users_up.sql":              "name doesn't start with an id",
+		"7add_users_up.sql":             "id isn't followed by an underscore",
+		"8_add users_up.sql":            "name is empty or holds characters other than letters, digits, underscores and dashes",
+		"9__up.sql":                     "name is empty or holds characters other than letters, digits, underscores and dashes",
+		"10_add_users.sql":              "name doesn't end with _up or _down",
+		"11_add_users_up.sql.bak":       "not a .sql file",
+		"99999999999999999999_x_up.sql": "id is out of range",
+	} {
+		var got string
+		id, mType, name, err := parseMigrationFile(path)
+		if fileErr := (*MigrationFileError)(nil); errors.As(err, &fileErr) {
+			got = fileErr.Reason
+		} else {
+			got = fmt.Sprintf("%d %s %s", id, mType, name)
+		}
+		if got != want {
+			t.Errorf("Invalid parse of %s: %s", path, got)
+		}
+		if err != nil && !errors.Is(err, InvalidMigrationFile) {
+			t.Errorf("Expected InvalidMigrationFile, got %v", err)
+		}
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(dir+"/1_add_users_up.sql", []byte("SELECT 1"), 0644)
+	os.WriteFile(dir+"/1_add_users_down.sql", []byte("SELECT 1"), 0644)
+	os.WriteFile(dir+"/2_add_roles.sql", []byte("SELECT 1"), 0644)
+	os.WriteFile(dir+"/README.md", []byte("Migrations"), 0644)
+	source := FileMigrationSource{Dir: dir}
+	if migrations, err := source.FindMigrations(log.New(io.Discard, "", 0)); err != nil || len(migrations) != 1 {
+		t.Errorf("Invalid migrations: %v, %v", migrations, err)
+	}
+	source.StrictNames = true
+	var fileErr *MigrationFileError
+	if _, err := source.FindMigrations(log.New(io.Discard, "", 0)); !errors.As(err, &fileErr) || fileErr.Path != dir+"/2_add_roles.sql" {
+		t.Errorf("Expected a migration file error, got %v", err)
+	}
+}
+
+func TestFilenamePattern(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/V1__add_users.sql", []byte("CREATE TABLE users (id INT)"), 0644)
+	os.WriteFile(dir+"/U1__add_users.sql", []byte("DROP TABLE users"), 0644)
+	os.WriteFile(dir+"/1_ignored_up.sql", []byte("SELECT 1"), 0644)
+	source := &FileMigrationSource{Dir: dir, Pattern: FlywayPattern}
+	m, err := NewMigratorWithLogger(db, adapter, source, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := m.Migrations(-1)
+	if len(all) != 1 || all[0].Name != "add_users" || all[0].DownPath != dir+"/U1__add_users.sql" {
+		t.Fatalf("Invalid migrations: %v", all)
+	}
+	migration, err := m.CreateMigration("add_roles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migration.UpPath != dir+"/V2__add_roles.sql" || migration.DownPath != dir+"/U2__add_roles.sql" {
+		t.Errorf("Invalid new migration: %+v", migration)
+	}
+
+	for file, want := range map[string]string{
+		"0012_add_users.up.sql":       "12 up add_users",
+		"0012_add_users.down.sql.enc": "12 down add_users",
+		"12_add_users_up.sql":         "invalid",
+	} {
+		got := "invalid"
+		if id, mType, name, err := DottedPattern.parse(file); err == nil {
+			got = fmt.Sprintf("%d %s %s", id, mType, name)
+		}
+		if got != want {
+			t.Errorf("Invalid parse of %s: %s", file, got)
+		}
+	}
+	cleanup()
+}
+
+func TestRecursive(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(dir+"/2024/01", 0755)
+	os.MkdirAll(dir+"/2024/02/up", 0755)
+	os.MkdirAll(dir+"/2024/02/down", 0755)
+	os.MkdirAll(dir+"/archive/2023", 0755)
+	os.WriteFile(dir+"/2024/01/2_add_users_up.sql", []byte("CREATE TABLE users (id INT)"), 0644)
+	os.WriteFile(dir+"/2024/01/2_add_users_down.sql", []byte("DROP TABLE users"), 0644)
+	os.WriteFile(dir+"/2024/02/up/3_add_roles.sql", []byte("CREATE TABLE roles (id INT)"), 0644)
+	os.WriteFile(dir+"/2024/02/down/3_add_roles.sql", []byte("DROP TABLE roles"), 0644)
+	os.WriteFile(dir+"/archive/2023/1_init_up.sql", []byte("SELECT 1"), 0644)
+
+	source := &FileMigrationSource{Dir: dir, Recursive: true, GroupLayout: "2006/01"}
+	m, err := NewMigratorWithLogger(db, adapter, source, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := m.Migrations(-1)
+	if len(all) != 3 || !all[0].Archived || all[2].DownPath != dir+"/2024/02/down/3_add_roles.sql" {
+		t.Fatalf("Invalid migrations: %v", all)
+	}
+	m.Clock = fixedClock(time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC))
+	migration, err := m.CreateMigration("add_groups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migration.Id != 4 || migration.UpPath != dir+"/2024/03/4_add_groups_up.sql" {
+		t.Errorf("Invalid new migration: %+v", migration)
+	}
+
+	// Without Recursive, only the top level is read.
+	if migrations, err := (FileMigrationSource{Dir: dir}).FindMigrations(log.New(io.Discard, "", 0)); err != nil || len(migrations) != 0 {
+		t.Errorf("Invalid flat migrations: %v, %v", migrations, err)
+	}
+	cleanup()
+}
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(dir+"/shared", 0755)
+	for path, sql := range map[string]string{
+		dir + "/1_add_notes_up.sql":   "-- gomigrate: include shared/notes.sql\nINSERT INTO notes VALUES (1);",
+		dir + "/1_add_notes_down.sql": "-- gomigrate: include shared/missing.sql",
+		dir + "/shared/notes.sql":     "-- gomigrate: include shared/columns.sql\nCREATE TABLE notes (id INT);",
+		dir + "/shared/columns.sql":   "-- columns",
+		dir + "/2_add_cycle_up.sql":   "-- gomigrate: include shared/cycle.sql",
+		dir + "/2_add_cycle_down.sql": "SELECT 1;",
+		dir + "/shared/cycle.sql":     "-- gomigrate: include 2_add_cycle_up.sql",
+	} {
+		os.WriteFile(path, []byte(sql), 0644)
+	}
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql, err := m.migrationSql(dir + "/1_add_notes_up.sql")
+	if err != nil || string(sql) != "-- columns\nCREATE TABLE notes (id INT);\nINSERT INTO notes VALUES (1);" {
+		t.Errorf("Invalid expanded migration: %q, %v", sql, err)
+	}
+	if _, err := m.migrationSql(dir + "/1_add_notes_down.sql"); err != InvalidInclude {
+		t.Errorf("Expected an invalid include error for a missing file, got: %v", err)
+	}
+	if _, err := m.migrationSql(dir + "/2_add_cycle_up.sql"); err != InvalidInclude {
+		t.Errorf("Expected an invalid include error for a cycle, got: %v", err)
+	}
+}
+
+func TestDescription(t *testing.T) {
+	for sql, description := range map[string]string{
+		"-- Adds the users table,\n--   with emails.\n-- gomigrate: timeout=1m\nCREATE TABLE users (id INT);": "Adds the users table, with emails.",
+		"\n/*\n * Backfills\n * roles.\n */\nUPDATE users SET role = 'admin';":                                "Backfills roles.",
+		"-- Seeds notes.\n\n-- Not part of the description.\nSELECT 1;":                                       "Seeds notes.",
+		"SELECT 1; -- trailing": "",
+	} {
+		if parsed := parseDescription(sql); parsed != description {
+			t.Errorf("Invalid description of %q: %q", sql, parsed)
+		}
+	}
+
+	db.Exec("CREATE TABLE description_test (id INT)")
+	defer db.Exec("DROP TABLE description_test")
+	dir := t.TempDir()
+	os.WriteFile(dir+"/1_seed_up.sql", []byte("-- Seeds the first row.\n-- gomigrate: author=jane ticket=PROJ-123\nINSERT INTO description_test VALUES (1)"), 0644)
+	os.WriteFile(dir+"/1_seed_down.sql", []byte("DELETE FROM description_test"), 0644)
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(dir+"/1_seed_up.sql", []byte("-- Changed after applying.\nINSERT INTO description_test VALUES (1)"), 0644)
+	if err := m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if description := m.migrations[1].Description; description != "Seeds the first row." {
+		t.Errorf("Invalid recorded description: %q", description)
+	}
+	history, err := m.History()
+	if err != nil || len(history) != 1 || history[0].Description != "Seeds the first row." {
+		t.Fatalf("Invalid history: %v, %v", history, err)
+	}
+	if history[0].Author != "jane" || history[0].Ticket != "PROJ-123" {
+		t.Errorf("Invalid recorded metadata: %+v", history[0])
+	}
+	cleanup()
+}
+
+func TestApprovals(t *testing.T) {
+	db.Exec("CREATE TABLE approval_test (id INT)")
+	defer db.Exec("DROP TABLE approval_test")
+	dir := t.TempDir()
+	os.WriteFile(dir+"/1_seed_up.sql", []byte("-- gomigrate: author=jane\nINSERT INTO approval_test VALUES (1)"), 0644)
+	os.WriteFile(dir+"/1_seed_down.sql", []byte("DELETE FROM approval_test"), 0644)
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Protected = true
+	if err := m.Migrate(); err != UnapprovedMigration {
+		t.Fatalf("Expected an unapproved migration error, got: %v", err)
+	}
+
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	m.Approvals = &Approvals{Keys: map[string]ed25519.PublicKey{"sam": key.Public().(ed25519.PublicKey)}}
+	for _, approver := range []string{"jane", "sam"} {
+		approval, err := m.Approve(1, approver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Approvals.Approvals = append(m.Approvals.Approvals, approval)
+	}
+	// Neither the author's approval nor an unsigned one count.
+	if err := m.ApplyMigration(m.migrations[1], upMigration); err != UnapprovedMigration {
+		t.Fatalf("Expected an unapproved migration error, got: %v", err)
+	}
+	m.Approvals.Approvals[1].Sign(key)
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Migrations(Active)) != 1 {
+		t.Errorf("Expected the approved migration to be applied")
+	}
+	cleanup()
+
+	// Editing the file voids the approval.
+	os.WriteFile(dir+"/1_seed_up.sql", []byte("INSERT INTO approval_test VALUES (2)"), 0644)
+	if err := m.checkApproval(m.migrations[1]); err != UnapprovedMigration {
+		t.Errorf("Expected an edited migration to be unapproved, got: %v", err)
+	}
+}
+
+func TestSignatures(t *testing.T) {
+	db.Exec("CREATE TABLE signature_test (id INT)")
+	defer db.Exec("DROP TABLE signature_test")
+	dir := t.TempDir()
+	os.MkdirAll(dir+"/shared", 0755)
+	paths := []string{dir + "/1_seed_up.sql", dir + "/1_seed_down.sql", dir + "/shared/rows.sql"}
+	os.WriteFile(paths[0], []byte("-- gomigrate: include shared/rows.sql"), 0644)
+	os.WriteFile(paths[1], []byte("DELETE FROM signature_test"), 0644)
+	os.WriteFile(paths[2], []byte("INSERT INTO signature_test VALUES (1)"), 0644)
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	if err := SignFiles(key, paths); err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Signatures = Ed25519Signatures{Keys: []ed25519.PublicKey{key.Public().(ed25519.PublicKey)}}
+
+	// A tampered included file is refused before anything runs.
+	os.WriteFile(paths[2], []byte("DROP TABLE signature_test"), 0644)
+	if err := m.Migrate(); err != UnsignedMigration {
+		t.Fatalf("Expected an unsigned migration error, got: %v", err)
+	}
+	os.WriteFile(paths[2], []byte("INSERT INTO signature_test VALUES (1)"), 0644)
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// With a manifest, files must be listed with their checksum.
+	manifest := dir + "/SHA256SUMS"
+	if err := SignManifest(key, manifest, paths[1:]); err != nil {
+		t.Fatal(err)
+	}
+	m.Signatures = Ed25519Signatures{Keys: []ed25519.PublicKey{key.Public().(ed25519.PublicKey)}, Manifest: manifest}
+	if err := m.verifySignature(paths[1]); err != nil {
+		t.Errorf("Expected a file of the manifest to be signed, got: %v", err)
+	}
+	if err := m.verifySignature(paths[0]); err != UnsignedMigration {
+		t.Errorf("Expected a file missing from the manifest to be unsigned, got: %v", err)
+	}
+	cleanup()
+}
+
+func TestServerRequirements(t *testing.T) {
+	db.Exec("CREATE TABLE requires_test (id INT)")
+	defer db.Exec("DROP TABLE requires_test")
+	// Requirements name the driver of the adapter under test.
+	registryMu.Lock()
+	registry["requires-test"] = func() Adapter { return adapter.(Adapter) }
+	registryMu.Unlock()
+	defer func() {
+		registryMu.Lock()
+		delete(registry, "requires-test")
+		registryMu.Unlock()
+	}()
+	dir := t.TempDir()
+	os.WriteFile(dir+"/1_seed_up.sql", []byte("-- gomigrate: requires requires-test>=999, unknown>=1\nINSERT INTO requires_test VALUES (1)"), 0644)
+	os.WriteFile(dir+"/1_seed_down.sql", []byte("DELETE FROM requires_test"), 0644)
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requires := fmt.Sprint(m.migrations[1].Requires); requires != "[requires-test>=999 unknown>=1]" {
+		t.Errorf("Invalid requirements: %s", requires)
+	}
+	if err := m.Migrate(); err != ServerTooOld {
+		t.Fatalf("Expected a server too old error, got: %v", err)
+	}
+	if err := m.ApplyMigration(m.migrations[1], upMigration); err != ServerTooOld {
+		t.Errorf("Expected a server too old error, got: %v", err)
+	}
+
+	m.migrations[1].Requires = []ServerRequirement{{"requires-test", "1"}, {"unknown", "1"}}
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseRequirements([]string{"postgres=14"}); err == nil {
+		t.Error("Expected an invalid requirement error")
+	}
+	cleanup()
+}
+
+// Checks and creates extensions listed in the extension_test table.
+type extensionAdapter struct {
+	Migratable
+}
+
+func (e extensionAdapter) ExtensionSql() string {
+	if dbType == "pg" {
+		return "SELECT COUNT(*) FROM extension_test WHERE name = $1"
+	}
+	return "SELECT COUNT(*) FROM extension_test WHERE name = ?"
+}
+
+func (e extensionAdapter) CreateExtensionSql(name string) string {
+	return "INSERT INTO extension_test VALUES (" + quoteLiteral(name) + ")"
+}
+
+func TestRequiredExtensions(t *testing.T) {
+	db.Exec("CREATE TABLE extension_test (name VARCHAR(255))")
+	defer db.Exec("DROP TABLE extension_test")
+	dir := t.TempDir()
+	os.WriteFile(dir+"/1_seed_up.sql", []byte("-- gomigrate: requires-extension postgis, pgcrypto\nSELECT 1"), 0644)
+	os.WriteFile(dir+"/1_seed_down.sql", []byte("SELECT 1"), 0644)
+	m, err := NewMigratorWithLogger(db, extensionAdapter{adapter}, &FileMigrationSource{Dir: dir}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if extensions := fmt.Sprint(m.migrations[1].Extensions); extensions != "[postgis pgcrypto]" {
+		t.Errorf("Invalid extensions: %s", extensions)
+	}
+
+	db.Exec("INSERT INTO extension_test VALUES ('pgcrypto')")
+	if err := m.Migrate(); err != MissingExtension {
+		t.Fatalf("Expected a missing extension error, got: %v", err)
+	}
+	if _, err := m.Plan(upMigration, 0); err != MissingExtension {
+		t.Errorf("Expected a missing extension error, got: %v", err)
+	}
+
+	m.CreateExtensions = true
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM extension_test").Scan(&count)
+	if count != 2 {
+		t.Errorf("Expected postgis to be created, found %d extensions", count)
+	}
+	cleanup()
+}
+
+// Reads the privileges of the user from the privilege_test table.
+type privilegeAdapter struct {
+	Migratable
+}
+
+func (p privilegeAdapter) CreatePrivilegeSql() string {
+	return "SELECT COUNT(*) > 0 FROM privilege_test WHERE name = 'schema'"
+}
+
+func (p privilegeAdapter) TableOwnerSql() string {
+	if dbType == "pg" {
+		return "SELECT owned FROM privilege_test WHERE name = $1"
+	}
+	return "SELECT owned FROM privilege_test WHERE name = ?"
+}
+
+func TestCheckPrivileges(t *testing.T) {
+	for statement, expected := range map[string]string{
+		"ALTER TABLE IF EXISTS ONLY public.orders ADD COLUMN x INT":   "public.orders",
+		"-- Old orders\nDROP TABLE orders":                            "orders",
+		"CREATE UNIQUE INDEX CONCURRENTLY o_x ON ONLY \"Orders\" (x)": `"Orders"`,
+		"CREATE TABLE orders (id INT)":                                "",
+		"INSERT INTO orders VALUES (1)":                               "",
+	} {
+		if table := ownedTable(statement); table != expected {
+			t.Errorf("Expected %q to need table %q, got %q", statement, expected, table)
+		}
+	}
+
+	db.Exec("CREATE TABLE privilege_test (name VARCHAR(255), owned INT)")
+	defer db.Exec("DROP TABLE privilege_test")
+	dir := t.TempDir()
+	os.WriteFile(dir+"/1_alter_up.sql", []byte("ALTER TABLE theirs ADD COLUMN x INT; ALTER TABLE mine ADD COLUMN x INT; ALTER TABLE created ADD COLUMN x INT"), 0644)
+	os.WriteFile(dir+"/1_alter_down.sql", []byte("SELECT 1"), 0644)
+	m, err := NewMigratorWithLogger(db, privilegeAdapter{adapter}, &FileMigrationSource{Dir: dir}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Exec("INSERT INTO privilege_test VALUES ('theirs', 0), ('mine', 1)")
+	m.RequirePrivileges = true
+	if err := m.Migrate(); err != MissingPrivileges {
+		t.Fatalf("Expected a missing privileges error, got: %v", err)
+	}
+	missing, err := m.CheckPrivileges()
+	if err != MissingPrivileges || len(missing) != 2 || missing[0].Privilege != "CREATE" || missing[1].Table != "theirs" {
+		t.Errorf("Invalid missing privileges: %v, %v", missing, err)
+	}
+
+	db.Exec("INSERT INTO privilege_test VALUES ('schema', 1)")
+	db.Exec("UPDATE privilege_test SET owned = 1")
+	if missing, err := m.CheckPrivileges(); err != nil || len(missing) != 0 {
+		t.Errorf("Expected no missing privileges, got: %v, %v", missing, err)
+	}
+	if _, err := (&Migrator{dbAdapter: Sqlite3{}}).newRun().checkPrivileges(nil); err != PrivilegesUnsupported {
+		t.Errorf("Expected privileges to be unsupported, got: %v", err)
+	}
+	cleanup()
+}
+
+// Explains every statement with a fixed Postgres plan. Scripts are
+// split on every database, as Sqlite3 runs them whole.
+type explainAdapter struct {
+	Migratable
+}
+
+func (e explainAdapter) GetMigrationCommands(sql string) []string {
+	return splitStatements(sql)
+}
+
+func (e explainAdapter) ExplainSql(statement string) string {
+	if strings.Contains(statement, "missing") {
+		return "SELECT * FROM missing_table"
+	}
+	return `SELECT '[{"Plan": {"Node Type": "ModifyTable", "Total Cost": 35.5, "Plan Rows": 1200, "Plans": [{"Node Type": "Seq Scan", "Relation Name": "orders"}]}}]'`
+}
+
+func (e explainAdapter) ParseExplain(output string) (*Estimate, error) {
+	return parsePostgresExplain(output)
+}
+
+func TestExplain(t *testing.T) {
+	estimate, err := parseMysqlExplain(`{"query_block": {"cost_info": {"query_cost": "12.50"},
+		"table": {"table_name": "orders", "access_type": "ALL", "rows_examined_per_scan": 300}}}`)
+	if err != nil || estimate.String() != "cost 12.50, 300 rows, full scan of orders" {
+		t.Errorf("Invalid MySQL estimate: %v, %v", estimate, err)
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(dir+"/1_backfill_up.sql", []byte("CREATE TABLE explain_test (id INT);\n-- Backfill\nUPDATE orders SET total = 0;\nDELETE FROM missing"), 0644)
+	os.WriteFile(dir+"/1_backfill_down.sql", []byte("DROP TABLE explain_test"), 0644)
+	m, err := NewMigratorWithLogger(db, explainAdapter{adapter}, &FileMigrationSource{Dir: dir}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := m.Plan(upMigration, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Explain(plan); err != nil {
+		t.Fatal(err)
+	}
+	estimates := plan.Migrations[0].Estimates
+	if len(estimates) != 2 || estimates[0].Rows != 1200 || estimates[0].FullScans[0] != "orders" || estimates[1].Error == "" {
+		t.Fatalf("Invalid estimates: %v", estimates)
+	}
+	var output bytes.Buffer
+	plan.Print(&output)
+	if !strings.Contains(output.String(), "UPDATE orders SET total = 0: cost 35.50, 1200 rows, full scan of orders") {
+		t.Errorf("Estimates not printed: %s", output.String())
+	}
+	if err := (&Migrator{dbAdapter: Sqlite3{}}).Explain(plan); err != ExplainUnsupported {
+		t.Errorf("Expected explaining to be unsupported, got: %v", err)
+	}
+}
+
+// Analyzes locks as Postgres does on any database, splitting scripts
+// as Sqlite3 doesn't.
+type lockAdapter struct {
+	Migratable
+}
+
+func (l lockAdapter) GetMigrationCommands(sql string) []string {
+	return splitStatements(sql)
+}
+
+func (l lockAdapter) AnalyzeLocks(statement string) *LockImpact {
+	return postgresLockImpact(statement)
+}
+
+func TestLockImpact(t *testing.T) {
+	for statement, expected := range map[string]string{
+		"ALTER TABLE orders ALTER COLUMN total TYPE bigint":                               "ACCESS EXCLUSIVE lock on orders, blocks reads and writes while rewriting the table",
+		"ALTER TABLE orders ADD COLUMN id uuid DEFAULT gen_random_uuid()":                 "ACCESS EXCLUSIVE lock on orders, blocks reads and writes while rewriting the table",
+		"ALTER TABLE orders ADD COLUMN paid boolean DEFAULT false":                        "ACCESS EXCLUSIVE lock on orders, blocks reads and writes",
+		"ALTER TABLE orders ALTER COLUMN total SET NOT NULL":                              "ACCESS EXCLUSIVE lock on orders, blocks reads and writes while scanning the table",
+		"ALTER TABLE orders ADD CONSTRAINT fk FOREIGN KEY (u) REFERENCES users NOT VALID": "SHARE ROW EXCLUSIVE lock on orders, blocks writes",
+		"ALTER TABLE orders VALIDATE CONSTRAINT fk":                                       "SHARE UPDATE EXCLUSIVE lock on orders, doesn't block reads or writes",
+		"CREATE INDEX orders_total ON orders (total)":                                     "SHARE lock on orders, blocks writes while scanning the table",
+		"CREATE INDEX CONCURRENTLY orders_total ON orders (total)":                        "SHARE UPDATE EXCLUSIVE lock on orders, doesn't block reads or writes",
+		"-- Reclaim space\nVACUUM FULL orders":                                            "ACCESS EXCLUSIVE lock on orders, blocks reads and writes while rewriting the table",
+		"UPDATE orders SET total = 0":                                                     "<nil>",
+		"CREATE TABLE orders (id INT)":                                                    "<nil>",
+	} {
+		if impact := fmt.Sprint(postgresLockImpact(statement)); impact != expected {
+			t.Errorf("Expected %q to take %q, got %q", statement, expected, impact)
+		}
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(dir+"/1_index_up.sql", []byte("CREATE TABLE lock_test (id INT);\nCREATE INDEX lock_test_id ON lock_test (id)"), 0644)
+	os.WriteFile(dir+"/1_index_down.sql", []byte("DROP TABLE lock_test"), 0644)
+	m, err := NewMigratorWithLogger(db, lockAdapter{adapter}, &FileMigrationSource{Dir: dir}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := m.Plan(upMigration, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locks := plan.Migrations[0].Locks
+	if len(locks) != 1 || locks[0].Mode != "SHARE" || !locks[0].Long() {
+		t.Fatalf("Invalid locks: %v", locks)
+	}
+	var output bytes.Buffer
+	plan.Print(&output)
+	if !strings.Contains(output.String(), "CREATE INDEX lock_test_id ON lock_test (id): SHARE lock on lock_test, blocks writes") {
+		t.Errorf("Locks not printed: %s", output.String())
+	}
+}
+
+// Reads the validity of indexes from the index_test table.
+type indexAdapter struct {
+	Migratable
+}
+
+func (i indexAdapter) IndexValidSql() string {
+	if dbType == "pg" {
+		return "SELECT valid = 1 FROM index_test WHERE name = $1"
+	}
+	return "SELECT valid = 1 FROM index_test WHERE name = ?"
+}
+
+func (i indexAdapter) DropIndexSql(name string) string {
+	return "DROP INDEX " + name
+}
+
+func (i indexAdapter) IndexProgressSql() string {
+	return "SELECT 'building', 1, 2"
+}
+
+// Records the statements executed instead of running them.
+type recordingTx struct {
+	statements []string
+}
+
+func (r *recordingTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	r.statements = append(r.statements, query)
+	return nil, nil
+}
+
+func (r *recordingTx) Commit() error   { return nil }
+func (r *recordingTx) Rollback() error { return nil }
+
+func TestOnlineIndex(t *testing.T) {
+	db.Exec("CREATE TABLE index_test (name VARCHAR(255), valid INT)")
+	defer db.Exec("DROP TABLE index_test")
+	dir := t.TempDir()
+	os.WriteFile(dir+"/1_index_up.sql", []byte("-- gomigrate: online-index\nCREATE INDEX orders_total ON orders (total)"), 0644)
+	os.WriteFile(dir+"/1_index_down.sql", []byte("DROP INDEX orders_total"), 0644)
+	m, err := NewMigratorWithLogger(db, indexAdapter{adapter}, &FileMigrationSource{Dir: dir}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	migration := m.migrations[1]
+	if !migration.OnlineIndex || !migration.NoTransaction {
+		t.Fatalf("Invalid migration: %+v", migration)
+	}
+
+	db.Exec("INSERT INTO index_test VALUES ('orders_total', 0)")
+	tx := &recordingTx{}
+	if _, err := m.newRun().execOnlineIndex(tx, migration, "CREATE INDEX orders_total ON orders (total)"); err != InvalidIndex {
+		t.Errorf("Expected an invalid index error, got: %v", err)
+	}
+	if statements := strings.Join(tx.statements, "; "); statements != "DROP INDEX orders_total; CREATE INDEX CONCURRENTLY orders_total ON orders (total)" {
+		t.Errorf("Invalid statements: %s", statements)
+	}
+
+	db.Exec("UPDATE index_test SET valid = 1")
+	tx = &recordingTx{}
+	if _, err := m.newRun().execOnlineIndex(tx, migration, "CREATE INDEX CONCURRENTLY orders_total ON orders (total)"); err != nil {
+		t.Error(err)
+	}
+	if len(tx.statements) != 1 {
+		t.Errorf("Expected the valid index to be kept, got: %v", tx.statements)
+	}
+	if _, err := (&Migrator{dbAdapter: Sqlite3{}}).newRun().execOnlineIndex(tx, migration, ""); err != IndexBuildUnsupported {
+		t.Errorf("Expected online indexes to be unsupported, got: %v", err)
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	if _, err := parseBackfill("table=orders chunk=0"); err == nil {
+		t.Error("Expected an invalid backfill error")
+	}
+	db.Exec("CREATE TABLE backfill_test (id INT, total INT)")
+	defer db.Exec("DROP TABLE backfill_test")
+	defer db.Exec("DROP TABLE gomigrate_backfill")
+	for id := 1; id <= 10; id++ {
+		db.Exec(fmt.Sprintf("INSERT INTO backfill_test (id) VALUES (%d)", id))
+	}
+	update := "UPDATE backfill_test SET total = id * 2 WHERE id > ? AND id <= ?"
+	if dbType == "pg" {
+		update = "UPDATE backfill_test SET total = id * 2 WHERE id > $1 AND id <= $2"
+	}
+	dir := t.TempDir()
+	os.WriteFile(dir+"/1_totals_up.sql", []byte("-- gomigrate: backfill table=backfill_test key=id chunk=3\n"+update), 0644)
+	os.WriteFile(dir+"/1_totals_down.sql", []byte("UPDATE backfill_test SET total = NULL"), 0644)
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backfill := m.migrations[1].Backfill; backfill == nil || backfill.ChunkSize != 3 || !m.migrations[1].NoTransaction {
+		t.Fatalf("Invalid backfill: %+v", backfill)
+	}
+
+	// Resume after the chunks committed by an interrupted run.
+	recorder := adapter.(BackfillRecorder)
+	db.Exec(recorder.CreateBackfillTableSql())
+	db.Exec(recorder.InsertBackfillSql(), 1, 6, 6, 0)
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	var updated, progress int
+	db.QueryRow("SELECT COUNT(*) FROM backfill_test WHERE total = id * 2").Scan(&updated)
+	db.QueryRow("SELECT COUNT(*) FROM gomigrate_backfill").Scan(&progress)
+	if updated != 4 || progress != 0 {
+		t.Errorf("Expected keys 7 to 10 to be backfilled, got %d rows and %d progress rows", updated, progress)
+	}
+	cleanup()
+}
+
+func TestMigrationDirs(t *testing.T) {
+	core, billing := t.TempDir(), t.TempDir()
+	for path, sql := range map[string]string{
+		core + "/1_add_users_up.sql":      "CREATE TABLE users (id INT)",
+		core + "/1_add_users_down.sql":    "DROP TABLE users",
+		core + "/2_add_roles_up.sql":      "CREATE TABLE roles (id INT)",
+		core + "/2_add_roles_down.sql":    "DROP TABLE roles",
+		billing + "/1_add_plans_up.sql":   "CREATE TABLE plans (id INT)",
+		billing + "/1_add_plans_down.sql": "DROP TABLE plans",
+		billing + "/3_add_bills_up.sql":   "CREATE TABLE bills (id INT)",
+		billing + "/3_add_bills_down.sql": "DROP TABLE bills",
+	} {
+		os.WriteFile(path, []byte(sql), 0644)
+	}
+	source := &FileMigrationSource{
+		Dir:  core,
+		Dirs: []MigrationDir{{Namespace: "billing", Dir: billing, IdOffset: 1000}},
+	}
+	m, err := NewMigratorWithLogger(db, adapter, source, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0)
+	for _, migration := range m.Migrations(-1) {
+		ids = append(ids, fmt.Sprintf("%s:%d", migration.Namespace, migration.Id))
+	}
+	if strings.Join(ids, ",") != ":1,:2,billing:1001,billing:1003" {
+		t.Errorf("Invalid migrations: %v", ids)
+	}
+	if gaps := m.Status().Gaps; len(gaps) != 1 || gaps[0] != (IdGap{1001, 1003}) {
+		t.Errorf("Invalid gaps: %v", gaps)
+	}
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+
+	// Without an offset, the first migration of billing takes an id of
+	// the core directory.
+	source.Dirs[0].IdOffset = 0
+	_, err = source.FindMigrations(log.New(io.Discard, "", 0))
+	var conflict *NamespaceConflict
+	if !errors.As(err, &conflict) || conflict.Id != 1 || conflict.Namespaces[1] != "billing" || len(conflict.Paths) != 2 {
+		t.Errorf("Expected a namespace conflict, got %v", err)
+	}
+	cleanup()
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/" + ConfigFileName
+	os.WriteFile(path, []byte(`environments:
+  staging:
+    driver: postgres
+    dsn_env: GOMIGRATE_TEST_DSN
+    dir: migrations
+    require_writable: true
+    out_of_order: fail
+  local:
+    dsn: "dbname=app"
+    dir: /srv/migrations
+`), 0644)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	staging, err := config.Environment("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if staging.Name != "staging" || staging.Driver != "postgres" || !staging.RequireWritable || staging.OutOfOrderPolicy != "fail" {
+		t.Errorf("Invalid environment: %+v", staging)
+	}
+	if staging.Dir != dir+"/migrations" {
+		t.Errorf("Invalid directory: %s", staging.Dir)
+	}
+	if _, err := staging.DataSourceName(); err != InvalidConfig {
+		t.Errorf("Expected InvalidConfig for an unset variable, got %v", err)
+	}
+	t.Setenv("GOMIGRATE_TEST_DSN", "dbname=staging")
+	if dsn, err := staging.DataSourceName(); err != nil || dsn != "dbname=staging" {
+		t.Errorf("Invalid data source name: %s, %v", dsn, err)
+	}
+
+	local, err := config.Environment("local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dsn, _ := local.DataSourceName(); dsn != "dbname=app" || local.Dir != "/srv/migrations" {
+		t.Errorf("Invalid environment: %+v", local)
+	}
+	if _, err := config.Environment("production"); err != UnknownEnvironment {
+		t.Errorf("Expected UnknownEnvironment, got %v", err)
+	}
+
+	os.WriteFile(path, []byte("environments: [staging"), 0644)
+	if _, err := LoadConfig(path); err != InvalidConfig {
+		t.Errorf("Expected InvalidConfig, got %v", err)
+	}
+}
+
+func TestSplitDirs(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(dir+"/up", 0755)
+	os.Mkdir(dir+"/down", 0755)
+	os.WriteFile(dir+"/up/1_split.sql", []byte("CREATE TABLE split_first (id INT)"), 0644)
+	os.WriteFile(dir+"/down/1_split.sql", []byte("DROP TABLE split_first"), 0644)
+	source := &FileMigrationSource{Dir: dir, SplitDirs: true}
+	m, err := NewMigratorWithLogger(db, adapter, source, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	migration, err := m.CreateMigration("created")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migration.UpPath != dir+"/up/2_created.sql" || migration.DownPath != dir+"/down/2_created.sql" {
+		t.Errorf("Invalid paths: %s, %s", migration.UpPath, migration.DownPath)
+	}
+	os.WriteFile(migration.UpPath, []byte("CREATE TABLE split_second (id INT)"), 0644)
+	os.WriteFile(migration.DownPath, []byte("DROP TABLE split_second"), 0644)
+
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Applied()) != 2 {
+		t.Errorf("Invalid applied migrations: %v", m.Applied())
+	}
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+
+	// A down file without its up file is an invalid pair.
+	os.Remove(dir + "/up/1_split.sql")
+	if _, err := NewMigratorWithLogger(db, adapter, source, log.New(io.Discard, "", 0)); err != InvalidMigrationPair {
+		t.Errorf("Expected InvalidMigrationPair, got %v", err)
+	}
+	cleanup()
+}
+
+func TestIrreversibleDirective(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/1_first_up.sql", []byte("CREATE TABLE guarded_first (id INT)"), 0644)
+	os.WriteFile(dir+"/1_first_down.sql", []byte("DROP TABLE guarded_first"), 0644)
+	os.WriteFile(dir+"/2_backfill_up.sql", []byte("-- gomigrate: irreversible\nCREATE TABLE guarded_backfill (id INT)"), 0644)
+	os.WriteFile(dir+"/2_backfill_down.sql", []byte("DROP TABLE guarded_backfill"), 0644)
+	os.WriteFile(dir+"/3_third_up.sql", []byte("CREATE TABLE guarded_third (id INT)"), 0644)
+	os.WriteFile(dir+"/3_third_down.sql", []byte("DROP TABLE guarded_third"), 0644)
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rollbacks stop at the irreversible migration.
+	if err := m.RollbackAll(); err != IrreversibleMigration {
+		t.Errorf("Expected IrreversibleMigration, got %v", err)
+	}
+	if applied := m.Applied(); len(applied) != 2 || applied[1].Id != 2 {
+		t.Errorf("Invalid applied migrations: %v", applied)
+	}
+	if plan, err := m.PlanRollback(2); err != nil || plan.Runnable() {
+		t.Errorf("Irreversible migration not flagged: %+v, %v", plan, err)
+	}
+
+	m.ForceRollback = true
+	if err := m.RollbackAll(); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Applied()) != 0 {
+		t.Errorf("Migrations left applied: %d", len(m.Applied()))
+	}
+	cleanup()
+}
+
+func TestRollbackPlan(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/1_first_up.sql", []byte("CREATE TABLE plan_first (id INT)"), 0644)
+	os.WriteFile(dir+"/1_first_down.sql", []byte("DROP TABLE plan_first"), 0644)
+	os.WriteFile(dir+"/2_kept_up.sql", []byte("CREATE TABLE plan_kept (id INT)"), 0644)
+	os.WriteFile(dir+"/3_third_up.sql", []byte("CREATE TABLE plan_third (id INT)"), 0644)
+	os.WriteFile(dir+"/3_third_down.sql", []byte("DROP TABLE plan_third"), 0644)
+	source := &FileMigrationSource{Dir: dir, AllowMissingDown: true}
+	m, err := NewMigratorWithLogger(db, adapter, source, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(dir + "/3_third_down.sql")
+
+	plan, err := m.PlanRollback(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Migrations) != 3 || plan.Target != 0 || plan.Runnable() {
+		t.Fatalf("Invalid plan: %+v", plan)
+	}
+	third, kept, first := plan.Migrations[0], plan.Migrations[1], plan.Migrations[2]
+	if !third.Missing || !kept.Irreversible || first.Missing || first.Irreversible || first.Path != dir+"/1_first_down.sql" {
+		t.Errorf("Invalid planned migrations: %+v %+v %+v", third, kept, first)
+	}
+	if err := m.ExecutePlan(plan); err != IrreversibleMigration {
+		t.Errorf("Expected IrreversibleMigration, got %v", err)
+	}
+	if len(m.Applied()) != 3 {
+		t.Errorf("Migrations rolled back: %d", 3-len(m.Applied()))
+	}
+
+	if plan, err := m.PlanRollback(1); err != nil || len(plan.Migrations) != 1 || plan.Target != 2 {
+		t.Errorf("Invalid plan: %+v, %v", plan, err)
+	}
+	for _, table := range []string{"plan_first", "plan_kept", "plan_third"} {
+		db.Exec("DROP TABLE " + table)
+	}
+	cleanup()
+}
+
+func TestPreflight(t *testing.T) {
+	m := GetMigrator("test1")
+	m.MinServerVersion = "1.0"
+	report, err := m.Preflight()
+	if err != nil || !report.Connected || !report.CanCreateTable || report.ServerVersion == "" {
+		t.Fatalf("Invalid report: %+v, %v", report, err)
+	}
+	var table string
+	if err := db.QueryRow(adapter.SelectMigrationTableSql(), "gomigrate_preflight").Scan(&table); err != sql.ErrNoRows {
+		t.Errorf("Preflight table left behind: %v", err)
+	}
+
+	m.MinServerVersion = "999"
+	m.RequiredExtensions = []string{"gomigrate_missing"}
+	report, err = m.Preflight()
+	if err != PreflightFailed || len(report.Problems) != 2 || len(report.MissingExtensions) != 1 {
+		t.Errorf("Invalid report: %+v, %v", report, err)
+	}
+
+	if compareVersions("8.0.33-log", "8.0.4") != 1 || compareVersions("15.3 (Debian 15.3-1)", "15.3") != 0 || compareVersions("3.9", "3.31") != -1 {
+		t.Errorf("Invalid version comparison")
+	}
+	cleanup()
+}
+
+func TestValidatePending(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/1_checked_up.sql", []byte("CREATE TABLE checked (id INT)"), 0644)
+	os.WriteFile(dir+"/1_checked_down.sql", []byte("DROP TABLE checked"), 0644)
+	os.WriteFile(dir+"/2_typo_up.sql", []byte("INSERT INTO checked VALUES (1,, 2)"), 0644)
+	os.WriteFile(dir+"/2_typo_down.sql", []byte("DELETE FROM checked"), 0644)
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.ValidateFirst = true
+
+	// The down file disappears after the migrations were loaded.
+	os.Remove(dir + "/1_checked_down.sql")
+	issues, err := m.ValidatePending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := 1
+	if _, ok := adapter.(StatementChecker); ok {
+		expected = 2
+	}
+	if len(issues) != expected || issues[0].Migration.Id != 1 {
+		t.Fatalf("Invalid issues: %v", issues)
+	}
+	if expected == 2 && (issues[1].Migration.Id != 2 || issues[1].Statement == "") {
+		t.Errorf("Syntax error not found: %v", issues[1])
+	}
+	if err := m.Migrate(); err != InvalidPending {
+		t.Errorf("Expected InvalidPending, got %v", err)
+	}
+	if len(m.Migrations(Active)) != 0 {
+		t.Errorf("Migrations applied despite issues")
+	}
+	cleanup()
+}
+
+func TestState(t *testing.T) {
+	m := GetMigrator("test1")
+	m.Clock = fixedClock(time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC))
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	var state bytes.Buffer
+	if err := m.ExportState(&state); err != nil {
+		t.Fatal(err)
+	}
+
+	// The database is restored without the migration records.
+	if _, err := db.Exec("DELETE FROM gomigrate"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := m.ImportState(bytes.NewReader(state.Bytes()))
+	if err != nil || imported != len(m.migrations) {
+		t.Fatalf("Invalid import: %d, %v", imported, err)
+	}
+	for _, migration := range m.Migrations(Active) {
+		if migration.Checksum == "" || !migration.AppliedAt.Equal(time.Time(m.Clock.(fixedClock))) {
+			t.Errorf("Migration not restored: %+v", migration)
+		}
+	}
+	if len(m.Migrations(Inactive)) != 0 {
+		t.Errorf("Migrations left inactive: %d", len(m.Migrations(Inactive)))
+	}
+	if imported, err := m.ImportState(bytes.NewReader(state.Bytes())); err != nil || imported != 0 {
+		t.Errorf("Invalid second import: %d, %v", imported, err)
+	}
+	if _, err := m.ImportState(strings.NewReader(`{"format_version": 2}`)); err != InvalidState {
+		t.Errorf("Expected InvalidState, got %v", err)
+	}
+
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+	cleanup()
+}
+
+func TestForeignMigrationTables(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/1_users_up.sql", []byte("CREATE TABLE foreign_users (id INT)"), 0644)
+	os.WriteFile(dir+"/1_users_down.sql", []byte("DROP TABLE foreign_users"), 0644)
+	os.WriteFile(dir+"/2_posts_up.sql", []byte("CREATE TABLE foreign_posts (id INT)"), 0644)
+	os.WriteFile(dir+"/2_posts_down.sql", []byte("DROP TABLE foreign_posts"), 0644)
+	logger := log.New(io.Discard, "", 0)
+
+	goose, err := NewMigratorWithLogger(db, GooseTable{Adapter: adapter}, &FileMigrationSource{Dir: dir}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := goose.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := goose.RollbackN(1); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM goose_db_version WHERE version_id = 1 AND is_applied").Scan(&count); err != nil || count != 1 {
+		t.Errorf("Invalid goose rows: %d, %v", count, err)
+	}
+	if version, err := goose.newRun().appliedVersion(); err != nil || version != 1 {
+		t.Errorf("Invalid goose version: %d, %v", version, err)
+	}
+	if err := goose.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+	db.Exec("drop table goose_db_version")
+
+	migrate, err := NewMigratorWithLogger(db, GolangMigrateTable{Adapter: adapter}, &FileMigrationSource{Dir: dir}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	var version uint64
+	if err := db.QueryRow("SELECT version FROM schema_migrations").Scan(&version); err != nil || version != 2 {
+		t.Errorf("Invalid golang-migrate version: %d, %v", version, err)
+	}
+	if err := migrate.RollbackN(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("SELECT version FROM schema_migrations").Scan(&version); err != nil || version != 1 {
+		t.Errorf("Invalid golang-migrate version: %d, %v", version, err)
+	}
+
+	// Dirty tables are refused.
+	db.Exec("UPDATE schema_migrations SET dirty = TRUE")
+	migrate, err = NewMigratorWithLogger(db, GolangMigrateTable{Adapter: adapter}, &FileMigrationSource{Dir: dir}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate.Migrate(); err != DirtyMigrationTable {
+		t.Errorf("Expected a dirty table, got: %v", err)
+	}
+	db.Exec("UPDATE schema_migrations SET dirty = FALSE")
+	if err := migrate.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+	db.Exec("drop table schema_migrations")
+}
+
+func TestCustomColumns(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/1_users_up.sql", []byte("-- gomigrate: ticket OPS-12\nCREATE TABLE column_users (id INT)"), 0644)
+	os.WriteFile(dir+"/1_users_down.sql", []byte("DROP TABLE column_users"), 0644)
+	logger := log.New(io.Discard, "", 0)
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := []CustomColumn{
+		{Name: "ticket", Value: func(migration *Migration) (string, error) {
+			return migration.Directives.Get("ticket"), nil
+		}},
+		{Name: "team", Value: func(migration *Migration) (string, error) {
+			return "payments", nil
+		}},
+	}
+	m.Columns = columns
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The values should be loaded by a new migrator.
+	m, err = NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Columns = columns
+	applied := m.Status().Applied
+	if len(applied) != 1 || applied[0].Columns["ticket"] != "OPS-12" || applied[0].Columns["team"] != "payments" {
+		t.Errorf("Invalid custom columns: %+v", applied)
+	}
+
+	m.Columns = append(m.Columns, CustomColumn{Name: "bad name"})
+	m.tableVersion = 0
+	if err := m.newRun().ensureMigrationTable(); err != InvalidColumn {
+		t.Errorf("Expected an invalid column, got: %v", err)
+	}
+	m.Columns = columns
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+	cleanup()
+}
+
+func TestAdminHandler(t *testing.T) {
+	m := GetMigrator("test1")
+	handler := &AdminHandler{Migrator: m}
+	request := func(method, path string) (int, map[string]json.RawMessage) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+		var body map[string]json.RawMessage
+		json.Unmarshal(recorder.Body.Bytes(), &body)
+		return recorder.Code, body
+	}
+
+	if code, _ := request("GET", "/migrations"); code != http.StatusForbidden {
+		t.Errorf("Expected 403 without an authorization hook, got: %d", code)
+	}
+	handler.Authorize = func(r *http.Request, action string) error {
+		if action == AdminRollback && r.Header.Get("X-Admin") == "" {
+			return errors.New("Admins only")
+		}
+		return nil
+	}
+
+	if code, _ := request("GET", "/migrate"); code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got: %d", code)
+	}
+	if code, body := request("POST", "/migrate"); code != http.StatusOK || string(body["Pending"]) != "[]" {
+		t.Errorf("Invalid migrate response: %d, %s", code, body)
+	}
+	if code, body := request("GET", "/migrations"); code != http.StatusOK || string(body["Applied"]) == "[]" {
+		t.Errorf("Invalid status response: %d, %s", code, body)
+	}
+	if code, _ := request("POST", "/rollback"); code != http.StatusForbidden {
+		t.Errorf("Expected 403, got: %d", code)
+	}
+	recorder := httptest.NewRecorder()
+	rollback := httptest.NewRequest("POST", "/rollback?n=1", nil)
+	rollback.Header.Set("X-Admin", "1")
+	handler.ServeHTTP(recorder, rollback)
+	if recorder.Code != http.StatusOK || len(m.Migrations(Active)) != 0 {
+		t.Errorf("Invalid rollback response: %d, %s", recorder.Code, recorder.Body)
+	}
+
+	cleanup()
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	events := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n Notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Error(err)
+		}
+		events = append(events, n.Event)
+	}))
+	defer server.Close()
+
+	m := GetMigrator("test1")
+	m.Notifier = &WebhookNotifier{URL: server.URL}
+	if err := m.Migrate(); err != nil {
+		t.Error(err)
+	}
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+
+	expected := "[started succeeded started succeeded]"
+	if fmt.Sprint(events) != expected {
+		t.Errorf("Invalid notifications, expected: %s, got: %v", expected, events)
+	}
+	cleanup()
+}
+
+func TestProgress(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/1_backfill_up.sql", []byte(`CREATE TABLE progress_test (id INT);
+INSERT INTO progress_test VALUES (1);
+INSERT INTO progress_test VALUES (2), (3), (4), (5), (6), (7), (8), (9)`), 0644)
+	os.WriteFile(dir+"/1_backfill_down.sql", []byte("DROP TABLE progress_test"), 0644)
+	m, err := NewMigratorWithLogger(db, adapter, &FileMigrationSource{Dir: dir}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Split the file into statements on every database.
+	m.SavepointPerStatement = true
+	executed := make([]StatementExecuted, 0)
+	m.Subscribe(func(e Event) {
+		if e, ok := e.(StatementExecuted); ok {
+			executed = append(executed, e)
+		}
+	})
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	var bytes int64
+	for _, e := range executed {
+		bytes += int64(len(e.Statement))
+		if e.Bytes != bytes {
+			t.Errorf("Invalid bytes after statement %d: %d", e.Index, e.Bytes)
+		}
+	}
+	last := executed[len(executed)-1]
+	if len(executed) != 3 || last.TotalBytes != bytes || last.Progress() != 1 || last.Remaining != 0 {
+		t.Errorf("Invalid progress: %+v", last)
+	}
+	if first := executed[0]; first.Progress() <= 0 || first.Progress() >= 0.5 {
+		t.Errorf("Invalid progress of the first statement: %v", first.Progress())
+	}
+	if remaining := estimateRemaining(10*time.Second, 25, 100); remaining != 30*time.Second {
+		t.Errorf("Invalid estimate: %v", remaining)
+	}
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+	cleanup()
+}
+
+func TestEvents(t *testing.T) {
+	m := GetMigrator("test1")
+	events := make([]string, 0)
+	statements := 0
+	m.Subscribe(func(e Event) {
+		events = append(events, fmt.Sprintf("%T", e))
+		if finished, ok := e.(MigrationFinished); ok {
+			statements += finished.Summary.Statements
+		}
+	})
+	if err := m.Migrate(); err != nil {
+		t.Error(err)
+	}
+
+	expected := "[gomigrate.MigrationStarted gomigrate.StatementExecuted gomigrate.MigrationFinished gomigrate.RunCompleted]"
+	if len(m.migrations) == 1 && fmt.Sprint(events) != expected {
+		t.Errorf("Invalid events, expected: %s, got: %v", expected, events)
+	}
+	if statements < len(m.migrations) {
+		t.Errorf("Invalid statement count in summaries: %d", statements)
+	}
+
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
 	cleanup()
 }
 
@@ -0,0 +1,103 @@
+// Reports whether the schema matches the migrations, for readiness
+// probes.
+
+package gomigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+)
+
+// Returned by Healthy when the schema is behind the migrations or
+// applied migrations changed.
+type HealthError struct {
+	// Migrations pending for the environment.
+	Pending []uint64 `json:"pending"`
+
+	// Applied migrations whose up file changed since they were applied.
+	Modified []uint64 `json:"modified"`
+}
+
+func (e *HealthError) Error() string {
+	return fmt.Sprintf("Schema is unhealthy: pending migrations %v, modified migrations %v", e.Pending, e.Modified)
+}
+
+// Checks the database for pending migrations and for applied migrations
+// whose up file no longer matches the recorded checksum, returning a
+// *HealthError when there are any. Checksums are compared using the
+// algorithm that recorded them; migrations applied before checksums
+// were recorded, or recorded by an unknown algorithm, aren't compared. The statuses are read from the
+// database on every call without waiting for running migrations, so
+// read-only migrators can back readiness probes.
+func (m *Migrator) Healthy() error {
+	if err := m.initialize(); err != nil {
+		return err
+	}
+	m.mu.RLock()
+	tableExists := m.tableVersion > 0
+	m.mu.RUnlock()
+
+	health := &HealthError{Pending: make([]uint64, 0), Modified: make([]uint64, 0)}
+	for _, migration := range m.snapshot() {
+		applied := migration.Archived
+		if tableExists && !applied {
+			var id uint64
+			err := m.executor.QueryRow(m.dbAdapter.GetMigrationSql(), migration.Id).Scan(&id)
+			if err != nil && err != sql.ErrNoRows {
+				m.errorf("Error getting migration status for %s: %v", migration.Name, err)
+				return err
+			}
+			applied = err == nil
+		}
+		if !applied {
+			if m.inEnvironment(migration) {
+				health.Pending = append(health.Pending, migration.Id)
+			}
+			continue
+		}
+
+		if migration.Archived {
+			continue
+		}
+		if err := m.newRun().getMigrationChecksum(migration); err != nil {
+			return err
+		}
+		if migration.Checksum == "" {
+			continue
+		}
+		algorithm := m.checksumAlgorithmNamed(migration.ChecksumAlgorithm)
+		if algorithm == nil {
+			m.warnf("Unknown checksum algorithm %s of migration %d", migration.ChecksumAlgorithm, migration.Id)
+			continue
+		}
+		checksum, err := m.checksum(migration, algorithm)
+		if err != nil {
+			return err
+		}
+		if checksum != migration.Checksum {
+			m.warnf("Migration %d changed since it was applied", migration.Id)
+			health.Modified = append(health.Modified, migration.Id)
+		}
+	}
+
+	if len(health.Pending) > 0 || len(health.Modified) > 0 {
+		return health
+	}
+	return nil
+}
+
+// Serves Healthy for readiness probes: 200 OK when healthy, and 503
+// Service Unavailable otherwise, with the HealthError as JSON.
+func (m *Migrator) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch err := m.Healthy().(type) {
+		case nil:
+			writeAdminJson(w, http.StatusOK, map[string]string{"status": "ok"})
+		case *HealthError:
+			writeAdminJson(w, http.StatusServiceUnavailable, err)
+		default:
+			writeAdminError(w, http.StatusServiceUnavailable, err)
+		}
+	})
+}
@@ -0,0 +1,190 @@
+// Records which migrations are running and refreshes their lock.
+
+package gomigrate
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Implemented by adapters that can record the migrations being applied
+// in the gomigrate_heartbeat table.
+type HeartbeatRecorder interface {
+	// Creates the gomigrate_heartbeat table when it doesn't exist.
+	CreateHeartbeatTableSql() string
+
+	// Inserts owner, migration_id, started_at_ns and last_seen_ns, in
+	// that order.
+	InsertHeartbeatSql() string
+
+	// Sets last_seen_ns of the row of an owner and migration id. Takes
+	// the time, the owner and the migration id, in that order.
+	UpdateHeartbeatSql() string
+
+	// Deletes the row of an owner and migration id.
+	DeleteHeartbeatSql() string
+
+	// Selects owner, migration_id, started_at_ns and last_seen_ns of
+	// every row.
+	SelectHeartbeatsSql() string
+}
+
+// A migration being applied, as recorded in the gomigrate_heartbeat
+// table.
+type Heartbeat struct {
+	// The runner applying the migration.
+	Owner       string    `json:"owner"`
+	MigrationId uint64    `json:"migration_id"`
+	StartedAt   time.Time `json:"started_at"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// Returns true if the runner hasn't refreshed the heartbeat for longer
+// than after, such as a few heartbeat intervals, so it most likely
+// crashed rather than still running the migration.
+func (h *Heartbeat) Stale(now time.Time, after time.Duration) bool {
+	return now.Sub(h.LastSeen) > after
+}
+
+// Returns the adapter as a HeartbeatRecorder, or HeartbeatUnsupported.
+func (m *Migrator) heartbeatRecorder() (HeartbeatRecorder, error) {
+	recorder, ok := m.dbAdapter.(HeartbeatRecorder)
+	if !ok {
+		m.warnf("Adapter does not support migration heartbeats")
+		return nil, HeartbeatUnsupported
+	}
+	return recorder, nil
+}
+
+// Creates the gomigrate_heartbeat table when HeartbeatInterval is set.
+func (m *run) ensureHeartbeatTable() error {
+	if m.HeartbeatInterval <= 0 {
+		return nil
+	}
+	recorder, err := m.heartbeatRecorder()
+	if err != nil {
+		return err
+	}
+	if _, err := m.executor.Exec(recorder.CreateHeartbeatTableSql()); err != nil {
+		m.errorf("Error creating migration heartbeat table: %v", err)
+		return err
+	}
+	if m.lockOwner == "" {
+		m.lockOwner = newLockOwner()
+	}
+	return nil
+}
+
+// Returns the runner recorded in heartbeats.
+func (m *Migrator) heartbeatOwner() string {
+	if m.Runner != "" {
+		return m.Runner
+	}
+	return m.lockOwner
+}
+
+// Records that a migration started and refreshes its heartbeat every
+// HeartbeatInterval, along with the migration lock when the runner
+// holds it, until the returned function is called. Heartbeats are
+// written outside of the migration's transaction, so failing to write
+// one is logged without failing the migration. They use the migrator's
+// executor even when the run uses a connection of the caller, which
+// the migration's transaction and statements keep busy.
+func (m *run) startHeartbeat(migration *Migration) func() {
+	if m.HeartbeatInterval <= 0 {
+		return func() {}
+	}
+	recorder, err := m.heartbeatRecorder()
+	if err != nil {
+		return func() {}
+	}
+	heartbeat := &run{m.Migrator, m.Migrator.executor, m.lockTable}
+	owner := m.heartbeatOwner()
+	started := m.now().UnixNano()
+	if _, err := heartbeat.executor.Exec(recorder.InsertHeartbeatSql(), owner, migration.Id, started, started); err != nil {
+		m.warnf("Error recording heartbeat of migration %d: %v", migration.Id, err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(m.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				heartbeat.beat(recorder, owner, migration)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+		if _, err := heartbeat.executor.Exec(recorder.DeleteHeartbeatSql(), owner, migration.Id); err != nil {
+			m.warnf("Error clearing heartbeat of migration %d: %v", migration.Id, err)
+		}
+	}
+}
+
+// Refreshes the heartbeat of a running migration, and the migration
+// lock when the runner holds it, so it doesn't expire while a single
+// migration outlasts LockExpiry.
+func (m *run) beat(recorder HeartbeatRecorder, owner string, migration *Migration) {
+	m.debugf("Migration %d still running", migration.Id)
+	if _, err := m.executor.Exec(recorder.UpdateHeartbeatSql(), m.now().UnixNano(), owner, migration.Id); err != nil {
+		m.warnf("Error recording heartbeat of migration %d: %v", migration.Id, err)
+	}
+	if !m.lockTable {
+		return
+	}
+	holder, err := m.lockHolder()
+	if err != nil || holder == nil {
+		return
+	}
+	if holder.Owner != m.lockOwner {
+		m.errorf("Migration lock was taken over by %s while migration %d runs", holder.Owner, migration.Id)
+		return
+	}
+	if _, err := m.tryLock(); err != nil {
+		m.warnf("Error refreshing migration lock: %v", err)
+	}
+}
+
+// Returns the migrations being applied, as recorded with
+// HeartbeatInterval set, with the runners applying them. Heartbeats
+// whose LastSeen is far behind the interval were left by runners that
+// crashed. Requires an adapter implementing HeartbeatRecorder and a
+// database/sql connection.
+func (m *Migrator) Heartbeats() ([]*Heartbeat, error) {
+	recorder, err := m.heartbeatRecorder()
+	if err != nil {
+		return nil, err
+	}
+	if m.DB == nil {
+		return nil, errors.New("Reading heartbeats requires a database/sql connection")
+	}
+	rows, err := m.DB.Query(recorder.SelectHeartbeatsSql())
+	if err != nil {
+		m.errorf("Error reading migration heartbeats: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	heartbeats := make([]*Heartbeat, 0)
+	for rows.Next() {
+		var heartbeat Heartbeat
+		var startedAt, lastSeen int64
+		if err := rows.Scan(&heartbeat.Owner, &heartbeat.MigrationId, &startedAt, &lastSeen); err != nil {
+			return nil, err
+		}
+		heartbeat.StartedAt = time.Unix(0, startedAt)
+		heartbeat.LastSeen = time.Unix(0, lastSeen)
+		heartbeats = append(heartbeats, &heartbeat)
+	}
+	return heartbeats, rows.Err()
+}
@@ -0,0 +1,155 @@
+// Reports the recorded applications of migrations.
+
+package gomigrate
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+)
+
+// A migration recorded as applied in the migrations table.
+type HistoryEntry struct {
+	Id uint64 `json:"id"`
+
+	// Empty when the migration has no files.
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Author      string `json:"author,omitempty"`
+	Ticket      string `json:"ticket,omitempty"`
+	Phase       string `json:"phase,omitempty"`
+
+	// Zero when applied before the time was recorded.
+	AppliedAt         time.Time `json:"applied_at"`
+	DurationMs        int64     `json:"duration_ms"`
+	StatementCount    int       `json:"statement_count"`
+	Checksum          string    `json:"checksum"`
+	ChecksumAlgorithm string    `json:"checksum_algorithm"`
+	SkipReason        string    `json:"skip_reason,omitempty"`
+
+	AppliedBy      string `json:"applied_by"`
+	Host           string `json:"host"`
+	Application    string `json:"application"`
+	LibraryVersion string `json:"library_version"`
+	Build          string `json:"build"`
+	Runner         string `json:"runner"`
+
+	// Values of the custom columns, by column name.
+	Columns map[string]string `json:"columns,omitempty"`
+}
+
+// Returns every migration recorded in the migrations table, including
+// those without files, in the order they were applied, for audits and
+// change management reports. Rolled back migrations are no longer
+// recorded. The table is read on every call.
+func (m *Migrator) History() ([]*HistoryEntry, error) {
+	if err := m.initialize(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	tableExists := m.tableVersion > 0
+	names := make(map[uint64]string, len(m.migrations))
+	known := make(map[uint64]*Migration, len(m.migrations))
+	for id, migration := range m.migrations {
+		names[id] = migration.Name
+		known[id] = migration
+	}
+	m.mu.RUnlock()
+	if !tableExists {
+		return make([]*HistoryEntry, 0), nil
+	}
+
+	ids, err := m.recordedIds(names)
+	if err != nil {
+		return nil, err
+	}
+	r := m.newRun()
+	columns := r.presentColumns()
+	history := make([]*HistoryEntry, 0, len(ids))
+	for _, id := range ids {
+		migration := &Migration{Id: id, Name: names[id]}
+		if file, ok := known[id]; ok {
+			migration.Description = file.Description
+			migration.Author = file.Author
+			migration.Ticket = file.Ticket
+			migration.Phase = file.Phase
+		}
+		for _, load := range []func(*Migration) error{
+			r.getMigrationStats,
+			r.getMigrationAudit,
+			r.getMigrationChecksum,
+			r.getMigrationSkip,
+			r.getMigrationDescription,
+			r.getMigrationMetadata,
+			r.getMigrationPhase,
+		} {
+			if err := load(migration); err != nil {
+				return nil, err
+			}
+		}
+		if err := r.getMigrationColumns(migration, columns); err != nil {
+			return nil, err
+		}
+		history = append(history, &HistoryEntry{
+			Id:                id,
+			Name:              migration.Name,
+			Description:       migration.Description,
+			Author:            migration.Author,
+			Ticket:            migration.Ticket,
+			Phase:             migration.Phase,
+			AppliedAt:         migration.AppliedAt,
+			DurationMs:        migration.DurationMs,
+			StatementCount:    migration.StatementCount,
+			Checksum:          migration.Checksum,
+			ChecksumAlgorithm: migration.ChecksumAlgorithm,
+			SkipReason:        migration.SkipReason,
+			AppliedBy:         migration.Audit.User,
+			Host:              migration.Audit.Host,
+			Application:       migration.Audit.Application,
+			LibraryVersion:    migration.Audit.LibraryVersion,
+			Build:             migration.Audit.Build,
+			Runner:            migration.Audit.Runner,
+			Columns:           migration.Columns,
+		})
+	}
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].AppliedAt.Before(history[j].AppliedAt)
+	})
+	return history, nil
+}
+
+// Returns the ids recorded in the migrations table, sorted. Only the
+// known migrations are checked in the tables of other tools, and by
+// migrators without a database/sql connection.
+func (m *Migrator) recordedIds(known map[uint64]string) ([]uint64, error) {
+	ids := make([]uint64, 0)
+	if _, foreign := m.dbAdapter.(ForeignMigrationTable); foreign || m.DB == nil {
+		for id := range known {
+			var mid uint64
+			err := m.executor.QueryRow(m.dbAdapter.GetMigrationSql(), id).Scan(&mid)
+			if err == nil {
+				ids = append(ids, id)
+			} else if err != sql.ErrNoRows {
+				m.errorf("Error getting migration status for %d: %v", id, err)
+				return nil, err
+			}
+		}
+		sort.Sort(uint64slice(ids))
+		return ids, nil
+	}
+
+	rows, err := m.DB.Query("SELECT migration_id FROM gomigrate ORDER BY migration_id")
+	if err != nil {
+		m.errorf("Error reading migrations table: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uint64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,134 @@
+// Imports migration history recorded by other migration tools.
+
+package gomigrate
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"strconv"
+)
+
+var leadingDigits = regexp.MustCompile(`^(\d+)`)
+
+// Reads the applied migration versions recorded by another
+// migration tool.
+type HistoryImporter interface {
+	AppliedVersions(db *sql.DB) ([]uint64, error)
+}
+
+// Reads applied versions from the schema_migrations table maintained
+// by Rails' ActiveRecord migrations.
+type RailsImporter struct{}
+
+func (r RailsImporter) AppliedVersions(db *sql.DB) ([]uint64, error) {
+	rows, err := db.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make([]uint64, 0)
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		id, err := strconv.ParseUint(version, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, id)
+	}
+	return versions, rows.Err()
+}
+
+// Reads applied versions from the django_migrations table. Django
+// numbers migrations per app, so only the migrations of App are
+// imported, using the numeric prefix of each migration name
+// ("0001_initial" becomes 1).
+type DjangoImporter struct {
+	App string
+}
+
+func (d DjangoImporter) AppliedVersions(db *sql.DB) ([]uint64, error) {
+	rows, err := db.Query("SELECT app, name FROM django_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make([]uint64, 0)
+	for rows.Next() {
+		var app, name string
+		if err := rows.Scan(&app, &name); err != nil {
+			return nil, err
+		}
+		if app != d.App {
+			continue
+		}
+		matches := leadingDigits.FindStringSubmatch(name)
+		if matches == nil {
+			continue
+		}
+		id, err := strconv.ParseUint(matches[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, id)
+	}
+	return versions, rows.Err()
+}
+
+// Records the versions applied by another migration tool as applied
+// gomigrate migrations. Versions already present in the migrations
+// table are left untouched. Returns the number of versions imported.
+func (m *Migrator) ImportHistory(importer HistoryImporter) (int, error) {
+	if err := m.checkReadOnly(); err != nil {
+		return 0, err
+	}
+	if err := m.initialize(); err != nil {
+		return 0, err
+	}
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+	if m.DB == nil {
+		return 0, errors.New("Importing history requires a database/sql connection")
+	}
+	versions, err := importer.AppliedVersions(m.DB)
+	if err != nil {
+		m.errorf("Error reading migration history: %v", err)
+		return 0, err
+	}
+
+	imported := 0
+	for _, id := range versions {
+		row := m.executor.QueryRow(m.dbAdapter.GetMigrationSql(), id)
+		var mid uint64
+		err := row.Scan(&mid)
+		if err == nil {
+			continue
+		}
+		if err != sql.ErrNoRows {
+			m.errorf("Error getting migration status for %d: %v", id, err)
+			return imported, err
+		}
+
+		if _, err := m.executor.Exec(m.dbAdapter.MigrationLogInsertSql(), id); err != nil {
+			m.errorf("Error logging migration: %v", err)
+			return imported, err
+		}
+		m.mu.Lock()
+		if migration, ok := m.migrations[id]; ok {
+			migration.Status = Active
+		} else {
+			m.warnf("Imported migration %d has no migration files", id)
+		}
+		m.mu.Unlock()
+		imported++
+	}
+
+	m.infof("Imported migrations: %v", imported)
+
+	return imported, nil
+}
@@ -0,0 +1,90 @@
+// Expands files included by migration files.
+
+package gomigrate
+
+import (
+	pathpkg "path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Matches "-- gomigrate: include shared/functions.sql" comments.
+var includeLine = regexp.MustCompile(`(?m)^[ \t]*--[ \t]*gomigrate:[ \t]*include[ \t]+([^\r\n]*?)[ \t\r]*$`)
+
+// Replaces the include directives of sql, read from the file at path,
+// with the contents of the included files, which may include others.
+// stack holds the files being expanded, to refuse cycles. Returns
+// InvalidInclude when an included file can't be read.
+func (m *Migrator) expandIncludes(path string, sql []byte, stack []string) ([]byte, error) {
+	var err error
+	expanded := includeLine.ReplaceAllFunc(sql, func(line []byte) []byte {
+		if err != nil {
+			return nil
+		}
+		name := string(includeLine.FindSubmatch(line)[1])
+		included := m.includePath(path, name)
+		if included == "" {
+			m.errorf("Invalid include of %q in %s", name, path)
+			err = InvalidInclude
+			return nil
+		}
+		for _, including := range stack {
+			if including == included {
+				m.errorf("Include cycle: %s", strings.Join(append(stack, included), " -> "))
+				err = InvalidInclude
+				return nil
+			}
+		}
+
+		if err = m.verifySignature(included); err != nil {
+			return nil
+		}
+		content, readErr := m.readMigrationFile(included)
+		if readErr != nil {
+			m.errorf("Error including %s in %s: %v", included, path, readErr)
+			err = InvalidInclude
+			return nil
+		}
+		content, err = m.expandIncludes(included, content, append(stack, included))
+		return content
+	})
+	if err != nil {
+		return nil, err
+	}
+	return expanded, nil
+}
+
+// Returns the path of a file included from the migration file at path,
+// relative to the directory of the source holding it, or an empty
+// string when name is invalid.
+func (m *Migrator) includePath(path, name string) string {
+	if name == "" || filepath.IsAbs(name) || strings.HasPrefix(name, "/") {
+		return ""
+	}
+	switch source := m.Source.(type) {
+	case *FileMigrationSource:
+		return filepath.Join(source.includeRoot(path), filepath.FromSlash(name))
+	case *AssetMigrationSource:
+		return pathpkg.Join(source.Dir, name)
+	}
+	return ""
+}
+
+// Returns the directory of the source holding the migration file at
+// path.
+func (f *FileMigrationSource) includeRoot(path string) string {
+	dirs := make([]string, 0, len(f.Dirs)+1)
+	if f.Dir != "" {
+		dirs = append(dirs, f.Dir)
+	}
+	for _, dir := range f.Dirs {
+		dirs = append(dirs, dir.Dir)
+	}
+	for _, dir := range dirs {
+		if rel, err := filepath.Rel(dir, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return dir
+		}
+	}
+	return filepath.Dir(path)
+}
@@ -0,0 +1,64 @@
+// Applies migrations from one of several replicas at once.
+
+package gomigrate
+
+import (
+	"context"
+)
+
+// Applies pending migrations like MigrateWithResult from one of several
+// replicas, such as the pods of a Deployment. The replica taking the
+// migration lock becomes the leader and applies the migrations; the
+// others wait according to LockWait and then find them applied, or
+// report RunLockHeld without an error when they give up. The lock table
+// is used whether or not LockTable is set. Once the lock is held,
+// statuses are reloaded so nothing is applied twice, and
+// NewerMigrations is returned when the database has migrations this
+// replica doesn't know about, because a newer release already migrated
+// it.
+func (m *Migrator) MigrateLeader(ctx context.Context, options ...RunOption) (*RunResult, error) {
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+	result := &RunResult{Outcome: RunError, Direction: string(upMigration), Applied: make([]uint64, 0)}
+	if err := m.checkReadOnly(); err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+
+	r := m.newRun()
+	r.lockTable = true
+	if err := r.ensureLockTable(); err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+	result, err := r.migrate(ctx, options, true)
+	if result.Outcome == RunLockHeld {
+		return result, nil
+	}
+	return result, err
+}
+
+// Returns NewerMigrations when the migrations table holds an id
+// higher than every known migration.
+func (m *run) checkNewerMigrations() error {
+	highest, err := m.appliedVersion()
+	if err != nil {
+		return err
+	}
+	if highest > m.highestId() {
+		m.errorf("Database has migration %d, newer than the known migrations", highest)
+		return NewerMigrations
+	}
+	return nil
+}
+
+// Returns the highest id of the known migrations.
+func (m *Migrator) highestId() uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ids := make([]uint64, 0, len(m.migrations))
+	for id := range m.migrations {
+		ids = append(ids, id)
+	}
+	return highestId(ids)
+}
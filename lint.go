@@ -0,0 +1,156 @@
+// Flags risky statements in pending migrations.
+
+package gomigrate
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Lint severities.
+const (
+	LintOff = iota
+	LintWarning
+	LintError
+)
+
+// Checks a single statement for a risky operation.
+type LintRule struct {
+	Name     string
+	Severity int
+	Message  string
+	Check    func(statement string) bool
+}
+
+// A risky statement found in a migration.
+type LintIssue struct {
+	Migration *Migration
+	Rule      string
+	Severity  int
+	Message   string
+	Statement string
+}
+
+var (
+	dropTable      = regexp.MustCompile(`(?i)\bDROP\s+TABLE\b`)
+	dropColumn     = regexp.MustCompile(`(?i)\bDROP\s+COLUMN\b`)
+	alterType      = regexp.MustCompile(`(?i)\bALTER\s+(COLUMN\s+)?\S+\s+(SET\s+DATA\s+)?TYPE\b|\bMODIFY\s+(COLUMN\s+)?\S+`)
+	addColumn      = regexp.MustCompile(`(?i)\bADD\s+(COLUMN\s+)?`)
+	setNotNull     = regexp.MustCompile(`(?i)\bSET\s+NOT\s+NULL\b`)
+	notNull        = regexp.MustCompile(`(?i)\bNOT\s+NULL\b`)
+	hasDefault     = regexp.MustCompile(`(?i)\bDEFAULT\b`)
+	createIndex    = regexp.MustCompile(`(?i)\bCREATE\s+(UNIQUE\s+)?INDEX\b`)
+	concurrently   = regexp.MustCompile(`(?i)\bCONCURRENTLY\b`)
+	updateOrDelete = regexp.MustCompile(`(?i)^\s*(UPDATE|DELETE)\b`)
+	hasWhere       = regexp.MustCompile(`(?i)\bWHERE\b`)
+	lineComment    = regexp.MustCompile(`--[^\n]*`)
+)
+
+// The rules checked by Lint. Severities can be changed per migrator
+// through LintSeverity.
+var LintRules = []LintRule{
+	{
+		Name:     "drop-table",
+		Severity: LintError,
+		Message:  "drops a table",
+		Check:    dropTable.MatchString,
+	},
+	{
+		Name:     "drop-column",
+		Severity: LintError,
+		Message:  "drops a column",
+		Check:    dropColumn.MatchString,
+	},
+	{
+		Name:     "alter-type",
+		Severity: LintWarning,
+		Message:  "changes a column type, which may narrow it or rewrite the table",
+		Check:    alterType.MatchString,
+	},
+	{
+		Name:     "not-null-without-default",
+		Severity: LintError,
+		Message:  "adds a NOT NULL column without a default",
+		Check: func(statement string) bool {
+			if setNotNull.MatchString(statement) {
+				return true
+			}
+			return addColumn.MatchString(statement) &&
+				notNull.MatchString(statement) &&
+				!hasDefault.MatchString(statement)
+		},
+	},
+	{
+		Name:     "non-concurrent-index",
+		Severity: LintWarning,
+		Message:  "creates an index without CONCURRENTLY, locking writes on large tables",
+		Check: func(statement string) bool {
+			return createIndex.MatchString(statement) && !concurrently.MatchString(statement)
+		},
+	},
+	{
+		Name:     "missing-where",
+		Severity: LintError,
+		Message:  "updates or deletes without a WHERE clause",
+		Check: func(statement string) bool {
+			return updateOrDelete.MatchString(statement) && !hasWhere.MatchString(statement)
+		},
+	},
+}
+
+// Checks the up files of all inactive migrations for risky statements.
+// A rule can be allowed for a single file with a comment such as:
+//
+//	-- gomigrate: allow drop-table,drop-column
+func (m *Migrator) Lint() ([]LintIssue, error) {
+	issues := make([]LintIssue, 0)
+	for _, migration := range m.Migrations(Inactive) {
+		sql, err := m.readMigrationFile(migration.UpPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, issue := range lintSql(string(sql), m.LintSeverity) {
+			issue.Migration = migration
+			issues = append(issues, issue)
+			m.warnf(
+				"Lint %s in %s: %s",
+				issue.Rule,
+				migration.UpPath,
+				issue.Message,
+			)
+		}
+	}
+	return issues, nil
+}
+
+// Returns the issues found in a migration file.
+func lintSql(sql string, severities map[string]int) []LintIssue {
+	allowed := make(map[string]bool)
+	for _, rule := range ParseDirectives(sql).List("allow") {
+		allowed[rule] = true
+	}
+
+	issues := make([]LintIssue, 0)
+	for _, statement := range strings.Split(lineComment.ReplaceAllString(sql, ""), ";") {
+		statement = strings.TrimSpace(statement)
+		if statement == "" {
+			continue
+		}
+		for _, rule := range LintRules {
+			severity := rule.Severity
+			if s, ok := severities[rule.Name]; ok {
+				severity = s
+			}
+			if severity == LintOff || allowed[rule.Name] || !rule.Check(statement) {
+				continue
+			}
+			issues = append(issues, LintIssue{
+				Rule:      rule.Name,
+				Severity:  severity,
+				Message:   rule.Message,
+				Statement: statement,
+			})
+		}
+	}
+	return issues
+}
@@ -0,0 +1,176 @@
+// Tells which pending statements take locks blocking traffic.
+
+package gomigrate
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Implemented by adapters that can tell the locks statements take
+// without running them.
+type LockAnalyzer interface {
+	// Returns the lock a statement takes on an existing table, or nil
+	// when it only takes row locks.
+	AnalyzeLocks(statement string) *LockImpact
+}
+
+// A lock a statement of a planned migration holds while it runs.
+type LockImpact struct {
+	Statement string
+
+	// The table locked and the mode it is locked in, such as ACCESS
+	// EXCLUSIVE.
+	Table string
+	Mode  string
+
+	// Whether the lock blocks writes, and reads as well, of the table.
+	BlocksWrites bool
+	BlocksReads  bool
+
+	// Set when the statement rewrites or scans the whole table while
+	// holding a lock that blocks writes, so it is held for as long as
+	// that takes on large tables.
+	Rewrite bool
+	Scan    bool
+}
+
+func (l *LockImpact) String() string {
+	s := fmt.Sprintf("%s lock on %s", l.Mode, l.Table)
+	switch {
+	case l.BlocksReads:
+		s += ", blocks reads and writes"
+	case l.BlocksWrites:
+		s += ", blocks writes"
+	default:
+		s += ", doesn't block reads or writes"
+	}
+	switch {
+	case l.Rewrite:
+		s += " while rewriting the table"
+	case l.Scan:
+		s += " while scanning the table"
+	}
+	return s
+}
+
+// Returns true when the lock blocks traffic for longer than it takes to
+// change the catalog.
+func (l *LockImpact) Long() bool {
+	return l.BlocksWrites && (l.Rewrite || l.Scan)
+}
+
+// Postgres table lock modes taken by migrations, from the strongest.
+const (
+	accessExclusiveLock      = "ACCESS EXCLUSIVE"
+	exclusiveLock            = "EXCLUSIVE"
+	shareRowExclusiveLock    = "SHARE ROW EXCLUSIVE"
+	shareLock                = "SHARE"
+	shareUpdateExclusiveLock = "SHARE UPDATE EXCLUSIVE"
+)
+
+var (
+	lockedTableName = regexp.MustCompile(`(?i)^\s*(?:TRUNCATE\s+(?:TABLE\s+)?|VACUUM\s+(?:\([^)]*\)|FULL)\s*|CLUSTER\s+(?:VERBOSE\s+)?|REINDEX\s+(?:\([^)]*\)\s*)?TABLE\s+(?:CONCURRENTLY\s+)?|REFRESH\s+MATERIALIZED\s+VIEW\s+(?:CONCURRENTLY\s+)?|CREATE\s+(?:OR\s+REPLACE\s+)?(?:CONSTRAINT\s+)?TRIGGER\b.*?\bON\s+)(?:ONLY\s+)?([\w$."]+)`)
+	dropIndex       = regexp.MustCompile(`(?i)^\s*DROP\s+INDEX\b`)
+	truncate        = regexp.MustCompile(`(?i)^\s*TRUNCATE\b`)
+	tableRewrite    = regexp.MustCompile(`(?i)^\s*(VACUUM\s*(\([^)]*\bFULL\b[^)]*\)|FULL\b)|CLUSTER\b)`)
+	reindex         = regexp.MustCompile(`(?i)^\s*REINDEX\b`)
+	createTrigger   = regexp.MustCompile(`(?i)^\s*CREATE\s+(OR\s+REPLACE\s+)?(CONSTRAINT\s+)?TRIGGER\b`)
+	refreshView     = regexp.MustCompile(`(?i)^\s*REFRESH\s+MATERIALIZED\s+VIEW\b`)
+	volatileDefault = regexp.MustCompile(`(?i)\bDEFAULT\s+(random|gen_random_uuid|uuid_generate_v\w+|clock_timestamp|nextval|timeofday)\s*\(|\b(BIG|SMALL)?SERIAL\b|\bSTORED\b`)
+	notValid        = regexp.MustCompile(`(?i)\bNOT\s+VALID\b`)
+	addForeignKey   = regexp.MustCompile(`(?i)\bADD\s+(CONSTRAINT\s+\S+\s+)?FOREIGN\s+KEY\b`)
+	addConstraint   = regexp.MustCompile(`(?i)\bADD\s+(CONSTRAINT\s+\S+\s+)?(CHECK|UNIQUE|PRIMARY\s+KEY|EXCLUDE)\b`)
+	usingIndex      = regexp.MustCompile(`(?i)\bUSING\s+INDEX\b`)
+	weakAlter       = regexp.MustCompile(`(?i)\b(VALIDATE\s+CONSTRAINT|SET\s+STATISTICS|SET\s*\(|RESET\s*\()`)
+	setTablespace   = regexp.MustCompile(`(?i)\bSET\s+(TABLESPACE|LOGGED|UNLOGGED)\b`)
+)
+
+// Returns the lock a Postgres statement takes on an existing table, as
+// documented for each command, or nil for statements taking no lock
+// beyond the row locks of DML. Types changed to binary-coercible ones,
+// such as a longer varchar, are reported as rewrites though Postgres
+// skips them.
+func postgresLockImpact(statement string) *LockImpact {
+	statement = lineComment.ReplaceAllString(statement, "")
+	impact := &LockImpact{Table: ownedTable(statement)}
+	if impact.Table == "" {
+		if match := lockedTableName.FindStringSubmatch(statement); match != nil {
+			impact.Table = match[1]
+		}
+	}
+
+	switch {
+	case dropIndex.MatchString(statement):
+		impact.Table = "the table of the index"
+		impact.Mode = accessExclusiveLock
+		if concurrently.MatchString(statement) {
+			impact.Mode = shareUpdateExclusiveLock
+		}
+	case createIndex.MatchString(statement):
+		impact.Mode, impact.Scan = shareLock, true
+		if concurrently.MatchString(statement) {
+			impact.Mode, impact.Scan = shareUpdateExclusiveLock, false
+		}
+	case reindex.MatchString(statement):
+		impact.Mode, impact.Scan = shareLock, true
+		if concurrently.MatchString(statement) {
+			impact.Mode, impact.Scan = shareUpdateExclusiveLock, false
+		}
+	case truncate.MatchString(statement), dropTable.MatchString(statement):
+		impact.Mode = accessExclusiveLock
+	case tableRewrite.MatchString(statement):
+		impact.Mode, impact.Rewrite = accessExclusiveLock, true
+	case refreshView.MatchString(statement):
+		impact.Mode, impact.Scan = accessExclusiveLock, true
+		if concurrently.MatchString(statement) {
+			impact.Mode = exclusiveLock
+		}
+	case createTrigger.MatchString(statement):
+		impact.Mode = shareRowExclusiveLock
+	case !alterTableName.MatchString(statement):
+		return nil
+	case weakAlter.MatchString(statement):
+		impact.Mode = shareUpdateExclusiveLock
+	case addForeignKey.MatchString(statement):
+		impact.Mode, impact.Scan = shareRowExclusiveLock, !notValid.MatchString(statement)
+	default:
+		impact.Mode = accessExclusiveLock
+		switch {
+		case alterType.MatchString(statement), setTablespace.MatchString(statement):
+			impact.Rewrite = true
+		case addColumn.MatchString(statement) && volatileDefault.MatchString(statement):
+			impact.Rewrite = true
+		case setNotNull.MatchString(statement):
+			impact.Scan = true
+		case addConstraint.MatchString(statement):
+			impact.Scan = !notValid.MatchString(statement) && !usingIndex.MatchString(statement)
+		}
+	}
+	impact.BlocksWrites = impact.Mode != shareUpdateExclusiveLock
+	impact.BlocksReads = impact.Mode == accessExclusiveLock
+	return impact
+}
+
+// Sets the locks the statements of the migrations a plan applies take,
+// when the adapter implements LockAnalyzer.
+func (m *Migrator) analyzeLocks(plan *Plan) {
+	analyzer, ok := m.dbAdapter.(LockAnalyzer)
+	if !ok || plan.Direction != upMigration {
+		return
+	}
+	for _, planned := range plan.Migrations {
+		planned.Locks = make([]*LockImpact, 0)
+		for _, statement := range planned.Statements {
+			impact := analyzer.AnalyzeLocks(statement)
+			if impact == nil {
+				continue
+			}
+			impact.Statement = statement
+			planned.Locks = append(planned.Locks, impact)
+			if impact.Long() {
+				m.warnf("Migration %d takes %s", planned.Migration.Id, impact)
+			}
+		}
+	}
+}
@@ -0,0 +1,304 @@
+// Serializes migration runs across processes with a lock table.
+
+package gomigrate
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+)
+
+// How runs wait for the migration lock held by another runner.
+const (
+	// Waits up to LockTimeout, or indefinitely when it is zero.
+	LockWaitTimeout = iota
+	// Waits until the run's context is done.
+	LockWaitForever
+	// Fails immediately.
+	LockNoWait
+)
+
+// How often a runner waiting for the lock tries to take it at first and
+// at most, and how long a lock lasts when LockExpiry isn't set.
+var (
+	lockPollInterval    = 500 * time.Millisecond
+	lockMaxPollInterval = 5 * time.Second
+	defaultLockExpiry   = 10 * time.Minute
+)
+
+// Implemented by adapters that can serialize runs with the single row
+// of the gomigrate_lock table, on databases without advisory locks.
+type MigrationLocker interface {
+	// Creates the gomigrate_lock table when it doesn't exist.
+	CreateLockTableSql() string
+
+	// Inserts the lock row, with the id 1 and no owner, unless it
+	// exists.
+	InsertLockRowSql() string
+
+	// Sets owner and expires_at_ns of the lock row when it has no owner,
+	// belongs to the owner or expired. Takes the owner, the expiry, the
+	// owner again and the current time, in that order.
+	AcquireLockSql() string
+
+	// Clears the owner of the lock row when it belongs to the owner.
+	ReleaseLockSql() string
+
+	// Selects owner and expires_at_ns of the lock row.
+	SelectLockSql() string
+}
+
+// The runner holding the migration lock.
+type LockHolder struct {
+	Owner     string
+	ExpiresAt time.Time
+}
+
+// Returned when a run gave up waiting for the migration lock. Matches
+// MigrationLocked with errors.Is.
+type LockTimeout struct {
+	// The runner holding the lock when the run gave up, nil when it was
+	// released in the meantime.
+	Holder *LockHolder
+	Waited time.Duration
+}
+
+func (e *LockTimeout) Error() string {
+	if e.Holder == nil {
+		return fmt.Sprintf("Migration lock not acquired after %v", e.Waited)
+	}
+	return fmt.Sprintf("Migration lock held by %s not acquired after %v", e.Holder.Owner, e.Waited)
+}
+
+func (e *LockTimeout) Is(target error) bool {
+	return target == MigrationLocked
+}
+
+// Returns the adapter as a MigrationLocker, or LockingUnsupported.
+func (m *Migrator) locker() (MigrationLocker, error) {
+	locker, ok := m.dbAdapter.(MigrationLocker)
+	if !ok {
+		m.warnf("Adapter does not support the migration lock table")
+		return nil, LockingUnsupported
+	}
+	return locker, nil
+}
+
+// Creates the gomigrate_lock table and its row when the run takes the
+// lock.
+func (m *run) ensureLockTable() error {
+	if !m.lockTable {
+		return nil
+	}
+	locker, err := m.locker()
+	if err != nil {
+		return err
+	}
+	for _, query := range []string{locker.CreateLockTableSql(), locker.InsertLockRowSql()} {
+		if _, err := m.executor.Exec(query); err != nil {
+			m.errorf("Error creating migration lock table: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Identifies the runner holding the lock.
+func newLockOwner() string {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s:%d:%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// Takes or renews the lock. Returns whether the lock is held.
+func (m *run) tryLock() (bool, error) {
+	locker, err := m.locker()
+	if err != nil {
+		return false, err
+	}
+	expiry := m.LockExpiry
+	if expiry <= 0 {
+		expiry = defaultLockExpiry
+	}
+	now := m.now()
+	result, err := m.executor.Exec(
+		locker.AcquireLockSql(),
+		m.lockOwner,
+		now.Add(expiry).UnixNano(),
+		m.lockOwner,
+		now.UnixNano(),
+	)
+	if err != nil {
+		m.errorf("Error taking migration lock: %v", err)
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// Waits for the lock according to LockWait when the run takes it, then
+// reloads the migration statuses, which other runners may have changed.
+// Returns a function releasing the lock, or a LockTimeout error.
+func (m *run) acquireLock(ctx context.Context) (func(), error) {
+	if !m.lockTable {
+		return func() {}, nil
+	}
+	if m.lockOwner == "" {
+		m.lockOwner = newLockOwner()
+	}
+
+	// Started before the timeout, so Waited is never less than it.
+	start := time.Now()
+	wait := ctx
+	if m.LockWait == LockWaitTimeout && m.LockTimeout > 0 {
+		var cancel context.CancelFunc
+		wait, cancel = context.WithTimeout(ctx, m.LockTimeout)
+		defer cancel()
+	}
+	interval := lockPollInterval
+	for {
+		locked, err := m.tryLock()
+		if err != nil {
+			return nil, err
+		}
+		if locked {
+			break
+		}
+		if m.LockWait == LockNoWait {
+			return nil, m.lockTimeout(start)
+		}
+		m.infof("Waiting %v for the migration lock", interval)
+		select {
+		case <-wait.Done():
+			if ctx.Err() != nil {
+				return nil, Interrupted
+			}
+			return nil, m.lockTimeout(start)
+		case <-time.After(interval):
+		}
+		if interval *= 2; interval > lockMaxPollInterval {
+			interval = lockMaxPollInterval
+		}
+	}
+	m.debugf("Migration lock acquired by %s", m.lockOwner)
+
+	// Migrations rolled back by other runners are no longer recorded.
+	m.mu.Lock()
+	for _, migration := range m.migrations {
+		migration.Status = Inactive
+		migration.SkipReason = ""
+	}
+	err := m.getMigrationStatuses(m.migrations)
+	m.mu.Unlock()
+	if err != nil {
+		m.releaseLock()
+		return nil, err
+	}
+	return m.releaseLock, nil
+}
+
+// Extends the lock before each migration, so it only expires when a
+// single migration outlasts LockExpiry. Returns MigrationLocked when
+// another runner took the lock over.
+func (m *run) renewLock() error {
+	if !m.lockTable {
+		return nil
+	}
+	locked, err := m.tryLock()
+	if err != nil {
+		return err
+	}
+	if !locked {
+		m.errorf("Migration lock was taken over by another runner")
+		return MigrationLocked
+	}
+	return nil
+}
+
+// Returns the LockTimeout error of a run that started waiting at start.
+func (m *run) lockTimeout(start time.Time) error {
+	err := &LockTimeout{Waited: time.Since(start)}
+	err.Holder, _ = m.lockHolder()
+	m.warnf("%v", err)
+	return err
+}
+
+func (m *run) releaseLock() {
+	locker, err := m.locker()
+	if err != nil {
+		return
+	}
+	if _, err := m.executor.Exec(locker.ReleaseLockSql(), m.lockOwner); err != nil {
+		m.errorf("Error releasing migration lock: %v", err)
+		return
+	}
+	m.debugf("Migration lock released by %s", m.lockOwner)
+}
+
+// Returns the runner holding the migration lock, or nil when the lock
+// is free. Expired locks are still returned until another runner takes
+// them over.
+func (m *Migrator) LockHolder() (*LockHolder, error) {
+	return m.newRun().lockHolder()
+}
+
+func (m *run) lockHolder() (*LockHolder, error) {
+	locker, err := m.locker()
+	if err != nil {
+		return nil, err
+	}
+	var owner string
+	var expiresAt int64
+	err = m.executor.QueryRow(locker.SelectLockSql()).Scan(&owner, &expiresAt)
+	if err == sql.ErrNoRows || err == nil && owner == "" {
+		return nil, nil
+	}
+	if err != nil {
+		m.errorf("Error getting migration lock: %v", err)
+		return nil, err
+	}
+	return &LockHolder{Owner: owner, ExpiresAt: time.Unix(0, expiresAt)}, nil
+}
+
+// Releases the migration lock held by owner, as reported by LockHolder,
+// after a runner crashed without releasing it. Returns LockNotHeld when
+// the lock is free or held by another runner, so a lock taken over in
+// the meantime isn't released. Make sure the owner stopped first: its
+// migrations would otherwise run alongside the next runner's.
+func (m *Migrator) ForceUnlock(owner string) error {
+	if err := m.checkReadOnly(); err != nil {
+		return err
+	}
+	locker, err := m.locker()
+	if err != nil {
+		return err
+	}
+	holder, err := m.LockHolder()
+	if err != nil {
+		return err
+	}
+	if holder == nil || holder.Owner != owner {
+		m.warnf("Migration lock is not held by %s", owner)
+		return LockNotHeld
+	}
+
+	m.warnf("FORCIBLY RELEASING the migration lock held by %s until %s", holder.Owner, holder.ExpiresAt.Format(time.RFC3339))
+	result, err := m.executor.Exec(locker.ReleaseLockSql(), owner)
+	if err != nil {
+		m.errorf("Error releasing migration lock: %v", err)
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows != 1 {
+		m.warnf("Migration lock is no longer held by %s", owner)
+		return LockNotHeld
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+// Logs messages by severity.
+
+package gomigrate
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// Severity of a log message.
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[LogLevel]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l LogLevel) String() string {
+	return levelNames[l]
+}
+
+// Returns the level with the given name, such as "warn".
+func ParseLogLevel(name string) (LogLevel, error) {
+	for level, levelName := range levelNames {
+		if levelName == name {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid log level: %s", name)
+}
+
+// Implemented by loggers receiving the severity of each message.
+// Loggers only implementing Logger receive every message through
+// Printf.
+type LevelLogger interface {
+	Logger
+	Logf(level LogLevel, format string, v ...interface{})
+}
+
+// Writes messages of at least Level to Logger, prefixed with their
+// level. Messages written through Print, Printf and Println are logged
+// at LevelInfo.
+type LeveledLogger struct {
+	Logger Logger
+	Level  LogLevel
+}
+
+func (l *LeveledLogger) Logf(level LogLevel, format string, v ...interface{}) {
+	if level < l.Level {
+		return
+	}
+	l.Logger.Printf("["+level.String()+"] "+format, v...)
+}
+
+func (l *LeveledLogger) Print(v ...interface{}) {
+	l.Logf(LevelInfo, "%s", fmt.Sprint(v...))
+}
+
+func (l *LeveledLogger) Printf(format string, v ...interface{}) {
+	l.Logf(LevelInfo, format, v...)
+}
+
+func (l *LeveledLogger) Println(v ...interface{}) {
+	l.Logf(LevelInfo, "%s", fmt.Sprint(v...))
+}
+
+func (l *LeveledLogger) Fatalf(format string, v ...interface{}) {
+	l.Logger.Fatalf(format, v...)
+}
+
+// Discards every message. Fatalf panics, since the migrator can't
+// continue.
+type NopLogger struct{}
+
+func (NopLogger) Logf(level LogLevel, format string, v ...interface{}) {}
+func (NopLogger) Print(v ...interface{})                               {}
+func (NopLogger) Printf(format string, v ...interface{})               {}
+func (NopLogger) Println(v ...interface{})                             {}
+
+func (NopLogger) Fatalf(format string, v ...interface{}) {
+	panic(fmt.Sprintf(format, v...))
+}
+
+// Returns the logger used by NewMigrator, writing informational
+// messages, warnings and errors to stderr.
+func DefaultLogger() Logger {
+	return &LeveledLogger{
+		Logger: log.New(os.Stderr, "[gomigrate] ", log.LstdFlags),
+		Level:  LevelInfo,
+	}
+}
+
+// Logs a message with its level when the logger accepts levels.
+func logf(logger Logger, level LogLevel, format string, v ...interface{}) {
+	if l, ok := logger.(LevelLogger); ok {
+		l.Logf(level, format, v...)
+		return
+	}
+	logger.Printf(format, v...)
+}
+
+func (m *Migrator) debugf(format string, v ...interface{}) {
+	logf(m.logger, LevelDebug, format, v...)
+}
+
+func (m *Migrator) infof(format string, v ...interface{}) {
+	logf(m.logger, LevelInfo, format, v...)
+}
+
+func (m *Migrator) warnf(format string, v ...interface{}) {
+	logf(m.logger, LevelWarn, format, v...)
+}
+
+func (m *Migrator) errorf(format string, v ...interface{}) {
+	logf(m.logger, LevelError, format, v...)
+}
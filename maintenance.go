@@ -0,0 +1,96 @@
+// Refreshes the statistics of the tables migrations change once they
+// commit.
+
+package gomigrate
+
+import (
+	"regexp"
+)
+
+// Implemented by adapters that can refresh the statistics of tables and
+// reclaim their dead rows.
+type TableMaintainer interface {
+	// Returns a statement refreshing the planner statistics of a table.
+	AnalyzeSql(table string) string
+
+	// Returns a statement reclaiming the dead rows of a table without
+	// blocking reads or writes, or an empty string when the database
+	// can't do so safely.
+	VacuumSql(table string) string
+}
+
+// Matches the tables whose rows statements change.
+var (
+	insertTable = regexp.MustCompile(`(?i)^\s*INSERT\s+(?:IGNORE\s+)?INTO\s+([\w$."]+)`)
+	updateTable = regexp.MustCompile(`(?i)^\s*(?:UPDATE|DELETE\s+FROM)\s+(?:ONLY\s+)?([\w$."]+)`)
+)
+
+// The tables changed by the statements of a migration, in order: those
+// whose schema, indexes or rows changed, to analyze, and those where
+// rows were updated or deleted, leaving dead rows to vacuum. Tables the
+// migration drops are left out.
+func touchedTables(statements []string) (analyze, vacuum []string) {
+	analyzed, vacuumed, dropped := make(map[string]bool), make(map[string]bool), make(map[string]bool)
+	for _, statement := range statements {
+		statement = lineComment.ReplaceAllString(statement, "")
+		var table string
+		if match := updateTable.FindStringSubmatch(statement); match != nil {
+			table = match[1]
+			if !vacuumed[table] {
+				vacuumed[table] = true
+				vacuum = append(vacuum, table)
+			}
+		} else if match := insertTable.FindStringSubmatch(statement); match != nil {
+			table = match[1]
+		} else if table = ownedTable(statement); table != "" && dropTable.MatchString(statement) {
+			dropped[table] = true
+			continue
+		}
+		if table != "" && !analyzed[table] {
+			analyzed[table] = true
+			analyze = append(analyze, table)
+		}
+	}
+	return withoutTables(analyze, dropped), withoutTables(vacuum, dropped)
+}
+
+func withoutTables(tables []string, excluded map[string]bool) []string {
+	kept := make([]string, 0, len(tables))
+	for _, table := range tables {
+		if !excluded[table] {
+			kept = append(kept, table)
+		}
+	}
+	return kept
+}
+
+// Analyzes the tables a committed migration changed when AnalyzeAfter
+// is set, and vacuums those with dead rows when VacuumAfter is set too.
+// The migration already committed, so failures are only logged.
+func (m *run) maintainTables(migration *Migration, statements []string) {
+	if !m.AnalyzeAfter {
+		return
+	}
+	maintainer, ok := m.dbAdapter.(TableMaintainer)
+	if !ok {
+		m.warnf("Adapter does not support analyzing tables")
+		return
+	}
+	analyze, vacuum := touchedTables(statements)
+	if m.VacuumAfter {
+		for _, table := range vacuum {
+			if sql := maintainer.VacuumSql(table); sql != "" {
+				m.debugf("Vacuuming %s after migration %d", table, migration.Id)
+				if _, err := m.executor.Exec(sql); err != nil {
+					m.warnf("Error vacuuming %s after migration %d: %v", table, migration.Id, err)
+				}
+			}
+		}
+	}
+	for _, table := range analyze {
+		m.debugf("Analyzing %s after migration %d", table, migration.Id)
+		if _, err := m.executor.Exec(maintainer.AnalyzeSql(table)); err != nil {
+			m.warnf("Error analyzing %s after migration %d: %v", table, migration.Id, err)
+		}
+	}
+}
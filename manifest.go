@@ -0,0 +1,107 @@
+// Loads migration metadata from a manifest.
+
+package gomigrate
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Names of the manifest files looked for in a migrations directory.
+// JSON manifests are parsed as YAML.
+var manifestNames = []string{"migrations.yaml", "migrations.yml", "migrations.json"}
+
+// Declares the migrations of a directory along with their metadata.
+type Manifest struct {
+	Migrations []ManifestEntry `yaml:"migrations"`
+}
+
+// A migration declared in a manifest.
+type ManifestEntry struct {
+	Id uint64 `yaml:"id"`
+
+	// Names of the migration files, checked against the directory.
+	Up   string `yaml:"up"`
+	Down string `yaml:"down"`
+
+	Description  string   `yaml:"description"`
+	Tags         []string `yaml:"tags"`
+	DependsOn    []uint64 `yaml:"depends_on"`
+	Environments []string `yaml:"only"`
+
+	// Marks a migration that can't be rolled back, which then doesn't
+	// need a down file.
+	Irreversible bool `yaml:"irreversible"`
+}
+
+// Returns the path of the manifest among the files of a directory, or
+// an empty string when there is none.
+func findManifest(files []string) string {
+	for _, name := range manifestNames {
+		for _, file := range files {
+			if filepath.Base(file) == name {
+				return file
+			}
+		}
+	}
+	return ""
+}
+
+// Collects the migrations of a directory described by a manifest.
+// Every migration file must be declared in the manifest and every
+// declared migration must have its files.
+func loadManifest(path string, files []string, allowMissingDown bool, pattern *FilenamePattern, logger Logger) (map[uint64]*Migration, error) {
+	logf(logger, LevelDebug, "Migrations manifest found: %s", path)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		logf(logger, LevelError, "Error parsing manifest: %v", err)
+		return nil, InvalidManifest
+	}
+
+	migrations, err := collectMigrations(files, true, pattern, logger)
+	if err != nil {
+		return migrations, err
+	}
+
+	declared := make(map[uint64]bool)
+	for _, entry := range manifest.Migrations {
+		migration, ok := migrations[entry.Id]
+		switch {
+		case declared[entry.Id]:
+			logf(logger, LevelWarn, "Migration %d is declared twice in the manifest", entry.Id)
+			return nil, InvalidManifest
+		case !ok:
+			logf(logger, LevelWarn, "Migration %d of the manifest has no files", entry.Id)
+			return nil, InvalidManifest
+		case entry.Up != "" && entry.Up != filepath.Base(migration.UpPath),
+			entry.Down != "" && entry.Down != filepath.Base(migration.DownPath):
+			logf(logger, LevelWarn, "Files of migration %d don't match the manifest", entry.Id)
+			return nil, InvalidManifest
+		}
+		declared[entry.Id] = true
+
+		if migration.DownPath == "" && !entry.Irreversible && !allowMissingDown {
+			logf(logger, LevelWarn, "Invalid migration pair for path: %s", migration.UpPath)
+			return nil, InvalidMigrationPair
+		}
+		migration.Irreversible = migration.Irreversible || entry.Irreversible
+		migration.Description = entry.Description
+		migration.Tags = entry.Tags
+		migration.DependsOn = entry.DependsOn
+		migration.Environments = entry.Environments
+	}
+
+	for id, migration := range migrations {
+		if !declared[id] {
+			logf(logger, LevelWarn, "Migration is missing from the manifest: %s", migration.UpPath)
+			return nil, InvalidManifest
+		}
+	}
+	return migrations, nil
+}
@@ -0,0 +1,52 @@
+// Records who wrote migrations and the tickets they belong to.
+
+package gomigrate
+
+import (
+	"database/sql"
+)
+
+// Implemented by adapters that can record the author and ticket of
+// applied migrations.
+type MigrationMetadataRecorder interface {
+	// Sets author and ticket for a migration id, in that order.
+	MigrationMetadataUpdateSql() string
+
+	// Selects author and ticket for a migration id.
+	MigrationMetadataSelectSql() string
+}
+
+// Records the author and ticket of an applied migration in the
+// transaction it runs in.
+func (m *Migrator) recordMetadata(transaction TxExecutor, migration *Migration) error {
+	recorder, ok := m.dbAdapter.(MigrationMetadataRecorder)
+	if !ok || m.tableVersion < 10 || migration.Author == "" && migration.Ticket == "" {
+		return nil
+	}
+	if _, err := transaction.Exec(recorder.MigrationMetadataUpdateSql(), migration.Author, migration.Ticket, migration.Id); err != nil {
+		m.errorf("Error logging migration: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Loads the recorded author and ticket of an applied migration.
+// Migrations applied without them keep those declared in their files.
+func (m *run) getMigrationMetadata(migration *Migration) error {
+	recorder, ok := m.dbAdapter.(MigrationMetadataRecorder)
+	if !ok || m.tableVersion < 10 {
+		return nil
+	}
+
+	var author, ticket sql.NullString
+	row := m.executor.QueryRow(recorder.MigrationMetadataSelectSql(), migration.Id)
+	if err := row.Scan(&author, &ticket); err != nil {
+		m.errorf("Error getting migration metadata for %s: %v", migration.Name, err)
+		return err
+	}
+	if author.Valid || ticket.Valid {
+		migration.Author = author.String
+		migration.Ticket = ticket.String
+	}
+	return nil
+}
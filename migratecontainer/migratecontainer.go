@@ -0,0 +1,140 @@
+// Package migratecontainer runs tests against a disposable database
+// container with every migration applied, using testcontainers. Docker
+// must be available to the tests.
+//
+//	func TestOrders(t *testing.T) {
+//		db := migratecontainer.Postgres(t, &gomigrate.FileMigrationSource{Dir: "../migrations"})
+//		// db has the migrated schema and is removed after the test.
+//	}
+package migratecontainer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DavidHuie/gomigrate"
+	"github.com/DavidHuie/gomigrate/migratetest"
+	"github.com/docker/go-connections/nat"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// Images used for the containers.
+var (
+	PostgresImage = "postgres:16-alpine"
+	MysqlImage    = "mysql:8.0"
+)
+
+// How long to wait for a container to accept connections.
+var StartupTimeout = time.Minute
+
+// Describes how to start a database container and connect to it.
+type database struct {
+	image   string
+	port    nat.Port
+	env     map[string]string
+	driver  string
+	adapter gomigrate.Migratable
+	dsn     func(host, port string) string
+}
+
+// Starts a PostgreSQL container, applies the migrations and returns a
+// connection to it. The container is removed when the test ends.
+func Postgres(t testing.TB, source gomigrate.MigrationSource) *sql.DB {
+	t.Helper()
+	return start(t, source, database{
+		image: PostgresImage,
+		port:  "5432/tcp",
+		env: map[string]string{
+			"POSTGRES_USER":     "gomigrate",
+			"POSTGRES_PASSWORD": "gomigrate",
+			"POSTGRES_DB":       "gomigrate",
+		},
+		driver:  "postgres",
+		adapter: gomigrate.Postgres{},
+		dsn: func(host, port string) string {
+			return fmt.Sprintf("host=%s port=%s user=gomigrate password=gomigrate dbname=gomigrate sslmode=disable", host, port)
+		},
+	})
+}
+
+// Starts a MySQL container, applies the migrations and returns a
+// connection to it. The container is removed when the test ends.
+func Mysql(t testing.TB, source gomigrate.MigrationSource) *sql.DB {
+	t.Helper()
+	return start(t, source, database{
+		image: MysqlImage,
+		port:  "3306/tcp",
+		env: map[string]string{
+			"MYSQL_ROOT_PASSWORD": "gomigrate",
+			"MYSQL_USER":          "gomigrate",
+			"MYSQL_PASSWORD":      "gomigrate",
+			"MYSQL_DATABASE":      "gomigrate",
+		},
+		driver:  "mysql",
+		adapter: gomigrate.Mysql{},
+		dsn: func(host, port string) string {
+			return fmt.Sprintf("gomigrate:gomigrate@tcp(%s:%s)/gomigrate", host, port)
+		},
+	})
+}
+
+func start(t testing.TB, source gomigrate.MigrationSource, d database) *sql.DB {
+	t.Helper()
+	ctx := context.Background()
+
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        d.image,
+			ExposedPorts: []string{string(d.port)},
+			Env:          d.env,
+			WaitingFor:   wait.ForListeningPort(string(d.port)).WithStartupTimeout(StartupTimeout),
+		},
+		Started: true,
+	})
+	if err != nil {
+		t.Fatalf("Error starting %s container: %v", d.image, err)
+	}
+	t.Cleanup(func() {
+		if err := container.Terminate(context.Background()); err != nil {
+			t.Logf("Error removing %s container: %v", d.image, err)
+		}
+	})
+
+	host, err := container.Host(ctx)
+	if err != nil {
+		t.Fatalf("Error getting container host: %v", err)
+	}
+	port, err := container.MappedPort(ctx, string(d.port))
+	if err != nil {
+		t.Fatalf("Error getting container port: %v", err)
+	}
+	db, err := sql.Open(d.driver, d.dsn(host, port.Port()))
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// The port can open before the server accepts connections.
+	deadline := time.Now().Add(StartupTimeout)
+	for err = db.Ping(); err != nil; err = db.Ping() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Database didn't accept connections: %v", err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	migrator, err := gomigrate.NewMigratorWithLogger(db, d.adapter, source, migratetest.Logger(t))
+	if err != nil {
+		t.Fatalf("Error creating migrator: %v", err)
+	}
+	if err := migrator.Migrate(); err != nil {
+		t.Fatalf("Error applying migrations: %v", err)
+	}
+	return db
+}
@@ -0,0 +1,121 @@
+// Package migratetest checks in tests that migrations apply cleanly and
+// can be rolled back.
+//
+//	func TestMigrations(t *testing.T) {
+//		db := openTestDatabase(t)
+//		migratetest.MigrateUpDownUp(t, db, gomigrate.Postgres{},
+//			&gomigrate.FileMigrationSource{Dir: "./migrations"})
+//	}
+package migratetest
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/DavidHuie/gomigrate"
+)
+
+// Applies each pending migration, rolls it back and applies it again,
+// failing the test on the first error. When the adapter can inspect the
+// schema, rolling a migration back must restore the schema it was
+// applied to and applying it again must reproduce the same schema.
+// Irreversible migrations are only applied. Returns the migrator, with
+// every migration applied.
+func MigrateUpDownUp(t testing.TB, db *sql.DB, adapter gomigrate.Migratable, source gomigrate.MigrationSource) *gomigrate.Migrator {
+	t.Helper()
+
+	migrator, err := gomigrate.NewMigratorWithLogger(db, adapter, source, Logger(t))
+	if err != nil {
+		t.Fatalf("Error creating migrator: %v", err)
+	}
+
+	for _, migration := range migrator.Pending() {
+		before := inspect(t, db, adapter)
+		if err := migrator.ApplyMigration(migration, gomigrate.Up); err != nil {
+			t.Fatalf("Error applying migration %s: %v", migration.UpPath, err)
+		}
+		if migration.Irreversible {
+			continue
+		}
+		after := inspect(t, db, adapter)
+
+		if err := migrator.ApplyMigration(migration, gomigrate.Down); err != nil {
+			t.Fatalf("Error rolling back migration %s: %v", migration.DownPath, err)
+		}
+		if schema := inspect(t, db, adapter); !reflect.DeepEqual(schema, before) {
+			t.Fatalf("Rolling back %s changed the schema:\nbefore: %v\nafter:  %v", migration.DownPath, before, schema)
+		}
+
+		if err := migrator.ApplyMigration(migration, gomigrate.Up); err != nil {
+			t.Fatalf("Error reapplying migration %s: %v", migration.UpPath, err)
+		}
+		if schema := inspect(t, db, adapter); !reflect.DeepEqual(schema, after) {
+			t.Fatalf("Reapplying %s produced a different schema:\nfirst:  %v\nsecond: %v", migration.UpPath, after, schema)
+		}
+	}
+	return migrator
+}
+
+// Returns the current schema, or nil when the adapter can't inspect
+// it.
+func inspect(t testing.TB, db *sql.DB, adapter gomigrate.Migratable) gomigrate.Schema {
+	t.Helper()
+	schema, err := gomigrate.InspectSchema(db, adapter)
+	if err == gomigrate.UnsupportedAdapter {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("Error inspecting schema: %v", err)
+	}
+	return schema
+}
+
+// Returns a gomigrate logger writing to the test log.
+func Logger(t testing.TB) gomigrate.Logger {
+	return logger{t}
+}
+
+type logger struct {
+	t testing.TB
+}
+
+func (l logger) Print(v ...interface{})                 { l.t.Log(v...) }
+func (l logger) Printf(format string, v ...interface{}) { l.t.Logf(format, v...) }
+func (l logger) Println(v ...interface{})               { l.t.Log(v...) }
+func (l logger) Fatalf(format string, v ...interface{}) { l.t.Fatalf(format, v...) }
+
+// Set to rewrite golden schema files instead of comparing against them,
+// from the GOMIGRATE_UPDATE_GOLDEN environment variable by default.
+var UpdateGolden = os.Getenv("GOMIGRATE_UPDATE_GOLDEN") != ""
+
+// Compares the schema of the database, in the form of Schema.String,
+// with a committed golden file, failing the test when they differ so
+// unexpected schema changes are caught in review. The file is written
+// instead when UpdateGolden is set or it doesn't exist yet.
+func MatchGoldenSchema(t testing.TB, db *sql.DB, adapter gomigrate.Migratable, path string) {
+	t.Helper()
+
+	schema, err := gomigrate.InspectSchema(db, adapter)
+	if err != nil {
+		t.Fatalf("Error inspecting schema: %v", err)
+	}
+	dump := schema.String()
+
+	golden, err := ioutil.ReadFile(path)
+	if UpdateGolden || os.IsNotExist(err) {
+		if err := ioutil.WriteFile(path, []byte(dump), 0644); err != nil {
+			t.Fatalf("Error writing golden schema: %v", err)
+		}
+		t.Logf("Wrote golden schema: %s", path)
+		return
+	}
+	if err != nil {
+		t.Fatalf("Error reading golden schema: %v", err)
+	}
+	if string(golden) != dump {
+		t.Errorf("Schema differs from %s, set GOMIGRATE_UPDATE_GOLDEN=1 to update it:\ngolden:\n%s\nactual:\n%s", path, golden, dump)
+	}
+}
@@ -3,7 +3,13 @@
 package gomigrate
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
 // Migration statuses.
@@ -14,21 +20,139 @@ const (
 
 // Holds configuration information for a given migration.
 type Migration struct {
-	DownPath string
-	Id       uint64
-	Name     string
-	Status   int
-	UpPath   string
+	DownPath     string
+	Id           uint64
+	Irreversible bool
+	Name         string
+	Status       int
+	UpPath       string
+
+	// How long the migration took to apply, how many statements it ran
+	// and when it was applied, when recorded by the adapter.
+	DurationMs     int64
+	StatementCount int
+	AppliedAt      time.Time
+
+	// Who applied the migration and from where, when recorded by the
+	// adapter.
+	Audit AuditInfo
+
+	// Checksum of the up file when the migration was applied, and the
+	// name of the algorithm computing it, when recorded by the adapter.
+	Checksum          string
+	ChecksumAlgorithm string
+
+	// Why the migration was recorded as applied without running, when
+	// it was skipped with Migrator.Skip.
+	SkipReason string
+
+	// Values of the custom columns recorded when the migration was
+	// applied, by column name.
+	Columns map[string]string
+
+	// Set for migrations found in the archive subdirectory, which count
+	// as applied by a baseline and never run.
+	Archived bool
+
+	// Ids of the migrations this one depends on, declared in the up
+	// file with "-- gomigrate: depends-on".
+	DependsOn []uint64
+
+	// Environments the migration is restricted to, declared in the up
+	// file with "-- gomigrate: only". Empty when it runs everywhere.
+	Environments []string
+
+	// Tags used to select migrations to run, declared in the up file
+	// with "-- gomigrate: tags".
+	Tags []string
+
+	// Minimum versions of the database server, declared in the up file
+	// with "-- gomigrate: requires postgres>=14".
+	Requires []ServerRequirement
+
+	// Extensions the migration needs, declared in the up file with
+	// "-- gomigrate: requires-extension postgis".
+	Extensions []string
+
+	// Describes the migration, as declared in a manifest or by the
+	// comments leading the up file. Applied migrations keep the
+	// description recorded with them.
+	Description string
+
+	// Who wrote the migration and the ticket it belongs to, declared in
+	// the up file with "-- gomigrate: author=jane ticket=PROJ-123".
+	// Applied migrations keep those recorded with them.
+	Author string
+	Ticket string
+
+	// Runs the statements one at a time outside of a transaction,
+	// declared in the up file with "-- gomigrate: no-transaction".
+	NoTransaction bool
+
+	// Builds the indexes of the up file concurrently, declared with
+	// "-- gomigrate: online-index", which implies no-transaction.
+	OnlineIndex bool
+
+	// Applies the single statement of the up file in chunks of keys,
+	// declared with "-- gomigrate: backfill table=orders key=id", which
+	// implies no-transaction.
+	Backfill *Backfill
+
+	// Limits the pace of the statements, declared in the up file with
+	// "-- gomigrate: throttle delay=100ms rows=5000 max-lag=10s".
+	Throttle *Throttle
+
+	// When the migration is applied during a deploy: PhaseSchema before
+	// the application is rolled out, PhaseData after, declared in the up
+	// file with "-- gomigrate: phase=data", or PhasePost once it is
+	// rolled out, for migrations of the post directory.
+	Phase string
+
+	// The name of the window of Migrator.Windows the migration is
+	// deferred to, declared in the up file with
+	// "-- gomigrate: window=off-peak".
+	Window string
+
+	// Cancels the migration transaction when it runs longer, declared
+	// in the up file with "-- gomigrate: timeout=30s".
+	Timeout time.Duration
+
+	// Every directive of the up file, including options unknown to
+	// gomigrate.
+	Directives Directives
+
+	// The namespace of the directory the migration was found in, when
+	// it comes from one of FileMigrationSource.Dirs.
+	Namespace string
 }
 
 // Performs a basic validation of a migration.
 func (m *Migration) valid() bool {
-	if m.Id != 0 && m.Name != "" && m.UpPath != "" && m.DownPath != "" {
+	if m.Id != 0 && m.Name != "" && m.UpPath != "" && (m.DownPath != "" || m.Irreversible) {
 		return true
 	}
 	return false
 }
 
+// Reads the directives and description declared in each migration's up
+// file.
+func (m *Migrator) loadDirectives(migrations map[uint64]*Migration) error {
+	for _, migration := range migrations {
+		sql, err := m.readMigrationFile(migration.UpPath)
+		if err != nil {
+			return err
+		}
+		if migration.Description == "" {
+			migration.Description = parseDescription(string(sql))
+		}
+		if err := applyDirectives(migration, ParseDirectives(string(sql))); err != nil {
+			m.warnf("Invalid directive in %s: %v", migration.UpPath, err)
+			return InvalidMigrationFile
+		}
+	}
+	return nil
+}
+
 type MigrationSource interface {
 	// Finds the migrations.
 	//
@@ -38,60 +162,247 @@ type MigrationSource interface {
 
 type FileMigrationSource struct {
 	Dir string
+
+	// Accept migrations without a down file. They are marked as
+	// irreversible and can't be rolled back.
+	AllowMissingDown bool
+
+	// Decrypts migration files ending in .sql.enc.
+	KeyProvider KeyProvider
+
+	// Reads up files from an up directory and down files from a down
+	// directory inside Dir, with identical names such as
+	// up/1_add_users.sql and down/1_add_users.sql, instead of names
+	// ending in _up.sql and _down.sql. Files of both layouts are found
+	// either way; migrations created or squashed use the layout set.
+	SplitDirs bool
+
+	// Finds migration files in the subdirectories of Dir too, so large
+	// projects can group them, such as in 2024/01/12345_add_users_up.sql.
+	// The archive directory is still kept apart.
+	Recursive bool
+
+	// Names of the migration files, when they don't follow the default
+	// 1_add_users_up.sql convention. The split layout only applies to
+	// the default names.
+	Pattern *FilenamePattern
+
+	// Fails with a *MigrationFileError when a .sql file of the
+	// directory can't be parsed, instead of skipping it with a warning,
+	// so misnamed migrations don't go unnoticed.
+	StrictNames bool
+
+	// Creates new migrations in a subdirectory named by formatting the
+	// current time with this layout, such as "2006/01". Requires
+	// Recursive.
+	GroupLayout string
+
+	// More directories merged with Dir into a single sequence ordered
+	// by id, such as one for each module of a monolith. Dir may be left
+	// empty when they are set. Migrations are only created and squashed
+	// in Dir.
+	Dirs []MigrationDir
 }
 
-func (f FileMigrationSource) FindMigrations(logger Logger) (map[uint64]*Migration, error) {
-	// Normalize the migrations path.
-	path := []byte(f.Dir)
-	pathLength := len(path)
-	if path[pathLength-1] != '/' {
-		path = append(path, '/')
-	}
+// A directory of migrations merged with others by FileMigrationSource.
+type MigrationDir struct {
+	// Names the directory in conflicts and in the Namespace of its
+	// migrations.
+	Namespace string
 
-	logger.Printf("Migrations path: %s", path)
-	pathGlob := append([]byte(f.Dir), []byte("*")...)
+	Dir string
 
-	matches, err := filepath.Glob(string(pathGlob))
-	if err != nil {
-		logger.Fatalf("Error while globbing migrations: %v", err)
+	// Added to the ids of the directory's files, giving each directory
+	// its own id prefix so modules can number their migrations from 1:
+	// with an offset of 1000000, 1_add_invoices_up.sql has id 1000001.
+	IdOffset uint64
+}
+
+// Returned when migrations of different directories of a
+// FileMigrationSource end up with the same id.
+type NamespaceConflict struct {
+	Id uint64
+
+	// The namespaces of the directories using the id, empty for Dir,
+	// and the up files, in the order the directories were read.
+	Namespaces []string
+	Paths      []string
+}
+
+func (n *NamespaceConflict) Error() string {
+	uses := make([]string, len(n.Paths))
+	for i, path := range n.Paths {
+		namespace := n.Namespaces[i]
+		if namespace == "" {
+			namespace = "default"
+		}
+		uses[i] = fmt.Sprintf("%s (%s)", path, namespace)
 	}
-	ms := make(map[uint64]*Migration)
-	for _, match := range matches {
-		num, migrationType, name, err := parseMigrationPath(filepath.Base(match))
+	return fmt.Sprintf("Migration id %d used in several namespaces: %s", n.Id, strings.Join(uses, ", "))
+}
+
+func (f FileMigrationSource) FindMigrations(logger Logger) (map[uint64]*Migration, error) {
+	migrations := make(map[uint64]*Migration)
+	if f.Dir != "" || len(f.Dirs) == 0 {
+		found, err := f.findMigrations(f.Dir, logger)
 		if err != nil {
-			logger.Printf("Invalid migration file found: %s", match)
-			continue
+			return found, err
 		}
+		migrations = found
+	}
 
-		logger.Printf("Migration file found: %s", match)
-
-		migration, ok := ms[num]
-		if !ok {
-			migration = &Migration{Id: num, Name: name, Status: Inactive}
-			ms[num] = migration
+	for _, dir := range f.Dirs {
+		found, err := f.findMigrations(dir.Dir, logger)
+		if err != nil {
+			return migrations, err
 		}
-		if migrationType == upMigration {
-			migration.UpPath = match
-		} else {
-			migration.DownPath = match
+		ids := make([]uint64, 0, len(found))
+		for id := range found {
+			ids = append(ids, id)
+		}
+		sort.Sort(uint64slice(ids))
+		for _, id := range ids {
+			migration := found[id]
+			migration.Id += dir.IdOffset
+			migration.Namespace = dir.Namespace
+			if existing, ok := migrations[migration.Id]; ok {
+				conflict := &NamespaceConflict{
+					Id:         migration.Id,
+					Namespaces: []string{existing.Namespace, migration.Namespace},
+					Paths:      []string{existing.UpPath, migration.UpPath},
+				}
+				logf(logger, LevelWarn, "%v", conflict)
+				return migrations, conflict
+			}
+			migrations[migration.Id] = migration
 		}
 	}
+	return migrations, nil
+}
 
-	// Validate each migration.
-	for _, migration := range ms {
-		if !migration.valid() {
-			path := migration.UpPath
-			if path == "" {
-				path = migration.DownPath
+// Finds the migrations of a single directory, including its manifest
+// and archive.
+func (f FileMigrationSource) findMigrations(dir string, logger Logger) (map[uint64]*Migration, error) {
+	logf(logger, LevelDebug, "Migrations path: %s", dir)
+	matches, err := migrationFiles(dir, f.SplitDirs, f.Recursive)
+	if err != nil {
+		logf(logger, LevelError, "Error while listing migrations: %v", err)
+		return nil, err
+	}
+	if f.StrictNames {
+		for _, match := range matches {
+			if _, ok := trimSqlExtension(filepath.Base(match)); !ok {
+				continue
+			}
+			if _, _, _, err := f.Pattern.parse(match); err != nil {
+				logf(logger, LevelError, "%v", err)
+				return nil, err
 			}
-			logger.Printf("Invalid migration pair for path: %s", path)
-			return ms, InvalidMigrationPair
 		}
 	}
+	var migrations map[uint64]*Migration
+	if manifest := findManifest(matches); manifest != "" {
+		migrations, err = loadManifest(manifest, matches, f.AllowMissingDown, f.Pattern, logger)
+	} else {
+		migrations, err = collectMigrations(matches, f.AllowMissingDown, f.Pattern, logger)
+	}
+	if err != nil {
+		return migrations, err
+	}
+	markPost(migrations, dir)
+	return migrations, addArchived(migrations, filepath.Join(dir, archiveDirName), f, logger)
+}
+
+// Returns the path of a migration file in the layout of the source.
+func (f FileMigrationSource) migrationPath(id uint64, name string, mType migrationType) string {
+	if f.Pattern != nil {
+		return filepath.Join(f.Dir, f.Pattern.format(id, name, mType))
+	}
+	if f.SplitDirs {
+		return filepath.Join(f.Dir, string(mType), fmt.Sprintf("%d_%s.sql", id, name))
+	}
+	return filepath.Join(f.Dir, fmt.Sprintf("%d_%s_%s.sql", id, name, mType))
+}
 
-	logger.Printf("Migrations file pairs found: %v", len(ms))
+// Returns the path of a new migration file, in the subdirectory named
+// by GroupLayout when it is set.
+func (f FileMigrationSource) newMigrationPath(id uint64, name string, mType migrationType, now time.Time) string {
+	path := f.migrationPath(id, name, mType)
+	if f.GroupLayout == "" {
+		return path
+	}
+	rel, err := filepath.Rel(f.Dir, path)
+	if err != nil {
+		return path
+	}
+	return filepath.Join(f.Dir, now.Format(f.GroupLayout), rel)
+}
 
-	return ms, nil
+// Returns the files of a migrations directory in lexical order, along
+// with those of its post directory, of the up and down directories of
+// both when split is set, or of all its subdirectories but the archive
+// directory when recursive is set. The directory is walked rather than
+// globbed, so its path may end with a separator or contain glob
+// metacharacters. A missing directory has none.
+func migrationFiles(dir string, split, recursive bool) ([]string, error) {
+	dir = filepath.Clean(dir)
+	archive := filepath.Join(dir, archiveDirName)
+	post := filepath.Join(dir, postDirName)
+	files := make([]string, 0)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir && errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !entry.IsDir() {
+			files = append(files, path)
+			return nil
+		}
+		switch {
+		case path == dir, path == post:
+			return nil
+		case recursive && path != archive:
+			return nil
+		case split && (filepath.Dir(path) == dir || filepath.Dir(path) == post) && isTypeDir(entry.Name()):
+			return nil
+		}
+		return filepath.SkipDir
+	})
+	return files, err
+}
+
+// Returns true if name is the name of an up or down directory.
+func isTypeDir(name string) bool {
+	return name == string(upMigration) || name == string(downMigration)
+}
+
+// Adds the migrations of an archive directory, which count as applied
+// by a baseline and are never run or rolled back, but are still
+// validated along with the others. Migrations of the directory itself
+// take precedence over archived ones with the same id, such as the
+// baseline of a squash.
+func addArchived(migrations map[uint64]*Migration, dir string, source FileMigrationSource, logger Logger) error {
+	matches, err := migrationFiles(dir, source.SplitDirs, source.Recursive)
+	if err != nil || len(matches) == 0 {
+		return err
+	}
+	logf(logger, LevelDebug, "Archived migrations path: %s", dir)
+	archived, err := collectMigrations(matches, true, source.Pattern, logger)
+	if err != nil {
+		return err
+	}
+	for id, migration := range archived {
+		if _, ok := migrations[id]; ok {
+			continue
+		}
+		migration.Archived = true
+		migration.Irreversible = true
+		migration.Status = Active
+		migrations[id] = migration
+	}
+	return nil
 }
 
 type AssetMigrationSource struct {
@@ -103,6 +414,17 @@ type AssetMigrationSource struct {
 
 	// Path in the bindata to use.
 	Dir string
+
+	// Accept migrations without a down file. They are marked as
+	// irreversible and can't be rolled back.
+	AllowMissingDown bool
+
+	// Decrypts migration files ending in .sql.enc.
+	KeyProvider KeyProvider
+
+	// Names of the migration files, when they don't follow the default
+	// 1_add_users_up.sql convention.
+	Pattern *FilenamePattern
 }
 
 func (a AssetMigrationSource) FindMigrations(logger Logger) (map[uint64]*Migration, error) {
@@ -111,41 +433,118 @@ func (a AssetMigrationSource) FindMigrations(logger Logger) (map[uint64]*Migrati
 		return nil, err
 	}
 
+	return collectMigrations(files, a.AllowMissingDown, a.Pattern, logger)
+}
+
+// Groups migration files into migrations by id and validates that each
+// migration has exactly one name and a pair of files, or only an up
+// file when allowMissingDown is set. File names are parsed with
+// pattern, or the default names when it is nil.
+func collectMigrations(files []string, allowMissingDown bool, pattern *FilenamePattern, logger Logger) (map[uint64]*Migration, error) {
 	ms := make(map[uint64]*Migration)
-	for _, match := range files {
-		num, migrationType, name, err := parseMigrationPath(match)
+	var duplicate *DuplicateMigrationId
+	var highest uint64
+	for _, file := range files {
+		num, migrationType, name, err := pattern.parse(file)
 		if err != nil {
-			logger.Printf("Invalid migration file found: %s", match)
+			if _, ok := trimSqlExtension(filepath.Base(file)); ok {
+				logf(logger, LevelWarn, "%v, skipping it", err)
+			} else {
+				logf(logger, LevelDebug, "Ignoring file: %s", file)
+			}
 			continue
 		}
 
-		logger.Printf("Migration file found: %s", match)
+		logf(logger, LevelDebug, "Migration file found: %s", file)
+
+		if num > highest {
+			highest = num
+		}
 
 		migration, ok := ms[num]
 		if !ok {
 			migration = &Migration{Id: num, Name: name, Status: Inactive}
 			ms[num] = migration
 		}
+		if migration.Name != name {
+			logf(logger, LevelWarn, "Duplicate migration id %d found: %s", num, file)
+			if duplicate == nil {
+				duplicate = &DuplicateMigrationId{Id: num, Name: migration.Name}
+				for _, path := range []string{migration.UpPath, migration.DownPath} {
+					if path != "" {
+						duplicate.Paths = append(duplicate.Paths, path)
+					}
+				}
+			}
+			if duplicate.Id == num {
+				duplicate.Paths = append(duplicate.Paths, file)
+			}
+			continue
+		}
 		if migrationType == upMigration {
-			migration.UpPath = match
+			migration.UpPath = file
 		} else {
-			migration.DownPath = match
+			migration.DownPath = file
 		}
 	}
+	if duplicate != nil {
+		duplicate.NextId = highest + 1
+		return ms, duplicate
+	}
 
 	// Validate each migration.
 	for _, migration := range ms {
+		if allowMissingDown && migration.DownPath == "" {
+			logf(logger, LevelWarn, "Migration has no down file: %s", migration.UpPath)
+			migration.Irreversible = true
+		}
 		if !migration.valid() {
 			path := migration.UpPath
 			if path == "" {
 				path = migration.DownPath
 			}
-			logger.Printf("Invalid migration pair for path: %s", path)
+			logf(logger, LevelWarn, "Invalid migration pair for path: %s", path)
 			return ms, InvalidMigrationPair
 		}
 	}
 
-	logger.Printf("Migrations file pairs found: %v", len(ms))
+	logf(logger, LevelDebug, "Migrations file pairs found: %v", len(ms))
 
 	return ms, nil
 }
+
+// Returned when files with different names share a migration id.
+type DuplicateMigrationId struct {
+	Id uint64
+
+	// The name that was found first and keeps the id.
+	Name string
+
+	// All files using the id.
+	Paths []string
+
+	// The lowest id not used by any migration.
+	NextId uint64
+}
+
+func (d *DuplicateMigrationId) Error() string {
+	return fmt.Sprintf(
+		"Duplicate migration id %d used by: %s",
+		d.Id,
+		strings.Join(d.Paths, ", "),
+	)
+}
+
+// Suggests new paths for the files that don't keep the id, renumbering
+// them to NextId.
+func (d *DuplicateMigrationId) Renames() map[string]string {
+	renames := make(map[string]string)
+	for _, path := range d.Paths {
+		_, _, name, err := parseMigrationFile(path)
+		if err != nil || name == d.Name {
+			continue
+		}
+		renames[path] = renumberedPath(path, d.NextId)
+	}
+	return renames
+}
@@ -0,0 +1,130 @@
+// Notifies external services about migration runs.
+
+package gomigrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Migration run events.
+const (
+	RunStarted   = "started"
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+)
+
+// Describes a migration run for notifiers.
+type Notification struct {
+	Event     string    `json:"event"`
+	Direction string    `json:"direction"`
+	Time      time.Time `json:"time"`
+
+	// Number of migrations the run applies and how many of them were
+	// applied so far.
+	Pending int `json:"pending"`
+	Applied int `json:"applied"`
+
+	// The migration that failed and why, for failed runs.
+	Failed *Migration `json:"failed,omitempty"`
+	Error  string     `json:"error,omitempty"`
+
+	Application string `json:"application"`
+	Host        string `json:"host"`
+}
+
+// Receives notifications about migration runs.
+type Notifier interface {
+	Notify(n *Notification) error
+}
+
+// Sends notifications to the notifier. Notifier errors are logged
+// without failing the run.
+func (m *Migrator) notify(n *Notification) {
+	if m.Notifier == nil {
+		return
+	}
+	audit := currentAuditInfo(m.ApplicationName)
+	n.Time = m.now()
+	n.Application = audit.Application
+	n.Host = audit.Host
+	if err := m.Notifier.Notify(n); err != nil {
+		m.errorf("Error sending notification: %v", err)
+	}
+}
+
+// Posts notifications as JSON to a URL.
+type WebhookNotifier struct {
+	URL string
+
+	// Defaults to a client with a ten second timeout.
+	Client *http.Client
+}
+
+func (w *WebhookNotifier) Notify(n *Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	return post(w.Client, w.URL, body)
+}
+
+// Posts notifications to a Slack incoming webhook.
+type SlackNotifier struct {
+	WebhookURL string
+
+	// Only notify about failed runs.
+	FailuresOnly bool
+
+	// Defaults to a client with a ten second timeout.
+	Client *http.Client
+}
+
+func (s *SlackNotifier) Notify(n *Notification) error {
+	if s.FailuresOnly && n.Event != RunFailed {
+		return nil
+	}
+
+	var text string
+	switch n.Event {
+	case RunStarted:
+		text = fmt.Sprintf("Migrating %s: %d migrations on %s", n.Direction, n.Pending, n.Host)
+	case RunSucceeded:
+		text = fmt.Sprintf(":white_check_mark: Migrated %s: %d migrations on %s", n.Direction, n.Applied, n.Host)
+	case RunFailed:
+		text = fmt.Sprintf(
+			":x: Migration %d_%s failed on %s after %d of %d migrations: %s",
+			n.Failed.Id,
+			n.Failed.Name,
+			n.Host,
+			n.Applied,
+			n.Pending,
+			n.Error,
+		)
+	}
+
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	return post(s.Client, s.WebhookURL, body)
+}
+
+// Posts a JSON body, failing on non-2xx responses.
+func post(client *http.Client, url string, body []byte) error {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Notification to %s failed: %s", url, resp.Status)
+	}
+	return nil
+}
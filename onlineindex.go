@@ -0,0 +1,123 @@
+// Builds the indexes of online-index migrations concurrently.
+
+package gomigrate
+
+import (
+	"database/sql"
+	"regexp"
+	"time"
+)
+
+// Implemented by adapters that can build indexes without blocking
+// writes, as Postgres does with CREATE INDEX CONCURRENTLY.
+type IndexBuilder interface {
+	// Returns a query selecting whether the index named by its single
+	// parameter is valid, as a single boolean, or no rows when it
+	// doesn't exist.
+	IndexValidSql() string
+
+	// Returns a statement dropping an index without blocking writes.
+	DropIndexSql(name string) string
+
+	// Returns a query selecting the phase, blocks done and total blocks
+	// of the index builds on the table named by its single parameter.
+	IndexProgressSql() string
+}
+
+// How often the progress of indexes built by online-index migrations is
+// logged.
+var IndexPollInterval = 10 * time.Second
+
+// Matches CREATE INDEX statements, with the index and table names.
+var concurrentIndex = regexp.MustCompile(`(?i)^(\s*CREATE\s+(?:UNIQUE\s+)?INDEX\s+)(CONCURRENTLY\s+)?(IF\s+NOT\s+EXISTS\s+)?([\w$."]+)\s+ON\s+(?:ONLY\s+)?([\w$."]+)`)
+
+// Builds an index of an online-index migration: CONCURRENTLY is added
+// when missing, an invalid index left by an earlier attempt is dropped
+// first, the progress of the build is logged, and the statement fails
+// with InvalidIndex unless the index is valid once built. Other
+// statements are executed as they are.
+func (m *run) execOnlineIndex(transaction TxExecutor, migration *Migration, statement string) (sql.Result, error) {
+	builder, ok := m.dbAdapter.(IndexBuilder)
+	if !ok {
+		return nil, IndexBuildUnsupported
+	}
+	stripped := lineComment.ReplaceAllString(statement, "")
+	match := concurrentIndex.FindStringSubmatchIndex(stripped)
+	if match == nil {
+		return transaction.Exec(statement)
+	}
+	index, table := stripped[match[8]:match[9]], stripped[match[10]:match[11]]
+	if match[4] < 0 {
+		statement = stripped[:match[3]] + "CONCURRENTLY " + stripped[match[3]:]
+	}
+
+	valid, err := m.indexValid(builder, index)
+	if err != nil {
+		return nil, err
+	}
+	if valid != nil && !*valid {
+		m.warnf("Dropping invalid index %s left by an earlier attempt of migration %d", index, migration.Id)
+		if _, err := transaction.Exec(builder.DropIndexSql(index)); err != nil {
+			m.errorf("Error dropping invalid index %s: %v", index, err)
+			return nil, err
+		}
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go m.logIndexProgress(builder, migration, table, done)
+	result, err := transaction.Exec(statement)
+	if err != nil {
+		return nil, err
+	}
+
+	if valid, err = m.indexValid(builder, index); err != nil {
+		return nil, err
+	}
+	if valid != nil && !*valid {
+		m.errorf("Index %s of migration %d was built but is invalid", index, migration.Id)
+		return nil, InvalidIndex
+	}
+	return result, nil
+}
+
+// Returns whether an index is valid, or nil when it doesn't exist.
+func (m *run) indexValid(builder IndexBuilder, index string) (*bool, error) {
+	var valid bool
+	err := m.executor.QueryRow(builder.IndexValidSql(), index).Scan(&valid)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		m.errorf("Error checking index %s: %v", index, err)
+		return nil, err
+	}
+	return &valid, nil
+}
+
+// Logs the progress of the index builds on a table every
+// IndexPollInterval until done is closed.
+func (m *run) logIndexProgress(builder IndexBuilder, migration *Migration, table string, done chan struct{}) {
+	ticker := time.NewTicker(IndexPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		var phase string
+		var blocksDone, blocksTotal int64
+		if err := m.executor.QueryRow(builder.IndexProgressSql(), table).Scan(&phase, &blocksDone, &blocksTotal); err != nil {
+			if err != sql.ErrNoRows {
+				m.debugf("Error reading the progress of the index of migration %d: %v", migration.Id, err)
+			}
+			continue
+		}
+		if blocksTotal > 0 {
+			m.infof("Index of migration %d on %s: %s, %d%% of blocks", migration.Id, table, phase, blocksDone*100/blocksTotal)
+		} else {
+			m.infof("Index of migration %d on %s: %s", migration.Id, table, phase)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Runs independent migrations concurrently.
+
+package gomigrate
+
+import (
+	"context"
+)
+
+// Applies pending migrations with up to Parallelism of them running at
+// once, starting each migration as soon as its dependencies are
+// applied. No new migrations are started after a failure or once ctx
+// is done, but running ones are allowed to finish. Returns the number
+// of migrations applied and the migration that failed.
+func (m *run) applyParallel(ctx context.Context, migrations []*Migration) (int, *Migration, error) {
+	type result struct {
+		migration *Migration
+		err       error
+	}
+
+	// Migrations of this run that haven't finished, and those that
+	// haven't started. Dependencies outside of the run are applied, as
+	// migrations depending on unselected ones aren't selected.
+	unfinished := make(map[uint64]bool)
+	pending := make(map[uint64]bool)
+	for _, migration := range migrations {
+		unfinished[migration.Id] = true
+		pending[migration.Id] = true
+	}
+
+	results := make(chan result)
+	running, applied := 0, 0
+	var failed *Migration
+	var firstErr error
+	for {
+		// Start every migration whose dependencies are applied.
+		lowest := lowestId(unfinished)
+		for _, migration := range migrations {
+			if firstErr != nil || running >= m.Parallelism {
+				break
+			}
+			if !pending[migration.Id] || !dependenciesApplied(migration, unfinished, lowest) {
+				continue
+			}
+			if err := interrupted(ctx); err != nil {
+				m.warnf("Interrupted before migration: %d", migration.Id)
+				failed, firstErr = migration, err
+				break
+			}
+			delete(pending, migration.Id)
+			running++
+			go func(migration *Migration) {
+				results <- result{migration, m.applyWithRetry(ctx, migration, upMigration)}
+			}(migration)
+		}
+
+		if running == 0 {
+			if firstErr == nil && len(pending) > 0 {
+				m.warnf("Dependencies of pending migrations can't be satisfied")
+				firstErr = DependencyCycle
+			}
+			return applied, failed, firstErr
+		}
+
+		r := <-results
+		running--
+		if r.err != nil {
+			if firstErr == nil {
+				failed, firstErr = r.migration, r.err
+			}
+			continue
+		}
+		delete(unfinished, r.migration.Id)
+		applied++
+	}
+}
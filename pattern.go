@@ -0,0 +1,80 @@
+// Parses and formats migration file names following other conventions.
+
+package gomigrate
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// Describes the names of migration files, for teams whose files follow
+// another convention than 1_add_users_up.sql. Files keep their
+// .sql.tmpl and .sql.enc extensions for templates and encryption, which
+// the expressions must accept.
+type FilenamePattern struct {
+	// Match the base names of up and down files, with the id as the
+	// first group and the name as the second.
+	Up   *regexp.Regexp
+	Down *regexp.Regexp
+
+	// Formats the base names of new files from the id and name, such as
+	// "%04d_%s.up.sql".
+	UpFormat   string
+	DownFormat string
+}
+
+// Filename patterns of other tools.
+var (
+	// 0001_add_users.up.sql and 0001_add_users.down.sql, as written by
+	// golang-migrate.
+	DottedPattern = &FilenamePattern{
+		Up:         regexp.MustCompile(`^(\d+)_([\w-]+)\.up\.sql(\.tmpl|\.enc)?$`),
+		Down:       regexp.MustCompile(`^(\d+)_([\w-]+)\.down\.sql(\.tmpl|\.enc)?$`),
+		UpFormat:   "%04d_%s.up.sql",
+		DownFormat: "%04d_%s.down.sql",
+	}
+
+	// V1__add_users.sql and U1__add_users.sql, the versioned and undo
+	// migrations of Flyway.
+	FlywayPattern = &FilenamePattern{
+		Up:         regexp.MustCompile(`^V(\d+)__(\w+)\.sql(\.tmpl|\.enc)?$`),
+		Down:       regexp.MustCompile(`^U(\d+)__(\w+)\.sql(\.tmpl|\.enc)?$`),
+		UpFormat:   "V%d__%s.sql",
+		DownFormat: "U%d__%s.sql",
+	}
+)
+
+// Returns the id, type and name of a migration file. A nil pattern
+// parses the default names and the split layout.
+func (p *FilenamePattern) parse(path string) (uint64, migrationType, string, error) {
+	if p == nil {
+		return parseMigrationFile(path)
+	}
+	base := filepath.Base(path)
+	for _, mType := range []migrationType{upMigration, downMigration} {
+		expression := p.Up
+		if mType == downMigration {
+			expression = p.Down
+		}
+		matches := expression.FindStringSubmatch(base)
+		if len(matches) < 3 {
+			continue
+		}
+		id, err := strconv.ParseUint(matches[1], 10, 64)
+		if err != nil {
+			return 0, "", "", err
+		}
+		return id, mType, matches[2], nil
+	}
+	return 0, "", "", &MigrationFileError{path, "name doesn't match the filename pattern"}
+}
+
+// Returns the base name of a new migration file.
+func (p *FilenamePattern) format(id uint64, name string, mType migrationType) string {
+	if mType == upMigration {
+		return fmt.Sprintf(p.UpFormat, id, name)
+	}
+	return fmt.Sprintf(p.DownFormat, id, name)
+}
@@ -0,0 +1,136 @@
+// Package pgxexecutor runs gomigrate migrations over pgx connections,
+// so applications using pgx don't need a database/sql connection pool
+// just for migrations.
+//
+//	pool, _ := pgxpool.New(ctx, "postgres://localhost/app")
+//	migrator, _ := gomigrate.NewMigratorWithExecutor(
+//		pgxexecutor.New(pool),
+//		gomigrate.Postgres{},
+//		&gomigrate.FileMigrationSource{Dir: "./migrations"},
+//		logger,
+//	)
+package pgxexecutor
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/DavidHuie/gomigrate"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// The subset of pgx used by the executor. *pgxpool.Pool, *pgx.Conn and
+// pgx.Tx implement it; transactions begun on a pgx.Tx are savepoints.
+// Transaction options are applied with SET TRANSACTION.
+type Conn interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// Returns an executor running statements through conn.
+func New(conn Conn) gomigrate.DBExecutor {
+	return &executor{conn}
+}
+
+type executor struct {
+	conn Conn
+}
+
+func (e *executor) Exec(query string, args ...interface{}) (sql.Result, error) {
+	tag, err := e.conn.Exec(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return result(tag), nil
+}
+
+func (e *executor) QueryRow(query string, args ...interface{}) gomigrate.Row {
+	return row{e.conn.QueryRow(context.Background(), query, args...)}
+}
+
+func (e *executor) BeginTx(opts *sql.TxOptions) (gomigrate.TxExecutor, error) {
+	tx, err := e.conn.Begin(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if opts != nil {
+		if err := setTxOptions(tx, opts); err != nil {
+			tx.Rollback(context.Background())
+			return nil, err
+		}
+	}
+	return &transaction{tx}, nil
+}
+
+var isolationLevels = map[sql.IsolationLevel]string{
+	sql.LevelReadUncommitted: "READ UNCOMMITTED",
+	sql.LevelReadCommitted:   "READ COMMITTED",
+	sql.LevelRepeatableRead:  "REPEATABLE READ",
+	sql.LevelSerializable:    "SERIALIZABLE",
+}
+
+// Applies database/sql transaction options to a pgx transaction.
+func setTxOptions(tx pgx.Tx, opts *sql.TxOptions) error {
+	if opts.Isolation != sql.LevelDefault {
+		level, ok := isolationLevels[opts.Isolation]
+		if !ok {
+			return errors.New("Unsupported isolation level: " + opts.Isolation.String())
+		}
+		if _, err := tx.Exec(context.Background(), "SET TRANSACTION ISOLATION LEVEL "+level); err != nil {
+			return err
+		}
+	}
+	if opts.ReadOnly {
+		if _, err := tx.Exec(context.Background(), "SET TRANSACTION READ ONLY"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type transaction struct {
+	tx pgx.Tx
+}
+
+func (t *transaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	tag, err := t.tx.Exec(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return result(tag), nil
+}
+
+func (t *transaction) Commit() error {
+	return t.tx.Commit(context.Background())
+}
+
+func (t *transaction) Rollback() error {
+	return t.tx.Rollback(context.Background())
+}
+
+// Translates pgx.ErrNoRows into sql.ErrNoRows.
+type row struct {
+	row pgx.Row
+}
+
+func (r row) Scan(dest ...interface{}) error {
+	err := r.row.Scan(dest...)
+	if err == pgx.ErrNoRows {
+		return sql.ErrNoRows
+	}
+	return err
+}
+
+// Exposes a pgx command tag as a sql.Result.
+type result pgconn.CommandTag
+
+func (r result) LastInsertId() (int64, error) {
+	return 0, errors.New("LastInsertId is not supported by pgx")
+}
+
+func (r result) RowsAffected() (int64, error) {
+	return pgconn.CommandTag(r).RowsAffected(), nil
+}
@@ -0,0 +1,156 @@
+// Splits migrations into phases applied at different times of a deploy.
+
+package gomigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Phases of migrations, declared in the up file with
+// "-- gomigrate: phase=data".
+const (
+	// Fast schema changes applied before the application is deployed,
+	// the phase of migrations that don't declare one.
+	PhaseSchema = "schema"
+
+	// Heavy data changes applied once the application is deployed, the
+	// phase of backfill migrations that don't declare one.
+	PhaseData = "data"
+
+	// Changes deferred until the application is rolled out, such as
+	// dropping columns the previous version still uses, the phase of
+	// the migrations of the post directory. Only MigratePost and runs
+	// selecting the phase with WithPhase apply them.
+	PhasePost = "post"
+)
+
+// The phases migrations can declare.
+var phases = []string{PhaseSchema, PhaseData, PhasePost}
+
+// The subdirectory of a FileMigrationSource directory holding the
+// migrations of PhasePost.
+const postDirName = "post"
+
+// Implemented by adapters that can record the phase of applied
+// migrations.
+type MigrationPhaseRecorder interface {
+	// Sets phase for a migration id.
+	MigrationPhaseUpdateSql() string
+
+	// Selects phase for a migration id.
+	MigrationPhaseSelectSql() string
+}
+
+// Returns the phase declared by the directives of a migration, or its
+// default phase.
+func parsePhase(directives Directives, backfill *Backfill) (string, error) {
+	if !directives.Has("phase") {
+		if backfill != nil {
+			return PhaseData, nil
+		}
+		return PhaseSchema, nil
+	}
+	phase := directives.Get("phase")
+	for _, known := range phases {
+		if phase == known {
+			return phase, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown phase %q, expected one of %v", phase, phases)
+}
+
+// Only applies migrations of the phases.
+func WithPhase(phases ...string) RunOption {
+	return func(c *runConfig) {
+		c.phases = append(c.phases, phases...)
+	}
+}
+
+// Returns the phase of a migration, PhaseSchema for migrations not
+// read from files.
+func phaseOf(migration *Migration) string {
+	if migration.Phase == "" {
+		return PhaseSchema
+	}
+	return migration.Phase
+}
+
+// Returns true if the migration belongs to one of the phases, or when
+// no phases are given to any phase but PhasePost.
+func inPhase(migration *Migration, phases []string) bool {
+	if len(phases) == 0 {
+		return phaseOf(migration) != PhasePost
+	}
+	for _, phase := range phases {
+		if phaseOf(migration) == phase {
+			return true
+		}
+	}
+	return false
+}
+
+// Applies the pending schema migrations, as deploys do before rolling
+// out the application. Data migrations are left pending, and schema
+// migrations depending on them are skipped.
+func (m *Migrator) MigrateSchema(options ...RunOption) error {
+	return m.Migrate(append(options, WithPhase(PhaseSchema))...)
+}
+
+// Applies the pending data migrations, as deploys do once the
+// application is rolled out.
+func (m *Migrator) MigrateData(options ...RunOption) error {
+	return m.Migrate(append(options, WithPhase(PhaseData))...)
+}
+
+// Applies the pending migrations of the post directory, deferred until
+// the application is rolled out. Migrate leaves them pending.
+func (m *Migrator) MigratePost(options ...RunOption) error {
+	return m.Migrate(append(options, WithPhase(PhasePost))...)
+}
+
+// Sets the phase of the migrations whose up file is in the post
+// directory of dir.
+func markPost(migrations map[uint64]*Migration, dir string) {
+	post := filepath.Join(filepath.Clean(dir), postDirName) + string(filepath.Separator)
+	for _, migration := range migrations {
+		if strings.HasPrefix(migration.UpPath, post) {
+			migration.Phase = PhasePost
+		}
+	}
+}
+
+// Records the phase of an applied migration in the transaction it runs
+// in.
+func (m *Migrator) recordPhase(transaction TxExecutor, migration *Migration) error {
+	recorder, ok := m.dbAdapter.(MigrationPhaseRecorder)
+	if !ok || m.tableVersion < 11 {
+		return nil
+	}
+	if _, err := transaction.Exec(recorder.MigrationPhaseUpdateSql(), phaseOf(migration), migration.Id); err != nil {
+		m.errorf("Error logging migration: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Loads the recorded phase of an applied migration. Migrations applied
+// before phases were recorded keep the phase declared in their files.
+func (m *run) getMigrationPhase(migration *Migration) error {
+	recorder, ok := m.dbAdapter.(MigrationPhaseRecorder)
+	if !ok || m.tableVersion < 11 {
+		return nil
+	}
+
+	var phase sql.NullString
+	if err := m.executor.QueryRow(recorder.MigrationPhaseSelectSql(), migration.Id).Scan(&phase); err != nil {
+		m.errorf("Error getting migration phase for %s: %v", migration.Name, err)
+		return err
+	}
+	if phase.Valid {
+		migration.Phase = phase.String
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+// Plans migration runs so they can be reviewed before they execute.
+
+package gomigrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// A migration a plan runs, with the statements it will execute.
+type PlannedMigration struct {
+	// A copy of the migration when the plan was made.
+	Migration *Migration
+
+	Statements []string
+
+	// False when the statements run one at a time outside of a
+	// transaction, as with adapters implementing NonTransactionalDdl.
+	Transactional bool
+
+	// Size of the statements in bytes.
+	Size int
+
+	// The file the statements are read from.
+	Path string
+
+	// Set in plans going down for migrations that can't be rolled
+	// back, because they are irreversible without ForceRollback or
+	// their down file can't be read. Such plans fail before anything
+	// runs.
+	Irreversible bool
+	Missing      bool
+
+	// Estimates of the statements changing data, as set by
+	// Migrator.Explain.
+	Estimates []*Estimate
+
+	// The table locks its statements take, with adapters implementing
+	// LockAnalyzer.
+	Locks []*LockImpact
+}
+
+// An ordered list of migrations to apply or roll back, as returned by
+// Plan and run by ExecutePlan.
+type Plan struct {
+	Direction  migrationType
+	Target     uint64
+	Migrations []*PlannedMigration
+}
+
+// Returns the size of the statements of the plan in bytes.
+func (p *Plan) Size() int {
+	size := 0
+	for _, planned := range p.Migrations {
+		size += planned.Size
+	}
+	return size
+}
+
+// Returns true unless a migration of the plan can't be rolled back.
+func (p *Plan) Runnable() bool {
+	for _, planned := range p.Migrations {
+		if planned.Irreversible || planned.Missing {
+			return false
+		}
+	}
+	return true
+}
+
+// Writes a readable summary of the plan, in the order the migrations
+// run.
+func (p *Plan) Print(w io.Writer) {
+	if len(p.Migrations) == 0 {
+		fmt.Fprintf(w, "Nothing to run %s\n", p.Direction)
+		return
+	}
+	for _, planned := range p.Migrations {
+		switch {
+		case planned.Irreversible:
+			fmt.Fprintf(w, "%s %d %s: irreversible\n", p.Direction, planned.Migration.Id, planned.Migration.Name)
+			continue
+		case planned.Missing:
+			fmt.Fprintf(w, "%s %d %s: missing down file %s\n", p.Direction, planned.Migration.Id, planned.Migration.Name, planned.Path)
+			continue
+		}
+		mode := "transactional"
+		if !planned.Transactional {
+			mode = "non-transactional"
+		}
+		fmt.Fprintf(w, "%s %d %s: %d statements, %d bytes, %s, %s\n",
+			p.Direction, planned.Migration.Id, planned.Migration.Name,
+			len(planned.Statements), planned.Size, mode, planned.Path)
+		for _, lock := range planned.Locks {
+			if lock.BlocksWrites {
+				fmt.Fprintf(w, "  %s: %s\n", abbreviate(lock.Statement, 60), lock)
+			}
+		}
+		for _, estimate := range planned.Estimates {
+			fmt.Fprintf(w, "  %s: %s\n", abbreviate(estimate.Statement, 60), estimate)
+		}
+	}
+}
+
+// Returns the migrations that would run to bring the database to a
+// target migration id. Going up, pending migrations for the environment
+// up to the target are applied, or all of them when the target is 0;
+// options such as WithTags restrict them further. Going down, applied
+// migrations above the target are rolled back.
+func (m *Migrator) Plan(direction migrationType, target uint64, options ...RunOption) (*Plan, error) {
+	if direction != upMigration && direction != downMigration {
+		return nil, InvalidMigrationType
+	}
+	if err := m.initialize(); err != nil {
+		return nil, err
+	}
+
+	var migrations []*Migration
+	if direction == upMigration {
+		for _, migration := range m.selectMigrations(m.Migrations(Inactive), options) {
+			if target == 0 || migration.Id <= target {
+				migrations = append(migrations, migration)
+			}
+		}
+	} else {
+		migrations = appliedAbove(m.rollbackable(), target)
+	}
+	return m.plan(direction, target, migrations)
+}
+
+// Returns the plan rolling back the last n applied migrations, as
+// RollbackN would. Its target is the id of the migration left applied
+// last, or 0 when none are.
+func (m *Migrator) PlanRollback(n int) (*Plan, error) {
+	if err := m.initialize(); err != nil {
+		return nil, err
+	}
+	applied := m.rollbackable()
+	migrations := lastApplied(applied, n)
+	var target uint64
+	if remaining := len(applied) - len(migrations); remaining > 0 {
+		target = applied[remaining-1].Id
+	}
+	return m.plan(downMigration, target, migrations)
+}
+
+// Reads the statements of the migrations of a plan. Going down,
+// irreversible migrations and unreadable down files are flagged instead
+// of failing, so the whole plan can be reviewed.
+func (m *Migrator) plan(direction migrationType, target uint64, migrations []*Migration) (*Plan, error) {
+	_, nonTransactional := m.dbAdapter.(NonTransactionalDdl)
+	plan := &Plan{Direction: direction, Target: target, Migrations: make([]*PlannedMigration, 0)}
+	for _, migration := range migrations {
+		m.mu.RLock()
+		c := *migration
+		m.mu.RUnlock()
+		planned := &PlannedMigration{
+			Migration:     &c,
+			Transactional: !nonTransactional && !migration.NoTransaction,
+			Path:          migration.UpPath,
+		}
+		plan.Migrations = append(plan.Migrations, planned)
+		if direction == downMigration {
+			planned.Path = migration.DownPath
+			if !m.reversible(migration) {
+				planned.Irreversible = true
+				continue
+			}
+		}
+
+		statements, err := m.newRun().migrationCommands(migration, direction)
+		if err != nil && direction == downMigration {
+			m.warnf("Down file of migration %d can't be read: %v", migration.Id, err)
+			planned.Missing = true
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		planned.Statements = statements
+		for _, statement := range statements {
+			planned.Size += len(statement)
+		}
+	}
+	m.analyzeLocks(plan)
+	return plan, nil
+}
+
+// Runs the migrations of a plan. Returns StalePlan without running
+// anything when a migration was applied, rolled back or changed since
+// the plan was made.
+func (m *Migrator) ExecutePlan(plan *Plan) error {
+	return m.ExecutePlanContext(context.Background(), plan)
+}
+
+// Runs the migrations of a plan like ExecutePlan, stopping between
+// migrations once ctx is done.
+func (m *Migrator) ExecutePlanContext(ctx context.Context, plan *Plan) error {
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+	r := m.newRun()
+	if err := r.initialize(); err != nil {
+		return err
+	}
+	release, err := r.acquireLock(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if plan.Direction == upMigration {
+		if err := m.Validate(); err != nil {
+			return err
+		}
+	}
+	if !plan.Runnable() {
+		m.warnf("Plan rolls back migrations that can't be rolled back")
+		return IrreversibleMigration
+	}
+	if err := r.checkWritable(); err != nil {
+		return err
+	}
+
+	expected := Inactive
+	if plan.Direction == downMigration {
+		expected = Active
+	}
+	migrations := make([]*Migration, 0, len(plan.Migrations))
+	for _, planned := range plan.Migrations {
+		m.mu.RLock()
+		migration, ok := m.migrations[planned.Migration.Id]
+		stale := !ok || migration.Status != expected
+		m.mu.RUnlock()
+		if !stale {
+			statements, err := r.migrationCommands(migration, plan.Direction)
+			if err != nil {
+				return err
+			}
+			stale = !equalStatements(statements, planned.Statements)
+		}
+		if stale {
+			m.warnf("Migration %d changed since the plan was made", planned.Migration.Id)
+			return StalePlan
+		}
+		migrations = append(migrations, migration)
+	}
+	_, err = r.runMigrations(ctx, migrations, plan.Direction)
+	return err
+}
+
+func equalStatements(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,152 @@
+// Checks that a database is ready to be migrated.
+
+package gomigrate
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Implemented by adapters that can tell the version of the database
+// server.
+type ServerVersioner interface {
+	// Returns a query selecting the version as a single string.
+	ServerVersionSql() string
+}
+
+// Implemented by adapters for databases with extensions.
+type ExtensionChecker interface {
+	// Returns a query selecting the number of installed extensions
+	// with the name given as its single parameter.
+	ExtensionSql() string
+}
+
+// The outcome of the checks run by Preflight.
+type PreflightReport struct {
+	Connected      bool `json:"connected"`
+	CanCreateTable bool `json:"can_create_table"`
+
+	// Empty when the adapter can't tell the version.
+	ServerVersion string `json:"server_version"`
+
+	// RequiredExtensions that aren't installed, or can't be checked.
+	MissingExtensions []string `json:"missing_extensions"`
+
+	// Describes each failed check.
+	Problems []string `json:"problems"`
+}
+
+// Returns true when every check passed.
+func (r *PreflightReport) Ok() bool {
+	return len(r.Problems) == 0
+}
+
+var versionNumber = regexp.MustCompile(`^\d+(\.\d+)*`)
+
+// Checks that the database is ready to be migrated before any migration
+// runs: the connection works, the user can create tables, the
+// extensions listed in RequiredExtensions are installed and the server
+// version is at least MinServerVersion. The table created by the check
+// is dropped, or rolled back by databases with transactional DDL.
+// Returns the report, with PreflightFailed when any check failed.
+func (m *Migrator) Preflight() (*PreflightReport, error) {
+	report := &PreflightReport{MissingExtensions: make([]string, 0), Problems: make([]string, 0)}
+	fail := func(format string, args ...interface{}) {
+		problem := fmt.Sprintf(format, args...)
+		m.warnf("Preflight check failed: %s", problem)
+		report.Problems = append(report.Problems, problem)
+	}
+
+	var one int
+	if err := m.executor.QueryRow("SELECT 1").Scan(&one); err != nil {
+		fail("can't query the database: %v", err)
+		return report, PreflightFailed
+	}
+	report.Connected = true
+
+	if err := m.checkCreateTable(); err != nil {
+		fail("can't create tables: %v", err)
+	} else {
+		report.CanCreateTable = true
+	}
+
+	if versioner, ok := m.dbAdapter.(ServerVersioner); ok {
+		if err := m.executor.QueryRow(versioner.ServerVersionSql()).Scan(&report.ServerVersion); err != nil {
+			fail("can't read the server version: %v", err)
+		}
+	}
+	if m.MinServerVersion != "" {
+		switch {
+		case report.ServerVersion == "":
+			fail("can't check the server version against %s", m.MinServerVersion)
+		case compareVersions(report.ServerVersion, m.MinServerVersion) < 0:
+			fail("server version %s is older than %s", report.ServerVersion, m.MinServerVersion)
+		}
+	}
+
+	checker, ok := m.dbAdapter.(ExtensionChecker)
+	for _, extension := range m.RequiredExtensions {
+		var count int
+		if !ok {
+			report.MissingExtensions = append(report.MissingExtensions, extension)
+			fail("can't check extension %s", extension)
+		} else if err := m.executor.QueryRow(checker.ExtensionSql(), extension).Scan(&count); err != nil {
+			report.MissingExtensions = append(report.MissingExtensions, extension)
+			fail("can't check extension %s: %v", extension, err)
+		} else if count == 0 {
+			report.MissingExtensions = append(report.MissingExtensions, extension)
+			fail("extension %s is not installed", extension)
+		}
+	}
+
+	if !report.Ok() {
+		return report, PreflightFailed
+	}
+	m.infof("Preflight checks passed")
+	return report, nil
+}
+
+// Creates and drops a table, inside a transaction that is rolled back
+// when the database has transactional DDL.
+func (m *Migrator) checkCreateTable() error {
+	create := "CREATE TABLE gomigrate_preflight (id INTEGER)"
+	if !capabilities(m.dbAdapter).Has(TransactionalDdl) {
+		if _, err := m.executor.Exec(create); err != nil {
+			return err
+		}
+		_, err := m.executor.Exec("DROP TABLE gomigrate_preflight")
+		return err
+	}
+	transaction, err := m.executor.BeginTx(nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback()
+	_, err = transaction.Exec(create)
+	return err
+}
+
+// Compares the leading numbers of two dotted versions such as
+// "15.3 (Debian 15.3-1)" and "8.0.33-log", returning -1, 0 or 1.
+func compareVersions(a, b string) int {
+	as := strings.Split(versionNumber.FindString(strings.TrimSpace(a)), ".")
+	bs := strings.Split(versionNumber.FindString(strings.TrimSpace(b)), ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
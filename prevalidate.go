@@ -0,0 +1,107 @@
+// Checks every pending migration before any is applied.
+
+package gomigrate
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Implemented by adapters that can check the syntax of a statement
+// without running it, such as by explaining it.
+type StatementChecker interface {
+	// Returns a statement checking the syntax of statement, or an empty
+	// string when it can't be checked. It runs in a transaction that is
+	// rolled back.
+	CheckStatementSql(statement string) string
+
+	// Returns true when err, returned by the check, reports invalid
+	// syntax. Other errors are ignored, since statements may refer to
+	// tables created by earlier pending migrations.
+	SyntaxError(err error) bool
+}
+
+// A problem found in a pending migration by ValidatePending.
+type PendingIssue struct {
+	Migration *Migration
+
+	// The file and statement with the problem, when it is about one.
+	Path      string
+	Statement string
+
+	Message string
+}
+
+func (p PendingIssue) String() string {
+	if p.Statement != "" {
+		return fmt.Sprintf("%s: %s: %s", p.Path, p.Message, p.Statement)
+	}
+	return fmt.Sprintf("%s: %s", p.Path, p.Message)
+}
+
+var explainable = regexp.MustCompile(`(?i)^\s*(SELECT|INSERT|UPDATE|DELETE|WITH|VALUES|MERGE)\b`)
+
+// Checks every pending migration before any runs, so an obvious
+// mistake in a later migration doesn't stop a run halfway: each up file
+// is read, its directives parsed and its statements split, down files
+// must be readable, and statements are checked by adapters implementing
+// StatementChecker in transactions that are rolled back. Returns the
+// issues found, or an error when the checks couldn't run.
+func (m *Migrator) ValidatePending() ([]PendingIssue, error) {
+	if err := m.initialize(); err != nil {
+		return nil, err
+	}
+	return m.newRun().validatePending(m.pendingMigrations())
+}
+
+func (m *run) validatePending(migrations []*Migration) ([]PendingIssue, error) {
+	issues := make([]PendingIssue, 0)
+	report := func(issue PendingIssue) {
+		m.warnf("Invalid pending migration %s", issue)
+		issues = append(issues, issue)
+	}
+	checker, _ := m.dbAdapter.(StatementChecker)
+
+	for _, migration := range migrations {
+		if _, skip := m.Skip[migration.Id]; skip {
+			continue
+		}
+		sql, err := m.migrationSql(migration.UpPath)
+		if err != nil {
+			report(PendingIssue{Migration: migration, Path: migration.UpPath, Message: err.Error()})
+			continue
+		}
+		if err := applyDirectives(&Migration{}, ParseDirectives(string(sql))); err != nil {
+			report(PendingIssue{Migration: migration, Path: migration.UpPath, Message: "invalid directive: " + err.Error()})
+		}
+		if migration.DownPath != "" {
+			if _, err := m.migrationSql(migration.DownPath); err != nil {
+				report(PendingIssue{Migration: migration, Path: migration.DownPath, Message: "invalid down file: " + err.Error()})
+			}
+		}
+		if checker == nil {
+			continue
+		}
+		for _, statement := range splitStatements(string(sql)) {
+			query := checker.CheckStatementSql(statement)
+			if query == "" {
+				continue
+			}
+			if err := m.checkStatement(query); err != nil && checker.SyntaxError(err) {
+				report(PendingIssue{Migration: migration, Path: migration.UpPath, Statement: statement, Message: err.Error()})
+			}
+		}
+	}
+	return issues, nil
+}
+
+// Runs a check in a transaction that is always rolled back.
+func (m *run) checkStatement(query string) error {
+	transaction, err := m.executor.BeginTx(nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback()
+	_, err = transaction.Exec(query)
+	return err
+}
@@ -0,0 +1,134 @@
+// Checks the privileges pending migrations need before any runs.
+
+package gomigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+)
+
+// Implemented by adapters that can tell the privileges of the user
+// migrations run as.
+type PrivilegeChecker interface {
+	// Returns a query selecting whether the user can create objects in
+	// the schema migrations run in, as a single boolean.
+	CreatePrivilegeSql() string
+
+	// Returns a query selecting whether the user owns the table named
+	// by its single parameter, as a single boolean, or no rows when the
+	// table doesn't exist.
+	TableOwnerSql() string
+}
+
+// A privilege the user lacks to apply a pending migration.
+type MissingPrivilege struct {
+	// The migration needing it, or nil for the CREATE privilege on the
+	// schema every migration may need.
+	Migration *Migration
+
+	// CREATE on the schema, or OWNER of Table.
+	Privilege string
+	Table     string
+}
+
+func (p MissingPrivilege) String() string {
+	if p.Migration == nil {
+		return fmt.Sprintf("%s on the schema", p.Privilege)
+	}
+	return fmt.Sprintf("%s of table %s, altered by migration %d %s", p.Privilege, p.Table, p.Migration.Id, p.Migration.Name)
+}
+
+// Matches the tables altered by statements only their owner may run.
+var (
+	alterTableName = regexp.MustCompile(`(?i)^\s*(?:ALTER|DROP)\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?([\w$."]+)`)
+	indexTableName = regexp.MustCompile(`(?i)^\s*CREATE\s+(?:UNIQUE\s+)?INDEX\b.*?\bON\s+(?:ONLY\s+)?([\w$."]+)`)
+)
+
+// Returns the table a statement needs to own, or an empty string.
+func ownedTable(statement string) string {
+	statement = lineComment.ReplaceAllString(statement, "")
+	for _, pattern := range []*regexp.Regexp{alterTableName, indexTableName} {
+		if match := pattern.FindStringSubmatch(statement); match != nil {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+// Checks that the user can apply the pending migrations before any runs,
+// so a missing grant is reported up front instead of failing a run half
+// way: the user must be able to create objects in the schema and own the
+// existing tables the migrations alter, drop or index. Tables created by
+// earlier pending migrations can't be checked. Returns the missing
+// privileges, along with MissingPrivileges when there are any, or
+// PrivilegesUnsupported when the adapter doesn't implement
+// PrivilegeChecker.
+func (m *Migrator) CheckPrivileges() ([]MissingPrivilege, error) {
+	if err := m.initialize(); err != nil {
+		return nil, err
+	}
+	return m.newRun().checkPrivileges(m.pendingMigrations())
+}
+
+func (m *run) checkPrivileges(migrations []*Migration) ([]MissingPrivilege, error) {
+	checker, ok := m.dbAdapter.(PrivilegeChecker)
+	if !ok {
+		return nil, PrivilegesUnsupported
+	}
+	missing := make([]MissingPrivilege, 0)
+	report := func(privilege MissingPrivilege) {
+		m.errorf("Missing privilege: %s", privilege)
+		missing = append(missing, privilege)
+	}
+
+	var canCreate bool
+	if err := m.executor.QueryRow(checker.CreatePrivilegeSql()).Scan(&canCreate); err != nil {
+		m.errorf("Error checking the CREATE privilege: %v", err)
+		return nil, err
+	}
+	if !canCreate {
+		report(MissingPrivilege{Privilege: "CREATE"})
+	}
+
+	for _, migration := range migrations {
+		if _, skip := m.Skip[migration.Id]; skip {
+			continue
+		}
+		sql, err := m.migrationSql(migration.UpPath)
+		if err != nil {
+			return nil, err
+		}
+		checked := make(map[string]bool)
+		for _, statement := range splitStatements(string(sql)) {
+			table := ownedTable(statement)
+			if table == "" || checked[table] {
+				continue
+			}
+			checked[table] = true
+			owner, err := m.ownsTable(checker, table)
+			if err != nil {
+				m.errorf("Error checking the owner of %s: %v", table, err)
+				return nil, err
+			}
+			if !owner {
+				report(MissingPrivilege{Migration: migration, Privilege: "OWNER", Table: table})
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		return missing, MissingPrivileges
+	}
+	return missing, nil
+}
+
+// Returns true when the user owns table, or when it doesn't exist yet.
+func (m *run) ownsTable(checker PrivilegeChecker, table string) (bool, error) {
+	var owner bool
+	err := m.executor.QueryRow(checker.TableOwnerSql(), table).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	return owner, err
+}
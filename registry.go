@@ -0,0 +1,138 @@
+// Registers adapters by driver name and describes their capabilities.
+
+package gomigrate
+
+import (
+	"database/sql"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// Database features an adapter supports beyond the statements of
+// Migratable, combined as flags.
+type Capability uint
+
+const (
+	// DDL statements are rolled back with the migration transaction.
+	TransactionalDdl Capability = 1 << iota
+
+	// Statements can run inside savepoints, as required by
+	// SavepointPerStatement.
+	Savepoints
+
+	// Migrations can be applied at once on separate connections, as
+	// done when Parallelism is set.
+	ConcurrentMigrations
+)
+
+// Returns true when every flag of c is set.
+func (c Capability) Has(flags Capability) bool {
+	return c&flags == flags
+}
+
+// An adapter declaring the features its database supports. Adapters
+// only implementing Migratable are assumed to support every feature,
+// except for transactional DDL when they implement
+// ImplicitDdlCommitter.
+type Adapter interface {
+	Migratable
+	Capabilities() Capability
+}
+
+// Returns the capabilities of an adapter.
+func capabilities(adapter Migratable) Capability {
+	if a, ok := adapter.(Adapter); ok {
+		return a.Capabilities()
+	}
+	c := TransactionalDdl | Savepoints | ConcurrentMigrations
+	if committer, ok := adapter.(ImplicitDdlCommitter); ok && committer.CommitsDdlImplicitly() {
+		c &^= TransactionalDdl
+	}
+	return c
+}
+
+// Creates an adapter for a database driver.
+type AdapterFactory func() Adapter
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]AdapterFactory)
+)
+
+func init() {
+	RegisterAdapter("postgres", func() Adapter { return Postgres{} })
+	RegisterAdapter("pgx", func() Adapter { return Postgres{} })
+	RegisterAdapter("mysql", func() Adapter { return Mysql{} })
+	RegisterAdapter("sqlite3", func() Adapter { return Sqlite3{} })
+	RegisterAdapter("snowflake", func() Adapter { return Snowflake{} })
+}
+
+// Makes an adapter available under the name of its database/sql
+// driver, so packages outside of gomigrate can provide adapters from
+// their init functions. Panics when the driver already has an adapter
+// or the factory is nil.
+func RegisterAdapter(driver string, factory AdapterFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if factory == nil {
+		panic("gomigrate: RegisterAdapter factory is nil")
+	}
+	if _, dup := registry[driver]; dup {
+		panic("gomigrate: RegisterAdapter called twice for driver " + driver)
+	}
+	registry[driver] = factory
+}
+
+// Returns a new adapter for a driver, or UnknownAdapter when none is
+// registered.
+func NewAdapter(driver string) (Adapter, error) {
+	registryMu.RLock()
+	factory, ok := registry[driver]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, UnknownAdapter
+	}
+	return factory(), nil
+}
+
+// Returns the sorted names of the drivers with a registered adapter.
+func Adapters() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	drivers := make([]string, 0, len(registry))
+	for driver := range registry {
+		drivers = append(drivers, driver)
+	}
+	sort.Strings(drivers)
+	return drivers
+}
+
+// Returns a new adapter for the driver of db, found by comparing it with
+// the drivers registered with database/sql under the names of the
+// registered adapters. Returns UnknownAdapter when none matches.
+func DetectAdapter(db *sql.DB) (Adapter, error) {
+	driverType := reflect.TypeOf(db.Driver())
+	registered := make(map[string]bool)
+	for _, driver := range sql.Drivers() {
+		registered[driver] = true
+	}
+
+	for _, driver := range Adapters() {
+		if !registered[driver] {
+			continue
+		}
+		// Opening a database doesn't connect to it, and gives access
+		// to the driver registered under the name.
+		candidate, err := sql.Open(driver, "")
+		if err != nil {
+			continue
+		}
+		match := reflect.TypeOf(candidate.Driver()) == driverType
+		candidate.Close()
+		if match {
+			return NewAdapter(driver)
+		}
+	}
+	return nil, UnknownAdapter
+}
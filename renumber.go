@@ -0,0 +1,191 @@
+// Renumbers migration files, as after merging branches that used the
+// same ids.
+
+package gomigrate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var migrationIdRef = regexp.MustCompile(`\d+`)
+
+// Moves the files of a migration to a new id. Name selects among the
+// migrations sharing an id after a merge, and may be left empty
+// otherwise.
+type Renumbering struct {
+	Id    uint64
+	Name  string
+	NewId uint64
+}
+
+// Renames the files of the migrations of a directory to their new ids,
+// keeping any zero padding, and updates the depends-on directives
+// referring to ids no migration uses anymore. New ids must not be used
+// by any migration. Returns InvalidRenumbering when a migration isn't
+// found, is ambiguous or can't take its new id. Directories with a
+// manifest must be renumbered by hand, along with the manifest, and
+// references in encrypted files aren't updated.
+//
+// Rewritten directives change the checksums of up files, so recorded
+// checksums no longer match until the migrations are reapplied or the
+// checksums are updated.
+func RenumberMigrations(dir string, renumberings []Renumbering, logger Logger) error {
+	files, err := migrationFiles(dir, true, false)
+	if err != nil {
+		return err
+	}
+	if manifest := findManifest(files); manifest != "" {
+		logf(logger, LevelWarn, "Migrations with a manifest must be renumbered by hand: %s", manifest)
+		return InvalidRenumbering
+	}
+
+	// Group the files by id and name, since ids may be duplicated.
+	paths := make(map[uint64]map[string][]string)
+	used := make(map[uint64]bool)
+	for _, file := range files {
+		id, _, name, err := parseMigrationFile(file)
+		if err != nil {
+			continue
+		}
+		if paths[id] == nil {
+			paths[id] = make(map[string][]string)
+		}
+		paths[id][name] = append(paths[id][name], file)
+		used[id] = true
+	}
+
+	renames := make(map[string]string)
+	moved := make(map[uint64]int)
+	targets := make(map[uint64]bool)
+	for _, r := range renumberings {
+		names := paths[r.Id]
+		if r.Name == "" && len(names) == 1 {
+			for name := range names {
+				r.Name = name
+			}
+		}
+		migrationFiles, ok := names[r.Name]
+		switch {
+		case len(names) > 1 && r.Name == "":
+			logf(logger, LevelWarn, "Migration id %d is used by more than one migration, name the one to renumber", r.Id)
+			return InvalidRenumbering
+		case !ok:
+			logf(logger, LevelWarn, "No migration found to renumber: %d %s", r.Id, r.Name)
+			return InvalidRenumbering
+		case used[r.NewId] || targets[r.NewId]:
+			logf(logger, LevelWarn, "Migration id %d is already used", r.NewId)
+			return InvalidRenumbering
+		}
+		targets[r.NewId] = true
+		moved[r.Id]++
+		delete(names, r.Name)
+		for _, path := range migrationFiles {
+			renames[path] = renumberedPath(path, r.NewId)
+		}
+	}
+
+	// References are only updated when their id was vacated by a
+	// single migration, since they can't tell apart migrations that
+	// shared an id.
+	refs := make(map[uint64]uint64)
+	for _, r := range renumberings {
+		if len(paths[r.Id]) == 0 && moved[r.Id] == 1 {
+			refs[r.Id] = r.NewId
+		}
+	}
+
+	for from, to := range renames {
+		if err := os.Rename(from, to); err != nil {
+			logf(logger, LevelError, "Error renaming migration file: %v", err)
+			return err
+		}
+		logf(logger, LevelInfo, "Renamed migration file %s to %s", from, to)
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+	files, err = migrationFiles(dir, true, false)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		_, mType, _, err := parseMigrationFile(file)
+		if err != nil || mType != upMigration || isEncrypted(file) {
+			continue
+		}
+		if err := rewriteDependsOn(file, refs, logger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the path of a migration file renumbered to id, keeping any
+// zero padding of the original id.
+func renumberedPath(path string, id uint64) string {
+	base := filepath.Base(path)
+	rest := strings.TrimLeft(base, "0123456789")
+	renamed := fmt.Sprintf("%0*d%s", len(base)-len(rest), id, rest)
+	return filepath.Join(filepath.Dir(path), renamed)
+}
+
+// Replaces the ids of the depends-on directives of a file according to
+// refs, and writes the file when any changed.
+func rewriteDependsOn(path string, refs map[uint64]uint64, logger Logger) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sql := string(data)
+	var out strings.Builder
+	last := 0
+	for _, match := range directiveLine.FindAllStringSubmatchIndex(sql, -1) {
+		if sql[match[2]:match[3]] != "depends-on" {
+			continue
+		}
+		out.WriteString(sql[last:match[4]])
+		out.WriteString(migrationIdRef.ReplaceAllStringFunc(sql[match[4]:match[5]], func(ref string) string {
+			id, err := strconv.ParseUint(ref, 10, 64)
+			if to, ok := refs[id]; ok && err == nil {
+				return strconv.FormatUint(to, 10)
+			}
+			return ref
+		}))
+		last = match[5]
+	}
+	out.WriteString(sql[last:])
+	if out.String() == sql {
+		return nil
+	}
+	logf(logger, LevelInfo, "Updated dependencies of migration file: %s", path)
+	return ioutil.WriteFile(path, []byte(out.String()), 0644)
+}
+
+// Returns the statements moving the records of renumbered migrations to
+// their new ids, for databases that applied them under their old ids,
+// such as development databases of the branch that was renumbered.
+// Don't run them on databases where the old ids belong to the
+// migrations that kept them. The gomigrate_sql table is included when
+// sqlLog is set.
+func RenumberSql(renumberings []Renumbering, sqlLog bool) []string {
+	sorted := append([]Renumbering(nil), renumberings...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Id < sorted[j].Id })
+	tables := []string{migrationTableName}
+	if sqlLog {
+		tables = append(tables, "gomigrate_sql")
+	}
+	statements := make([]string, 0, len(sorted)*len(tables))
+	for _, r := range sorted {
+		for _, table := range tables {
+			statements = append(statements, fmt.Sprintf("UPDATE %s SET migration_id = %d WHERE migration_id = %d;", table, r.NewId, r.Id))
+		}
+	}
+	return statements
+}
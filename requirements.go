@@ -0,0 +1,170 @@
+// Checks the database requirements declared by migrations.
+
+package gomigrate
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Matches "postgres>=14" requirements.
+var serverRequirement = regexp.MustCompile(`^([\w-]+)>=(\d+(?:\.\d+)*)$`)
+
+// A minimum version of the database server a migration needs, declared
+// in the up file with "-- gomigrate: requires postgres>=14". Driver
+// names the adapter the requirement applies to, as registered with
+// RegisterAdapter.
+type ServerRequirement struct {
+	Driver  string
+	Version string
+}
+
+func (r ServerRequirement) String() string {
+	return r.Driver + ">=" + r.Version
+}
+
+// Parses requirements such as "postgres>=14" and "mysql>=8.0.13".
+func parseRequirements(fields []string) ([]ServerRequirement, error) {
+	requirements := make([]ServerRequirement, 0, len(fields))
+	for _, field := range fields {
+		match := serverRequirement.FindStringSubmatch(field)
+		if match == nil {
+			return nil, fmt.Errorf("invalid requirement %q, expected driver>=version", field)
+		}
+		requirements = append(requirements, ServerRequirement{Driver: match[1], Version: match[2]})
+	}
+	return requirements, nil
+}
+
+// Returns true when the requirement applies to adapter, as it names a
+// driver registered with an adapter of the same type.
+func (r ServerRequirement) appliesTo(adapter Migratable) bool {
+	registryMu.RLock()
+	factory, ok := registry[r.Driver]
+	registryMu.RUnlock()
+	return ok && reflect.TypeOf(factory()) == reflect.TypeOf(adapter)
+}
+
+// Returns ServerTooOld when the server is older than a version required
+// by any of the migrations to apply, before a run applies any.
+func (m *run) checkServerVersions(migrations []*Migration) error {
+	for _, migration := range migrations {
+		if _, skip := m.Skip[migration.Id]; skip {
+			continue
+		}
+		if err := m.checkServerVersion(migration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns ServerTooOld when the server is older than a version required
+// by the migration, or when its version can't be read. Requirements for
+// other drivers are ignored.
+func (m *run) checkServerVersion(migration *Migration) error {
+	var version string
+	for _, requirement := range migration.Requires {
+		if !requirement.appliesTo(m.dbAdapter) {
+			continue
+		}
+		if version == "" {
+			versioner, ok := m.dbAdapter.(ServerVersioner)
+			if !ok {
+				m.errorf("Migration %d %s requires %s, but the adapter can't tell the server version", migration.Id, migration.Name, requirement)
+				return ServerTooOld
+			}
+			if err := m.executor.QueryRow(versioner.ServerVersionSql()).Scan(&version); err != nil {
+				m.errorf("Error reading the server version: %v", err)
+				return err
+			}
+		}
+		if compareVersions(version, requirement.Version) < 0 {
+			m.errorf("Migration %d %s requires %s, but the server version is %s", migration.Id, migration.Name, requirement, version)
+			return ServerTooOld
+		}
+	}
+	return nil
+}
+
+// Implemented by adapters that can create extensions, as done for
+// Migrator.CreateExtensions.
+type ExtensionCreator interface {
+	// Returns a statement creating an extension unless it exists.
+	CreateExtensionSql(name string) string
+}
+
+// Returns the extensions required by a migration, declared in the up
+// file with "-- gomigrate: requires-extension postgis", that aren't
+// installed or can't be checked.
+func (m *run) missingExtensions(migration *Migration) ([]string, error) {
+	missing := make([]string, 0)
+	if len(migration.Extensions) == 0 {
+		return missing, nil
+	}
+	checker, ok := m.dbAdapter.(ExtensionChecker)
+	if !ok {
+		m.errorf("Migration %d %s requires extensions, but the adapter can't check them", migration.Id, migration.Name)
+		return migration.Extensions, nil
+	}
+	for _, extension := range migration.Extensions {
+		var count int
+		if err := m.executor.QueryRow(checker.ExtensionSql(), extension).Scan(&count); err != nil {
+			m.errorf("Error checking extension %s: %v", extension, err)
+			return nil, err
+		}
+		if count == 0 {
+			missing = append(missing, extension)
+		}
+	}
+	return missing, nil
+}
+
+// Checks the extensions required by the migrations to apply before a run
+// applies any, creating the missing ones when CreateExtensions is set.
+// Returns MissingExtension when any is still missing.
+func (m *run) checkExtensions(migrations []*Migration) error {
+	creator, canCreate := m.dbAdapter.(ExtensionCreator)
+	created := make(map[string]bool)
+	for _, migration := range migrations {
+		if _, skip := m.Skip[migration.Id]; skip {
+			continue
+		}
+		missing, err := m.missingExtensions(migration)
+		if err != nil {
+			return err
+		}
+		for _, extension := range missing {
+			switch {
+			case created[extension]:
+				continue
+			case !m.CreateExtensions || !canCreate:
+				m.errorf("Migration %d %s requires extension %s, which is not installed", migration.Id, migration.Name, extension)
+				return MissingExtension
+			}
+			m.infof("Creating extension %s required by migration %d", extension, migration.Id)
+			if _, err := m.executor.Exec(creator.CreateExtensionSql(extension)); err != nil {
+				m.errorf("Error creating extension %s: %v", extension, err)
+				return err
+			}
+			created[extension] = true
+		}
+	}
+	return nil
+}
+
+// Returns MissingExtension when an extension required by the migration
+// isn't installed.
+func (m *run) checkExtension(migration *Migration) error {
+	missing, err := m.missingExtensions(migration)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		m.errorf("Migration %d %s requires extensions that are not installed: %s", migration.Id, migration.Name, strings.Join(missing, ", "))
+		return MissingExtension
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+// Describes the outcome of migration runs for automation.
+
+package gomigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// How a migration run ended.
+type RunOutcome int
+
+const (
+	// Pending migrations were applied.
+	RunApplied RunOutcome = iota
+	// No migrations were pending once the lock was held.
+	RunUpToDate
+	// Another runner held the migration lock.
+	RunLockHeld
+	// A migration failed, as named by the result.
+	RunMigrationFailed
+	// The run failed before or between migrations, for instance while
+	// validating them or once interrupted.
+	RunError
+	// Migrations were disabled, as by the kill switch of AutoMigrate.
+	RunDisabled
+)
+
+var outcomeNames = map[RunOutcome]string{
+	RunApplied:         "applied",
+	RunUpToDate:        "up-to-date",
+	RunLockHeld:        "lock-held",
+	RunMigrationFailed: "migration-failed",
+	RunError:           "error",
+	RunDisabled:        "disabled",
+}
+
+func (o RunOutcome) String() string {
+	return outcomeNames[o]
+}
+
+// Outcomes are encoded by name in JSON.
+func (o RunOutcome) MarshalText() ([]byte, error) {
+	name, ok := outcomeNames[o]
+	if !ok {
+		return nil, fmt.Errorf("Invalid run outcome: %d", int(o))
+	}
+	return []byte(name), nil
+}
+
+// Describes how a migration run ended, for pipelines branching on it.
+type RunResult struct {
+	Outcome   RunOutcome `json:"outcome"`
+	Direction string     `json:"direction"`
+
+	// Ids of the migrations applied by the run, in order.
+	Applied []uint64 `json:"applied"`
+
+	// Ids of the pending migrations deferred until their scheduling
+	// window opens, in order.
+	Deferred []uint64 `json:"deferred,omitempty"`
+
+	// The migration that failed, for RunMigrationFailed, and the error
+	// the run failed with.
+	FailedId uint64 `json:"failed_id,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Applies pending migrations like MigrateContext and describes the
+// outcome. The result is returned along with any error.
+func (m *Migrator) MigrateWithResult(ctx context.Context, options ...RunOption) (*RunResult, error) {
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+	return m.newRun().migrate(ctx, options, false)
+}
+
+// Applies pending migrations while holding the lock when the run takes
+// it. Leader runs first check that no newer migrations were applied.
+func (m *run) migrate(ctx context.Context, options []RunOption, leader bool) (*RunResult, error) {
+	result := &RunResult{Direction: string(upMigration), Applied: make([]uint64, 0)}
+	fail := func(outcome RunOutcome, err error) (*RunResult, error) {
+		result.Outcome = outcome
+		result.Error = err.Error()
+		return result, err
+	}
+
+	if err := m.initialize(); err != nil {
+		return fail(RunError, err)
+	}
+	release, err := m.acquireLock(ctx)
+	if errors.Is(err, MigrationLocked) {
+		return fail(RunLockHeld, err)
+	}
+	if err != nil {
+		return fail(RunError, err)
+	}
+	defer release()
+	if leader {
+		if err := m.checkNewerMigrations(); err != nil {
+			return fail(RunError, err)
+		}
+	}
+
+	pending := m.pendingMigrations()
+	if err := m.checkWindows(pending); err != nil {
+		return fail(RunError, err)
+	}
+	for _, migration := range m.deferredMigrations(pending, options) {
+		m.infof("Deferring migration %d until window %s opens", migration.Id, migration.Window)
+		result.Deferred = append(result.Deferred, migration.Id)
+	}
+	migrations := m.selectMigrations(m.Migrations(Inactive), options)
+	if len(migrations) == 0 {
+		m.infof("Migrations are up to date")
+		result.Outcome = RunUpToDate
+		return result, nil
+	}
+	if err := m.Validate(); err != nil {
+		return fail(RunError, err)
+	}
+	if m.ValidateFirst {
+		issues, err := m.validatePending(migrations)
+		if err != nil {
+			return fail(RunError, err)
+		}
+		if len(issues) > 0 {
+			return fail(RunError, InvalidPending)
+		}
+	}
+	if err := m.checkApprovals(migrations); err != nil {
+		return fail(RunError, err)
+	}
+	if err := m.checkSignatures(migrations); err != nil {
+		return fail(RunError, err)
+	}
+	if err := m.checkServerVersions(migrations); err != nil {
+		return fail(RunError, err)
+	}
+	if err := m.checkWritable(); err != nil {
+		return fail(RunError, err)
+	}
+	if m.RequirePrivileges {
+		if _, err := m.checkPrivileges(migrations); err != nil {
+			return fail(RunError, err)
+		}
+	}
+	if err := m.checkExtensions(migrations); err != nil {
+		return fail(RunError, err)
+	}
+	if m.Shadow != nil {
+		if err := m.canary(ctx, options); err != nil {
+			return fail(RunError, err)
+		}
+	}
+
+	failed, err := m.runMigrations(ctx, migrations, upMigration)
+	m.mu.RLock()
+	for _, migration := range migrations {
+		if migration.Status == Active {
+			result.Applied = append(result.Applied, migration.Id)
+		}
+	}
+	m.mu.RUnlock()
+	switch {
+	case errors.Is(err, MigrationLocked):
+		return fail(RunLockHeld, err)
+	case failed != nil && err != Interrupted:
+		result.FailedId = failed.Id
+		return fail(RunMigrationFailed, err)
+	case err != nil:
+		return fail(RunError, err)
+	}
+	result.Outcome = RunApplied
+	return result, nil
+}
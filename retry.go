@@ -0,0 +1,105 @@
+// Retries migrations failing with transient errors.
+
+package gomigrate
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// SQLSTATE codes and classes treated as transient by default:
+// connection exceptions, serialization failures, deadlocks and lock
+// timeouts.
+var TransientStates = []string{"08", "40001", "40P01", "55P03"}
+
+// Error messages of drivers that don't expose SQLSTATE codes which
+// indicate a transient error.
+var transientMessages = []string{
+	"Error 1205", // MySQL lock wait timeout
+	"Error 1213", // MySQL deadlock
+	"database is locked",
+}
+
+// Controls how migrations failing with transient errors are retried.
+type RetryPolicy struct {
+	// Total number of attempts per migration, including the first.
+	MaxAttempts int
+
+	// Wait before the first retry, doubled after every attempt up to
+	// MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// SQLSTATE codes or classes to retry. Defaults to TransientStates.
+	States []string
+}
+
+// Returns true if err is a transient error according to the policy.
+func (r *RetryPolicy) Retryable(err error) bool {
+	if e, ok := err.(*StatementError); ok {
+		err = e.Err
+	}
+	if e, ok := err.(*redactedError); ok {
+		err = e.err
+	}
+	if err == driver.ErrBadConn || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+
+	states := r.States
+	if states == nil {
+		states = TransientStates
+	}
+	if e, ok := err.(interface {
+		SQLState() string
+	}); ok {
+		for _, state := range states {
+			if strings.HasPrefix(e.SQLState(), state) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, message := range transientMessages {
+		if strings.Contains(err.Error(), message) {
+			return true
+		}
+	}
+	return false
+}
+
+// Applies a migration after renewing the migration lock, retrying it
+// according to the retry policy. Waiting for a retry stops once ctx is done.
+func (m *run) applyWithRetry(ctx context.Context, migration *Migration, mType migrationType) error {
+	if err := m.renewLock(); err != nil {
+		return err
+	}
+	err := m.applyMigration(migration, mType)
+	if m.Retry == nil {
+		return err
+	}
+
+	backoff := m.Retry.Backoff
+	for attempt := 1; attempt < m.Retry.MaxAttempts && err != nil && m.Retry.Retryable(err); attempt++ {
+		m.warnf("Retrying migration %d in %v: %v", migration.Id, backoff, err)
+		select {
+		case <-ctx.Done():
+			return Interrupted
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if m.Retry.MaxBackoff > 0 && backoff > m.Retry.MaxBackoff {
+			backoff = m.Retry.MaxBackoff
+		}
+		err = m.applyMigration(migration, mType)
+	}
+	return err
+}
@@ -0,0 +1,20 @@
+// Binds migration runs to the executor they use.
+
+package gomigrate
+
+// A migrator bound to the executor of a single run, so runs using a
+// connection owned by the caller don't change the migrator's executor
+// while other methods use it.
+type run struct {
+	*Migrator
+	executor DBExecutor
+
+	// Whether the run takes the lock of the lock table: LockTable, or
+	// true for runs that always need it, such as MigrateLeader.
+	lockTable bool
+}
+
+// Returns a run using the migrator's executor.
+func (m *Migrator) newRun() *run {
+	return &run{m, m.executor, m.LockTable}
+}
@@ -0,0 +1,58 @@
+// Runs migration statements inside savepoints.
+
+package gomigrate
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Returned when a statement of a migration fails in savepoint mode.
+type StatementError struct {
+	Migration *Migration
+
+	// Zero-based position of the statement in the migration file.
+	Index     int
+	Statement string
+	Err       error
+}
+
+func (e *StatementError) Error() string {
+	return fmt.Sprintf(
+		"Statement %d of migration %d failed: %v\n%s",
+		e.Index+1,
+		e.Migration.Id,
+		e.Err,
+		e.Statement,
+	)
+}
+
+// Executes a statement inside its own savepoint. If the statement fails
+// and may fail in lenient mode, the transaction is rolled back to the
+// savepoint and a nil result is returned.
+func (m *Migrator) execWithSavepoint(transaction TxExecutor, migration *Migration, index int, statement string) (sql.Result, error) {
+	savepoint := fmt.Sprintf("gomigrate_%d", index)
+	if _, err := transaction.Exec("SAVEPOINT " + savepoint); err != nil {
+		m.errorf("Error creating savepoint: %v", err)
+		return nil, err
+	}
+
+	result, err := transaction.Exec(statement)
+	if err != nil {
+		if !m.Lenient || !ParseDirectives(statement).Has("allow-failure") {
+			return nil, &StatementError{migration, index, statement, err}
+		}
+		m.warnf("Ignoring failed statement %d: %v", index+1, err)
+		if _, err := transaction.Exec("ROLLBACK TO SAVEPOINT " + savepoint); err != nil {
+			m.errorf("Error rolling back to savepoint: %v", err)
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	if _, err := transaction.Exec("RELEASE SAVEPOINT " + savepoint); err != nil {
+		m.errorf("Error releasing savepoint: %v", err)
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,61 @@
+// Creates the files of new migrations.
+
+package gomigrate
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// Names of migrations, in any script.
+var migrationName = regexp.MustCompile(`^[\p{L}\p{M}\p{N}_-]+$`)
+
+// Creates empty up and down files for a new migration in the migrations
+// directory, or its subdirectory named by GroupLayout, numbered by
+// IdGenerator, and loads it. Directories with a
+// manifest must declare the new migration before it can be loaded.
+func (m *Migrator) CreateMigration(name string) (*Migration, error) {
+	source, ok := m.Source.(*FileMigrationSource)
+	if !ok {
+		return nil, errors.New("Creating migrations requires a FileMigrationSource")
+	}
+	if !migrationName.MatchString(name) {
+		m.warnf("Invalid migration name: %s", name)
+		return nil, InvalidMigrationFile
+	}
+
+	m.mu.RLock()
+	ids := make([]uint64, 0, len(m.migrations))
+	for id := range m.migrations {
+		ids = append(ids, id)
+	}
+	m.mu.RUnlock()
+	generator := m.IdGenerator
+	if generator == nil {
+		generator = SequentialIds{}
+	}
+	id := generator.NextId(ids)
+
+	now := m.now()
+	for _, mType := range []migrationType{upMigration, downMigration} {
+		path := source.newMigrationPath(id, name, mType, now)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			m.errorf("Error creating migration: %v", err)
+			return nil, err
+		}
+		m.infof("Created migration file: %s", path)
+	}
+
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.migrations[id], nil
+}
@@ -0,0 +1,158 @@
+// Introspects database schemas and derives down migrations from them.
+
+package gomigrate
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Implemented by adapters that can describe the current schema.
+type SchemaInspector interface {
+	// Returns a query selecting the table name, column name and data
+	// type of every column in the current schema.
+	SchemaColumnsSql() string
+}
+
+// Maps table names to their columns' data types.
+type Schema map[string]map[string]string
+
+// Reads the current schema of a database. The migrations table is left
+// out.
+func InspectSchema(db *sql.DB, adapter Migratable) (Schema, error) {
+	inspector, ok := adapter.(SchemaInspector)
+	if !ok {
+		return nil, UnsupportedAdapter
+	}
+
+	rows, err := db.Query(inspector.SchemaColumnsSql())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schema := make(Schema)
+	for rows.Next() {
+		var table, column, dataType string
+		if err := rows.Scan(&table, &column, &dataType); err != nil {
+			return nil, err
+		}
+		if table == migrationTableName {
+			continue
+		}
+		if schema[table] == nil {
+			schema[table] = make(map[string]string)
+		}
+		schema[table][column] = dataType
+	}
+	return schema, rows.Err()
+}
+
+// Returns the sorted table names of a schema.
+func (s Schema) Tables() []string {
+	tables := make([]string, 0, len(s))
+	for table := range s {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// Returns the sorted column names of a table.
+func (s Schema) Columns(table string) []string {
+	columns := make([]string, 0, len(s[table]))
+	for column := range s[table] {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// Returns a deterministic text form of the schema, listing the tables
+// and their columns sorted by name with lowercase data types:
+//
+//	users
+//	  email varchar
+//	  id integer
+func (s Schema) String() string {
+	var dump bytes.Buffer
+	for _, table := range s.Tables() {
+		fmt.Fprintf(&dump, "%s\n", table)
+		for _, column := range s.Columns(table) {
+			fmt.Fprintf(&dump, "  %s %s\n", column, strings.ToLower(s[table][column]))
+		}
+	}
+	return dump.String()
+}
+
+// Applies an up migration to a scratch database and returns a best-effort
+// down migration reversing the schema changes it made. Created tables
+// and columns are dropped and dropped columns are re-added; changes that
+// can't be reversed from the schema alone are left as comments for
+// review. The up migration stays applied to the scratch database.
+func GenerateDown(db *sql.DB, adapter Migratable, up string) (string, error) {
+	before, err := InspectSchema(db, adapter)
+	if err != nil {
+		return "", err
+	}
+
+	transaction, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	for _, cmd := range adapter.GetMigrationCommands(up) {
+		if _, err := transaction.Exec(cmd); err != nil {
+			if rollbackErr := transaction.Rollback(); rollbackErr != nil {
+				return "", rollbackErr
+			}
+			return "", err
+		}
+	}
+	if err := transaction.Commit(); err != nil {
+		return "", err
+	}
+
+	after, err := InspectSchema(db, adapter)
+	if err != nil {
+		return "", err
+	}
+	return diffDown(before, after), nil
+}
+
+// Returns the statements reverting the schema from after to before.
+func diffDown(before, after Schema) string {
+	var down bytes.Buffer
+
+	for _, table := range after.Tables() {
+		if _, ok := before[table]; !ok {
+			fmt.Fprintf(&down, "DROP TABLE %s;\n", table)
+			continue
+		}
+		for _, column := range after.Columns(table) {
+			oldType, ok := before[table][column]
+			if !ok {
+				fmt.Fprintf(&down, "ALTER TABLE %s DROP COLUMN %s;\n", table, column)
+				continue
+			}
+			if newType := after[table][column]; newType != oldType {
+				fmt.Fprintf(&down, "-- TODO: change %s.%s back from %s to %s\n", table, column, newType, oldType)
+			}
+		}
+		for _, column := range before.Columns(table) {
+			if _, ok := after[table][column]; !ok {
+				fmt.Fprintf(&down, "ALTER TABLE %s ADD COLUMN %s %s;\n", table, column, before[table][column])
+			}
+		}
+	}
+
+	for _, table := range before.Tables() {
+		if _, ok := after[table]; !ok {
+			fmt.Fprintf(&down, "-- TODO: recreate dropped table %s\n", table)
+		}
+	}
+
+	return down.String()
+}
@@ -0,0 +1,111 @@
+// Resolves secret placeholders in migration statements.
+
+package gomigrate
+
+import (
+	"database/sql"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var secretPlaceholder = regexp.MustCompile(`\$\{SECRET:([^}]+)\}`)
+
+// Resolves the values of ${SECRET:NAME} placeholders.
+type SecretResolver interface {
+	Secret(name string) (string, error)
+}
+
+// Resolves secrets with a function, such as one reading them from a
+// Vault client.
+type SecretFunc func(name string) (string, error)
+
+func (f SecretFunc) Secret(name string) (string, error) {
+	return f(name)
+}
+
+// Resolves secrets from environment variables, named by the secret
+// name following Prefix.
+type EnvSecrets struct {
+	Prefix string
+}
+
+func (e EnvSecrets) Secret(name string) (string, error) {
+	value, ok := os.LookupEnv(e.Prefix + name)
+	if !ok {
+		return "", MissingSecret
+	}
+	return value, nil
+}
+
+// Returns a statement with its secret placeholders replaced, along with
+// the secret values.
+func (m *Migrator) resolveSecrets(statement string) (string, []string, error) {
+	if !secretPlaceholder.MatchString(statement) {
+		return statement, nil, nil
+	}
+	resolver := m.Secrets
+	if resolver == nil {
+		resolver = EnvSecrets{}
+	}
+
+	var secrets []string
+	var firstErr error
+	resolved := secretPlaceholder.ReplaceAllStringFunc(statement, func(placeholder string) string {
+		name := secretPlaceholder.FindStringSubmatch(placeholder)[1]
+		value, err := resolver.Secret(name)
+		if err != nil {
+			if firstErr == nil {
+				m.errorf("Error resolving secret %s: %v", name, err)
+				firstErr = err
+			}
+			return placeholder
+		}
+		if value != "" {
+			secrets = append(secrets, value)
+		}
+		return value
+	})
+	if firstErr != nil {
+		return "", nil, firstErr
+	}
+	return resolved, secrets, nil
+}
+
+// Executes migration statements with their secret placeholders
+// resolved. Errors of statements using secrets have the secret values
+// redacted.
+type secretExecutor struct {
+	TxExecutor
+	m *Migrator
+}
+
+func (s secretExecutor) Exec(query string, args ...interface{}) (sql.Result, error) {
+	resolved, secrets, err := s.m.resolveSecrets(query)
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.TxExecutor.Exec(resolved, args...)
+	if err != nil && len(secrets) > 0 {
+		return nil, &redactedError{err, secrets}
+	}
+	return result, err
+}
+
+// An error whose message may contain secret values.
+type redactedError struct {
+	err     error
+	secrets []string
+}
+
+func (e *redactedError) Error() string {
+	message := e.err.Error()
+	for _, secret := range e.secrets {
+		message = strings.Replace(message, secret, "[REDACTED]", -1)
+	}
+	return message
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,173 @@
+// Applies pending migrations to a disposable shadow database before the
+// target.
+
+package gomigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Implemented by adapters that can create and drop databases on the
+// server of the target.
+type ShadowCreator interface {
+	// Returns a statement creating an empty database, or a copy of the
+	// template database when one is given, or an empty string when the
+	// database can't copy one.
+	CreateDatabaseSql(name, template string) string
+
+	// Returns a statement dropping a database if it exists.
+	DropDatabaseSql(name string) string
+
+	// Returns a query selecting the name of the connected database.
+	CurrentDatabaseSql() string
+}
+
+// A disposable database pending migrations are applied to before the
+// target, as a canary catching failures specific to an environment,
+// such as existing rows violating a new constraint.
+type Shadow struct {
+	// An existing disposable database, such as one opened from a DSN
+	// provided for the purpose. It is left as migrated.
+	DB *sql.DB
+
+	// Otherwise the database named Name is created on the server of the
+	// target, opened with Open and dropped once the canary finished. A
+	// database left by an earlier run is replaced, so Name may not name
+	// the target database. Requires an adapter implementing
+	// ShadowCreator.
+	Name string
+
+	// The database the shadow database is created as a copy of, such as
+	// the target itself, instead of an empty one. Postgres only copies
+	// a database no other session is connected to, so copying the
+	// target fails while other connections to it are open, including
+	// idle ones of the migrator's pool.
+	Template string
+
+	// Opens the created database by name.
+	Open func(name string) (*sql.DB, error)
+}
+
+// Returned when a migration fails on the shadow database, before any
+// migration was applied to the target.
+type ShadowError struct {
+	MigrationId uint64
+	Err         error
+}
+
+func (e *ShadowError) Error() string {
+	if e.MigrationId == 0 {
+		return fmt.Sprintf("Migrating the shadow database failed: %v", e.Err)
+	}
+	return fmt.Sprintf("Migration %d failed on the shadow database: %v", e.MigrationId, e.Err)
+}
+
+func (e *ShadowError) Unwrap() error {
+	return e.Err
+}
+
+// Applies the migrations a run selects to the shadow database, after
+// those already applied to the target that it lacks.
+func (m *run) canary(ctx context.Context, options []RunOption) error {
+	db, release, err := m.openShadow()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	m.infof("Applying pending migrations to the shadow database first")
+	shadow, err := NewMigratorWithLogger(db, m.dbAdapter, m.Source, m.logger)
+	if err != nil {
+		return err
+	}
+	m.configureShadow(shadow)
+	if err := shadow.initialize(); err != nil {
+		return &ShadowError{Err: err}
+	}
+
+	// An empty shadow database lacks the migrations of the target.
+	for _, applied := range m.Migrations(Active) {
+		migration, ok := shadow.migrations[applied.Id]
+		if !ok || migration.Status == Active {
+			continue
+		}
+		if err := shadow.ApplyMigration(migration, upMigration); err != nil {
+			return &ShadowError{migration.Id, err}
+		}
+	}
+	result, err := shadow.MigrateWithResult(ctx, options...)
+	if err != nil {
+		return &ShadowError{result.FailedId, err}
+	}
+	m.infof("Shadow database migrated, applying %d migrations to the target", len(result.Applied))
+	return nil
+}
+
+// Returns the shadow database and a function releasing it.
+func (m *run) openShadow() (*sql.DB, func(), error) {
+	if m.Shadow.DB != nil {
+		return m.Shadow.DB, func() {}, nil
+	}
+	if m.Shadow.Name == "" || m.Shadow.Open == nil {
+		return nil, nil, InvalidShadow
+	}
+	creator, ok := m.dbAdapter.(ShadowCreator)
+	if !ok {
+		return nil, nil, ShadowUnsupported
+	}
+	create := creator.CreateDatabaseSql(m.Shadow.Name, m.Shadow.Template)
+	if create == "" {
+		return nil, nil, ShadowUnsupported
+	}
+
+	// Replacing the shadow database must never drop the target.
+	var current string
+	if err := m.executor.QueryRow(creator.CurrentDatabaseSql()).Scan(&current); err != nil {
+		m.errorf("Error getting the name of the target database: %v", err)
+		return nil, nil, err
+	}
+	if current == m.Shadow.Name {
+		m.errorf("Refusing to use target database %s as the shadow database", current)
+		return nil, nil, ShadowIsTarget
+	}
+	drop := func() {
+		if _, err := m.executor.Exec(creator.DropDatabaseSql(m.Shadow.Name)); err != nil {
+			m.warnf("Error dropping shadow database %s: %v", m.Shadow.Name, err)
+		}
+	}
+
+	drop()
+	if _, err := m.executor.Exec(create); err != nil {
+		m.errorf("Error creating shadow database %s: %v", m.Shadow.Name, err)
+		return nil, nil, err
+	}
+	db, err := m.Shadow.Open(m.Shadow.Name)
+	if err != nil {
+		m.errorf("Error opening shadow database %s: %v", m.Shadow.Name, err)
+		drop()
+		return nil, nil, err
+	}
+	return db, func() {
+		db.Close()
+		drop()
+	}, nil
+}
+
+// Applies the settings of the migrator that change how migrations run
+// to the migrator of the shadow database.
+func (m *Migrator) configureShadow(shadow *Migrator) {
+	shadow.SavepointPerStatement = m.SavepointPerStatement
+	shadow.Lenient = m.Lenient
+	shadow.TxOptions = m.TxOptions
+	shadow.CreateExtensions = m.CreateExtensions
+	shadow.Secrets = m.Secrets
+	shadow.TemplateData = m.TemplateData
+	shadow.StrictTemplates = m.StrictTemplates
+	shadow.Environment = m.Environment
+	shadow.Clock = m.Clock
+	shadow.ChecksumAlgorithm = m.ChecksumAlgorithm
+	shadow.Skip = m.Skip
+	shadow.Windows = m.Windows
+}
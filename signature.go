@@ -0,0 +1,168 @@
+// Verifies the signatures of migration files before they run.
+
+package gomigrate
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Verifies that migration files are signed, so files tampered with on
+// their way through artifact stores are refused. Ed25519Signatures
+// verifies ed25519 signatures; other schemes, such as GPG or sigstore,
+// can be plugged in by implementing it.
+type SignatureVerifier interface {
+	// Returns an error when data, the contents of the file at path, is
+	// not signed by a trusted key. read reads other files of the
+	// migration source, such as detached signatures.
+	Verify(read func(path string) ([]byte, error), path string, data []byte) error
+}
+
+// Verifies ed25519 signatures by any of Keys. Each file is signed by a
+// detached signature in the file named like it with a .sig suffix, or,
+// when Manifest is set, listed with its SHA-256 checksum in the
+// Manifest file, which is signed by Manifest with a .sig suffix.
+// Signatures are base64 encoded. The manifest lists a file per line as
+// written by sha256sum, with paths relative to its directory:
+//
+//	9f86d081884c7d659a2feaa0c55ad015...  1_add_users_up.sql
+type Ed25519Signatures struct {
+	Keys     []ed25519.PublicKey
+	Manifest string
+}
+
+func (e Ed25519Signatures) Verify(read func(path string) ([]byte, error), path string, data []byte) error {
+	if e.Manifest == "" {
+		signature, err := read(path + ".sig")
+		if err != nil {
+			return errors.New("missing signature")
+		}
+		return e.verify(data, signature)
+	}
+
+	manifest, err := read(e.Manifest)
+	if err != nil {
+		return errors.New("missing manifest")
+	}
+	signature, err := read(e.Manifest + ".sig")
+	if err != nil {
+		return errors.New("missing manifest signature")
+	}
+	if err := e.verify(manifest, signature); err != nil {
+		return fmt.Errorf("manifest: %v", err)
+	}
+	name, err := filepath.Rel(filepath.Dir(e.Manifest), path)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	scanner := bufio.NewScanner(bytes.NewReader(manifest))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == filepath.ToSlash(name) {
+			if fields[0] != hex.EncodeToString(sum[:]) {
+				return errors.New("checksum differs from the manifest")
+			}
+			return nil
+		}
+	}
+	return errors.New("missing from the manifest")
+}
+
+// Returns an error unless signature, base64 encoded, signs data with
+// one of the keys.
+func (e Ed25519Signatures) verify(data, signature []byte) error {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return errors.New("invalid signature")
+	}
+	for _, key := range e.Keys {
+		if len(key) == ed25519.PublicKeySize && ed25519.Verify(key, data, decoded) {
+			return nil
+		}
+	}
+	return errors.New("not signed by a trusted key")
+}
+
+// Returns the base64 encoded signature of data with key, as written to
+// .sig files.
+func SignData(key ed25519.PrivateKey, data []byte) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(key, data)) + "\n")
+}
+
+// Writes a detached signature of each file with key, named like the
+// file with a .sig suffix.
+func SignFiles(key ed25519.PrivateKey, paths []string) error {
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path+".sig", SignData(key, data), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes a manifest of the checksums of files, relative to the
+// directory of manifest, and its signature with key.
+func SignManifest(key ed25519.PrivateKey, manifest string, paths []string) error {
+	var out bytes.Buffer
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(filepath.Dir(manifest), path)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		fmt.Fprintf(&out, "%s  %s\n", hex.EncodeToString(sum[:]), filepath.ToSlash(name))
+	}
+	if err := ioutil.WriteFile(manifest, out.Bytes(), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(manifest+".sig", SignData(key, out.Bytes()), 0644)
+}
+
+// Returns UnsignedMigration when Signatures is set and the file at path
+// isn't signed, logging why.
+func (m *Migrator) verifySignature(path string) error {
+	if m.Signatures == nil {
+		return nil
+	}
+	data, err := m.readSourceFile(path)
+	if err == nil {
+		err = m.Signatures.Verify(m.readSourceFile, path, data)
+	}
+	if err != nil {
+		m.errorf("Refusing unsigned migration file %s: %v", path, err)
+		return UnsignedMigration
+	}
+	return nil
+}
+
+// Verifies the up files of migrations, and the files they include,
+// before a run applies any.
+func (m *Migrator) checkSignatures(migrations []*Migration) error {
+	if m.Signatures == nil {
+		return nil
+	}
+	for _, migration := range migrations {
+		if _, err := m.migrationSql(migration.UpPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
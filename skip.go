@@ -0,0 +1,80 @@
+// Records migrations as applied without running them.
+
+package gomigrate
+
+import (
+	"database/sql"
+)
+
+// Implemented by adapters that can record migrations skipped with
+// Migrator.Skip.
+type MigrationSkipRecorder interface {
+	// Sets skip_reason for a migration id, in that order.
+	MigrationSkipUpdateSql() string
+
+	// Selects skip_reason for a migration id.
+	MigrationSkipSelectSql() string
+}
+
+// Returns whether the statements of a migration are skipped: up files
+// of migrations listed in Skip, and down files of migrations that were
+// skipped when applied. Returns SkipUnsupported when the adapter can't
+// record skipped migrations.
+func (m *Migrator) skipped(migration *Migration, mType migrationType) (bool, error) {
+	m.mu.RLock()
+	skipped := migration.SkipReason != ""
+	m.mu.RUnlock()
+	if mType == upMigration {
+		_, skipped = m.Skip[migration.Id]
+	}
+	if !skipped {
+		return false, nil
+	}
+	if _, ok := m.dbAdapter.(MigrationSkipRecorder); !ok {
+		m.warnf("Adapter does not support skipping migrations")
+		return false, SkipUnsupported
+	}
+	m.warnf("Skipping the statements of migration %d %s", migration.Id, mType)
+	return true, nil
+}
+
+// Records why a migration listed in Skip was skipped, in the
+// transaction it is logged in.
+func (m *Migrator) recordSkip(transaction TxExecutor, migration *Migration) error {
+	reason, ok := m.Skip[migration.Id]
+	if !ok {
+		return nil
+	}
+	if reason == "" {
+		reason = "skipped"
+	}
+	recorder, ok := m.dbAdapter.(MigrationSkipRecorder)
+	if !ok {
+		return SkipUnsupported
+	}
+	if _, err := transaction.Exec(recorder.MigrationSkipUpdateSql(), reason, migration.Id); err != nil {
+		m.errorf("Error logging migration: %v", err)
+		return err
+	}
+	m.mu.Lock()
+	migration.SkipReason = reason
+	m.mu.Unlock()
+	return nil
+}
+
+// Loads why an applied migration was skipped.
+func (m *run) getMigrationSkip(migration *Migration) error {
+	recorder, ok := m.dbAdapter.(MigrationSkipRecorder)
+	if !ok || m.tableVersion < 5 {
+		return nil
+	}
+
+	var reason sql.NullString
+	row := m.executor.QueryRow(recorder.MigrationSkipSelectSql(), migration.Id)
+	if err := row.Scan(&reason); err != nil {
+		m.errorf("Error getting migration skip reason for %s: %v", migration.Name, err)
+		return err
+	}
+	migration.SkipReason = reason.String
+	return nil
+}
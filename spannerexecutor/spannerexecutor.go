@@ -0,0 +1,259 @@
+// Package spannerexecutor runs gomigrate migrations against Cloud
+// Spanner, whose DDL statements can't run through a SQL connection.
+// DDL statements are submitted in batches through the database admin
+// API and other statements run in read-write transactions.
+//
+//	client, _ := spanner.NewClient(ctx, name)
+//	admin, _ := database.NewDatabaseAdminClient(ctx)
+//	migrator, _ := gomigrate.NewMigratorWithExecutor(
+//		spannerexecutor.New(client, admin, name),
+//		gomigrate.Spanner{},
+//		&gomigrate.FileMigrationSource{Dir: "./migrations"},
+//		logger,
+//	)
+package spannerexecutor
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/spanner"
+	database "cloud.google.com/go/spanner/admin/database/apiv1"
+	"cloud.google.com/go/spanner/admin/database/apiv1/databasepb"
+	"cloud.google.com/go/spanner/apiv1/spannerpb"
+	"github.com/DavidHuie/gomigrate"
+	"google.golang.org/api/iterator"
+)
+
+// Returns an executor for the database with the given name, in the
+// form projects/<project>/instances/<instance>/databases/<database>.
+func New(client *spanner.Client, admin *database.DatabaseAdminClient, name string) gomigrate.DBExecutor {
+	return &executor{client, admin, name}
+}
+
+type executor struct {
+	client *spanner.Client
+	admin  *database.DatabaseAdminClient
+	name   string
+}
+
+func (e *executor) Exec(query string, args ...interface{}) (sql.Result, error) {
+	if isDdl(query) {
+		return result(0), e.updateDdl([]string{query})
+	}
+	var count int64
+	_, err := e.client.ReadWriteTransaction(context.Background(), func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		var err error
+		count, err = txn.Update(ctx, statement(query, args))
+		return err
+	})
+	return result(count), err
+}
+
+func (e *executor) QueryRow(query string, args ...interface{}) gomigrate.Row {
+	return &row{e.client.Single().Query(context.Background(), statement(query, args))}
+}
+
+// Transaction options don't apply to Spanner and are ignored.
+func (e *executor) BeginTx(opts *sql.TxOptions) (gomigrate.TxExecutor, error) {
+	return &transaction{executor: e}, nil
+}
+
+// Submits a batch of DDL statements and waits for the long-running
+// operation applying them.
+func (e *executor) updateDdl(statements []string) error {
+	ctx := context.Background()
+	op, err := e.admin.UpdateDatabaseDdl(ctx, &databasepb.UpdateDatabaseDdlRequest{
+		Database:   e.name,
+		Statements: statements,
+	})
+	if err != nil {
+		return err
+	}
+	return op.Wait(ctx)
+}
+
+// Buffers the statements of a migration until it is committed. The DDL
+// statements are then applied as one batch, followed by the other
+// statements in a read-write transaction, so DDL statements always run
+// before the DML of the same migration. Rolling back before the commit
+// discards every statement; a failure after the DDL batch leaves it
+// applied.
+type transaction struct {
+	executor *executor
+	ddl      []string
+	dml      []spanner.Statement
+}
+
+// Buffered statements report no affected rows.
+func (t *transaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	if isDdl(query) {
+		t.ddl = append(t.ddl, query)
+	} else {
+		t.dml = append(t.dml, statement(query, args))
+	}
+	return result(0), nil
+}
+
+func (t *transaction) Commit() error {
+	if len(t.ddl) > 0 {
+		if err := t.executor.updateDdl(t.ddl); err != nil {
+			return err
+		}
+	}
+	if len(t.dml) == 0 {
+		return nil
+	}
+	_, err := t.executor.client.ReadWriteTransaction(context.Background(), func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		_, err := txn.BatchUpdate(ctx, t.dml)
+		return err
+	})
+	return err
+}
+
+func (t *transaction) Rollback() error {
+	t.ddl, t.dml = nil, nil
+	return nil
+}
+
+// Returns true for statements that must go through the admin API.
+func isDdl(query string) bool {
+	for _, line := range strings.Split(query, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "--") {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch strings.ToUpper(fields[0]) {
+		case "CREATE", "ALTER", "DROP", "ANALYZE", "GRANT", "REVOKE", "RENAME":
+			return true
+		}
+		return false
+	}
+	return false
+}
+
+// Binds positional arguments to the @p1, @p2... parameters used by the
+// Spanner adapter. Spanner has no unsigned integers, so integers are
+// passed as INT64.
+func statement(query string, args []interface{}) spanner.Statement {
+	params := make(map[string]interface{}, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case uint64:
+			arg = int64(v)
+		case int:
+			arg = int64(v)
+		}
+		params["p"+strconv.Itoa(i+1)] = arg
+	}
+	return spanner.Statement{SQL: query, Params: params}
+}
+
+// Reads the first row of a query, translating the end of the results
+// into sql.ErrNoRows.
+type row struct {
+	iter *spanner.RowIterator
+}
+
+func (r *row) Scan(dest ...interface{}) error {
+	defer r.iter.Stop()
+	next, err := r.iter.Next()
+	if err == iterator.Done {
+		return sql.ErrNoRows
+	}
+	if err != nil {
+		return err
+	}
+	for i, d := range dest {
+		var value spanner.GenericColumnValue
+		if err := next.Column(i, &value); err != nil {
+			return err
+		}
+		if err := assign(value, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stores a column value in a destination accepted by database/sql, such
+// as *uint64 or *sql.NullString.
+func assign(value spanner.GenericColumnValue, dest interface{}) error {
+	var v interface{}
+	switch value.Type.Code {
+	case spannerpb.TypeCode_INT64:
+		var n spanner.NullInt64
+		if err := value.Decode(&n); err != nil {
+			return err
+		}
+		if n.Valid {
+			v = n.Int64
+		}
+	case spannerpb.TypeCode_BOOL:
+		var b spanner.NullBool
+		if err := value.Decode(&b); err != nil {
+			return err
+		}
+		if b.Valid {
+			v = b.Bool
+		}
+	default:
+		var s spanner.NullString
+		if err := value.Decode(&s); err != nil {
+			return err
+		}
+		if s.Valid {
+			v = s.StringVal
+		}
+	}
+
+	if scanner, ok := dest.(sql.Scanner); ok {
+		return scanner.Scan(v)
+	}
+	switch d := dest.(type) {
+	case *string:
+		if s, ok := v.(string); ok {
+			*d = s
+			return nil
+		}
+	case *bool:
+		if b, ok := v.(bool); ok {
+			*d = b
+			return nil
+		}
+	case *int64:
+		if n, ok := v.(int64); ok {
+			*d = n
+			return nil
+		}
+	case *int:
+		if n, ok := v.(int64); ok {
+			*d = int(n)
+			return nil
+		}
+	case *uint64:
+		if n, ok := v.(int64); ok && n >= 0 {
+			*d = uint64(n)
+			return nil
+		}
+	default:
+		return fmt.Errorf("Unsupported scan destination: %T", dest)
+	}
+	return fmt.Errorf("Can't scan %v into %T", v, dest)
+}
+
+// Exposes a number of affected rows as a sql.Result.
+type result int64
+
+func (r result) LastInsertId() (int64, error) {
+	return 0, errors.New("LastInsertId is not supported by Spanner")
+}
+
+func (r result) RowsAffected() (int64, error) {
+	return int64(r), nil
+}
@@ -0,0 +1,111 @@
+// Records the statements executed by each migration.
+
+package gomigrate
+
+import (
+	"database/sql"
+)
+
+// Implemented by adapters that can record the statements executed by
+// migrations in the gomigrate_sql table.
+type MigrationSqlRecorder interface {
+	// Creates the gomigrate_sql table when it doesn't exist.
+	CreateMigrationSqlTableSql() string
+
+	// Inserts migration_id, direction, applied_at_ns, statement_index
+	// and sql_text, in that order.
+	MigrationSqlInsertSql() string
+
+	// Selects the highest applied_at_ns for a migration id and
+	// direction.
+	MigrationSqlLatestSql() string
+
+	// Selects sql_text for a migration id, applied_at_ns and
+	// statement_index.
+	MigrationSqlSelectSql() string
+}
+
+// Returns the adapter as a MigrationSqlRecorder, or SqlLogUnsupported.
+func (m *Migrator) sqlRecorder() (MigrationSqlRecorder, error) {
+	recorder, ok := m.dbAdapter.(MigrationSqlRecorder)
+	if !ok {
+		m.warnf("Adapter does not support recording migration SQL")
+		return nil, SqlLogUnsupported
+	}
+	return recorder, nil
+}
+
+// Creates the gomigrate_sql table when RecordSql is set.
+func (m *run) ensureSqlLogTable() error {
+	if !m.RecordSql {
+		return nil
+	}
+	recorder, err := m.sqlRecorder()
+	if err != nil {
+		return err
+	}
+	if _, err := m.executor.Exec(recorder.CreateMigrationSqlTableSql()); err != nil {
+		m.errorf("Error creating migration SQL table: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Records the statements of a migration in the transaction it runs in.
+// Statements of the same run share a timestamp.
+func (m *Migrator) recordSql(transaction TxExecutor, migration *Migration, mType migrationType, commands []string) error {
+	recorder, err := m.sqlRecorder()
+	if err != nil {
+		return err
+	}
+	appliedAt := m.now().UnixNano()
+	for i, cmd := range commands {
+		if _, err := transaction.Exec(
+			recorder.MigrationSqlInsertSql(),
+			migration.Id,
+			string(mType),
+			appliedAt,
+			i,
+			cmd,
+		); err != nil {
+			m.errorf("Error recording migration SQL: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the statements executed the last time a migration was
+// applied, as recorded with RecordSql set. Returns no statements when
+// none were recorded.
+func (m *Migrator) AppliedSql(migration *Migration) ([]string, error) {
+	recorder, err := m.sqlRecorder()
+	if err != nil {
+		return nil, err
+	}
+
+	var appliedAt sql.NullInt64
+	row := m.executor.QueryRow(recorder.MigrationSqlLatestSql(), migration.Id, string(upMigration))
+	if err := row.Scan(&appliedAt); err != nil {
+		m.errorf("Error getting migration SQL for %s: %v", migration.Name, err)
+		return nil, err
+	}
+
+	statements := make([]string, 0)
+	if !appliedAt.Valid {
+		return statements, nil
+	}
+	for i := 0; ; i++ {
+		var statement string
+		row := m.executor.QueryRow(recorder.MigrationSqlSelectSql(), migration.Id, appliedAt.Int64, i)
+		err := row.Scan(&statement)
+		if err == sql.ErrNoRows {
+			return statements, nil
+		}
+		if err != nil {
+			m.errorf("Error getting migration SQL for %s: %v", migration.Name, err)
+			return nil, err
+		}
+		statements = append(statements, statement)
+	}
+}
@@ -0,0 +1,235 @@
+// Package sqlsplit splits SQL scripts into statements, for databases and
+// drivers that run a single statement at a time. Semicolons inside
+// quotes, comments and the bodies of functions and triggers don't end
+// statements; which of those a script may contain depends on its
+// dialect.
+//
+//	for _, statement := range sqlsplit.Postgres.Split(script) {
+//		if _, err := db.Exec(statement); err != nil {
+//			return err
+//		}
+//	}
+package sqlsplit
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// The syntax of a dialect that matters when splitting its scripts.
+type Dialect struct {
+	// Characters quoting strings and identifiers, such as ' and ".
+	// Doubled quotes inside them are escapes.
+	Quotes string
+
+	// Whether a backslash escapes the next character in ' and " quotes.
+	// Backslashes in ` quoted identifiers are never escapes.
+	BackslashEscapes bool
+
+	// Whether quotes prefixed with E are escape strings, in which a
+	// backslash escapes the next character.
+	EscapeStrings bool
+
+	// Whether $tag$ quotes bodies, such as those of functions.
+	DollarQuotes bool
+
+	// Whether # starts a comment running to the end of the line.
+	HashComments bool
+
+	// Whether /* */ comments nest.
+	NestedComments bool
+
+	// Whether [ ] quotes identifiers.
+	BracketQuotes bool
+
+	// Whether the bodies of CREATE TRIGGER statements are delimited by
+	// BEGIN and END, with statements ending in semicolons inside.
+	TriggerBodies bool
+
+	// Whether a DELIMITER line changes the string ending statements, as
+	// in scripts for the mysql client.
+	DelimiterCommand bool
+}
+
+var (
+	// Skips quotes with ', " and `, and $tag$ bodies. gomigrate splits
+	// statements this way for adapters without a dialect of their own.
+	Generic = Dialect{Quotes: "'\"`", DollarQuotes: true}
+
+	Postgres = Dialect{Quotes: `'"`, EscapeStrings: true, DollarQuotes: true, NestedComments: true}
+
+	Mysql = Dialect{Quotes: "'\"`", BackslashEscapes: true, HashComments: true, DelimiterCommand: true}
+
+	Sqlite = Dialect{Quotes: "'\"`", BracketQuotes: true, TriggerBodies: true}
+)
+
+var (
+	dollarQuoteTag = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+	createTrigger  = regexp.MustCompile(`(?i)\bCREATE\s+((TEMP|TEMPORARY)\s+)?TRIGGER\b`)
+)
+
+// Splits sql into statements with the generic dialect.
+func Split(sql string) []string {
+	return Generic.Split(sql)
+}
+
+// Splits sql into statements, trimmed and without the delimiter ending
+// them. Comments preceding a statement are kept with it; chunks
+// consisting only of comments are dropped, as are DELIMITER lines.
+func (d Dialect) Split(sql string) []string {
+	statements := make([]string, 0)
+	delimiter := ";"
+	start := 0
+	hasCode := false
+	// Nesting of BEGIN and CASE blocks in a trigger body.
+	depth := 0
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case d.DelimiterCommand && !hasCode && isDelimiterCommand(sql, i):
+			end := skipUntil(sql, i, "\n")
+			if fields := strings.Fields(sql[i:end]); len(fields) > 1 {
+				delimiter = fields[1]
+			}
+			start = end
+			i = end - 1
+			continue
+		case strings.HasPrefix(sql[i:], delimiter) && depth == 0:
+			if hasCode {
+				statements = appendStatement(statements, sql[start:i])
+			}
+			i += len(delimiter) - 1
+			start = i + 1
+			hasCode = false
+			continue
+		case c == '-' && strings.HasPrefix(sql[i:], "--"), c == '#' && d.HashComments:
+			i = skipUntil(sql, i+1, "\n") - 1
+			continue
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			if d.NestedComments {
+				i = skipNestedComment(sql, i+2) - 1
+			} else {
+				i = skipUntil(sql, i+2, "*/") - 1
+			}
+			continue
+		case strings.IndexByte(d.Quotes, c) >= 0:
+			escapes := d.BackslashEscapes && c != '`' || d.EscapeStrings && c == '\'' && i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e')
+			i = skipQuoted(sql, i+1, c, escapes) - 1
+		case c == '[' && d.BracketQuotes:
+			i = skipUntil(sql, i+1, "]") - 1
+		case c == '$' && d.DollarQuotes:
+			if tag := dollarQuoteTag.FindString(sql[i:]); tag != "" {
+				i = skipUntil(sql, i+len(tag), tag) - 1
+			}
+		case d.TriggerBodies && isWordStart(sql, i):
+			end := i
+			for end < len(sql) && isWordChar(sql[end]) {
+				end++
+			}
+			switch strings.ToUpper(sql[i:end]) {
+			case "BEGIN":
+				if depth > 0 || createTrigger.MatchString(sql[start:i]) {
+					depth++
+				}
+			case "CASE":
+				if depth > 0 {
+					depth++
+				}
+			case "END":
+				if depth > 0 {
+					depth--
+				}
+			}
+			i = end - 1
+		}
+		if !unicode.IsSpace(rune(c)) {
+			hasCode = true
+		}
+	}
+	if hasCode {
+		statements = appendStatement(statements, sql[start:])
+	}
+	return statements
+}
+
+// Appends statement trimmed, unless it's only made of spaces outside of
+// ASCII.
+func appendStatement(statements []string, statement string) []string {
+	if statement = strings.TrimSpace(statement); statement != "" {
+		statements = append(statements, statement)
+	}
+	return statements
+}
+
+// Returns whether a DELIMITER command starts at i, at the start of a
+// line.
+func isDelimiterCommand(sql string, i int) bool {
+	if i > 0 && sql[i-1] != '\n' {
+		return false
+	}
+	const command = "DELIMITER"
+	if len(sql)-i <= len(command) || !strings.EqualFold(sql[i:i+len(command)], command) {
+		return false
+	}
+	return sql[i+len(command)] == ' ' || sql[i+len(command)] == '\t'
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isWordStart(sql string, i int) bool {
+	return isWordChar(sql[i]) && (i == 0 || !isWordChar(sql[i-1]))
+}
+
+// Returns the index just past the first occurrence of end in sql at or
+// after from, or the length of sql if there is none.
+func skipUntil(sql string, from int, end string) int {
+	if from > len(sql) {
+		return len(sql)
+	}
+	n := strings.Index(sql[from:], end)
+	if n < 0 {
+		return len(sql)
+	}
+	return from + n + len(end)
+}
+
+// Returns the index just past the quote closing a quoted string or
+// identifier starting at from. Doubled quotes are handled by the caller
+// as two adjacent quoted parts.
+func skipQuoted(sql string, from int, quote byte, escapes bool) int {
+	for i := from; i < len(sql); i++ {
+		switch sql[i] {
+		case '\\':
+			if escapes {
+				i++
+			}
+		case quote:
+			return i + 1
+		}
+	}
+	return len(sql)
+}
+
+// Returns the index just past the end of a comment, which may contain
+// other comments, whose body starts at from.
+func skipNestedComment(sql string, from int) int {
+	depth := 1
+	for i := from; i < len(sql)-1; i++ {
+		switch {
+		case sql[i] == '/' && sql[i+1] == '*':
+			depth++
+			i++
+		case sql[i] == '*' && sql[i+1] == '/':
+			depth--
+			i++
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(sql)
+}
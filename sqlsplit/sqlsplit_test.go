@@ -0,0 +1,104 @@
+package sqlsplit
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name       string
+		dialect    Dialect
+		sql        string
+		statements []string
+	}{
+		{
+			"generic",
+			Generic,
+			"-- first\nDROP TABLE a;\nINSERT INTO b VALUES ('a;b', \"c;d\", `e;f`);\n/* ; */\n-- trailing;\n",
+			[]string{"-- first\nDROP TABLE a", "INSERT INTO b VALUES ('a;b', \"c;d\", `e;f`)"},
+		},
+		{
+			"postgres",
+			Postgres,
+			"CREATE FUNCTION f() RETURNS void AS $body$ BEGIN PERFORM 1; END $body$ LANGUAGE plpgsql;\n" +
+				"/* outer /* inner; */ still; */ SELECT E'it\\'s;', 'x''y;';",
+			[]string{
+				"CREATE FUNCTION f() RETURNS void AS $body$ BEGIN PERFORM 1; END $body$ LANGUAGE plpgsql",
+				"/* outer /* inner; */ still; */ SELECT E'it\\'s;', 'x''y;'",
+			},
+		},
+		{
+			"mysql",
+			Mysql,
+			"INSERT INTO a VALUES ('it\\'s;'); # comment;\nDELIMITER //\nCREATE PROCEDURE p() BEGIN SELECT 1; END//\nDELIMITER ;\nSELECT 2;",
+			[]string{
+				"INSERT INTO a VALUES ('it\\'s;')",
+				"CREATE PROCEDURE p() BEGIN SELECT 1; END",
+				"SELECT 2",
+			},
+		},
+		{
+			"mysql identifier",
+			Mysql,
+			"CREATE TABLE `a\\` (id int); SELECT 1;",
+			[]string{"CREATE TABLE `a\\` (id int)", "SELECT 1"},
+		},
+		{
+			"sqlite",
+			Sqlite,
+			"BEGIN TRANSACTION;\nCREATE TRIGGER t AFTER INSERT ON [a;b] BEGIN\n  UPDATE c SET d = CASE WHEN 1 THEN 2 END;\n  DELETE FROM e;\nEND;\nCOMMIT;",
+			[]string{
+				"BEGIN TRANSACTION",
+				"CREATE TRIGGER t AFTER INSERT ON [a;b] BEGIN\n  UPDATE c SET d = CASE WHEN 1 THEN 2 END;\n  DELETE FROM e;\nEND",
+				"COMMIT",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		statements := test.dialect.Split(test.sql)
+		if strings.Join(statements, "|") != strings.Join(test.statements, "|") {
+			t.Errorf("%s: invalid statements: %q", test.name, statements)
+		}
+	}
+}
+
+func FuzzSplit(f *testing.F) {
+	f.Add("CREATE TABLE a (id int); INSERT INTO a VALUES (1);")
+	f.Add("SELECT 'a;b'; /* ; */ -- ;\nSELECT $x$ ; $x$;")
+	f.Add("DELIMITER //\nSELECT 1//\nDELIMITER ;\nSELECT \"it\\\";\";")
+	f.Add("CREATE TRIGGER t BEGIN SELECT CASE WHEN 1 THEN 2 END; END; SELECT [a;b];")
+
+	f.Fuzz(func(t *testing.T, sql string) {
+		for _, dialect := range []Dialect{Generic, Postgres, Mysql, Sqlite} {
+			from := 0
+			for _, statement := range dialect.Split(sql) {
+				if statement == "" || statement != strings.TrimSpace(statement) {
+					t.Fatalf("Invalid statement %q", statement)
+				}
+				// Statements are parts of the script, in order.
+				n := strings.Index(sql[from:], statement)
+				if n < 0 {
+					t.Fatalf("Statement %q not found in order in %q", statement, sql)
+				}
+				from += n + len(statement)
+			}
+		}
+	})
+}
+
+func BenchmarkSplit(b *testing.B) {
+	var script strings.Builder
+	for i := 0; i < 1000; i++ {
+		script.WriteString("-- add a row\nINSERT INTO notes (body) VALUES ('a;b', \"c\");\n")
+		script.WriteString("CREATE FUNCTION f() RETURNS void AS $$ BEGIN PERFORM 1; END $$ LANGUAGE plpgsql;\n")
+	}
+	sql := script.String()
+	b.SetBytes(int64(len(sql)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		Postgres.Split(sql)
+	}
+}
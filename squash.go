@@ -0,0 +1,223 @@
+// Consolidates old migrations into a single baseline migration.
+
+package gomigrate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const archiveDirName = "archive"
+
+// Squashes migrations up to and including upTo into a single baseline
+// migration with id upTo. The up files are concatenated in order, the
+// down files in reverse order, and the original files are moved into
+// the archive subdirectory of the migrations directory, where they
+// count as applied by the baseline.
+//
+// Because the baseline keeps the id of the last squashed migration,
+// databases already at upTo need no changes; on the migrator's own
+// database the log entries of the squashed migrations are removed.
+// Databases that haven't reached upTo yet must be migrated before
+// squashing, otherwise they would rerun the consolidated migrations.
+//
+// Squashing again folds the earlier baseline into the new one. Encrypted
+// and template migrations, and those declaring directives, can't be
+// squashed.
+func (m *Migrator) Squash(upTo uint64, name string) error {
+	if err := m.checkReadOnly(); err != nil {
+		return err
+	}
+	if err := m.initialize(); err != nil {
+		return err
+	}
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+	source, ok := m.Source.(*FileMigrationSource)
+	if !ok {
+		return errors.New("Squashing requires a FileMigrationSource")
+	}
+	m.mu.RLock()
+	target, ok := m.migrations[upTo]
+	migrations := m.migrationsById()
+	m.mu.RUnlock()
+	if !ok || target.Archived {
+		m.warnf("No migration found with id: %d", upTo)
+		return InvalidSquashTarget
+	}
+
+	// Archived migrations were squashed before, into a baseline that is
+	// squashed again instead.
+	squashed := make([]*Migration, 0)
+	applied := 0
+	for _, migration := range migrations {
+		if migration.Id > upTo {
+			break
+		}
+		if migration.Archived {
+			continue
+		}
+		squashed = append(squashed, migration)
+		if migration.Status == Active {
+			applied++
+		}
+	}
+	if applied != 0 && applied != len(squashed) {
+		return PartiallyApplied
+	}
+
+	// The baseline would hold the plaintext of encrypted migrations, and
+	// render templates with the data of a single run.
+	for _, migration := range squashed {
+		if isEncrypted(migration.UpPath) || isEncrypted(migration.DownPath) {
+			m.warnf("Encrypted migrations can't be squashed: %s", migration.UpPath)
+			return InvalidSquashTarget
+		}
+		if isTemplate(migration.UpPath) || isTemplate(migration.DownPath) {
+			m.warnf("Template migrations can't be squashed: %s", migration.UpPath)
+			return InvalidSquashTarget
+		}
+	}
+
+	// Build the baseline from the original files. The baseline can only
+	// be rolled back if every squashed migration can. Directives of a
+	// single migration, such as only or backfill, would apply to the
+	// whole baseline.
+	var up, down bytes.Buffer
+	irreversible := false
+	for i, migration := range squashed {
+		sql, err := ioutil.ReadFile(migration.UpPath)
+		if err != nil {
+			m.errorf("Error reading migration: %s", migration.UpPath)
+			return err
+		}
+		if len(ParseDirectives(string(sql))) > 0 {
+			m.warnf("Migrations with directives can't be squashed: %s", migration.UpPath)
+			return InvalidSquashTarget
+		}
+		fmt.Fprintf(&up, "-- %s\n%s\n", filepath.Base(migration.UpPath), sql)
+
+		downMigration := squashed[len(squashed)-1-i]
+		if downMigration.Irreversible {
+			irreversible = true
+			continue
+		}
+		sql, err = ioutil.ReadFile(downMigration.DownPath)
+		if err != nil {
+			m.errorf("Error reading migration: %s", downMigration.DownPath)
+			return err
+		}
+		if len(ParseDirectives(string(sql))) > 0 {
+			m.warnf("Migrations with directives can't be squashed: %s", downMigration.DownPath)
+			return InvalidSquashTarget
+		}
+		fmt.Fprintf(&down, "-- %s\n%s\n", filepath.Base(downMigration.DownPath), sql)
+	}
+
+	// Archive the originals and write the baseline. An earlier baseline
+	// is removed instead, as the archive holds its originals under the
+	// same id.
+	archive := filepath.Join(source.Dir, archiveDirName)
+	archived, err := archivedIds(archive, source)
+	if err != nil {
+		return err
+	}
+	for _, migration := range squashed {
+		for _, path := range []string{migration.UpPath, migration.DownPath} {
+			if path == "" {
+				continue
+			}
+			if archived[migration.Id] {
+				if err := os.Remove(path); err != nil {
+					m.errorf("Error removing baseline: %s", path)
+					return err
+				}
+				continue
+			}
+			rel, err := filepath.Rel(source.Dir, path)
+			if err != nil {
+				return err
+			}
+			target := filepath.Join(archive, rel)
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Rename(path, target); err != nil {
+				m.errorf("Error archiving migration: %s", path)
+				return err
+			}
+		}
+	}
+	baseline := &Migration{
+		Id:           upTo,
+		Irreversible: irreversible,
+		Name:         name,
+		Status:       Inactive,
+		UpPath:       source.migrationPath(upTo, name, upMigration),
+	}
+	if err := ioutil.WriteFile(baseline.UpPath, up.Bytes(), 0644); err != nil {
+		return err
+	}
+	if !irreversible {
+		baseline.DownPath = source.migrationPath(upTo, name, downMigration)
+		if err := ioutil.WriteFile(baseline.DownPath, down.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	m.infof("Squashed %d migrations into: %s", len(squashed), baseline.UpPath)
+
+	// Rewrite the migration log.
+	if applied > 0 {
+		transaction, err := m.executor.BeginTx(m.TxOptions)
+		if err != nil {
+			m.errorf("Error opening transaction: %v", err)
+			return err
+		}
+		for _, migration := range squashed {
+			if migration.Id == upTo {
+				continue
+			}
+			if _, err := transaction.Exec(m.dbAdapter.MigrationLogDeleteSql(), migration.Id); err != nil {
+				m.errorf("Error logging migration: %v", err)
+				if rollbackErr := transaction.Rollback(); rollbackErr != nil {
+					m.errorf("Error rolling back transaction: %v", rollbackErr)
+					return rollbackErr
+				}
+				return err
+			}
+		}
+		if err := transaction.Commit(); err != nil {
+			m.errorf("Error commiting transaction: %v", err)
+			return err
+		}
+		baseline.Status = Active
+	}
+
+	m.mu.Lock()
+	for _, migration := range squashed {
+		delete(m.migrations, migration.Id)
+	}
+	m.migrations[upTo] = baseline
+	m.mu.Unlock()
+
+	return nil
+}
+
+// Returns the ids of the migrations in an archive directory.
+func archivedIds(dir string, source *FileMigrationSource) (map[uint64]bool, error) {
+	files, err := migrationFiles(dir, source.SplitDirs, source.Recursive)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[uint64]bool)
+	for _, file := range files {
+		if id, _, _, err := source.Pattern.parse(file); err == nil {
+			ids[id] = true
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,187 @@
+// Exports and restores the contents of the migrations table.
+
+package gomigrate
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+// Version of the state file format written by ExportState.
+const stateFormatVersion = 1
+
+// The contents of a migrations table, as written by ExportState.
+type State struct {
+	FormatVersion int             `json:"format_version"`
+	ExportedAt    time.Time       `json:"exported_at"`
+	Migrations    []*HistoryEntry `json:"migrations"`
+}
+
+// Writes the contents of the migrations table as JSON, to be restored
+// with ImportState after the database is rebuilt, for instance from a
+// backup taken without the migrations table or before the latest
+// migrations were recorded.
+func (m *Migrator) ExportState(w io.Writer) error {
+	history, err := m.History()
+	if err != nil {
+		return err
+	}
+	state := &State{FormatVersion: stateFormatVersion, ExportedAt: m.now(), Migrations: history}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(state)
+}
+
+// Records the migrations of a state written by ExportState that are
+// missing from the migrations table, along with their stats, audit
+// information, checksums, skip reasons, descriptions, authors, tickets,
+// phases and custom columns, in a single transaction. Migrations already
+// recorded are left untouched, and those missing from the state are
+// logged. Returns the number of migrations restored, or InvalidState
+// when the state can't be read. Only import a state matching the schema
+// of the database: restored migrations are never run.
+func (m *Migrator) ImportState(r io.Reader) (int, error) {
+	if err := m.checkReadOnly(); err != nil {
+		return 0, err
+	}
+	if err := m.initialize(); err != nil {
+		return 0, err
+	}
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+	if _, ok := m.dbAdapter.(ForeignMigrationTable); ok {
+		return 0, errors.New("Importing state requires the gomigrate table")
+	}
+	var state State
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		m.errorf("Error reading migration state: %v", err)
+		return 0, InvalidState
+	}
+	if state.FormatVersion != stateFormatVersion {
+		m.errorf("Unsupported migration state format: %d", state.FormatVersion)
+		return 0, InvalidState
+	}
+
+	current, err := m.History()
+	if err != nil {
+		return 0, err
+	}
+	recorded := make(map[uint64]bool, len(current))
+	for _, entry := range current {
+		recorded[entry.Id] = true
+	}
+	inState := make(map[uint64]bool, len(state.Migrations))
+	for _, entry := range state.Migrations {
+		inState[entry.Id] = true
+	}
+	for _, entry := range current {
+		if !inState[entry.Id] {
+			m.warnf("Migration %d is recorded but missing from the imported state", entry.Id)
+		}
+	}
+
+	transaction, err := m.executor.BeginTx(m.TxOptions)
+	if err != nil {
+		m.errorf("Error opening transaction: %v", err)
+		return 0, err
+	}
+	imported := 0
+	columns := m.newRun().presentColumns()
+	for _, entry := range state.Migrations {
+		if recorded[entry.Id] {
+			continue
+		}
+		if err := m.restoreEntry(transaction, entry, columns); err != nil {
+			m.errorf("Error restoring migration %d: %v", entry.Id, err)
+			if rollbackErr := transaction.Rollback(); rollbackErr != nil {
+				m.errorf("Error rolling back transaction: %v", rollbackErr)
+			}
+			return 0, err
+		}
+		imported++
+	}
+	if err := transaction.Commit(); err != nil {
+		m.errorf("Error commiting transaction: %v", err)
+		return 0, err
+	}
+	m.infof("Restored migrations: %d", imported)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return imported, m.newRun().getMigrationStatuses(m.migrations)
+}
+
+// Records a migration of an imported state with the recorders the
+// adapter implements.
+func (m *Migrator) restoreEntry(transaction TxExecutor, entry *HistoryEntry, columns []string) error {
+	if _, err := transaction.Exec(m.dbAdapter.MigrationLogInsertSql(), entry.Id); err != nil {
+		return err
+	}
+	if recorder, ok := m.dbAdapter.(MigrationStatsRecorder); ok {
+		var appliedAt sql.NullInt64
+		if !entry.AppliedAt.IsZero() {
+			appliedAt = sql.NullInt64{Int64: entry.AppliedAt.UnixNano(), Valid: true}
+		}
+		if _, err := transaction.Exec(recorder.MigrationStatsUpdateSql(), entry.DurationMs, entry.StatementCount, appliedAt, entry.Id); err != nil {
+			return err
+		}
+	}
+	if recorder, ok := m.dbAdapter.(MigrationAuditRecorder); ok {
+		if _, err := transaction.Exec(
+			recorder.MigrationAuditUpdateSql(),
+			entry.AppliedBy,
+			entry.Host,
+			entry.Application,
+			entry.LibraryVersion,
+			entry.Build,
+			entry.Runner,
+			entry.Id,
+		); err != nil {
+			return err
+		}
+	}
+	if recorder, ok := m.dbAdapter.(MigrationChecksumRecorder); ok && entry.Checksum != "" {
+		if _, err := transaction.Exec(recorder.MigrationChecksumUpdateSql(), entry.Checksum, entry.ChecksumAlgorithm, entry.Id); err != nil {
+			return err
+		}
+	}
+	if recorder, ok := m.dbAdapter.(MigrationSkipRecorder); ok && entry.SkipReason != "" {
+		if _, err := transaction.Exec(recorder.MigrationSkipUpdateSql(), entry.SkipReason, entry.Id); err != nil {
+			return err
+		}
+	}
+	if recorder, ok := m.dbAdapter.(MigrationDescriptionRecorder); ok && entry.Description != "" {
+		if _, err := transaction.Exec(recorder.MigrationDescriptionUpdateSql(), truncateDescription(entry.Description), entry.Id); err != nil {
+			return err
+		}
+	}
+	if recorder, ok := m.dbAdapter.(MigrationMetadataRecorder); ok && (entry.Author != "" || entry.Ticket != "") {
+		if _, err := transaction.Exec(recorder.MigrationMetadataUpdateSql(), entry.Author, entry.Ticket, entry.Id); err != nil {
+			return err
+		}
+	}
+	if recorder, ok := m.dbAdapter.(MigrationPhaseRecorder); ok && entry.Phase != "" {
+		if _, err := transaction.Exec(recorder.MigrationPhaseUpdateSql(), entry.Phase, entry.Id); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0)
+	args := make([]interface{}, 0)
+	for _, name := range columns {
+		if value, ok := entry.Columns[name]; ok {
+			names = append(names, name)
+			args = append(args, value)
+		}
+	}
+	if len(names) > 0 {
+		recorder := m.dbAdapter.(CustomColumnRecorder)
+		if _, err := transaction.Exec(recorder.CustomColumnsUpdateSql(names), append(args, entry.Id)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+// Reports on the state of the migrations.
+
+package gomigrate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Validation policies.
+const (
+	PolicyIgnore = iota
+	PolicyWarn
+	PolicyFail
+)
+
+// Two consecutive migration ids with numbers missing between them.
+type IdGap struct {
+	After  uint64
+	Before uint64
+}
+
+func (g IdGap) String() string {
+	return fmt.Sprintf("%d..%d", g.After, g.Before)
+}
+
+// A snapshot of the migrations known to a migrator.
+type StatusReport struct {
+	Applied []*Migration
+	Pending []*Migration
+
+	// Pending migrations with an id lower than the highest applied
+	// migration of their phase and tags, usually the result of merging
+	// branches.
+	OutOfOrder []*Migration
+
+	// Holes in the numbering of the migrations, within each namespace.
+	Gaps []IdGap
+
+	// Migrations that can't be rolled back.
+	Irreversible []*Migration
+
+	// Inactive migrations restricted to other environments.
+	Skipped []*Migration
+
+	// Pending migrations of PhasePost, which Migrate leaves to
+	// MigratePost.
+	Post []*Migration
+}
+
+// Returns copies of all migrations in order, which stay consistent
+// while migrations run.
+func (m *Migrator) snapshot() []*Migration {
+	all := m.Migrations(-1)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	copies := make([]*Migration, len(all))
+	for i, migration := range all {
+		c := *migration
+		copies[i] = &c
+	}
+	return copies
+}
+
+// Returns the highest id of the applied migrations in a snapshot.
+func version(all []*Migration) uint64 {
+	var version uint64
+	for _, migration := range all {
+		if migration.Status == Active && migration.Id > version {
+			version = migration.Id
+		}
+	}
+	return version
+}
+
+// Returns the schema version, the highest id of the applied
+// migrations, or 0 when no migrations are applied.
+func (m *Migrator) Version() uint64 {
+	return version(m.snapshot())
+}
+
+// Returns copies of the migrations Migrate would apply, in order.
+func (m *Migrator) Pending() []*Migration {
+	return m.Status().Pending
+}
+
+// Returns copies of the applied migrations in order, along with how
+// long they took and who applied them when recorded by the adapter.
+func (m *Migrator) Applied() []*Migration {
+	return m.Status().Applied
+}
+
+// Returns the current status of the migrations. The report holds
+// copies of the migrations, so it can be read while migrations run.
+func (m *Migrator) Status() *StatusReport {
+	report := &StatusReport{
+		Applied:      make([]*Migration, 0),
+		Pending:      make([]*Migration, 0),
+		OutOfOrder:   make([]*Migration, 0),
+		Gaps:         make([]IdGap, 0),
+		Irreversible: make([]*Migration, 0),
+		Skipped:      make([]*Migration, 0),
+		Post:         make([]*Migration, 0),
+	}
+
+	// Phases are applied at different times and runs can leave out
+	// migrations by tag, so pending migrations are only out of order
+	// when older than applied ones of their phase with the same tags,
+	// which every run selects together. Migrations deferred to a window
+	// are expected to run late.
+	all := m.snapshot()
+	highest := make(map[string]uint64)
+	for _, migration := range all {
+		if migration.Status == Active && migration.Id > highest[orderGroup(migration)] {
+			highest[orderGroup(migration)] = migration.Id
+		}
+	}
+	ids := make(map[string][]uint64)
+	for _, migration := range all {
+		switch {
+		case migration.Status == Active:
+			report.Applied = append(report.Applied, migration)
+		case !m.inEnvironment(migration):
+			report.Skipped = append(report.Skipped, migration)
+		case phaseOf(migration) == PhasePost:
+			report.Post = append(report.Post, migration)
+		default:
+			report.Pending = append(report.Pending, migration)
+			if migration.Id < highest[orderGroup(migration)] && migration.Window == "" {
+				report.OutOfOrder = append(report.OutOfOrder, migration)
+			}
+		}
+		if migration.Irreversible {
+			report.Irreversible = append(report.Irreversible, migration)
+		}
+		ids[migration.Namespace] = append(ids[migration.Namespace], migration.Id)
+	}
+
+	// Migrations are in order, so the ids of each namespace are too.
+	namespaces := make([]string, 0, len(ids))
+	for namespace := range ids {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		ids := ids[namespace]
+		for i := 1; i < len(ids); i++ {
+			if ids[i]-ids[i-1] > 1 {
+				report.Gaps = append(report.Gaps, IdGap{ids[i-1], ids[i]})
+			}
+		}
+	}
+
+	return report
+}
+
+// Returns the phase and sorted tags of a migration, identifying the
+// migrations that are always applied together.
+func orderGroup(migration *Migration) string {
+	tags := append([]string(nil), migration.Tags...)
+	sort.Strings(tags)
+	return phaseOf(migration) + " " + strings.Join(tags, ",")
+}
+
+// Checks the migrations for out of order pending migrations and holes
+// in the numbering, following OutOfOrderPolicy and GapPolicy.
+func (m *Migrator) Validate() error {
+	report := m.Status()
+
+	if len(report.OutOfOrder) > 0 && m.OutOfOrderPolicy != PolicyIgnore {
+		for _, migration := range report.OutOfOrder {
+			m.warnf("Pending migration is older than applied migrations: %s", migration.UpPath)
+		}
+		if m.OutOfOrderPolicy == PolicyFail {
+			return OutOfOrderMigrations
+		}
+	}
+
+	if len(report.Gaps) > 0 && m.GapPolicy != PolicyIgnore {
+		m.warnf("Gaps found in migration ids: %v", report.Gaps)
+		if m.GapPolicy == PolicyFail {
+			return MigrationIdGaps
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,282 @@
+// Upgrades the layout of the migrations table.
+
+package gomigrate
+
+import (
+	"database/sql"
+	"time"
+)
+
+// A change to the layout of the migrations table. An upgrade is needed
+// when its probe query fails.
+type tableUpgrade struct {
+	version    int
+	probe      string
+	statements []string
+
+	// Returns statements specific to the adapter, run after the others.
+	adapterStatements func(adapter Migratable) []string
+}
+
+// Upgrades of the migrations table, in order. Tables created by older
+// versions of this package are brought up to date when a migrator is
+// created.
+var tableUpgrades = []tableUpgrade{
+	{
+		version: 2,
+		probe:   "SELECT duration_ms, statement_count FROM gomigrate WHERE 1 = 0",
+		statements: []string{
+			"ALTER TABLE gomigrate ADD COLUMN duration_ms BIGINT",
+			"ALTER TABLE gomigrate ADD COLUMN statement_count INTEGER",
+		},
+	},
+	{
+		version: 3,
+		probe:   "SELECT applied_by, applied_host, application, library_version FROM gomigrate WHERE 1 = 0",
+		statements: []string{
+			"ALTER TABLE gomigrate ADD COLUMN applied_by VARCHAR(255)",
+			"ALTER TABLE gomigrate ADD COLUMN applied_host VARCHAR(255)",
+			"ALTER TABLE gomigrate ADD COLUMN application VARCHAR(255)",
+			"ALTER TABLE gomigrate ADD COLUMN library_version VARCHAR(255)",
+		},
+	},
+	{
+		version: 4,
+		probe:   "SELECT checksum FROM gomigrate WHERE 1 = 0",
+		statements: []string{
+			"ALTER TABLE gomigrate ADD COLUMN checksum VARCHAR(64)",
+		},
+	},
+	{
+		version: 5,
+		probe:   "SELECT skip_reason FROM gomigrate WHERE 1 = 0",
+		statements: []string{
+			"ALTER TABLE gomigrate ADD COLUMN skip_reason VARCHAR(255)",
+		},
+	},
+	{
+		version: 6,
+		probe:   "SELECT checksum_algorithm FROM gomigrate WHERE 1 = 0",
+		statements: []string{
+			"ALTER TABLE gomigrate ADD COLUMN checksum_algorithm VARCHAR(32)",
+		},
+		adapterStatements: func(adapter Migratable) []string {
+			if widener, ok := adapter.(ChecksumColumnWidener); ok {
+				return []string{widener.WidenChecksumSql()}
+			}
+			return nil
+		},
+	},
+	{
+		version: 7,
+		probe:   "SELECT build_info, runner FROM gomigrate WHERE 1 = 0",
+		statements: []string{
+			"ALTER TABLE gomigrate ADD COLUMN build_info VARCHAR(255)",
+			"ALTER TABLE gomigrate ADD COLUMN runner VARCHAR(255)",
+		},
+	},
+	{
+		version: 8,
+		probe:   "SELECT applied_at_ns FROM gomigrate WHERE 1 = 0",
+		statements: []string{
+			"ALTER TABLE gomigrate ADD COLUMN applied_at_ns BIGINT",
+		},
+	},
+	{
+		version: 9,
+		probe:   "SELECT description FROM gomigrate WHERE 1 = 0",
+		statements: []string{
+			"ALTER TABLE gomigrate ADD COLUMN description VARCHAR(1024)",
+		},
+	},
+	{
+		version: 10,
+		probe:   "SELECT author, ticket FROM gomigrate WHERE 1 = 0",
+		statements: []string{
+			"ALTER TABLE gomigrate ADD COLUMN author VARCHAR(255)",
+			"ALTER TABLE gomigrate ADD COLUMN ticket VARCHAR(255)",
+		},
+	},
+	{
+		version: 11,
+		probe:   "SELECT phase FROM gomigrate WHERE 1 = 0",
+		statements: []string{
+			"ALTER TABLE gomigrate ADD COLUMN phase VARCHAR(32)",
+		},
+	},
+}
+
+// Implemented by adapters that record how long each migration took, how
+// many statements it ran and when it was applied.
+type MigrationStatsRecorder interface {
+	// Sets duration_ms, statement_count and applied_at_ns, in that
+	// order, for a migration id.
+	MigrationStatsUpdateSql() string
+
+	// Selects duration_ms, statement_count and applied_at_ns for a
+	// migration id.
+	MigrationStatsSelectSql() string
+}
+
+// Implemented by adapters that record the layout version of the
+// migrations table in the gomigrate_version table, so upgrades don't
+// need to be probed and tables upgraded by newer versions of this
+// package are detected. Other adapters probe for every upgrade.
+type MigrationTableVersioner interface {
+	// Creates the gomigrate_version table when it doesn't exist.
+	CreateVersionTableSql() string
+
+	// Inserts the version row, with the id 1 and version 1, unless it
+	// exists.
+	InsertVersionRowSql() string
+
+	// Selects the version of the version row.
+	SelectVersionSql() string
+
+	// Sets the version of the version row.
+	UpdateVersionSql() string
+}
+
+// Returns the latest layout version of the migrations table.
+func latestTableVersion() int {
+	return tableUpgrades[len(tableUpgrades)-1].version
+}
+
+// Returns the layout version recorded by the adapter, or 0 when it
+// doesn't record one or the version table is missing.
+func (m *run) recordedTableVersion() int {
+	versioner, ok := m.dbAdapter.(MigrationTableVersioner)
+	if !ok {
+		return 0
+	}
+	var version int
+	if err := m.executor.QueryRow(versioner.SelectVersionSql()).Scan(&version); err != nil {
+		return 0
+	}
+	return version
+}
+
+// Applies the upgrades the migrations table is missing, each in a
+// transaction when the adapter supports transactional DDL, and records
+// the version reached. An upgrade failing because another runner
+// applied it at the same time is skipped. Returns NewerTableLayout
+// when the table was upgraded by a newer version of this package. The
+// recorded version is ignored when the table was just created.
+func (m *run) upgradeMigrationsTable(created bool) error {
+	versioner, versioned := m.dbAdapter.(MigrationTableVersioner)
+	if versioned {
+		for _, statement := range []string{versioner.CreateVersionTableSql(), versioner.InsertVersionRowSql()} {
+			if _, err := m.executor.Exec(statement); err != nil {
+				m.errorf("Error creating migrations table version: %v", err)
+				return err
+			}
+		}
+	}
+	recorded := 0
+	if !created {
+		recorded = m.recordedTableVersion()
+	}
+	if recorded > latestTableVersion() {
+		m.errorf("Migrations table has layout version %d, newer than version %d of this package", recorded, latestTableVersion())
+		return NewerTableLayout
+	}
+
+	for _, upgrade := range tableUpgrades {
+		if upgrade.version > recorded && m.executor.QueryRow(upgrade.probe).Scan() != sql.ErrNoRows {
+			m.infof("Upgrading migrations table to version %d", upgrade.version)
+			if err := m.applyTableUpgrade(upgrade); err != nil {
+				if m.executor.QueryRow(upgrade.probe).Scan() != sql.ErrNoRows {
+					m.errorf("Error upgrading migrations table: %v", err)
+					return err
+				}
+				m.infof("Migrations table was upgraded to version %d by another runner", upgrade.version)
+			}
+		}
+		m.tableVersion = upgrade.version
+	}
+
+	if versioned && recorded < m.tableVersion {
+		if _, err := m.executor.Exec(versioner.UpdateVersionSql(), m.tableVersion); err != nil {
+			m.errorf("Error recording migrations table version: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Runs the statements of an upgrade.
+func (m *run) applyTableUpgrade(upgrade tableUpgrade) error {
+	statements := upgrade.statements
+	if upgrade.adapterStatements != nil {
+		statements = append(statements, upgrade.adapterStatements(m.dbAdapter)...)
+	}
+
+	var transaction TxExecutor = autocommitExecutor{m.executor}
+	_, nonTransactional := m.dbAdapter.(NonTransactionalDdl)
+	if capabilities(m.dbAdapter).Has(TransactionalDdl) && !nonTransactional {
+		var err error
+		if transaction, err = m.executor.BeginTx(nil); err != nil {
+			return err
+		}
+	}
+	for _, statement := range statements {
+		if _, err := transaction.Exec(statement); err != nil {
+			transaction.Rollback()
+			return err
+		}
+	}
+	return transaction.Commit()
+}
+
+// Returns the layout version of an existing migrations table without
+// upgrading it.
+func (m *run) migrationsTableVersion() int {
+	if _, ok := m.dbAdapter.(ForeignMigrationTable); ok {
+		return 1
+	}
+	if version := m.recordedTableVersion(); version > 0 {
+		return version
+	}
+	version := 1
+	for _, upgrade := range tableUpgrades {
+		if err := m.executor.QueryRow(upgrade.probe).Scan(); err != sql.ErrNoRows {
+			break
+		}
+		version = upgrade.version
+	}
+	return version
+}
+
+// Returns the layout version of the migrations table, 0 when it is
+// missing from the database of a read-only migrator.
+func (m *Migrator) TableVersion() (int, error) {
+	if err := m.initialize(); err != nil {
+		return 0, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.tableVersion, nil
+}
+
+// Loads the recorded duration, statement count and time of an applied
+// migration. Migrations applied before the stats were recorded are left
+// at zero.
+func (m *run) getMigrationStats(migration *Migration) error {
+	recorder, ok := m.dbAdapter.(MigrationStatsRecorder)
+	if !ok || m.tableVersion < 8 {
+		return nil
+	}
+
+	var durationMs, statementCount, appliedAt sql.NullInt64
+	row := m.executor.QueryRow(recorder.MigrationStatsSelectSql(), migration.Id)
+	if err := row.Scan(&durationMs, &statementCount, &appliedAt); err != nil {
+		m.errorf("Error getting migration stats for %s: %v", migration.Name, err)
+		return err
+	}
+	migration.DurationMs = durationMs.Int64
+	migration.StatementCount = int(statementCount.Int64)
+	if appliedAt.Valid {
+		migration.AppliedAt = time.Unix(0, appliedAt.Int64)
+	}
+	return nil
+}
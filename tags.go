@@ -0,0 +1,81 @@
+// Selects the migrations of a run by tag.
+
+package gomigrate
+
+// Configures a migration run.
+type RunOption func(*runConfig)
+
+type runConfig struct {
+	withTags    []string
+	withoutTags []string
+	phases      []string
+	anyWindow   bool
+}
+
+// Only applies migrations with at least one of the tags.
+func WithTags(tags ...string) RunOption {
+	return func(c *runConfig) {
+		c.withTags = append(c.withTags, tags...)
+	}
+}
+
+// Doesn't apply migrations with any of the tags.
+func WithoutTags(tags ...string) RunOption {
+	return func(c *runConfig) {
+		c.withoutTags = append(c.withoutTags, tags...)
+	}
+}
+
+// Returns true if the migration has any of the tags.
+func hasTag(migration *Migration, tags []string) bool {
+	for _, tag := range migration.Tags {
+		for _, t := range tags {
+			if tag == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the migrations selected by the run options, in order, leaving
+// out those of other environments and those deferred until their
+// window opens. Migrations depending on a pending migration that isn't
+// selected are left out as well.
+func (m *Migrator) selectMigrations(migrations []*Migration, options []RunOption) []*Migration {
+	config := &runConfig{}
+	for _, option := range options {
+		option(config)
+	}
+
+	excluded := make(map[uint64]bool)
+	selected := make([]*Migration, 0)
+	for _, migration := range migrations {
+		if !m.inEnvironment(migration) {
+			excluded[migration.Id] = true
+			continue
+		}
+		if (len(config.withTags) > 0 && !hasTag(migration, config.withTags)) || hasTag(migration, config.withoutTags) || !inPhase(migration, config.phases) {
+			excluded[migration.Id] = true
+			continue
+		}
+		if !config.anyWindow && !m.windowOpen(migration) {
+			excluded[migration.Id] = true
+			continue
+		}
+		skip := false
+		for _, id := range migration.DependsOn {
+			if excluded[id] {
+				m.warnf("Skipping migration %d, it depends on unselected migration %d", migration.Id, id)
+				skip = true
+				break
+			}
+		}
+		if skip {
+			excluded[migration.Id] = true
+			continue
+		}
+		selected = append(selected, migration)
+	}
+	return selected
+}
@@ -0,0 +1,99 @@
+// Renders migration files ending in .sql.tmpl with text/template.
+
+package gomigrate
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+var (
+	templateFuncsMu sync.RWMutex
+
+	// now is replaced by the Clock of the migrator rendering the
+	// template.
+	templateFuncs = template.FuncMap{
+		"now":   time.Now,
+		"uuid":  newUuid,
+		"quote": quoteLiteral,
+		"ident": quoteIdent,
+		"env":   os.Getenv,
+	}
+)
+
+// Makes a function available to every .sql.tmpl migration under name,
+// alongside the built-in now, uuid, quote, ident and env. Panics when
+// the name is already taken or fn isn't a valid template function.
+func RegisterTemplateFunc(name string, fn interface{}) {
+	templateFuncsMu.Lock()
+	defer templateFuncsMu.Unlock()
+	if fn == nil {
+		panic("gomigrate: RegisterTemplateFunc fn is nil")
+	}
+	if _, dup := templateFuncs[name]; dup {
+		panic("gomigrate: RegisterTemplateFunc called twice for " + name)
+	}
+	// Funcs panics on invalid names and functions.
+	template.New("").Funcs(template.FuncMap{name: fn})
+	templateFuncs[name] = fn
+}
+
+// Returns true if a migration file is a template.
+func isTemplate(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".sql.tmpl")
+}
+
+// Renders a migration template with TemplateData.
+func (m *Migrator) renderTemplate(path string, sql []byte) ([]byte, error) {
+	templateFuncsMu.RLock()
+	funcs := make(template.FuncMap, len(templateFuncs))
+	for name, fn := range templateFuncs {
+		funcs[name] = fn
+	}
+	templateFuncsMu.RUnlock()
+	funcs["now"] = m.now
+
+	tmpl := template.New(path).Funcs(funcs)
+	if m.StrictTemplates {
+		tmpl = tmpl.Option("missingkey=error")
+	}
+	tmpl, err := tmpl.Parse(string(sql))
+	if err != nil {
+		m.errorf("Error parsing migration template: %v", err)
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, m.TemplateData); err != nil {
+		m.errorf("Error rendering migration template: %v", err)
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Returns a random version 4 UUID.
+func newUuid() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// Quotes a value as an SQL string literal.
+func quoteLiteral(value interface{}) string {
+	return "'" + strings.ReplaceAll(fmt.Sprint(value), "'", "''") + "'"
+}
+
+// Quotes a value as an SQL identifier with double quotes, as in
+// Postgres, SQLite and MySQL in ANSI_QUOTES mode.
+func quoteIdent(value interface{}) string {
+	return `"` + strings.ReplaceAll(fmt.Sprint(value), `"`, `""`) + `"`
+}
@@ -0,0 +1,198 @@
+// Slows data migrations down to spare the other clients of a shared
+// database.
+
+package gomigrate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits the pace of a migration, declared in its up file with:
+//
+//	-- gomigrate: throttle delay=100ms rows=5000 max-lag=10s max-pause=30m
+//
+// Statements are throttled between each other, and backfill migrations
+// between chunks.
+type Throttle struct {
+	// Sleeps this long between statements.
+	Delay time.Duration
+
+	// Sleeps between statements as long as needed for the rows they
+	// affect to average at most this many per second.
+	RowsPerSecond int64
+
+	// Pauses before each statement while replicas lag further behind,
+	// as measured by Migrator.LagProbe or, by default, the adapter.
+	MaxLag time.Duration
+
+	// Gives up with a ReplicationLagTimeout once a statement was paused
+	// this long, DefaultMaxPause when 0.
+	MaxPause time.Duration
+}
+
+// Returned when replicas kept lagging further behind than MaxLag for
+// longer than MaxPause.
+type ReplicationLagTimeout struct {
+	MigrationId uint64
+	Lag         time.Duration
+	MaxLag      time.Duration
+	Paused      time.Duration
+}
+
+func (e *ReplicationLagTimeout) Error() string {
+	return fmt.Sprintf("Migration %d paused %v while replicas lag %v, more than %v", e.MigrationId, e.Paused, e.Lag, e.MaxLag)
+}
+
+// Measures how far the replicas of the database lag behind it.
+type LagProbe interface {
+	// Returns the lag of the replica furthest behind.
+	Lag() (time.Duration, error)
+}
+
+// Implemented by adapters that can measure the lag of replicas from the
+// primary, as Postgres does with pg_stat_replication.
+type ReplicationLagChecker interface {
+	// Returns a query selecting the lag of the replica furthest behind
+	// in seconds, 0 without replicas.
+	ReplicationLagSql() string
+}
+
+// How often a paused migration measures the lag of replicas again.
+var LagPollInterval = 5 * time.Second
+
+// How long a statement is paused for lagging replicas at most, unless
+// its throttle sets MaxPause.
+var DefaultMaxPause = time.Hour
+
+// Parses the value of a throttle directive.
+func parseThrottle(value string) (*Throttle, error) {
+	throttle := &Throttle{}
+	for _, pair := range strings.Fields(value) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid throttle option %q, expected key=value", pair)
+		}
+		switch parts[0] {
+		case "delay":
+			delay, err := time.ParseDuration(parts[1])
+			if err != nil || delay < 0 {
+				return nil, fmt.Errorf("Invalid throttle delay %q", parts[1])
+			}
+			throttle.Delay = delay
+		case "max-lag":
+			lag, err := time.ParseDuration(parts[1])
+			if err != nil || lag <= 0 {
+				return nil, fmt.Errorf("Invalid throttle max lag %q", parts[1])
+			}
+			throttle.MaxLag = lag
+		case "max-pause":
+			pause, err := time.ParseDuration(parts[1])
+			if err != nil || pause <= 0 {
+				return nil, fmt.Errorf("Invalid throttle max pause %q", parts[1])
+			}
+			throttle.MaxPause = pause
+		case "rows":
+			rows, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil || rows <= 0 {
+				return nil, fmt.Errorf("Invalid throttle rows per second %q", parts[1])
+			}
+			throttle.RowsPerSecond = rows
+		default:
+			return nil, fmt.Errorf("Unknown throttle option %q", parts[0])
+		}
+	}
+	return throttle, nil
+}
+
+// Returns how long to sleep after a statement affecting rows ran for
+// elapsed.
+func (t *Throttle) wait(rows int64, elapsed time.Duration) time.Duration {
+	wait := t.Delay
+	if t.RowsPerSecond > 0 {
+		if paced := time.Duration(rows*int64(time.Second)/t.RowsPerSecond) - elapsed; paced > wait {
+			wait = paced
+		}
+	}
+	return wait
+}
+
+// Returns the throttle of a migration: the one it declares, or
+// Migrator.Throttle for migrations of PhaseData.
+func (m *Migrator) throttleOf(migration *Migration) *Throttle {
+	if migration.Throttle != nil {
+		return migration.Throttle
+	}
+	if phaseOf(migration) == PhaseData {
+		return m.Throttle
+	}
+	return nil
+}
+
+// Sleeps after a statement of a migration as long as its throttle asks.
+func (m *Migrator) throttle(migration *Migration, rows int64, elapsed time.Duration) {
+	throttle := m.throttleOf(migration)
+	if throttle == nil {
+		return
+	}
+	if wait := throttle.wait(rows, elapsed); wait > 0 {
+		m.debugf("Throttling migration %d for %s", migration.Id, wait)
+		time.Sleep(wait)
+	}
+}
+
+// Waits before a statement of a migration while replicas lag further
+// behind than its throttle allows, up to its MaxPause.
+func (m *run) waitForReplicas(migration *Migration) error {
+	throttle := m.throttleOf(migration)
+	if throttle == nil || throttle.MaxLag <= 0 {
+		return nil
+	}
+	maxPause := throttle.MaxPause
+	if maxPause <= 0 {
+		maxPause = DefaultMaxPause
+	}
+	start := time.Now()
+	paused := false
+	for {
+		lag, err := m.replicationLag()
+		if err != nil {
+			m.errorf("Error measuring replication lag: %v", err)
+			return err
+		}
+		if lag <= throttle.MaxLag {
+			if paused {
+				m.infof("Resuming migration %d, replicas lag %s", migration.Id, lag)
+			}
+			return nil
+		}
+		if waited := time.Since(start); waited >= maxPause {
+			err := &ReplicationLagTimeout{MigrationId: migration.Id, Lag: lag, MaxLag: throttle.MaxLag, Paused: waited}
+			m.errorf("%v", err)
+			return err
+		}
+		if !paused {
+			m.warnf("Pausing migration %d while replicas lag %s, more than %s", migration.Id, lag, throttle.MaxLag)
+			paused = true
+		}
+		time.Sleep(LagPollInterval)
+	}
+}
+
+// Returns the lag of replicas, measured by LagProbe or the adapter.
+func (m *run) replicationLag() (time.Duration, error) {
+	if m.LagProbe != nil {
+		return m.LagProbe.Lag()
+	}
+	checker, ok := m.dbAdapter.(ReplicationLagChecker)
+	if !ok {
+		return 0, LagProbeUnsupported
+	}
+	var seconds float64
+	if err := m.executor.QueryRow(checker.ReplicationLagSql()).Scan(&seconds); err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
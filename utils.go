@@ -1,41 +1,107 @@
 package gomigrate
 
 import (
+	"fmt"
+	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
+
+	"github.com/DavidHuie/gomigrate/sqlsplit"
 )
 
 var (
-	upMigrationFile   = regexp.MustCompile(`(\d+)_([\w-]+)_up\.sql`)
-	downMigrationFile = regexp.MustCompile(`(\d+)_([\w-]+)_down\.sql`)
 	subMigrationSplit = regexp.MustCompile(`;\s*`)
 	allWhitespace     = regexp.MustCompile(`^\s*$`)
 )
 
+// Extensions of migration files, compared without case.
+var sqlExtensions = []string{".sql", ".sql.tmpl", ".sql.enc"}
+
+// Returned when the name of a migration file can't be parsed, with the
+// reason. Matches InvalidMigrationFile with errors.Is.
+type MigrationFileError struct {
+	Path   string
+	Reason string
+}
+
+func (e *MigrationFileError) Error() string {
+	return fmt.Sprintf("Invalid migration file %s: %s", e.Path, e.Reason)
+}
+
+func (e *MigrationFileError) Unwrap() error {
+	return InvalidMigrationFile
+}
+
 // Returns the migration number, type and base name, so 1, "up", "migration" from "01_migration_up.sql"
 func parseMigrationPath(filebase string) (uint64, migrationType, string, error) {
+	stem, ok := trimSqlExtension(filebase)
+	if !ok {
+		return 0, "", "", &MigrationFileError{filebase, "not a .sql file"}
+	}
+	lower := strings.ToLower(stem)
+	var mType migrationType
+	switch {
+	case strings.HasSuffix(lower, "_up"):
+		mType = upMigration
+	case strings.HasSuffix(lower, "_down"):
+		mType = downMigration
+	default:
+		return 0, "", "", &MigrationFileError{filebase, "name doesn't end with _up or _down"}
+	}
+	return parseIdAndName(filebase, stem[:len(stem)-len(mType)-1], mType)
+}
 
-	matches := upMigrationFile.FindAllSubmatch([]byte(filebase), -1)
-	if matches != nil {
-		return parseMatches(matches, upMigration)
+// Parses the path of a migration file in either layout: a name ending
+// in _up.sql or _down.sql, or a name such as 1_migration.sql in an up
+// or down directory. Errors are a *MigrationFileError with the path.
+func parseMigrationFile(path string) (uint64, migrationType, string, error) {
+	base := filepath.Base(path)
+	mType := migrationType(filepath.Base(filepath.Dir(path)))
+	id, fileType, name, err := parseMigrationPath(base)
+	if err != nil && isTypeDir(string(mType)) {
+		if stem, ok := trimSqlExtension(base); ok {
+			id, fileType, name, err = parseIdAndName(base, stem, mType)
+		}
 	}
-	matches = downMigrationFile.FindAllSubmatch([]byte(filebase), -1)
-	if matches != nil {
-		return parseMatches(matches, downMigration)
+	if fileErr, ok := err.(*MigrationFileError); ok {
+		fileErr.Path = path
 	}
+	return id, fileType, name, err
+}
 
-	return 0, "", "", InvalidMigrationFile
+// Returns a file name without its migration file extension, if it has
+// one.
+func trimSqlExtension(filebase string) (string, bool) {
+	lower := strings.ToLower(filebase)
+	for _, extension := range sqlExtensions {
+		if strings.HasSuffix(lower, extension) {
+			return filebase[:len(filebase)-len(extension)], true
+		}
+	}
+	return "", false
 }
 
-// Parses matches given by a migration file regex.
-func parseMatches(matches [][][]byte, mType migrationType) (uint64, migrationType, string, error) {
-	num := matches[0][1]
-	name := matches[0][2]
-	parsedNum, err := strconv.ParseUint(string(num), 10, 64)
+// Parses the id and name of a migration file, from stem, its name
+// without the extension and type suffix. Names may hold letters and
+// digits of any script, underscores and dashes.
+func parseIdAndName(filebase, stem string, mType migrationType) (uint64, migrationType, string, error) {
+	digits := len(stem) - len(strings.TrimLeft(stem, "0123456789"))
+	if digits == 0 {
+		return 0, "", "", &MigrationFileError{filebase, "name doesn't start with an id"}
+	}
+	id, err := strconv.ParseUint(stem[:digits], 10, 64)
 	if err != nil {
-		return 0, "", "", err
+		return 0, "", "", &MigrationFileError{filebase, "id is out of range"}
+	}
+	name := strings.TrimPrefix(stem[digits:], "_")
+	switch {
+	case len(name) == len(stem)-digits:
+		return 0, "", "", &MigrationFileError{filebase, "id isn't followed by an underscore"}
+	case !migrationName.MatchString(name):
+		return 0, "", "", &MigrationFileError{filebase, "name is empty or holds characters other than letters, digits, underscores and dashes"}
 	}
-	return parsedNum, mType, string(name), nil
+	return id, mType, name, nil
 }
 
 // This type is used to sort migration ids.
@@ -54,3 +120,9 @@ func (u uint64slice) Swap(a, b int) {
 	u[a] = u[b]
 	u[b] = tempA
 }
+
+// Splits SQL into statements on semicolons, ignoring semicolons inside
+// quotes, dollar-quoted bodies and comments.
+func splitStatements(sql string) []string {
+	return sqlsplit.Generic.Split(sql)
+}
@@ -0,0 +1,73 @@
+// Checks that the schema is recent enough for the running binary.
+
+package gomigrate
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Returned by RequireVersion when the schema is older than required.
+type SchemaVersionError struct {
+	Required uint64
+
+	// The highest applied migration, 0 when none are applied.
+	Current uint64
+}
+
+func (e *SchemaVersionError) Error() string {
+	return fmt.Sprintf("Schema version %d is older than the required version %d", e.Current, e.Required)
+}
+
+// Returns the highest id in the migrations table, or 0 when the table
+// is missing or empty.
+func (m *run) appliedVersion() (uint64, error) {
+	tableExists, err := m.migrationTableExists()
+	if err != nil || !tableExists {
+		return 0, err
+	}
+	query := "SELECT MAX(migration_id) FROM gomigrate"
+	if foreign, ok := m.dbAdapter.(ForeignMigrationTable); ok {
+		query = foreign.MaxMigrationSql()
+	}
+	var highest sql.NullInt64
+	if err := m.executor.QueryRow(query).Scan(&highest); err != nil {
+		m.errorf("Error getting the highest applied migration: %v", err)
+		return 0, err
+	}
+	return uint64(highest.Int64), nil
+}
+
+// Returns a *SchemaVersionError when the highest migration applied to
+// the database is below minId, for applications that must not start
+// against an old schema. The database is read on every call, so
+// migrations applied by other processes are seen.
+func (m *Migrator) RequireVersion(minId uint64) error {
+	current, err := m.newRun().appliedVersion()
+	if err != nil {
+		return err
+	}
+	if current < minId {
+		err := &SchemaVersionError{Required: minId, Current: current}
+		m.errorf("%v", err)
+		return err
+	}
+	return nil
+}
+
+// Checks the schema version like Migrator.RequireVersion for
+// applications that don't ship their migrations, with the adapter
+// registered for the driver of db.
+func RequireVersion(db *sql.DB, minId uint64) error {
+	adapter, err := DetectAdapter(db)
+	if err != nil {
+		return err
+	}
+	m := &Migrator{
+		DB:        db,
+		executor:  sqlExecutor{db},
+		dbAdapter: adapter,
+		logger:    DefaultLogger(),
+	}
+	return m.RequireVersion(minId)
+}
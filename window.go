@@ -0,0 +1,218 @@
+// Defers heavy migrations to scheduling windows, such as off-peak hours.
+
+package gomigrate
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A recurring period of time, named in Migrator.Windows, that the
+// migrations declaring it with "-- gomigrate: window=off-peak" are
+// deferred to.
+type Window struct {
+	// The time zone of the schedule, defaulting to that of the
+	// migrator's clock.
+	Location *time.Location
+
+	spec string
+
+	// The values allowed for the minute, hour, day of month, month and
+	// day of week, and whether the days are restricted.
+	fields             [5][]bool
+	anyDay, anyWeekday bool
+}
+
+// The names and ranges of the fields of a window.
+var windowFields = [5]struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// How often MigrateWindows checks whether the windows of deferred
+// migrations are open.
+var WindowPollInterval = time.Minute
+
+// Parses a window from the five fields of a cron schedule, matching
+// the minutes it is open: minute, hour, day of month, month and day of
+// week, 0 or 7 being Sunday. Fields hold *, numbers and ranges, each
+// optionally stepped with /n, or lists of them. For instance
+// "* 22-23,0-5 * * *" is open from 22:00 to 6:00, and "* * * * 6,0"
+// during weekends. As with cron, a window restricting both the day of
+// month and the day of week is open on days matching either.
+func ParseWindow(spec string) (*Window, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(windowFields) {
+		return nil, fmt.Errorf("Invalid window %q, expected minute, hour, day of month, month and day of week", spec)
+	}
+	window := &Window{
+		spec:       spec,
+		anyDay:     strings.HasPrefix(fields[2], "*"),
+		anyWeekday: strings.HasPrefix(fields[4], "*"),
+	}
+	for i, field := range fields {
+		values, err := parseWindowField(field, windowFields[i].min, windowFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s in window %q: %v", windowFields[i].name, spec, err)
+		}
+		window.fields[i] = values
+	}
+	if window.fields[4][7] {
+		window.fields[4][0] = true
+	}
+	return window, nil
+}
+
+// Returns the values from min to max a field of a window allows,
+// indexed by value.
+func parseWindowField(field string, min, max int) ([]bool, error) {
+	values := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		span, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid step %q", part)
+			}
+			span, step = part[:i], n
+		}
+		low, high := min, max
+		if span != "*" {
+			bounds := strings.SplitN(span, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value %q", part)
+			}
+			switch {
+			case len(bounds) == 2:
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid value %q", part)
+				}
+			case step == 1:
+				high = low
+			}
+			if low < min || high > max || low > high {
+				return nil, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+			}
+		}
+		for value := low; value <= high; value += step {
+			values[value] = true
+		}
+	}
+	return values, nil
+}
+
+// Returns true if the window is open at t.
+func (w *Window) Open(t time.Time) bool {
+	if w.Location != nil {
+		t = t.In(w.Location)
+	}
+	if !w.fields[0][t.Minute()] || !w.fields[1][t.Hour()] || !w.fields[3][int(t.Month())] {
+		return false
+	}
+	day, weekday := w.fields[2][t.Day()], w.fields[4][int(t.Weekday())]
+	if w.anyDay || w.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+func (w *Window) String() string {
+	return w.spec
+}
+
+// Doesn't leave out migrations whose window is closed.
+func anyWindow(c *runConfig) {
+	c.anyWindow = true
+}
+
+// Returns true if the migration declares no window, or its window is
+// open.
+func (m *Migrator) windowOpen(migration *Migration) bool {
+	if migration.Window == "" {
+		return true
+	}
+	window, ok := m.Windows[migration.Window]
+	return ok && window.Open(m.now())
+}
+
+// Returns UnknownWindow if a pending migration declares a window
+// missing from Windows.
+func (m *Migrator) checkWindows(migrations []*Migration) error {
+	for _, migration := range migrations {
+		if _, ok := m.Windows[migration.Window]; migration.Window != "" && !ok {
+			m.errorf("Migration %d declares unknown window %s", migration.Id, migration.Window)
+			return UnknownWindow
+		}
+	}
+	return nil
+}
+
+// Returns the pending migrations the run options select but which are
+// deferred until their window opens, in order.
+func (m *Migrator) Deferred(options ...RunOption) []*Migration {
+	return m.deferredMigrations(m.pendingMigrations(), options)
+}
+
+func (m *Migrator) deferredMigrations(migrations []*Migration, options []RunOption) []*Migration {
+	deferred := make([]*Migration, 0)
+	for _, migration := range m.selectMigrations(migrations, append(options, anyWindow)) {
+		if !m.windowOpen(migration) {
+			deferred = append(deferred, migration)
+		}
+	}
+	return deferred
+}
+
+// Applies pending migrations like MigrateWithResult, then waits for the
+// windows of the migrations it deferred to open and applies them, until
+// none are deferred. Returns Interrupted once ctx is done. The result
+// lists the migrations applied by every run.
+func (m *Migrator) MigrateWindows(ctx context.Context, options ...RunOption) (*RunResult, error) {
+	applied := make([]uint64, 0)
+	for {
+		result, err := m.MigrateWithResult(ctx, options...)
+		applied = append(applied, result.Applied...)
+		result.Applied = applied
+		if err != nil || len(result.Deferred) == 0 {
+			if len(applied) > 0 && result.Outcome == RunUpToDate {
+				result.Outcome = RunApplied
+			}
+			return result, err
+		}
+		if err := m.waitForWindows(ctx, result.Deferred); err != nil {
+			return result, err
+		}
+	}
+}
+
+// Waits until the window of one of the migrations is open.
+func (m *Migrator) waitForWindows(ctx context.Context, ids []uint64) error {
+	m.infof("Waiting for the windows of deferred migrations %v", ids)
+	ticker := time.NewTicker(WindowPollInterval)
+	defer ticker.Stop()
+	for {
+		m.mu.RLock()
+		for _, id := range ids {
+			if migration, ok := m.migrations[id]; ok && m.windowOpen(migration) {
+				m.mu.RUnlock()
+				return nil
+			}
+		}
+		m.mu.RUnlock()
+		select {
+		case <-ctx.Done():
+			return Interrupted
+		case <-ticker.C:
+		}
+	}
+}